@@ -105,6 +105,21 @@ func BookIDKey(id uint) string {
 	return fmt.Sprintf("books:id:%d", id)
 }
 
+// BookQueryKey is the key of one filtered page of books; it shares the
+// BookListKey prefix so that list invalidation drops all cached pages
+func BookQueryKey(query string) string {
+	return BookListKey() + ":" + query
+}
+
+// BookSearchKey is the key of one page of full-text search results
+func BookSearchKey(query string) string {
+	return BookListKey() + ":search:" + query
+}
+
+func BookISBNKey(isbn string) string {
+	return fmt.Sprintf("books:isbn:%s", isbn)
+}
+
 func ReaderListKey() string {
 	return "readers:list"
 }
@@ -112,3 +127,30 @@ func ReaderListKey() string {
 func ReaderIDKey(id uint) string {
 	return fmt.Sprintf("readers:id:%d", id)
 }
+
+// ReaderQueryKey is the key of one filtered page of readers, see BookQueryKey
+func ReaderQueryKey(query string) string {
+	return ReaderListKey() + ":" + query
+}
+
+// NameListKey is the prefix of the listings of authors, publishers or
+// subjects; table is the plural, e.g. "authors"
+func NameListKey(table string) string {
+	return table + ":list"
+}
+
+func NameQueryKey(table, query string) string {
+	return NameListKey(table) + ":" + query
+}
+
+func NameIDKey(table string, id uint) string {
+	return fmt.Sprintf("%s:id:%d", table, id)
+}
+
+func LoanIDKey(id uint) string {
+	return fmt.Sprintf("loans:id:%d", id)
+}
+
+func ReaderLoansKey(readerID uint) string {
+	return fmt.Sprintf("loans:reader:%d", readerID)
+}
@@ -0,0 +1,88 @@
+package cache
+
+import (
+	"log"
+	"strings"
+	"sync"
+)
+
+// Store is what repositories need from a cache. *Cache implements it, and so
+// does *Deferred for repositories working inside a database transaction.
+type Store interface {
+	Get(key string) (interface{}, bool)
+	Set(key string, value interface{})
+	Invalidate(key string)
+	InvalidatePattern(pattern string)
+}
+
+// Deferred is the view of a Cache from inside a database transaction.
+// Invalidations are collected and only applied by Commit, so that no other
+// request caches the old state between the invalidation and the commit. Keys
+// the transaction invalidated miss, the others are read from the cache, and
+// values are never stored since they could hold uncommitted data.
+type Deferred struct {
+	cache    *Cache
+	mu       sync.Mutex
+	keys     []string
+	patterns []string
+}
+
+// Defer returns a view of the cache for one transaction
+func (c *Cache) Defer() *Deferred {
+	return &Deferred{cache: c}
+}
+
+func (d *Deferred) Get(key string) (interface{}, bool) {
+	d.mu.Lock()
+	stale := d.invalidated(key)
+	d.mu.Unlock()
+	if stale {
+		return nil, false
+	}
+	return d.cache.Get(key)
+}
+
+func (d *Deferred) Set(key string, value interface{}) {}
+
+func (d *Deferred) Invalidate(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.keys = append(d.keys, key)
+}
+
+func (d *Deferred) InvalidatePattern(pattern string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.patterns = append(d.patterns, pattern)
+}
+
+// Commit applies the collected invalidations; call it once the transaction has committed
+func (d *Deferred) Commit() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, key := range d.keys {
+		d.cache.Invalidate(key)
+	}
+	for _, pattern := range d.patterns {
+		d.cache.InvalidatePattern(pattern)
+	}
+	if n := len(d.keys) + len(d.patterns); n > 0 {
+		log.Printf("Cache COMMIT: applied %d deferred invalidations", n)
+	}
+	d.keys, d.patterns = nil, nil
+}
+
+func (d *Deferred) invalidated(key string) bool {
+	for _, k := range d.keys {
+		if k == key {
+			return true
+		}
+	}
+	for _, pattern := range d.patterns {
+		if strings.HasPrefix(key, pattern) {
+			return true
+		}
+	}
+	return false
+}
@@ -2,20 +2,119 @@ package config
 
 import (
 	"encoding/json"
+	"lab1/rbac"
 	"log"
 	"os"
 )
 
 type Config struct {
-	CacheTTLSeconds    int64  `json:"cache_ttl_seconds"`
-	EnableGetBooks     bool   `json:"enable_get_books"`
-	EnablePostBooks    bool   `json:"enable_post_books"`
-	EnablePutBooks     bool   `json:"enable_put_books"`
-	EnableDeleteBooks  bool   `json:"enable_delete_books"`
-	EnableGetReaders   bool   `json:"enable_get_readers"`
-	EnablePostReaders  bool   `json:"enable_post_readers"`
-	EnablePutReaders   bool   `json:"enable_put_readers"`
-	EnableDeleteReaders bool  `json:"enable_delete_readers"`
+	// database_dsn is the file name for SQLite and a connection string such as
+	// "host=localhost user=library dbname=library" for PostgreSQL
+	DatabaseDriver                 string `json:"database_driver"` // "sqlite" or "postgres"
+	DatabaseDSN                    string `json:"database_dsn"`
+	DatabaseMaxOpenConns           int    `json:"database_max_open_conns"` // 0 means no limit
+	DatabaseMaxIdleConns           int    `json:"database_max_idle_conns"`
+	DatabaseConnMaxLifetimeMinutes int    `json:"database_conn_max_lifetime_minutes"` // 0 keeps connections open
+
+	// With full_text_search "auto" /books/search uses a ranked full-text index
+	// where the database has one: on PostgreSQL, and on SQLite only in builds
+	// with -tags sqlite_fts5. Otherwise it falls back to substring matching
+	// without ranking. "required" refuses to start without the index, "off"
+	// always uses the fallback.
+	FullTextSearch string `json:"full_text_search"`
+
+	CacheTTLSeconds         int64 `json:"cache_ttl_seconds"`
+	EnableGetBooks          bool  `json:"enable_get_books"`
+	EnablePostBooks         bool  `json:"enable_post_books"`
+	EnablePutBooks          bool  `json:"enable_put_books"`
+	EnableDeleteBooks       bool  `json:"enable_delete_books"`
+	EnableGetReaders        bool  `json:"enable_get_readers"`
+	EnablePostReaders       bool  `json:"enable_post_readers"`
+	EnablePutReaders        bool  `json:"enable_put_readers"`
+	EnableDeleteReaders     bool  `json:"enable_delete_readers"`
+	LoanPeriodDays          int   `json:"loan_period_days"`
+	HoldExpiryDays          int   `json:"hold_expiry_days"`           // how long a ready hold waits for pickup
+	FineDailyRateCents      int64 `json:"fine_daily_rate_cents"`      // charged for every started day past the due date
+	FineMaxCents            int64 `json:"fine_max_cents"`             // cap for a single overdue loan, 0 means no cap
+	FineBlockThresholdCents int64 `json:"fine_block_threshold_cents"` // readers owing more than this cannot borrow
+	DefaultPageSize         int   `json:"default_page_size"`          // page size of list endpoints without page_size
+	MaxPageSize             int   `json:"max_page_size"`
+	ImportMaxRows           int   `json:"import_max_rows"` // rows accepted by a single POST /books/import
+	AccessTokenTTLMinutes   int   `json:"access_token_ttl_minutes"`
+	RefreshTokenTTLDays     int   `json:"refresh_token_ttl_days"` // refreshing issues a new token with a fresh lifetime
+	TrashRetentionDays      int   `json:"trash_retention_days"`   // deleted books and readers are purged after this, 0 keeps them
+
+	// Requests taking longer than query_timeout_seconds are answered with 504;
+	// imports and exports get bulk_query_timeout_seconds. 0 means no limit.
+	QueryTimeoutSeconds     int `json:"query_timeout_seconds"`
+	BulkQueryTimeoutSeconds int `json:"bulk_query_timeout_seconds"`
+
+	MailDriver                string `json:"mail_driver"` // "smtp", or "log" to only log mails
+	MailFile                  string `json:"mail_file"`   // the log driver also appends mails to this file when set
+	MailFrom                  string `json:"mail_from"`
+	SMTPHost                  string `json:"smtp_host"`
+	SMTPPort                  int    `json:"smtp_port"`
+	SMTPUsername              string `json:"smtp_username"` // no authentication when empty
+	SMTPPassword              string `json:"smtp_password"`
+	AppBaseURL                string `json:"app_base_url"` // used for the links in mails
+	PasswordResetTTLMinutes   int    `json:"password_reset_ttl_minutes"`
+	EmailVerificationTTLHours int    `json:"email_verification_ttl_hours"`
+	RequireEmailVerification  bool   `json:"require_email_verification"` // unverified users can only read
+
+	// Roles maps role names to permissions ("*" grants all). A role defined in the
+	// file replaces the built-in role of the same name.
+	Roles       map[string][]string `json:"roles"`
+	DefaultRole string              `json:"default_role"` // role of newly registered users
+
+	TwoFactorRequiredRoles       []string `json:"two_factor_required_roles"` // users of these roles must enrol in TOTP before using the API; deliberately not changeable through the admin API
+	TwoFactorIssuer              string   `json:"two_factor_issuer"`         // shown by authenticator apps
+	TwoFactorChallengeTTLMinutes int      `json:"two_factor_challenge_ttl_minutes"`
+
+	// Failed password logins are throttled per username and per IP address: after
+	// n failures the next attempt waits login_backoff_base_seconds * 2^(n-1), up to
+	// login_backoff_max_seconds; for addresses n counts the failures beyond
+	// login_max_failures. At the max_failures the username or address is locked
+	// for login_lockout_minutes. Failures older than that are forgotten.
+	LoginMaxFailures          int `json:"login_max_failures"`
+	LoginIPMaxFailures        int `json:"login_ip_max_failures"` // higher, since users behind one address share it
+	LoginBackoffBaseSeconds   int `json:"login_backoff_base_seconds"`
+	LoginBackoffMaxSeconds    int `json:"login_backoff_max_seconds"`
+	LoginLockoutMinutes       int `json:"login_lockout_minutes"`
+	LoginAttemptRetentionDays int `json:"login_attempt_retention_days"`
+	// TrustedProxies may set X-Forwarded-For; without them the client IP is the
+	// connection's address, so that the header cannot dodge the throttling
+	TrustedProxies []string `json:"trusted_proxies"`
+
+	// JWTKeys sign and verify access tokens. Only the key JWTSigningKeyID signs,
+	// the others are still accepted, so a key can be rotated out without ending
+	// sessions. Without keys a temporary key is generated on every start.
+	JWTKeys         []JWTKey `json:"jwt_keys"`
+	JWTSigningKeyID string   `json:"jwt_signing_key_id"` // defaults to the first key with a private key
+
+	// OpenID Connect single sign-on, enabled when OIDCIssuer is set
+	OIDCIssuer        string            `json:"oidc_issuer"`
+	OIDCClientID      string            `json:"oidc_client_id"`
+	OIDCClientSecret  string            `json:"oidc_client_secret"` // empty for a public client
+	OIDCRedirectURL   string            `json:"oidc_redirect_url"`  // defaults to app_base_url + /auth/oidc/callback
+	OIDCScopes        []string          `json:"oidc_scopes"`
+	OIDCUsernameClaim string            `json:"oidc_username_claim"` // username of provisioned users, falls back to the email
+	OIDCRoleClaim     string            `json:"oidc_role_claim"`     // claim with group or role names, e.g. "groups"
+	OIDCRoleMapping   []OIDCRoleMapping `json:"oidc_role_mapping"`   // the first entry found in the role claim wins
+	OIDCLinkByEmail   bool              `json:"oidc_link_by_email"`  // sign existing users in by their verified email
+}
+
+// OIDCRoleMapping gives users with Value in the role claim the library role Role
+type OIDCRoleMapping struct {
+	Value string `json:"value"`
+	Role  string `json:"role"`
+}
+
+// JWTKey is a PEM key file; a key with only a public key file verifies but never signs
+type JWTKey struct {
+	ID             string `json:"kid"`
+	Algorithm      string `json:"algorithm"` // "RS256" or "EdDSA"
+	PrivateKeyFile string `json:"private_key_file"`
+	PublicKeyFile  string `json:"public_key_file"`
 }
 
 func LoadConfig(filePath string) (*Config, error) {
@@ -27,26 +126,81 @@ func LoadConfig(filePath string) (*Config, error) {
 		return nil, err
 	}
 
-	var cfg Config
-	if err := json.Unmarshal(data, &cfg); err != nil {
+	// Start from defaults so that options missing in the file keep sane values
+	cfg := DefaultConfig()
+	if err := json.Unmarshal(data, cfg); err != nil {
 		log.Printf("Error parsing config file: %v", err)
 		return nil, err
 	}
 
-	log.Printf("Configuration loaded successfully: cache_ttl_seconds=%d", cfg.CacheTTLSeconds)
-	return &cfg, nil
+	log.Printf("Configuration loaded successfully: database_driver=%s, cache_ttl_seconds=%d", cfg.DatabaseDriver, cfg.CacheTTLSeconds)
+	return cfg, nil
 }
 
 func DefaultConfig() *Config {
 	return &Config{
-		CacheTTLSeconds:    300, // 5 minutes default
-		EnableGetBooks:     true,
-		EnablePostBooks:    true,
-		EnablePutBooks:     true,
-		EnableDeleteBooks:  true,
-		EnableGetReaders:   true,
-		EnablePostReaders:  true,
-		EnablePutReaders:   true,
-		EnableDeleteReaders: true,
+		DatabaseDriver:               "sqlite",
+		DatabaseDSN:                  "library.db",
+		DatabaseMaxIdleConns:         2,
+		FullTextSearch:               "auto",
+		CacheTTLSeconds:              300, // 5 minutes default
+		EnableGetBooks:               true,
+		EnablePostBooks:              true,
+		EnablePutBooks:               true,
+		EnableDeleteBooks:            true,
+		EnableGetReaders:             true,
+		EnablePostReaders:            true,
+		EnablePutReaders:             true,
+		EnableDeleteReaders:          true,
+		LoanPeriodDays:               14,
+		HoldExpiryDays:               3,
+		FineDailyRateCents:           25,
+		FineMaxCents:                 1000,
+		FineBlockThresholdCents:      500,
+		DefaultPageSize:              20,
+		MaxPageSize:                  100,
+		ImportMaxRows:                5000,
+		AccessTokenTTLMinutes:        15,
+		RefreshTokenTTLDays:          30,
+		TrashRetentionDays:           30,
+		QueryTimeoutSeconds:          10,
+		BulkQueryTimeoutSeconds:      300,
+		MailDriver:                   "log",
+		MailFrom:                     "library@localhost",
+		SMTPPort:                     587,
+		AppBaseURL:                   "http://localhost:8080",
+		PasswordResetTTLMinutes:      60,
+		EmailVerificationTTLHours:    48,
+		Roles:                        rbac.DefaultRoles(),
+		DefaultRole:                  "member",
+		TwoFactorRequiredRoles:       []string{},
+		TwoFactorIssuer:              "Library",
+		TwoFactorChallengeTTLMinutes: 5,
+		LoginMaxFailures:             5,
+		LoginIPMaxFailures:           50,
+		LoginBackoffBaseSeconds:      1,
+		LoginBackoffMaxSeconds:       60,
+		LoginLockoutMinutes:          15,
+		LoginAttemptRetentionDays:    90,
+		TrustedProxies:               []string{},
+		JWTKeys:                      []JWTKey{},
+		OIDCScopes:                   []string{"openid", "email", "profile"},
+		OIDCUsernameClaim:            "preferred_username",
+		OIDCRoleMapping:              []OIDCRoleMapping{},
 	}
 }
+
+// RequiresTwoFactor reports whether users of the role must use two-factor authentication
+func (c *Config) RequiresTwoFactor(role string) bool {
+	for _, r := range c.TwoFactorRequiredRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// OIDCEnabled reports whether single sign-on is configured
+func (c *Config) OIDCEnabled() bool {
+	return c.OIDCIssuer != ""
+}
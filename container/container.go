@@ -1,69 +1,214 @@
 package container
 
 import (
+	"errors"
+	"fmt"
 	"lab1/cache"
 	"lab1/config"
+	"lab1/jwtkeys"
+	"lab1/mailer"
 	"lab1/models"
+	"lab1/oidc"
+	"lab1/rbac"
 	"lab1/repository"
+	"lab1/service"
 	"lab1/validation"
 	"log"
+	"strings"
+	"time"
 
+	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 )
 
 type Container struct {
-	DB               *gorm.DB
-	Config           *config.Config
-	Cache            *cache.Cache
-	BookRepository   repository.BookRepository
-	ReaderRepository repository.ReaderRepository
-	UserRepository   repository.UserRepository
-	Validator        *validation.Validator
+	DB                     *gorm.DB
+	Config                 *config.Config
+	Cache                  *cache.Cache
+	BookRepository         repository.BookRepository
+	ReaderRepository       repository.ReaderRepository
+	UserRepository         repository.UserRepository
+	TokenRepository        repository.TokenRepository
+	UserTokenRepository    repository.UserTokenRepository
+	APIKeyRepository       repository.APIKeyRepository
+	LoginAttemptRepository repository.LoginAttemptRepository
+	AuditRepository        repository.AuditRepository
+	LoanRepository         repository.LoanRepository
+	HoldRepository         repository.HoldRepository
+	AccountRepository      repository.AccountRepository
+	ItemRepository         repository.ItemRepository
+	AuthorRepository       repository.AuthorRepository
+	PublisherRepository    repository.PublisherRepository
+	SubjectRepository      repository.SubjectRepository
+	UnitOfWork             *service.UnitOfWork
+	BookService            *service.BookService
+	ReaderService          *service.ReaderService
+	LoanService            *service.LoanService
+	HoldService            *service.HoldService
+	ItemService            *service.ItemService
+	AccountService         *service.AccountService
+	Validator              *validation.Validator
+	Mailer                 mailer.Mailer
+	Policy                 *rbac.Policy
+	Keys                   *jwtkeys.KeySet
+	OIDC                   *oidc.Provider // nil without single sign-on
 }
 
-func NewContainer(dbPath string, configPath string) (*Container, error) {
+func NewContainer(configPath string) (*Container, error) {
 	cfg, err := config.LoadConfig(configPath)
 	if err != nil {
 		log.Printf("Failed to load config from %s, using default config", configPath)
 		cfg = config.DefaultConfig()
 	}
 
+	policy, err := rbac.NewPolicy(cfg.Roles)
+	if err != nil {
+		return nil, fmt.Errorf("invalid roles: %w", err)
+	}
+	for _, role := range append([]string{cfg.DefaultRole, adminRole}, cfg.TwoFactorRequiredRoles...) {
+		if !policy.IsRole(role) {
+			return nil, fmt.Errorf("invalid roles: role %q is not defined", role)
+		}
+	}
+
+	keys, err := jwtkeys.New(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid jwt keys: %w", err)
+	}
+
+	oidcProvider, err := newOIDCProvider(cfg, policy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid oidc configuration: %w", err)
+	}
+
+	switch cfg.FullTextSearch {
+	case "auto", "required", "off":
+	default:
+		return nil, fmt.Errorf("invalid full_text_search %q, expected \"auto\", \"required\" or \"off\"", cfg.FullTextSearch)
+	}
+
 	cacheInstance := cache.NewCache(cfg.CacheTTLSeconds)
 
-	db, err := gorm.Open(sqlite.Open(dbPath), &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Silent), // Suppress "record not found" logs
-	})
+	db, err := openDatabase(cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	err = db.AutoMigrate(&models.Book{}, &models.Reader{}, &models.User{})
+	err = db.AutoMigrate(&models.Book{}, &models.Reader{}, &models.User{}, &models.Loan{}, &models.Hold{}, &models.AccountTransaction{}, &models.Item{},
+		&models.Author{}, &models.Publisher{}, &models.Subject{}, &models.RefreshToken{}, &models.RevokedToken{}, &models.UserToken{},
+		&models.RecoveryCode{}, &models.APIKey{}, &models.LoginAttempt{}, &models.LoginThrottle{}, &models.AuditEvent{},
+		&models.BookRevision{}, &models.ReaderRevision{})
 	if err != nil {
 		return nil, err
 	}
 
 	// Seed admin user if not exists
 	seedAdminUser(db)
+	migrateLegacyRoles(db, policy, cfg.DefaultRole)
 
-	bookRepo := repository.NewBookRepository(db, cacheInstance)
+	bookRepo := repository.NewBookRepository(db, cacheInstance, cfg.FullTextSearch != "off")
+	if cfg.FullTextSearch == "required" && !bookRepo.FullText() {
+		return nil, errors.New("full-text search is unavailable (SQLite needs a build with -tags sqlite_fts5), " +
+			`set full_text_search to "auto" to fall back to substring matching`)
+	}
 	readerRepo := repository.NewReaderRepository(db, cacheInstance)
 	userRepo := repository.NewUserRepository(db)
+	tokenRepo := repository.NewTokenRepository(db)
+	userTokenRepo := repository.NewUserTokenRepository(db)
+	apiKeyRepo := repository.NewAPIKeyRepository(db)
+	loginAttemptRepo := repository.NewLoginAttemptRepository(db)
+	auditRepo := repository.NewAuditRepository(db)
+	loanRepo := repository.NewLoanRepository(db, cacheInstance)
+	holdRepo := repository.NewHoldRepository(db)
+	accountRepo := repository.NewAccountRepository(db)
+	itemRepo := repository.NewItemRepository(db)
+	authorRepo := repository.NewAuthorRepository(db, cacheInstance)
+	publisherRepo := repository.NewPublisherRepository(db, cacheInstance)
+	subjectRepo := repository.NewSubjectRepository(db, cacheInstance)
+
+	uow := service.NewUnitOfWork(db, cacheInstance, service.Repositories{
+		Books:    bookRepo,
+		Readers:  readerRepo,
+		Items:    itemRepo,
+		Loans:    loanRepo,
+		Holds:    holdRepo,
+		Accounts: accountRepo,
+	})
 
 	validator := validation.NewValidator()
 
+	mail, err := mailer.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Container{
-		DB:               db,
-		Config:           cfg,
-		Cache:            cacheInstance,
-		BookRepository:   bookRepo,
-		ReaderRepository: readerRepo,
-		UserRepository:   userRepo,
-		Validator:        validator,
+		DB:                     db,
+		Config:                 cfg,
+		Cache:                  cacheInstance,
+		BookRepository:         bookRepo,
+		ReaderRepository:       readerRepo,
+		UserRepository:         userRepo,
+		TokenRepository:        tokenRepo,
+		UserTokenRepository:    userTokenRepo,
+		APIKeyRepository:       apiKeyRepo,
+		LoginAttemptRepository: loginAttemptRepo,
+		AuditRepository:        auditRepo,
+		LoanRepository:         loanRepo,
+		HoldRepository:         holdRepo,
+		AccountRepository:      accountRepo,
+		ItemRepository:         itemRepo,
+		AuthorRepository:       authorRepo,
+		PublisherRepository:    publisherRepo,
+		SubjectRepository:      subjectRepo,
+		UnitOfWork:             uow,
+		BookService:            service.NewBookService(uow),
+		ReaderService:          service.NewReaderService(uow, cfg),
+		LoanService:            service.NewLoanService(uow, cfg),
+		HoldService:            service.NewHoldService(uow, cfg),
+		ItemService:            service.NewItemService(uow, cfg),
+		AccountService:         service.NewAccountService(uow),
+		Validator:              validator,
+		Mailer:                 mail,
+		Policy:                 policy,
+		Keys:                   keys,
+		OIDC:                   oidcProvider,
 	}, nil
 }
 
+// openDatabase connects to the database selected by database_driver and
+// applies the connection pool settings
+func openDatabase(cfg *config.Config) (*gorm.DB, error) {
+	var dialector gorm.Dialector
+	switch cfg.DatabaseDriver {
+	case "sqlite":
+		dialector = sqlite.Open(cfg.DatabaseDSN)
+	case "postgres":
+		dialector = postgres.Open(cfg.DatabaseDSN)
+	default:
+		return nil, fmt.Errorf("invalid database_driver %q, expected \"sqlite\" or \"postgres\"", cfg.DatabaseDriver)
+	}
+
+	db, err := gorm.Open(dialector, &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent), // Suppress "record not found" logs
+	})
+	if err != nil {
+		return nil, fmt.Errorf("opening %s database: %w", cfg.DatabaseDriver, err)
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	sqlDB.SetMaxOpenConns(cfg.DatabaseMaxOpenConns)
+	sqlDB.SetMaxIdleConns(cfg.DatabaseMaxIdleConns)
+	sqlDB.SetConnMaxLifetime(time.Duration(cfg.DatabaseConnMaxLifetimeMinutes) * time.Minute)
+	log.Printf("Connected to %s database", cfg.DatabaseDriver)
+	return db, nil
+}
+
 func (c *Container) Close() error {
 	sqlDB, err := c.DB.DB()
 	if err != nil {
@@ -72,6 +217,38 @@ func (c *Container) Close() error {
 	return sqlDB.Close()
 }
 
+// newOIDCProvider returns the identity provider for single sign-on, or nil if
+// it is not configured
+func newOIDCProvider(cfg *config.Config, policy *rbac.Policy) (*oidc.Provider, error) {
+	if !cfg.OIDCEnabled() {
+		return nil, nil
+	}
+	if cfg.OIDCClientID == "" {
+		return nil, fmt.Errorf("oidc_client_id is required")
+	}
+	for _, mapping := range cfg.OIDCRoleMapping {
+		if !policy.IsRole(mapping.Role) {
+			return nil, fmt.Errorf("oidc_role_mapping: role %q is not defined", mapping.Role)
+		}
+	}
+
+	redirectURL := cfg.OIDCRedirectURL
+	if redirectURL == "" {
+		redirectURL = strings.TrimRight(cfg.AppBaseURL, "/") + "/auth/oidc/callback"
+	}
+	log.Printf("Single sign-on with %s enabled, callback %s", cfg.OIDCIssuer, redirectURL)
+	return oidc.NewProvider(oidc.Config{
+		Issuer:       cfg.OIDCIssuer,
+		ClientID:     cfg.OIDCClientID,
+		ClientSecret: cfg.OIDCClientSecret,
+		RedirectURL:  redirectURL,
+		Scopes:       cfg.OIDCScopes,
+	}), nil
+}
+
+// adminRole is the role of the seeded admin user, so it must always be defined
+const adminRole = "admin"
+
 // seedAdminUser creates default admin user if it doesn't exist
 func seedAdminUser(db *gorm.DB) {
 	var adminUser models.User
@@ -79,10 +256,12 @@ func seedAdminUser(db *gorm.DB) {
 
 	if result.Error == gorm.ErrRecordNotFound {
 		// Create admin user
+		now := time.Now()
 		adminUser = models.User{
-			Username: "admin",
-			Email:    "admin@example.com",
-			Role:     "admin",
+			Username:        "admin",
+			Email:           "admin@example.com",
+			Role:            adminRole,
+			EmailVerifiedAt: &now,
 		}
 		if err := adminUser.HashPassword("password"); err != nil {
 			log.Printf("Failed to hash admin password: %v", err)
@@ -95,3 +274,28 @@ func seedAdminUser(db *gorm.DB) {
 		log.Println("✓ Default admin user created (username: admin, password: password)")
 	}
 }
+
+// migrateLegacyRoles moves users of the former "user" role, which every
+// registered user had, to the default role, and warns about users whose role
+// is not defined, since they have no permissions
+func migrateLegacyRoles(db *gorm.DB, policy *rbac.Policy, defaultRole string) {
+	if !policy.IsRole("user") {
+		result := db.Model(&models.User{}).Where("role = ?", "user").Update("role", defaultRole)
+		if result.Error != nil {
+			log.Printf("Failed to migrate users to the %s role: %v", defaultRole, result.Error)
+		} else if result.RowsAffected > 0 {
+			log.Printf("Moved %d users from the user role to the %s role", result.RowsAffected, defaultRole)
+		}
+	}
+
+	var roles []string
+	if err := db.Model(&models.User{}).Distinct().Pluck("role", &roles).Error; err != nil {
+		log.Printf("Failed to check user roles: %v", err)
+		return
+	}
+	for _, role := range roles {
+		if !policy.IsRole(role) {
+			log.Printf("Warning: users have the undefined role %q and no permissions", role)
+		}
+	}
+}
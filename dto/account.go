@@ -0,0 +1,25 @@
+package dto
+
+import "time"
+
+type AccountTransactionCreateDTO struct {
+	AmountCents int64  `json:"amount_cents" validate:"required,gt=0"`
+	Note        string `json:"note" validate:"max=255"`
+}
+
+type AccountTransactionResponseDTO struct {
+	ID          uint      `json:"id"`
+	Type        string    `json:"type"`
+	AmountCents int64     `json:"amount_cents"`
+	LoanID      *uint     `json:"loan_id"`
+	Note        string    `json:"note"`
+	CreatedBy   string    `json:"created_by,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type AccountResponseDTO struct {
+	ReaderID     uint                            `json:"reader_id"`
+	BalanceCents int64                           `json:"balance_cents"`
+	Blocked      bool                            `json:"blocked"` // reader may not borrow until the balance is paid down
+	Transactions []AccountTransactionResponseDTO `json:"transactions"`
+}
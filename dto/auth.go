@@ -1,5 +1,11 @@
 package dto
 
+import (
+	"encoding/json"
+	"lab1/rbac"
+	"time"
+)
+
 type RegisterRequest struct {
 	Username string `json:"username" binding:"required,min=3,max=50"`
 	Email    string `json:"email" binding:"required,email"`
@@ -11,17 +17,173 @@ type LoginRequest struct {
 	Password string `json:"password" binding:"required"`
 }
 
+// RefreshRequest exchanges a refresh token for a new access and refresh token
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// LogoutRequest may name the refresh token of the session to end; without it
+// the session of the access token is ended
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required,min=6"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
 type AuthResponse struct {
-	Token    string `json:"token"`
-	UserID   uint   `json:"user_id"`
-	Username string `json:"username"`
-	Email    string `json:"email"`
-	Role     string `json:"role"`
+	Token         string            `json:"token"` // access token
+	RefreshToken  string            `json:"refresh_token"`
+	ExpiresIn     int64             `json:"expires_in"` // lifetime of the access token in seconds
+	UserID        uint              `json:"user_id"`
+	Username      string            `json:"username"`
+	Email         string            `json:"email"`
+	EmailVerified bool              `json:"email_verified"`
+	Role          string            `json:"role"`
+	Permissions   []rbac.Permission `json:"permissions"` // granted by the role
 }
 
 type UserResponse struct {
-	ID       uint   `json:"id"`
-	Username string `json:"username"`
-	Email    string `json:"email"`
-	Role     string `json:"role"`
+	ID               uint              `json:"id"`
+	Username         string            `json:"username"`
+	Email            string            `json:"email"`
+	EmailVerified    bool              `json:"email_verified"`
+	TwoFactorEnabled bool              `json:"two_factor_enabled"`
+	Role             string            `json:"role"`
+	Permissions      []rbac.Permission `json:"permissions"`
+}
+
+// TwoFactorChallengeResponse is the login response of users with two-factor
+// authentication; the challenge token and a code are exchanged at /auth/2fa/login
+type TwoFactorChallengeResponse struct {
+	TwoFactorRequired bool   `json:"two_factor_required"`
+	ChallengeToken    string `json:"challenge_token"`
+	ExpiresIn         int64  `json:"expires_in"` // seconds
+}
+
+type TwoFactorLoginRequest struct {
+	ChallengeToken string `json:"challenge_token" binding:"required"`
+	Code           string `json:"code" binding:"required"` // authenticator code or recovery code
+}
+
+// OIDCTokenRequest redeems the login code of a single sign-on callback
+type OIDCTokenRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type TwoFactorSetupResponse struct {
+	Secret     string `json:"secret"` // base32, for entering the key by hand
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+// TwoFactorCodeRequest carries a code of the authenticator app
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type TwoFactorDisableRequest struct {
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code" binding:"required"` // authenticator code or recovery code
+}
+
+// RecoveryCodesResponse is the only time the recovery codes are shown
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// AdminUserResponse is a user as administrators see them
+type AdminUserResponse struct {
+	UserResponse
+	CreatedAt             time.Time  `json:"created_at"`
+	DisabledAt            *time.Time `json:"disabled_at"`
+	PasswordResetRequired bool       `json:"password_reset_required"`
+}
+
+type UserListResponse struct {
+	Data  []AdminUserResponse `json:"data"`
+	Meta  PageMetaDTO         `json:"meta"`
+	Links PageLinksDTO        `json:"links"`
+}
+
+// LoginAttemptResponse is a password login as recorded for administrators
+type LoginAttemptResponse struct {
+	ID        uint      `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Username  string    `json:"username"`
+	UserID    *uint     `json:"user_id"` // null for unknown usernames
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Result    string    `json:"result"` // success, failure or throttled
+}
+
+type LoginAttemptListResponse struct {
+	Data  []LoginAttemptResponse `json:"data"`
+	Meta  PageMetaDTO            `json:"meta"`
+	Links PageLinksDTO           `json:"links"`
+}
+
+// AuditEventResponse is a change recorded in the audit log. Before and After
+// are JSON snapshots of the entity, null where it did not exist.
+type AuditEventResponse struct {
+	ID         uint            `json:"id"`
+	CreatedAt  time.Time       `json:"created_at"`
+	ActorID    *uint           `json:"actor_id"` // null for anonymous requests
+	ActorName  string          `json:"actor_name"`
+	Action     string          `json:"action"`
+	EntityType string          `json:"entity_type"`
+	EntityID   *uint           `json:"entity_id"` // null for actions on many entities
+	Before     json.RawMessage `json:"before" swaggertype:"object"`
+	After      json.RawMessage `json:"after" swaggertype:"object"`
+	IP         string          `json:"ip"`
+	RequestID  string          `json:"request_id"`
+}
+
+type AuditEventListResponse struct {
+	Data  []AuditEventResponse `json:"data"`
+	Meta  PageMetaDTO          `json:"meta"`
+	Links PageLinksDTO         `json:"links"`
+}
+
+// RoleAssignRequest changes the role of a user
+type RoleAssignRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+type RoleResponse struct {
+	Name        string            `json:"name"`
+	Permissions []rbac.Permission `json:"permissions"`
+}
+
+// APIKeyCreateRequest creates a key limited to the given permissions, which the
+// owner's role must grant
+type APIKeyCreateRequest struct {
+	Name          string   `json:"name" binding:"required,max=100"`
+	Scopes        []string `json:"scopes" binding:"required,min=1,dive,required"`
+	ExpiresInDays *int     `json:"expires_in_days" binding:"omitempty,min=1,max=3650"` // omitted for a key that does not expire
+}
+
+type APIKeyResponse struct {
+	ID         uint              `json:"id"`
+	Name       string            `json:"name"`
+	Prefix     string            `json:"prefix"`
+	Scopes     []rbac.Permission `json:"scopes"`
+	CreatedAt  time.Time         `json:"created_at"`
+	ExpiresAt  *time.Time        `json:"expires_at"`
+	LastUsedAt *time.Time        `json:"last_used_at"`
+}
+
+// APIKeyCreatedResponse is the only time the key itself is shown
+type APIKeyCreatedResponse struct {
+	APIKeyResponse
+	Key string `json:"key"`
 }
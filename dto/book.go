@@ -1,19 +1,74 @@
 package dto
 
+import "time"
+
 type BookCreateDTO struct {
-	Title       string `json:"title" validate:"required,min=1,max=255"`
-	Description string `json:"description" validate:"max=1000"`
+	Title        string `json:"title" validate:"required,min=1,max=255,nocontrol"`
+	Description  string `json:"description" validate:"max=1000,nocontrol"`
+	ISBN         string `json:"isbn" validate:"omitempty,isbn"` // ISBN-10 or ISBN-13, hyphens allowed
+	AuthorIDs    []uint `json:"author_ids" validate:"dive,gt=0"`
+	PublisherIDs []uint `json:"publisher_ids" validate:"dive,gt=0"`
+	SubjectIDs   []uint `json:"subject_ids" validate:"dive,gt=0"`
 }
 
 type BookUpdateDTO struct {
-	Title       string `json:"title" validate:"required,min=1,max=255"`
-	Description string `json:"description" validate:"max=1000"`
+	Title        string `json:"title" validate:"required,min=1,max=255,nocontrol"`
+	Description  string `json:"description" validate:"max=1000,nocontrol"`
+	ISBN         string `json:"isbn" validate:"omitempty,isbn"` // ISBN-10 or ISBN-13, hyphens allowed
+	AuthorIDs    []uint `json:"author_ids" validate:"dive,gt=0"`
+	PublisherIDs []uint `json:"publisher_ids" validate:"dive,gt=0"`
+	SubjectIDs   []uint `json:"subject_ids" validate:"dive,gt=0"`
 }
 
 type BookResponseDTO struct {
-	ID          uint   `json:"id"`
-	Title       string `json:"title"`
-	Description string `json:"description"`
-	UserID      uint   `json:"user_id"`
-	Username    string `json:"username"`
+	ID              uint              `json:"id"`
+	Title           string            `json:"title"`
+	Description     string            `json:"description"`
+	ISBN            string            `json:"isbn,omitempty"`   // ISBN-13
+	ISBN10          string            `json:"isbn10,omitempty"` // only for 978-prefixed ISBNs
+	UserID          uint              `json:"user_id"`
+	Username        string            `json:"username"`
+	Authors         []NameResponseDTO `json:"authors"`
+	Publishers      []NameResponseDTO `json:"publishers"`
+	Subjects        []NameResponseDTO `json:"subjects"`
+	AvailableCopies int64             `json:"available_copies"`
+	TotalCopies     int64             `json:"total_copies"`
+	CreatedAt       time.Time         `json:"created_at"`
+}
+
+// BookSearchResultDTO is a search hit. TitleHighlight and Snippet are HTML-escaped
+// with the matched words wrapped in <mark> tags.
+type BookSearchResultDTO struct {
+	Book           BookResponseDTO `json:"book"`
+	Rank           float64         `json:"rank"`
+	TitleHighlight string          `json:"title_highlight"`
+	Snippet        string          `json:"snippet"`
+}
+
+type BookSearchResponseDTO struct {
+	Data  []BookSearchResultDTO `json:"data"`
+	Meta  PageMetaDTO           `json:"meta"`
+	Links PageLinksDTO          `json:"links"`
+}
+
+type BookListResponseDTO struct {
+	Data  []BookResponseDTO `json:"data"`
+	Meta  PageMetaDTO       `json:"meta"`
+	Links PageLinksDTO      `json:"links"`
+}
+
+// BookImportErrorDTO is a problem with one row of an import. Row is the line
+// number in a CSV file, the header being line 1, or the position of the record
+// in a MARC file, starting at 1.
+type BookImportErrorDTO struct {
+	Row     int    `json:"row"`
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type BookImportResultDTO struct {
+	DryRun   bool                 `json:"dry_run"`
+	Rows     int                  `json:"rows"`
+	Imported int                  `json:"imported"`
+	Errors   []BookImportErrorDTO `json:"errors"`
 }
@@ -0,0 +1,20 @@
+package dto
+
+import "time"
+
+type HoldCreateDTO struct {
+	ReaderID uint `json:"reader_id" validate:"required"`
+}
+
+type HoldResponseDTO struct {
+	ID         uint       `json:"id"`
+	ReaderID   uint       `json:"reader_id"`
+	ReaderName string     `json:"reader_name"`
+	BookID     uint       `json:"book_id"`
+	BookTitle  string     `json:"book_title"`
+	Status     string     `json:"status"`
+	Position   int        `json:"position,omitempty"` // place in the queue, only for active holds
+	CreatedAt  time.Time  `json:"created_at"`
+	ReadyAt    *time.Time `json:"ready_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+}
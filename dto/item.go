@@ -0,0 +1,30 @@
+package dto
+
+import "time"
+
+type ItemCreateDTO struct {
+	Barcode       string     `json:"barcode" validate:"required,max=64"`
+	ShelfLocation string     `json:"shelf_location" validate:"max=64"`
+	Condition     string     `json:"condition" validate:"max=255"`
+	Status        string     `json:"status" validate:"omitempty,oneof=available lost in-repair withdrawn"`
+	AcquiredAt    *time.Time `json:"acquired_at"`
+}
+
+type ItemUpdateDTO struct {
+	Barcode       string     `json:"barcode" validate:"required,max=64"`
+	ShelfLocation string     `json:"shelf_location" validate:"max=64"`
+	Condition     string     `json:"condition" validate:"max=255"`
+	Status        string     `json:"status" validate:"required,oneof=available lost in-repair withdrawn"`
+	AcquiredAt    *time.Time `json:"acquired_at"`
+}
+
+type ItemResponseDTO struct {
+	ID            uint       `json:"id"`
+	BookID        uint       `json:"book_id"`
+	BookTitle     string     `json:"book_title"`
+	Barcode       string     `json:"barcode"`
+	ShelfLocation string     `json:"shelf_location"`
+	Condition     string     `json:"condition"`
+	Status        string     `json:"status"`
+	AcquiredAt    *time.Time `json:"acquired_at"`
+}
@@ -0,0 +1,30 @@
+package dto
+
+import "time"
+
+// LoanCheckoutDTO identifies the copy to lend by barcode, item ID or book ID
+// (any available copy of the book)
+type LoanCheckoutDTO struct {
+	ReaderID uint       `json:"reader_id" validate:"required"`
+	BookID   uint       `json:"book_id" validate:"required_without_all=ItemID Barcode"`
+	ItemID   uint       `json:"item_id"`
+	Barcode  string     `json:"barcode" validate:"max=64"`
+	DueAt    *time.Time `json:"due_at"` // optional, defaults to loan_period_days from config
+}
+
+type LoanResponseDTO struct {
+	ID             uint       `json:"id"`
+	ReaderID       uint       `json:"reader_id"`
+	ReaderName     string     `json:"reader_name"`
+	BookID         uint       `json:"book_id"`
+	BookTitle      string     `json:"book_title"`
+	ItemID         *uint      `json:"item_id"`
+	Barcode        string     `json:"barcode,omitempty"`
+	CheckedOutAt   time.Time  `json:"checked_out_at"`
+	DueAt          time.Time  `json:"due_at"`
+	ReturnedAt     *time.Time `json:"returned_at"`
+	CheckedOutByID uint       `json:"checked_out_by_id"`
+	CheckedOutBy   string     `json:"checked_out_by"`
+	Status         string     `json:"status"`
+	FineCents      int64      `json:"fine_cents,omitempty"` // overdue charge, only reported on return
+}
@@ -0,0 +1,22 @@
+package dto
+
+// Authors, publishers and subjects consist of a name only and share their DTOs
+
+type NameCreateDTO struct {
+	Name string `json:"name" validate:"required,min=1,max=255"`
+}
+
+type NameUpdateDTO struct {
+	Name string `json:"name" validate:"required,min=1,max=255"`
+}
+
+type NameResponseDTO struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+}
+
+type NameListResponseDTO struct {
+	Data  []NameResponseDTO `json:"data"`
+	Meta  PageMetaDTO       `json:"meta"`
+	Links PageLinksDTO      `json:"links"`
+}
@@ -0,0 +1,17 @@
+package dto
+
+type PageMetaDTO struct {
+	Page       int   `json:"page"`
+	PageSize   int   `json:"page_size"`
+	Total      int64 `json:"total"`
+	TotalPages int   `json:"total_pages"`
+}
+
+// PageLinksDTO holds ready-to-follow URLs; Prev and Next are empty at the ends of the list
+type PageLinksDTO struct {
+	Self  string `json:"self"`
+	First string `json:"first"`
+	Last  string `json:"last"`
+	Prev  string `json:"prev,omitempty"`
+	Next  string `json:"next,omitempty"`
+}
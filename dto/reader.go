@@ -1,5 +1,7 @@
 package dto
 
+import "time"
+
 type ReaderCreateDTO struct {
 	Name    string `json:"name" validate:"required,min=1,max=100"`
 	Surname string `json:"surname" validate:"required,min=1,max=100"`
@@ -15,4 +17,11 @@ type ReaderResponseDTO struct {
 	Name             string            `json:"name"`
 	Surname          string            `json:"surname"`
 	CurrentlyReading []BookResponseDTO `json:"currently_reading"`
+	CreatedAt        time.Time         `json:"created_at"`
+}
+
+type ReaderListResponseDTO struct {
+	Data  []ReaderResponseDTO `json:"data"`
+	Meta  PageMetaDTO         `json:"meta"`
+	Links PageLinksDTO        `json:"links"`
 }
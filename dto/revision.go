@@ -0,0 +1,28 @@
+package dto
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// FieldChangeDTO is a field that differs from the previous revision
+type FieldChangeDTO struct {
+	Field string          `json:"field"`
+	From  json.RawMessage `json:"from" swaggertype:"object"` // null in the first revision
+	To    json.RawMessage `json:"to" swaggertype:"object"`
+}
+
+// RevisionDTO is a saved version of a book or reader: its fields in Data and
+// what changed compared to the revision before it
+type RevisionDTO struct {
+	Revision  int              `json:"revision"`
+	CreatedAt time.Time        `json:"created_at"`
+	Data      json.RawMessage  `json:"data" swaggertype:"object"`
+	Changes   []FieldChangeDTO `json:"changes"`
+}
+
+type RevisionListResponseDTO struct {
+	Data  []RevisionDTO `json:"data"`
+	Meta  PageMetaDTO   `json:"meta"`
+	Links PageLinksDTO  `json:"links"`
+}
@@ -0,0 +1,35 @@
+package dto
+
+import "time"
+
+// TrashedBookDTO is a deleted book; PurgeAt is when it is purged automatically,
+// absent when trash_retention_days is 0
+type TrashedBookDTO struct {
+	BookResponseDTO
+	DeletedAt time.Time  `json:"deleted_at"`
+	PurgeAt   *time.Time `json:"purge_at,omitempty"`
+}
+
+type TrashedBookListResponseDTO struct {
+	Data  []TrashedBookDTO `json:"data"`
+	Meta  PageMetaDTO      `json:"meta"`
+	Links PageLinksDTO     `json:"links"`
+}
+
+// TrashedReaderDTO is a deleted reader, see TrashedBookDTO
+type TrashedReaderDTO struct {
+	ReaderResponseDTO
+	DeletedAt time.Time  `json:"deleted_at"`
+	PurgeAt   *time.Time `json:"purge_at,omitempty"`
+}
+
+type TrashedReaderListResponseDTO struct {
+	Data  []TrashedReaderDTO `json:"data"`
+	Meta  PageMetaDTO        `json:"meta"`
+	Links PageLinksDTO       `json:"links"`
+}
+
+// PurgeResponseDTO reports how many records emptying the trash removed
+type PurgeResponseDTO struct {
+	Purged int64 `json:"purged"`
+}
@@ -0,0 +1,30 @@
+package fines
+
+import "time"
+
+// DaysLate returns the number of started days between the due date and the return
+func DaysLate(dueAt, returnedAt time.Time) int64 {
+	late := returnedAt.Sub(dueAt)
+	if late <= 0 {
+		return 0
+	}
+	days := int64(late / (24 * time.Hour))
+	if late%(24*time.Hour) != 0 {
+		days++
+	}
+	return days
+}
+
+// Calculate returns the overdue charge in cents for a book that was due at dueAt
+// and came back at returnedAt. Every started day past the due date costs
+// dailyRateCents; the total never exceeds maxCents (a non-positive max means no cap).
+func Calculate(dueAt, returnedAt time.Time, dailyRateCents, maxCents int64) int64 {
+	if dailyRateCents <= 0 {
+		return 0
+	}
+	amount := DaysLate(dueAt, returnedAt) * dailyRateCents
+	if maxCents > 0 && amount > maxCents {
+		amount = maxCents
+	}
+	return amount
+}
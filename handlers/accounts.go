@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"lab1/config"
+	"lab1/dto"
+	"lab1/models"
+	"lab1/repository"
+	"lab1/service"
+	"lab1/validation"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type AccountsHandler struct {
+	accounts   *service.AccountService
+	repo       repository.AccountRepository
+	readerRepo repository.ReaderRepository
+	validator  *validation.Validator
+	config     *config.Config
+}
+
+func NewAccountsHandler(accounts *service.AccountService, repo repository.AccountRepository, readerRepo repository.ReaderRepository, validator *validation.Validator, config *config.Config) *AccountsHandler {
+	return &AccountsHandler{accounts: accounts, repo: repo, readerRepo: readerRepo, validator: validator, config: config}
+}
+
+func transactionToResponse(transaction *models.AccountTransaction) dto.AccountTransactionResponseDTO {
+	response := dto.AccountTransactionResponseDTO{
+		ID:          transaction.ID,
+		Type:        transaction.Type,
+		AmountCents: transaction.AmountCents,
+		LoanID:      transaction.LoanID,
+		Note:        transaction.Note,
+		CreatedAt:   transaction.CreatedAt,
+	}
+	if transaction.CreatedBy != nil {
+		response.CreatedBy = transaction.CreatedBy.Username
+	}
+	return response
+}
+
+// findReader loads the reader from the :id path parameter, writing the error response itself
+func (h *AccountsHandler) findReader(c *gin.Context) (*models.Reader, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return nil, false
+	}
+
+	reader, err := h.readerRepo.FindByID(c.Request.Context(), uint(id))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Reader not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve reader"})
+		}
+		return nil, false
+	}
+	return reader, true
+}
+
+// @Summary Get reader account with balance and transactions
+// @Tags accounts
+// @Produce json
+// @Param id path int true "Reader ID"
+// @Success 200 {object} dto.AccountResponseDTO
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /readers/{id}/account [get]
+func (h *AccountsHandler) GetAccount(c *gin.Context) {
+	reader, ok := h.findReader(c)
+	if !ok {
+		return
+	}
+
+	transactions, err := h.repo.FindByReader(c.Request.Context(), reader.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve transactions"})
+		return
+	}
+
+	response := dto.AccountResponseDTO{
+		ReaderID:     reader.ID,
+		Transactions: make([]dto.AccountTransactionResponseDTO, len(transactions)),
+	}
+	for i := range transactions {
+		response.BalanceCents += transactions[i].SignedAmount()
+		response.Transactions[i] = transactionToResponse(&transactions[i])
+	}
+	response.Blocked = response.BalanceCents > h.config.FineBlockThresholdCents
+
+	c.JSON(http.StatusOK, response)
+}
+
+// @Summary Record a payment from a reader
+// @Tags accounts
+// @Accept json
+// @Produce json
+// @Param id path int true "Reader ID"
+// @Param payment body dto.AccountTransactionCreateDTO true "Payment"
+// @Success 201 {object} dto.AccountTransactionResponseDTO
+// @Failure 400 {object} map[string]interface{}
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /readers/{id}/account/payments [post]
+func (h *AccountsHandler) CreatePayment(c *gin.Context) {
+	h.createCredit(c, models.TransactionPayment)
+}
+
+// @Summary Waive part of a reader's fines
+// @Description Requires the accounts:waive permission
+// @Tags accounts
+// @Accept json
+// @Produce json
+// @Param id path int true "Reader ID"
+// @Param waiver body dto.AccountTransactionCreateDTO true "Waiver"
+// @Success 201 {object} dto.AccountTransactionResponseDTO
+// @Failure 400 {object} map[string]interface{}
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /readers/{id}/account/waivers [post]
+func (h *AccountsHandler) CreateWaiver(c *gin.Context) {
+	h.createCredit(c, models.TransactionWaiver)
+}
+
+// createCredit records a payment or waiver, which may not exceed the outstanding balance
+func (h *AccountsHandler) createCredit(c *gin.Context, transactionType string) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	readerID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	var transactionDTO dto.AccountTransactionCreateDTO
+	if err := c.ShouldBindJSON(&transactionDTO); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON", "details": err.Error()})
+		return
+	}
+
+	if err := h.validator.ValidateStruct(transactionDTO); err != nil {
+		c.JSON(http.StatusBadRequest, validation.FormatValidationErrors(err))
+		return
+	}
+
+	createdByID := userID.(uint)
+	transaction := models.AccountTransaction{
+		ReaderID:    uint(readerID),
+		Type:        transactionType,
+		AmountCents: transactionDTO.AmountCents,
+		Note:        transactionDTO.Note,
+		CreatedByID: &createdByID,
+	}
+
+	if err := h.accounts.Credit(c.Request.Context(), &transaction); err != nil {
+		var exceeded *service.BalanceExceededError
+		switch {
+		case writeNotFound(c, err):
+		case errors.As(err, &exceeded):
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Amount exceeds outstanding balance of %d cents", exceeded.Balance)})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record transaction"})
+		}
+		return
+	}
+
+	username, _ := c.Get("username")
+	transaction.CreatedBy = &models.User{Username: username.(string)}
+	c.JSON(http.StatusCreated, transactionToResponse(&transaction))
+}
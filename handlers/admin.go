@@ -0,0 +1,552 @@
+package handlers
+
+import (
+	"errors"
+	"lab1/config"
+	"lab1/dto"
+	"lab1/mailer"
+	"lab1/models"
+	"lab1/rbac"
+	"lab1/repository"
+	"lab1/validation"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type AdminHandler struct {
+	userRepo         repository.UserRepository
+	tokenRepo        repository.TokenRepository
+	userTokenRepo    repository.UserTokenRepository
+	loginAttemptRepo repository.LoginAttemptRepository
+	auditRepo        repository.AuditRepository
+	audit            auditLog
+	mails            accountMails
+	policy           *rbac.Policy
+	validator        *validation.Validator
+	config           *config.Config
+}
+
+func NewAdminHandler(userRepo repository.UserRepository, tokenRepo repository.TokenRepository, userTokenRepo repository.UserTokenRepository, loginAttemptRepo repository.LoginAttemptRepository, auditRepo repository.AuditRepository, mailer mailer.Mailer, policy *rbac.Policy, validator *validation.Validator, config *config.Config) *AdminHandler {
+	return &AdminHandler{
+		userRepo:         userRepo,
+		tokenRepo:        tokenRepo,
+		userTokenRepo:    userTokenRepo,
+		loginAttemptRepo: loginAttemptRepo,
+		auditRepo:        auditRepo,
+		audit:            auditLog{repo: auditRepo},
+		mails:            accountMails{userTokenRepo: userTokenRepo, mailer: mailer, config: config},
+		policy:           policy,
+		validator:        validator,
+		config:           config,
+	}
+}
+
+func adminUserToResponse(user *models.User, policy *rbac.Policy) dto.AdminUserResponse {
+	return dto.AdminUserResponse{
+		UserResponse:          userToResponse(user, policy),
+		CreatedAt:             user.CreatedAt,
+		DisabledAt:            user.DisabledAt,
+		PasswordResetRequired: user.PasswordResetRequired,
+	}
+}
+
+// load fetches the user named by the id path parameter, writing the error response if that fails
+func (h *AdminHandler) load(c *gin.Context) (*models.User, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return nil, false
+	}
+
+	user, err := h.userRepo.GetByID(c.Request.Context(), uint(id))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user"})
+		}
+		return nil, false
+	}
+	return user, true
+}
+
+// loadOther is load for actions an admin must not apply to themselves, since
+// they could lock out the last admin
+func (h *AdminHandler) loadOther(c *gin.Context, action string) (*models.User, bool) {
+	user, ok := h.load(c)
+	if !ok {
+		return nil, false
+	}
+	if user.ID == c.GetUint("user_id") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot " + action + " your own account"})
+		return nil, false
+	}
+	return user, true
+}
+
+// @Summary List users
+// @Tags admin
+// @Security BearerAuth
+// @Produce json
+// @Param q query string false "Search in username and email"
+// @Param role query string false "Only users with this role"
+// @Param status query string false "Only active or disabled users" Enums(active, disabled)
+// @Param created_after query string false "Only users registered after this RFC 3339 timestamp or YYYY-MM-DD date"
+// @Param sort query string false "Sort field" Enums(id, username, email, role, created_at)
+// @Param order query string false "Sort order" Enums(asc, desc)
+// @Param page query int false "Page number, starting at 1"
+// @Param page_size query int false "Page size"
+// @Success 200 {object} dto.UserListResponse
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/users [get]
+func (h *AdminHandler) GetUsers(c *gin.Context) {
+	query, ok := parseListQuery(c, h.config, repository.UserSortFields)
+	if !ok {
+		return
+	}
+
+	query.Role = c.Query("role")
+	if query.Role != "" && !h.policy.IsRole(query.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown role", "roles": h.policy.Roles()})
+		return
+	}
+	query.Status = c.Query("status")
+	if query.Status != "" && query.Status != "active" && query.Status != "disabled" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status, expected active or disabled"})
+		return
+	}
+
+	page, err := h.userRepo.FindPage(c.Request.Context(), query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve users"})
+		return
+	}
+
+	response := dto.UserListResponse{Data: make([]dto.AdminUserResponse, len(page.Users))}
+	for i := range page.Users {
+		response.Data[i] = adminUserToResponse(&page.Users[i], h.policy)
+	}
+	response.Meta, response.Links = pageResponse(c, query, page.Total)
+	c.JSON(http.StatusOK, response)
+}
+
+// @Summary Get user by ID
+// @Tags admin
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} dto.AdminUserResponse
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/users/{id} [get]
+func (h *AdminHandler) GetUser(c *gin.Context) {
+	user, ok := h.load(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, adminUserToResponse(user, h.policy))
+}
+
+// @Summary List roles and their permissions
+// @Tags admin
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {array} dto.RoleResponse
+// @Failure 403 {object} map[string]string
+// @Router /admin/roles [get]
+func (h *AdminHandler) GetRoles(c *gin.Context) {
+	roles := h.policy.Roles()
+	response := make([]dto.RoleResponse, len(roles))
+	for i, role := range roles {
+		response[i] = dto.RoleResponse{Name: role, Permissions: h.policy.Permissions(role)}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// @Summary Assign a role to a user
+// @Description The new role applies to the user's next request. Users cannot change their own role.
+// @Tags admin
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param role body dto.RoleAssignRequest true "New role"
+// @Success 200 {object} dto.AdminUserResponse
+// @Failure 400 {object} map[string]interface{}
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/users/{id}/role [put]
+func (h *AdminHandler) AssignRole(c *gin.Context) {
+	var req dto.RoleAssignRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, validation.FormatValidationErrors(err))
+		return
+	}
+	if !h.policy.IsRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown role", "roles": h.policy.Roles()})
+		return
+	}
+
+	user, ok := h.loadOther(c, "change the role of")
+	if !ok {
+		return
+	}
+
+	before := snapshotUser(user)
+	previous := user.Role
+	user.Role = req.Role
+	if err := h.userRepo.Update(c.Request.Context(), user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update role"})
+		return
+	}
+	log.Printf("AdminHandler.AssignRole: user ID=%d changed role of user ID=%d from %s to %s", c.GetUint("user_id"), user.ID, previous, user.Role)
+	h.audit.record(c, "assign_role", "user", user.ID, before, snapshotUser(user))
+
+	c.JSON(http.StatusOK, adminUserToResponse(user, h.policy))
+}
+
+// @Summary Disable a user
+// @Description A disabled user cannot log in, and all their sessions and tokens are revoked
+// @Tags admin
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} dto.AdminUserResponse
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/users/{id}/disable [post]
+func (h *AdminHandler) Disable(c *gin.Context) {
+	user, ok := h.loadOther(c, "disable")
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	if !user.IsDisabled() {
+		before := snapshotUser(user)
+		user.DisabledAt = &now
+		if err := h.userRepo.Update(c.Request.Context(), user); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable user"})
+			return
+		}
+		log.Printf("AdminHandler.Disable: user ID=%d disabled user ID=%d", c.GetUint("user_id"), user.ID)
+		h.audit.record(c, "disable", "user", user.ID, before, snapshotUser(user))
+	}
+	if err := h.tokenRepo.RevokeUser(c.Request.Context(), user.ID, now); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke tokens"})
+		return
+	}
+
+	c.JSON(http.StatusOK, adminUserToResponse(user, h.policy))
+}
+
+// @Summary Enable a disabled user
+// @Tags admin
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} dto.AdminUserResponse
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/users/{id}/enable [post]
+func (h *AdminHandler) Enable(c *gin.Context) {
+	user, ok := h.load(c)
+	if !ok {
+		return
+	}
+
+	if user.IsDisabled() {
+		before := snapshotUser(user)
+		user.DisabledAt = nil
+		if err := h.userRepo.Update(c.Request.Context(), user); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable user"})
+			return
+		}
+		log.Printf("AdminHandler.Enable: user ID=%d enabled user ID=%d", c.GetUint("user_id"), user.ID)
+		h.audit.record(c, "enable", "user", user.ID, before, snapshotUser(user))
+	}
+
+	c.JSON(http.StatusOK, adminUserToResponse(user, h.policy))
+}
+
+// @Summary Force a password reset
+// @Description Ends all sessions of the user, blocks logging in with the current password and mails a reset link.
+// @Description If the mail is lost the user can request a new one with POST /auth/password/forgot.
+// @Tags admin
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 202 {object} dto.AdminUserResponse
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/users/{id}/password-reset [post]
+func (h *AdminHandler) ForcePasswordReset(c *gin.Context) {
+	user, ok := h.loadOther(c, "force a password reset of")
+	if !ok {
+		return
+	}
+
+	before := snapshotUser(user)
+	user.PasswordResetRequired = true
+	if err := h.userRepo.Update(c.Request.Context(), user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+		return
+	}
+	if err := h.tokenRepo.RevokeUser(c.Request.Context(), user.ID, time.Now()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke tokens"})
+		return
+	}
+	log.Printf("AdminHandler.ForcePasswordReset: user ID=%d forced a password reset of user ID=%d", c.GetUint("user_id"), user.ID)
+	h.audit.record(c, "force_password_reset", "user", user.ID, before, snapshotUser(user))
+
+	if err := h.mails.sendPasswordReset(c.Request.Context(), user, "an administrator asked you to choose a new password for your account."); err != nil {
+		log.Printf("AdminHandler.ForcePasswordReset: failed to send reset mail to user ID=%d: %v", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Password reset is required, but the reset mail could not be sent"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, adminUserToResponse(user, h.policy))
+}
+
+// @Summary Reset two-factor authentication of a user
+// @Description For users who lost their authenticator app and recovery codes. Removes the secret and the recovery codes;
+// @Description users of roles that require two-factor authentication must enrol again before using the API.
+// @Tags admin
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} dto.AdminUserResponse
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/users/{id}/2fa/reset [post]
+func (h *AdminHandler) ResetTwoFactor(c *gin.Context) {
+	user, ok := h.loadOther(c, "reset two-factor authentication of")
+	if !ok {
+		return
+	}
+
+	before := snapshotUser(user)
+	if err := disableTwoFactor(c.Request.Context(), h.userRepo, h.userTokenRepo, user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset two-factor authentication"})
+		return
+	}
+	log.Printf("AdminHandler.ResetTwoFactor: user ID=%d reset two-factor authentication of user ID=%d", c.GetUint("user_id"), user.ID)
+	h.audit.record(c, "reset_2fa", "user", user.ID, before, snapshotUser(user))
+
+	c.JSON(http.StatusOK, adminUserToResponse(user, h.policy))
+}
+
+// @Summary Delete a user
+// @Description Revokes the user's sessions and deletes the account. Their books are kept, and the username and email stay reserved.
+// @Tags admin
+// @Security BearerAuth
+// @Param id path int true "User ID"
+// @Success 204
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/users/{id} [delete]
+func (h *AdminHandler) DeleteUser(c *gin.Context) {
+	user, ok := h.loadOther(c, "delete")
+	if !ok {
+		return
+	}
+
+	if err := h.tokenRepo.RevokeUser(c.Request.Context(), user.ID, time.Now()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke tokens"})
+		return
+	}
+	if err := h.userRepo.Delete(c.Request.Context(), user.ID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
+		}
+		return
+	}
+	log.Printf("AdminHandler.DeleteUser: user ID=%d deleted user ID=%d", c.GetUint("user_id"), user.ID)
+	h.audit.record(c, "delete", "user", user.ID, snapshotUser(user), nil)
+
+	c.Status(http.StatusNoContent)
+}
+
+// @Summary Unlock the login of a user
+// @Description Forget the failed logins of the user's username, which lifts a lockout and the back-off. Lockouts of IP addresses expire on their own.
+// @Tags admin
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} dto.AdminUserResponse
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/users/{id}/unlock [post]
+func (h *AdminHandler) Unlock(c *gin.Context) {
+	user, ok := h.load(c)
+	if !ok {
+		return
+	}
+
+	if err := h.loginAttemptRepo.ResetThrottle(c.Request.Context(), userThrottleKey(user.Username)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock user"})
+		return
+	}
+	log.Printf("AdminHandler.Unlock: user ID=%d unlocked the login of user ID=%d", c.GetUint("user_id"), user.ID)
+	h.audit.record(c, "unlock", "user", user.ID, nil, nil)
+
+	c.JSON(http.StatusOK, adminUserToResponse(user, h.policy))
+}
+
+// @Summary List login attempts
+// @Description Password logins, newest first by default, to spot guessing
+// @Tags admin
+// @Security BearerAuth
+// @Produce json
+// @Param q query string false "Search in username and IP address"
+// @Param result query string false "Only attempts with this result" Enums(success, failure, throttled)
+// @Param created_after query string false "Only attempts after this RFC 3339 timestamp or YYYY-MM-DD date"
+// @Param sort query string false "Sort field" Enums(id, created_at, username, ip, result)
+// @Param order query string false "Sort order" Enums(asc, desc)
+// @Param page query int false "Page number, starting at 1"
+// @Param page_size query int false "Page size"
+// @Success 200 {object} dto.LoginAttemptListResponse
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/login-attempts [get]
+func (h *AdminHandler) GetLoginAttempts(c *gin.Context) {
+	query, ok := parseListQuery(c, h.config, repository.LoginAttemptSortFields)
+	if !ok {
+		return
+	}
+	// The latest attempts are the interesting ones
+	if c.Query("sort") == "" && c.Query("order") == "" {
+		query.Sort, query.Order = "created_at", "desc"
+	}
+
+	query.Status = c.Query("result")
+	switch query.Status {
+	case "", models.LoginSucceeded, models.LoginFailed, models.LoginThrottled:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid result, expected success, failure or throttled"})
+		return
+	}
+
+	page, err := h.loginAttemptRepo.FindPage(c.Request.Context(), query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve login attempts"})
+		return
+	}
+
+	response := dto.LoginAttemptListResponse{Data: make([]dto.LoginAttemptResponse, len(page.Attempts))}
+	for i, attempt := range page.Attempts {
+		response.Data[i] = dto.LoginAttemptResponse{
+			ID:        attempt.ID,
+			CreatedAt: attempt.CreatedAt,
+			Username:  attempt.Username,
+			UserID:    attempt.UserID,
+			IP:        attempt.IP,
+			UserAgent: attempt.UserAgent,
+			Result:    attempt.Result,
+		}
+	}
+	response.Meta, response.Links = pageResponse(c, query, page.Total)
+	c.JSON(http.StatusOK, response)
+}
+
+// @Summary List the audit log
+// @Description Changes made through the API, newest first by default. Events cannot be changed or deleted.
+// @Tags admin
+// @Security BearerAuth
+// @Produce json
+// @Param actor_id query int false "Only changes by this user"
+// @Param action query string false "Only this action, e.g. create, update or delete"
+// @Param entity_type query string false "Only changes of this kind of entity" Enums(book, reader, user, api_key)
+// @Param entity_id query int false "Only changes of the entity with this ID"
+// @Param created_after query string false "Only changes after this RFC 3339 timestamp or YYYY-MM-DD date"
+// @Param created_before query string false "Only changes before this RFC 3339 timestamp or YYYY-MM-DD date"
+// @Param sort query string false "Sort field" Enums(id, created_at, actor_id, action, entity_type)
+// @Param order query string false "Sort order" Enums(asc, desc)
+// @Param page query int false "Page number, starting at 1"
+// @Param page_size query int false "Page size"
+// @Success 200 {object} dto.AuditEventListResponse
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/audit [get]
+func (h *AdminHandler) GetAuditEvents(c *gin.Context) {
+	query, ok := parseListQuery(c, h.config, repository.AuditEventSortFields)
+	if !ok {
+		return
+	}
+	if c.Query("sort") == "" && c.Query("order") == "" {
+		query.Sort, query.Order = "created_at", "desc"
+	}
+
+	query.Action = c.Query("action")
+	query.EntityType = c.Query("entity_type")
+	for param, target := range map[string]*uint{"actor_id": &query.ActorID, "entity_id": &query.EntityID} {
+		if raw := c.Query(param); raw != "" {
+			id, err := strconv.ParseUint(raw, 10, 32)
+			if err != nil || id == 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param})
+				return
+			}
+			*target = uint(id)
+		}
+	}
+	if raw := c.Query("created_before"); raw != "" {
+		createdBefore, err := parseTimeParam(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid created_before, expected RFC 3339 timestamp or YYYY-MM-DD date"})
+			return
+		}
+		query.CreatedBefore = &createdBefore
+	}
+
+	page, err := h.auditRepo.FindPage(c.Request.Context(), query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve audit log"})
+		return
+	}
+
+	response := dto.AuditEventListResponse{Data: make([]dto.AuditEventResponse, len(page.Events))}
+	for i, event := range page.Events {
+		response.Data[i] = dto.AuditEventResponse{
+			ID:         event.ID,
+			CreatedAt:  event.CreatedAt,
+			ActorID:    event.ActorID,
+			ActorName:  event.ActorName,
+			Action:     event.Action,
+			EntityType: event.EntityType,
+			EntityID:   event.EntityID,
+			Before:     auditSnapshotJSON(event.Before),
+			After:      auditSnapshotJSON(event.After),
+			IP:         event.IP,
+			RequestID:  event.RequestID,
+		}
+	}
+	response.Meta, response.Links = pageResponse(c, query, page.Total)
+	c.JSON(http.StatusOK, response)
+}
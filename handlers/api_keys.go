@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"errors"
+	"lab1/config"
+	"lab1/dto"
+	"lab1/middleware"
+	"lab1/models"
+	"lab1/rbac"
+	"lab1/repository"
+	"lab1/validation"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	apiKeyPrefix        = "lib_" // marks library keys, e.g. for secret scanners
+	apiKeyDisplayLength = 12     // characters of the key kept to tell keys apart
+)
+
+type APIKeysHandler struct {
+	repo      repository.APIKeyRepository
+	audit     auditLog
+	policy    *rbac.Policy
+	validator *validation.Validator
+	config    *config.Config
+}
+
+func NewAPIKeysHandler(repo repository.APIKeyRepository, auditRepo repository.AuditRepository, policy *rbac.Policy, validator *validation.Validator, config *config.Config) *APIKeysHandler {
+	return &APIKeysHandler{
+		repo:      repo,
+		audit:     auditLog{repo: auditRepo},
+		policy:    policy,
+		validator: validator,
+		config:    config,
+	}
+}
+
+func apiKeyToResponse(key *models.APIKey) dto.APIKeyResponse {
+	scopes := []rbac.Permission{}
+	for _, scope := range key.ScopeList() {
+		scopes = append(scopes, rbac.Permission(scope))
+	}
+	return dto.APIKeyResponse{
+		ID:         key.ID,
+		Name:       key.Name,
+		Prefix:     key.Prefix,
+		Scopes:     scopes,
+		CreatedAt:  key.CreatedAt,
+		ExpiresAt:  key.ExpiresAt,
+		LastUsedAt: key.LastUsedAt,
+	}
+}
+
+// GetAll godoc
+// @Summary List API keys
+// @Description List the API keys of the current user; the keys themselves are not shown again
+// @Tags auth
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {array} dto.APIKeyResponse
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/api-keys [get]
+func (h *APIKeysHandler) GetAll(c *gin.Context) {
+	keys, err := h.repo.FindByUser(c.Request.Context(), c.MustGet("user_id").(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve API keys"})
+		return
+	}
+
+	response := make([]dto.APIKeyResponse, 0, len(keys))
+	for i := range keys {
+		response = append(response, apiKeyToResponse(&keys[i]))
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Create godoc
+// @Summary Create an API key
+// @Description Create a key for "Authorization: ApiKey <key>". Its scopes must be permissions of the user's role; they limit what the key can do, also if the role changes later. The key is only shown in this response.
+// @Tags auth
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body dto.APIKeyCreateRequest true "Name, scopes and lifetime of the key"
+// @Success 201 {object} dto.APIKeyCreatedResponse
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/api-keys [post]
+func (h *APIKeysHandler) Create(c *gin.Context) {
+	var req dto.APIKeyCreateRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, validation.FormatValidationErrors(err))
+		return
+	}
+
+	role := c.GetString("role")
+	scopes := make([]string, 0, len(req.Scopes))
+	seen := make(map[string]bool, len(req.Scopes))
+	for _, scope := range req.Scopes {
+		if !h.policy.Has(role, rbac.Permission(scope)) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":       "Scope is not a permission of your role: " + scope,
+				"permissions": h.policy.Permissions(role),
+			})
+			return
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+
+	secret, err := middleware.RandomToken(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate API key"})
+		return
+	}
+	key := apiKeyPrefix + secret
+
+	apiKey := &models.APIKey{
+		UserID:  c.MustGet("user_id").(uint),
+		Name:    strings.TrimSpace(req.Name),
+		Prefix:  key[:apiKeyDisplayLength],
+		KeyHash: middleware.HashToken(key),
+		Scopes:  strings.Join(scopes, " "),
+	}
+	if req.ExpiresInDays != nil {
+		expiresAt := time.Now().AddDate(0, 0, *req.ExpiresInDays)
+		apiKey.ExpiresAt = &expiresAt
+	}
+	if err := h.repo.Create(c.Request.Context(), apiKey); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key"})
+		return
+	}
+	h.audit.record(c, "create", "api_key", apiKey.ID, nil, apiKeyToResponse(apiKey))
+
+	c.JSON(http.StatusCreated, dto.APIKeyCreatedResponse{
+		APIKeyResponse: apiKeyToResponse(apiKey),
+		Key:            key,
+	})
+}
+
+// Delete godoc
+// @Summary Revoke an API key
+// @Description Delete an API key of the current user; requests with it fail from now on
+// @Tags auth
+// @Security BearerAuth
+// @Param id path int true "API key ID"
+// @Success 204
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /auth/api-keys/{id} [delete]
+func (h *APIKeysHandler) Delete(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	if err := h.repo.Delete(c.Request.Context(), c.MustGet("user_id").(uint), uint(id)); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete API key"})
+		return
+	}
+	h.audit.record(c, "delete", "api_key", uint(id), nil, nil)
+
+	c.Status(http.StatusNoContent)
+}
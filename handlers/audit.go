@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"lab1/models"
+	"lab1/repository"
+	"log"
+
+	"github.com/gin-gonic/gin"
+)
+
+// auditLog records the changes a handler makes in the audit log
+type auditLog struct {
+	repo repository.AuditRepository
+}
+
+// bookSnapshot is what the audit log keeps of a book: the version its
+// revisions keep and the owner
+type bookSnapshot struct {
+	models.BookVersion
+	UserID uint `json:"user_id"`
+}
+
+// userSnapshot is what the audit log keeps of a user; secrets such as the
+// password hash are left out
+type userSnapshot struct {
+	Username              string `json:"username"`
+	Email                 string `json:"email"`
+	Role                  string `json:"role"`
+	EmailVerified         bool   `json:"email_verified"`
+	Disabled              bool   `json:"disabled"`
+	PasswordResetRequired bool   `json:"password_reset_required"`
+	TwoFactorEnabled      bool   `json:"two_factor_enabled"`
+	SingleSignOn          bool   `json:"single_sign_on"`
+}
+
+func snapshotBook(book *models.Book) bookSnapshot {
+	return bookSnapshot{BookVersion: models.NewBookVersion(book), UserID: book.UserID}
+}
+
+func snapshotReader(reader *models.Reader) models.ReaderVersion {
+	return models.NewReaderVersion(reader)
+}
+
+func snapshotUser(user *models.User) userSnapshot {
+	return userSnapshot{
+		Username:              user.Username,
+		Email:                 user.Email,
+		Role:                  user.Role,
+		EmailVerified:         user.EmailVerifiedAt != nil,
+		Disabled:              user.IsDisabled(),
+		PasswordResetRequired: user.PasswordResetRequired,
+		TwoFactorEnabled:      user.HasTwoFactor(),
+		SingleSignOn:          user.OIDCSubject != nil,
+	}
+}
+
+// event builds an event of the current user; before and after are snapshots
+// of the entity, nil where it does not exist. entityID 0 stands for actions
+// on many entities.
+func (a auditLog) event(c *gin.Context, action, entityType string, entityID uint, before, after interface{}) *models.AuditEvent {
+	event := &models.AuditEvent{
+		ActorName:  c.GetString("username"),
+		Action:     action,
+		EntityType: entityType,
+		Before:     auditJSON(before),
+		After:      auditJSON(after),
+		IP:         c.ClientIP(),
+		RequestID:  c.GetString("request_id"),
+	}
+	if actorID := c.GetUint("user_id"); actorID != 0 {
+		event.ActorID = &actorID
+	}
+	if entityID != 0 {
+		event.EntityID = &entityID
+	}
+	return event
+}
+
+// recordContext is the context events are recorded with. It is not cancelled
+// with the request, so that a change that was made is recorded even if the
+// request times out right after it.
+func recordContext(c *gin.Context) context.Context {
+	return context.WithoutCancel(c.Request.Context())
+}
+
+// record appends an event of the current user. The change has been made
+// already, so failing to record it only shows in the log.
+func (a auditLog) record(c *gin.Context, action, entityType string, entityID uint, before, after interface{}) {
+	a.repo.Record(recordContext(c), a.event(c, action, entityType, entityID, before, after))
+}
+
+// recordAs appends an event of a request that has no access token but acts
+// as the user, such as registering or following a password reset link
+func (a auditLog) recordAs(c *gin.Context, actor *models.User, action, entityType string, entityID uint, before, after interface{}) {
+	event := a.event(c, action, entityType, entityID, before, after)
+	event.ActorID = &actor.ID
+	event.ActorName = actor.Username
+	a.repo.Record(recordContext(c), event)
+}
+
+func auditJSON(snapshot interface{}) string {
+	if snapshot == nil {
+		return ""
+	}
+	b, err := json.Marshal(snapshot)
+	if err != nil {
+		log.Printf("auditLog: error encoding snapshot: %v", err)
+		return ""
+	}
+	return string(b)
+}
+
+// auditSnapshotJSON turns a stored snapshot back into JSON, null if there is none
+func auditSnapshotJSON(snapshot string) json.RawMessage {
+	if snapshot == "" {
+		return json.RawMessage("null")
+	}
+	return json.RawMessage(snapshot)
+}
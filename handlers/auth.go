@@ -1,27 +1,123 @@
 package handlers
 
 import (
+	"context"
 	"errors"
+	"lab1/config"
 	"lab1/dto"
+	"lab1/jwtkeys"
+	"lab1/mailer"
 	"lab1/middleware"
 	"lab1/models"
+	"lab1/oidc"
+	"lab1/rbac"
 	"lab1/repository"
 	"lab1/validation"
+	"log"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
 type AuthHandler struct {
-	userRepo  repository.UserRepository
-	validator *validation.Validator
+	userRepo      repository.UserRepository
+	tokenRepo     repository.TokenRepository
+	userTokenRepo repository.UserTokenRepository
+	mails         accountMails
+	throttle      loginThrottle
+	audit         auditLog
+	keys          *jwtkeys.KeySet
+	oidc          *oidc.Provider // nil without single sign-on
+	policy        *rbac.Policy
+	validator     *validation.Validator
+	config        *config.Config
 }
 
-func NewAuthHandler(userRepo repository.UserRepository, validator *validation.Validator) *AuthHandler {
+func NewAuthHandler(userRepo repository.UserRepository, tokenRepo repository.TokenRepository, userTokenRepo repository.UserTokenRepository, loginAttemptRepo repository.LoginAttemptRepository, auditRepo repository.AuditRepository, mailer mailer.Mailer, keys *jwtkeys.KeySet, oidcProvider *oidc.Provider, policy *rbac.Policy, validator *validation.Validator, config *config.Config) *AuthHandler {
 	return &AuthHandler{
-		userRepo:  userRepo,
-		validator: validator,
+		userRepo:      userRepo,
+		tokenRepo:     tokenRepo,
+		userTokenRepo: userTokenRepo,
+		mails:         accountMails{userTokenRepo: userTokenRepo, mailer: mailer, config: config},
+		throttle:      loginThrottle{repo: loginAttemptRepo, config: config},
+		audit:         auditLog{repo: auditRepo},
+		keys:          keys,
+		oidc:          oidcProvider,
+		policy:        policy,
+		validator:     validator,
+		config:        config,
+	}
+}
+
+// issueTokens creates an access token and a refresh token for the user. An empty
+// familyID starts a new session, otherwise the refresh token continues that one.
+func (h *AuthHandler) issueTokens(ctx context.Context, user *models.User, familyID string) (*dto.AuthResponse, error) {
+	accessTTL := time.Duration(h.config.AccessTokenTTLMinutes) * time.Minute
+	token, claims, err := middleware.GenerateToken(h.keys, user.ID, user.Username, user.Role, accessTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := middleware.RandomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	if familyID == "" {
+		if familyID, err = middleware.RandomToken(16); err != nil {
+			return nil, err
+		}
+	}
+
+	err = h.tokenRepo.Create(ctx, &models.RefreshToken{
+		UserID:          user.ID,
+		TokenHash:       middleware.HashToken(refreshToken),
+		FamilyID:        familyID,
+		AccessJTI:       claims.ID,
+		AccessExpiresAt: claims.ExpiresAt.Time,
+		ExpiresAt:       time.Now().AddDate(0, 0, h.config.RefreshTokenTTLDays),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &dto.AuthResponse{
+		Token:         token,
+		RefreshToken:  refreshToken,
+		ExpiresIn:     int64(accessTTL.Seconds()),
+		UserID:        user.ID,
+		Username:      user.Username,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerifiedAt != nil,
+		Role:          user.Role,
+		Permissions:   h.policy.Permissions(user.Role),
+	}, nil
+}
+
+// checkAccount refuses tokens to disabled users and to users who must reset
+// their password first. It writes a 403 response and returns false then.
+func (h *AuthHandler) checkAccount(c *gin.Context, user *models.User) bool {
+	if user.IsDisabled() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account is disabled"})
+		return false
+	}
+	if user.PasswordResetRequired {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Password reset required, use the link sent to your email address"})
+		return false
+	}
+	return true
+}
+
+func userToResponse(user *models.User, policy *rbac.Policy) dto.UserResponse {
+	return dto.UserResponse{
+		ID:               user.ID,
+		Username:         user.Username,
+		Email:            user.Email,
+		EmailVerified:    user.EmailVerifiedAt != nil,
+		TwoFactorEnabled: user.HasTwoFactor(),
+		Role:             user.Role,
+		Permissions:      policy.Permissions(user.Role),
 	}
 }
 
@@ -44,16 +140,16 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		return
 	}
 
-	// Check if username already exists
-	existingUser, err := h.userRepo.GetByUsername(req.Username)
-	if err == nil && existingUser != nil {
+	usernameTaken, emailTaken, err := h.userRepo.Taken(c.Request.Context(), req.Username, req.Email)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if usernameTaken {
 		c.JSON(http.StatusConflict, gin.H{"error": "Username already exists"})
 		return
 	}
-
-	// Check if email already exists
-	existingUser, err = h.userRepo.GetByEmail(req.Email)
-	if err == nil && existingUser != nil {
+	if emailTaken {
 		c.JSON(http.StatusConflict, gin.H{"error": "Email already exists"})
 		return
 	}
@@ -61,7 +157,7 @@ func (h *AuthHandler) Register(c *gin.Context) {
 	user := &models.User{
 		Username: req.Username,
 		Email:    req.Email,
-		Role:     "user",
+		Role:     h.config.DefaultRole,
 	}
 
 	if err := user.HashPassword(req.Password); err != nil {
@@ -69,39 +165,42 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		return
 	}
 
-	if err := h.userRepo.Create(user); err != nil {
+	if err := h.userRepo.Create(c.Request.Context(), user); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
 		return
 	}
+	h.audit.recordAs(c, user, "create", "user", user.ID, nil, snapshotUser(user))
+
+	// The account works without the mail, it can be requested again
+	if err := h.mails.sendVerification(c.Request.Context(), user); err != nil {
+		log.Printf("AuthHandler.Register: failed to send verification mail to user ID=%d: %v", user.ID, err)
+	}
 
-	// Generate token
-	token, err := middleware.GenerateToken(user.ID, user.Username, user.Role)
+	// Generate tokens
+	response, err := h.issueTokens(c.Request.Context(), user, "")
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
 
-	response := dto.AuthResponse{
-		Token:    token,
-		UserID:   user.ID,
-		Username: user.Username,
-		Email:    user.Email,
-		Role:     user.Role,
-	}
-
 	c.JSON(http.StatusCreated, response)
 }
 
 // Login godoc
 // @Summary User login
-// @Description Authenticate user and return JWT token
+// @Description Authenticate user and return JWT token. Users with two-factor authentication get a challenge token instead,
+// @Description which is exchanged together with a code at /auth/2fa/login.
+// @Description Failed attempts are throttled per username and IP address; throttled requests get 429 with a Retry-After header.
 // @Tags auth
 // @Accept json
 // @Produce json
 // @Param credentials body dto.LoginRequest true "Login credentials"
 // @Success 200 {object} dto.AuthResponse
+// @Success 202 {object} dto.TwoFactorChallengeResponse
 // @Failure 400 {object} map[string]interface{}
 // @Failure 401 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 429 {object} map[string]interface{}
 // @Router /auth/login [post]
 func (h *AuthHandler) Login(c *gin.Context) {
 	var req dto.LoginRequest
@@ -111,10 +210,15 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
+	if !h.throttle.check(c, req.Username) {
+		return
+	}
+
 	// Get user by username
-	user, err := h.userRepo.GetByUsername(req.Username)
+	user, err := h.userRepo.GetByUsername(c.Request.Context(), req.Username)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
+			h.throttle.failed(c, req.Username, nil)
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 			return
 		}
@@ -123,28 +227,166 @@ func (h *AuthHandler) Login(c *gin.Context) {
 	}
 
 	if err := user.CheckPassword(req.Password); err != nil {
+		h.throttle.failed(c, req.Username, &user.ID)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
+	h.throttle.succeeded(c, req.Username, user.ID)
+	if !h.checkAccount(c, user) {
+		return
+	}
 
-	// Generate token
-	token, err := middleware.GenerateToken(user.ID, user.Username, user.Role)
+	// Expired sessions are cleaned up lazily, a failure only leaves rows behind
+	h.tokenRepo.PurgeExpired(c.Request.Context(), time.Now())
+
+	if user.HasTwoFactor() {
+		h.startTwoFactorLogin(c, user)
+		return
+	}
+
+	// Generate tokens
+	response, err := h.issueTokens(c.Request.Context(), user, "")
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
 
-	response := dto.AuthResponse{
-		Token:    token,
-		UserID:   user.ID,
-		Username: user.Username,
-		Email:    user.Email,
-		Role:     user.Role,
+	c.JSON(http.StatusOK, response)
+}
+
+// Refresh godoc
+// @Summary Refresh tokens
+// @Description Exchange a refresh token for a new access token and a new refresh token.
+// @Description Each refresh token can be used once; presenting a used one again revokes the whole session.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body dto.RefreshRequest true "Refresh token"
+// @Success 200 {object} dto.AuthResponse
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Router /auth/refresh [post]
+func (h *AuthHandler) Refresh(c *gin.Context) {
+	var req dto.RefreshRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, validation.FormatValidationErrors(err))
+		return
+	}
+
+	token, err := h.tokenRepo.Rotate(c.Request.Context(), middleware.HashToken(req.RefreshToken), time.Now())
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		case errors.Is(err, repository.ErrRefreshTokenExpired):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token expired"})
+		case errors.Is(err, repository.ErrRefreshTokenRevoked):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token has been revoked"})
+		case errors.Is(err, repository.ErrRefreshTokenReused):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token was already used, the session has been revoked"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		}
+		return
+	}
+
+	// The user is read again so that role changes, deleted and disabled accounts take effect
+	user, err := h.userRepo.GetByID(c.Request.Context(), token.UserID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if !h.checkAccount(c, user) {
+		return
+	}
+
+	response, err := h.issueTokens(c.Request.Context(), user, token.FamilyID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
 	}
 
 	c.JSON(http.StatusOK, response)
 }
 
+// Logout godoc
+// @Summary Log out
+// @Description Revoke the access token and end its session, or the session of the given refresh token
+// @Tags auth
+// @Security BearerAuth
+// @Accept json
+// @Param request body dto.LogoutRequest false "Refresh token of the session"
+// @Success 204
+// @Failure 401 {object} map[string]interface{}
+// @Router /auth/logout [post]
+func (h *AuthHandler) Logout(c *gin.Context) {
+	var req dto.LogoutRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON", "details": err.Error()})
+			return
+		}
+	}
+
+	userID := c.MustGet("user_id").(uint)
+	jti := c.GetString("token_id")
+	now := time.Now()
+
+	if err := h.tokenRepo.RevokeAccess(c.Request.Context(), jti, c.MustGet("token_expires_at").(time.Time)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke token"})
+		return
+	}
+
+	var token *models.RefreshToken
+	var err error
+	if req.RefreshToken != "" {
+		token, err = h.tokenRepo.FindByHash(c.Request.Context(), middleware.HashToken(req.RefreshToken))
+	} else {
+		token, err = h.tokenRepo.FindByAccessJTI(c.Request.Context(), jti)
+	}
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// Another user's refresh token is ignored rather than revoked
+	if token != nil && token.UserID == userID {
+		if err := h.tokenRepo.RevokeFamily(c.Request.Context(), token.FamilyID, now); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke token"})
+			return
+		}
+	}
+	h.audit.record(c, "logout", "user", userID, nil, nil)
+
+	c.Status(http.StatusNoContent)
+}
+
+// LogoutAll godoc
+// @Summary Log out all sessions
+// @Description Revoke every access and refresh token of the current user
+// @Tags auth
+// @Security BearerAuth
+// @Success 204
+// @Failure 401 {object} map[string]interface{}
+// @Router /auth/logout-all [post]
+func (h *AuthHandler) LogoutAll(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint)
+
+	// Every access token is issued with a refresh token, so this covers the current one as well
+	if err := h.tokenRepo.RevokeUser(c.Request.Context(), userID, time.Now()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke tokens"})
+		return
+	}
+	h.audit.record(c, "logout_all", "user", userID, nil, nil)
+
+	c.Status(http.StatusNoContent)
+}
+
 // GetProfile godoc
 // @Summary Get user profile
 // @Description Get current user's profile information
@@ -161,18 +403,24 @@ func (h *AuthHandler) GetProfile(c *gin.Context) {
 		return
 	}
 
-	user, err := h.userRepo.GetByID(userID.(uint))
+	user, err := h.userRepo.GetByID(c.Request.Context(), userID.(uint))
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
 	}
 
-	response := dto.UserResponse{
-		ID:       user.ID,
-		Username: user.Username,
-		Email:    user.Email,
-		Role:     user.Role,
-	}
+	c.JSON(http.StatusOK, userToResponse(user, h.policy))
+}
 
-	c.JSON(http.StatusOK, response)
+// JWKS godoc
+// @Summary Public keys of access tokens
+// @Description JSON Web Key Set with the public keys that access tokens are signed with, selected by the kid header of a token. Other services verify tokens with it.
+// @Tags auth
+// @Produce json
+// @Success 200 {object} jwtkeys.JWKSet
+// @Router /.well-known/jwks.json [get]
+func (h *AuthHandler) JWKS(c *gin.Context) {
+	// Short enough that verifiers see a new key before it starts signing
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, h.keys.JWKS())
 }
@@ -0,0 +1,368 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"lab1/dto"
+	"lab1/middleware"
+	"lab1/models"
+	"lab1/repository"
+	"lab1/totp"
+	"lab1/validation"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	recoveryCodeCount = 10
+	// totpSkew is the number of 30 second steps a code may be late or early
+	totpSkew = 1
+)
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newRecoveryCodes returns recovery codes formatted as xxxxx-xxxxx, and the
+// hashes to store
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(recoveryCodeEncoding.EncodeToString(b))[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = middleware.HashToken(code)
+	}
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode accepts codes with or without the dash and in any case
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+// checkSecondFactor accepts a current authenticator code, once, or an unused
+// recovery code, which is used up
+func (h *AuthHandler) checkSecondFactor(ctx context.Context, user *models.User, code string) (bool, error) {
+	if counter, ok := totp.Validate(user.TOTPSecret, code, time.Now(), totpSkew, user.TOTPLastCounter); ok {
+		return h.userRepo.UseTOTPCounter(ctx, user.ID, counter)
+	}
+
+	err := h.userTokenRepo.ConsumeRecoveryCode(ctx, user.ID, middleware.HashToken(normalizeRecoveryCode(code)), time.Now())
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// startTwoFactorLogin answers a login with a correct password with a
+// challenge token, which only /auth/2fa/login accepts
+func (h *AuthHandler) startTwoFactorLogin(c *gin.Context, user *models.User) {
+	ttl := time.Duration(h.config.TwoFactorChallengeTTLMinutes) * time.Minute
+	challenge, err := h.mails.issueUserToken(c.Request.Context(), user, models.UserTokenTwoFactorLogin, ttl)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start two-factor login"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, dto.TwoFactorChallengeResponse{
+		TwoFactorRequired: true,
+		ChallengeToken:    challenge,
+		ExpiresIn:         int64(ttl.Seconds()),
+	})
+}
+
+// LoginTwoFactor godoc
+// @Summary Complete a two-factor login
+// @Description Exchange the challenge token of /auth/login and an authenticator or recovery code for tokens.
+// @Description The challenge token works once, after a wrong code the login starts over.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body dto.TwoFactorLoginRequest true "Challenge token and code"
+// @Success 200 {object} dto.AuthResponse
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Router /auth/2fa/login [post]
+func (h *AuthHandler) LoginTwoFactor(c *gin.Context) {
+	var req dto.TwoFactorLoginRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, validation.FormatValidationErrors(err))
+		return
+	}
+
+	// Using up the challenge on every attempt keeps codes from being guessed
+	token, err := h.userTokenRepo.Consume(c.Request.Context(), models.UserTokenTwoFactorLogin, middleware.HashToken(req.ChallengeToken), time.Now())
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired challenge token, please log in again"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	user, err := h.userRepo.GetByID(c.Request.Context(), token.UserID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired challenge token, please log in again"})
+		return
+	}
+	if !h.checkAccount(c, user) {
+		return
+	}
+
+	ok, err := h.checkSecondFactor(c.Request.Context(), user, req.Code)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code, please log in again"})
+		return
+	}
+
+	response, err := h.issueTokens(c.Request.Context(), user, "")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// SetupTwoFactor godoc
+// @Summary Start two-factor enrolment
+// @Description Create a new TOTP secret for the current user. It takes effect once a code is confirmed at /auth/2fa/verify.
+// @Tags auth
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} dto.TwoFactorSetupResponse
+// @Failure 401 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Router /auth/2fa/setup [post]
+func (h *AuthHandler) SetupTwoFactor(c *gin.Context) {
+	user, err := h.userRepo.GetByID(c.Request.Context(), c.GetUint("user_id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.HasTwoFactor() {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate secret"})
+		return
+	}
+	user.TOTPSecret = secret
+	if err := h.userRepo.Update(c.Request.Context(), user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store secret"})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.TwoFactorSetupResponse{
+		Secret:     secret,
+		OTPAuthURI: totp.URI(h.config.TwoFactorIssuer, user.Username, secret),
+	})
+}
+
+// VerifyTwoFactor godoc
+// @Summary Finish two-factor enrolment
+// @Description Confirm the secret of /auth/2fa/setup with a code of the authenticator app. Returns the recovery codes, which are not shown again.
+// @Tags auth
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body dto.TwoFactorCodeRequest true "Authenticator code"
+// @Success 200 {object} dto.RecoveryCodesResponse
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Router /auth/2fa/verify [post]
+func (h *AuthHandler) VerifyTwoFactor(c *gin.Context) {
+	var req dto.TwoFactorCodeRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, validation.FormatValidationErrors(err))
+		return
+	}
+
+	user, err := h.userRepo.GetByID(c.Request.Context(), c.GetUint("user_id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.HasTwoFactor() {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+	if user.TOTPSecret == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Start the setup with POST /auth/2fa/setup first"})
+		return
+	}
+
+	counter, ok := totp.Validate(user.TOTPSecret, req.Code, time.Now(), totpSkew, 0)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate recovery codes"})
+		return
+	}
+	if err := h.userTokenRepo.ReplaceRecoveryCodes(c.Request.Context(), user.ID, hashes); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store recovery codes"})
+		return
+	}
+
+	before := snapshotUser(user)
+	now := time.Now()
+	user.TOTPEnabledAt = &now
+	user.TOTPLastCounter = counter
+	if err := h.userRepo.Update(c.Request.Context(), user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable two-factor authentication"})
+		return
+	}
+	log.Printf("AuthHandler.VerifyTwoFactor: user ID=%d enabled two-factor authentication", user.ID)
+	h.audit.record(c, "enable_2fa", "user", user.ID, before, snapshotUser(user))
+
+	c.JSON(http.StatusOK, dto.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// RegenerateRecoveryCodes godoc
+// @Summary Replace the recovery codes
+// @Description Invalidate all recovery codes of the current user and return new ones
+// @Tags auth
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body dto.TwoFactorCodeRequest true "Authenticator code"
+// @Success 200 {object} dto.RecoveryCodesResponse
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Router /auth/2fa/recovery-codes [post]
+func (h *AuthHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	var req dto.TwoFactorCodeRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, validation.FormatValidationErrors(err))
+		return
+	}
+
+	user, ok := h.twoFactorUser(c, req.Code)
+	if !ok {
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate recovery codes"})
+		return
+	}
+	if err := h.userTokenRepo.ReplaceRecoveryCodes(c.Request.Context(), user.ID, hashes); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store recovery codes"})
+		return
+	}
+	h.audit.record(c, "regenerate_recovery_codes", "user", user.ID, nil, nil)
+
+	c.JSON(http.StatusOK, dto.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// DisableTwoFactor godoc
+// @Summary Disable two-factor authentication
+// @Description Requires the password and a code. Not possible for roles that require two-factor authentication.
+// @Tags auth
+// @Security BearerAuth
+// @Accept json
+// @Param request body dto.TwoFactorDisableRequest true "Password and authenticator or recovery code"
+// @Success 204
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Router /auth/2fa/disable [post]
+func (h *AuthHandler) DisableTwoFactor(c *gin.Context) {
+	var req dto.TwoFactorDisableRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, validation.FormatValidationErrors(err))
+		return
+	}
+
+	user, ok := h.twoFactorUser(c, req.Code)
+	if !ok {
+		return
+	}
+	if err := user.CheckPassword(req.Password); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid password"})
+		return
+	}
+	if h.config.RequiresTwoFactor(user.Role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Two-factor authentication is required for your role"})
+		return
+	}
+
+	before := snapshotUser(user)
+	if err := disableTwoFactor(c.Request.Context(), h.userRepo, h.userTokenRepo, user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable two-factor authentication"})
+		return
+	}
+	log.Printf("AuthHandler.DisableTwoFactor: user ID=%d disabled two-factor authentication", user.ID)
+	h.audit.record(c, "disable_2fa", "user", user.ID, before, snapshotUser(user))
+
+	c.Status(http.StatusNoContent)
+}
+
+// twoFactorUser loads the current user, who must have two-factor
+// authentication enabled and prove it with the code
+func (h *AuthHandler) twoFactorUser(c *gin.Context, code string) (*models.User, bool) {
+	user, err := h.userRepo.GetByID(c.Request.Context(), c.GetUint("user_id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return nil, false
+	}
+	if !user.HasTwoFactor() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is not enabled"})
+		return nil, false
+	}
+
+	ok, err := h.checkSecondFactor(c.Request.Context(), user, code)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return nil, false
+	}
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code"})
+		return nil, false
+	}
+	return user, true
+}
+
+// disableTwoFactor removes the user's secret and recovery codes
+func disableTwoFactor(ctx context.Context, userRepo repository.UserRepository, userTokenRepo repository.UserTokenRepository, user *models.User) error {
+	user.TOTPSecret = ""
+	user.TOTPEnabledAt = nil
+	user.TOTPLastCounter = 0
+	if err := userRepo.Update(ctx, user); err != nil {
+		return err
+	}
+	return userTokenRepo.DeleteRecoveryCodes(ctx, user.ID)
+}
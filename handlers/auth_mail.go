@@ -0,0 +1,260 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"lab1/config"
+	"lab1/dto"
+	"lab1/mailer"
+	"lab1/middleware"
+	"lab1/models"
+	"lab1/repository"
+	"lab1/validation"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// accountMails sends the mails with single-use links for account actions
+type accountMails struct {
+	userTokenRepo repository.UserTokenRepository
+	mailer        mailer.Mailer
+	config        *config.Config
+}
+
+// issueUserToken replaces the user's tokens of the purpose with a new one and
+// returns it; only its hash is stored
+func (m *accountMails) issueUserToken(ctx context.Context, user *models.User, purpose string, ttl time.Duration) (string, error) {
+	token, err := middleware.RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+	if err := m.userTokenRepo.DeleteByUser(ctx, user.ID, purpose); err != nil {
+		return "", err
+	}
+	err = m.userTokenRepo.Create(ctx, &models.UserToken{
+		UserID:    user.ID,
+		Purpose:   purpose,
+		TokenHash: middleware.HashToken(token),
+		ExpiresAt: time.Now().Add(ttl),
+	})
+	return token, err
+}
+
+// appLink builds a link to the web app that carries a token in the query string
+func (m *accountMails) appLink(param, token string) string {
+	return strings.TrimRight(m.config.AppBaseURL, "/") + "/?" + url.Values{param: {token}}.Encode()
+}
+
+// sendVerification mails the user a link that confirms the email address
+func (m *accountMails) sendVerification(ctx context.Context, user *models.User) error {
+	ttl := time.Duration(m.config.EmailVerificationTTLHours) * time.Hour
+	token, err := m.issueUserToken(ctx, user, models.UserTokenEmailVerification, ttl)
+	if err != nil {
+		return err
+	}
+
+	return m.mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf("Hello %s,\n\nplease confirm your email address by opening this link:\n\n%s\n\n"+
+			"The link is valid for %d hours. If you did not create an account, you can ignore this mail.\n",
+			user.Username, m.appLink("verify_token", token), m.config.EmailVerificationTTLHours),
+	})
+}
+
+// sendPasswordReset mails the user a link to choose a new password; intro
+// says why the mail was sent
+func (m *accountMails) sendPasswordReset(ctx context.Context, user *models.User, intro string) error {
+	ttl := time.Duration(m.config.PasswordResetTTLMinutes) * time.Minute
+	token, err := m.issueUserToken(ctx, user, models.UserTokenPasswordReset, ttl)
+	if err != nil {
+		return err
+	}
+
+	return m.mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hello %s,\n\n%s To choose a new password, open this link:\n\n%s\n\n"+
+			"The link is valid for %d minutes and can be used once. If you did not ask for it, you can ignore this mail.\n",
+			user.Username, intro, m.appLink("reset_token", token), m.config.PasswordResetTTLMinutes),
+	})
+}
+
+// ForgotPassword godoc
+// @Summary Request a password reset
+// @Description Mail a single-use password reset link. The response is the same whether or not the email is registered.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body dto.ForgotPasswordRequest true "Email address of the account"
+// @Success 202 {object} map[string]string
+// @Failure 400 {object} map[string]interface{}
+// @Router /auth/password/forgot [post]
+func (h *AuthHandler) ForgotPassword(c *gin.Context) {
+	var req dto.ForgotPasswordRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, validation.FormatValidationErrors(err))
+		return
+	}
+
+	// Failures are only logged, the response must not reveal whether the account exists
+	user, err := h.userRepo.GetByEmail(c.Request.Context(), req.Email)
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		log.Printf("AuthHandler.ForgotPassword: no user with the requested email")
+	case err != nil:
+		log.Printf("AuthHandler.ForgotPassword: error looking up user: %v", err)
+	default:
+		if err := h.mails.sendPasswordReset(c.Request.Context(), user, "someone asked to reset the password of your account."); err != nil {
+			log.Printf("AuthHandler.ForgotPassword: failed to send reset mail to user ID=%d: %v", user.ID, err)
+		}
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "If an account with this email exists, a password reset link has been sent"})
+}
+
+// ResetPassword godoc
+// @Summary Reset the password
+// @Description Set a new password with the token of a reset mail. All sessions of the user are ended.
+// @Tags auth
+// @Accept json
+// @Param request body dto.ResetPasswordRequest true "Reset token and new password"
+// @Success 204
+// @Failure 400 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Router /auth/password/reset [post]
+func (h *AuthHandler) ResetPassword(c *gin.Context) {
+	var req dto.ResetPasswordRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, validation.FormatValidationErrors(err))
+		return
+	}
+
+	now := time.Now()
+	token, err := h.userTokenRepo.Consume(c.Request.Context(), models.UserTokenPasswordReset, middleware.HashToken(req.Token), now)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset token"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	user, err := h.userRepo.GetByID(c.Request.Context(), token.UserID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset token"})
+		return
+	}
+
+	before := snapshotUser(user)
+	if err := user.HashPassword(req.Password); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
+	// Receiving the mail proves the address as well
+	if user.EmailVerifiedAt == nil {
+		user.EmailVerifiedAt = &now
+	}
+	user.PasswordResetRequired = false
+	if err := h.userRepo.Update(c.Request.Context(), user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password"})
+		return
+	}
+
+	// Whoever knew the old password must not stay logged in
+	if err := h.tokenRepo.RevokeUser(c.Request.Context(), user.ID, now); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke tokens"})
+		return
+	}
+	h.audit.recordAs(c, user, "reset_password", "user", user.ID, before, snapshotUser(user))
+
+	c.Status(http.StatusNoContent)
+}
+
+// VerifyEmail godoc
+// @Summary Confirm the email address
+// @Description Confirm the email address with the token of a verification mail
+// @Tags auth
+// @Accept json
+// @Param request body dto.VerifyEmailRequest true "Verification token"
+// @Success 204
+// @Failure 400 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Router /auth/email/verify [post]
+func (h *AuthHandler) VerifyEmail(c *gin.Context) {
+	var req dto.VerifyEmailRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, validation.FormatValidationErrors(err))
+		return
+	}
+
+	now := time.Now()
+	token, err := h.userTokenRepo.Consume(c.Request.Context(), models.UserTokenEmailVerification, middleware.HashToken(req.Token), now)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification token"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	user, err := h.userRepo.GetByID(c.Request.Context(), token.UserID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification token"})
+		return
+	}
+	if user.EmailVerifiedAt == nil {
+		before := snapshotUser(user)
+		user.EmailVerifiedAt = &now
+		if err := h.userRepo.Update(c.Request.Context(), user); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+			return
+		}
+		h.audit.recordAs(c, user, "verify_email", "user", user.ID, before, snapshotUser(user))
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// ResendVerification godoc
+// @Summary Resend the verification mail
+// @Description Mail a new verification link to the current user; earlier links stop working
+// @Tags auth
+// @Security BearerAuth
+// @Produce json
+// @Success 202 {object} map[string]string
+// @Failure 401 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Router /auth/email/resend [post]
+func (h *AuthHandler) ResendVerification(c *gin.Context) {
+	user, err := h.userRepo.GetByID(c.Request.Context(), c.MustGet("user_id").(uint))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.EmailVerifiedAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Email address is already verified"})
+		return
+	}
+
+	if err := h.mails.sendVerification(c.Request.Context(), user); err != nil {
+		log.Printf("AuthHandler.ResendVerification: failed to send verification mail to user ID=%d: %v", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send verification mail"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "A verification link has been sent to " + user.Email})
+}
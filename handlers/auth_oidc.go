@@ -0,0 +1,369 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"lab1/dto"
+	"lab1/middleware"
+	"lab1/models"
+	"lab1/oidc"
+	"lab1/validation"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	oidcCookieName = "oidc_login"
+	oidcFlowTTL    = 10 * time.Minute // time to sign in at the identity provider
+	oidcCodeTTL    = 2 * time.Minute  // time for the web app to redeem the login code
+)
+
+// oidcFlow is kept in a cookie between login and callback. The state in it
+// binds the callback to the browser that started the flow.
+type oidcFlow struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+}
+
+// oidcSignInError is a reason the sign-in failed that the user is shown
+type oidcSignInError string
+
+func (e oidcSignInError) Error() string {
+	return string(e)
+}
+
+// GetOIDCConfig godoc
+// @Summary Single sign-on status
+// @Description Whether login with the organisation's identity provider is available
+// @Tags auth
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Router /auth/oidc [get]
+func (h *AuthHandler) GetOIDCConfig(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"enabled": h.oidc != nil})
+}
+
+// OIDCLogin godoc
+// @Summary Start single sign-on
+// @Description Redirect the browser to the identity provider. After signing in there, the callback redirects to the web app with a login code.
+// @Tags auth
+// @Success 302
+// @Failure 404 {object} map[string]string
+// @Failure 502 {object} map[string]string
+// @Router /auth/oidc/login [get]
+func (h *AuthHandler) OIDCLogin(c *gin.Context) {
+	if h.oidc == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Single sign-on is not configured"})
+		return
+	}
+
+	var flow oidcFlow
+	var err error
+	if flow.State, err = oidc.NewState(); err == nil {
+		if flow.Nonce, err = oidc.NewState(); err == nil {
+			flow.Verifier, err = oidc.NewVerifier()
+		}
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start sign-in"})
+		return
+	}
+
+	authURL, err := h.oidc.AuthCodeURL(c.Request.Context(), flow.State, flow.Nonce, flow.Verifier)
+	if err != nil {
+		log.Printf("AuthHandler.OIDCLogin: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Identity provider is unavailable"})
+		return
+	}
+
+	value, _ := json.Marshal(flow)
+	h.setOIDCCookie(c, base64.RawURLEncoding.EncodeToString(value), int(oidcFlowTTL/time.Second))
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// OIDCCallback godoc
+// @Summary Single sign-on callback
+// @Description The identity provider redirects here. The user is signed in, linked by verified email or created, and the browser is sent to the web app with a single-use oidc_code, or with oidc_error.
+// @Tags auth
+// @Param code query string false "Authorization code"
+// @Param state query string true "State of the login request"
+// @Success 302
+// @Failure 404 {object} map[string]string
+// @Router /auth/oidc/callback [get]
+func (h *AuthHandler) OIDCCallback(c *gin.Context) {
+	if h.oidc == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Single sign-on is not configured"})
+		return
+	}
+
+	var flow oidcFlow
+	cookie, _ := c.Cookie(oidcCookieName)
+	value, err := base64.RawURLEncoding.DecodeString(cookie)
+	if err == nil {
+		err = json.Unmarshal(value, &flow)
+	}
+	// The flow is single-use, whatever the outcome
+	h.setOIDCCookie(c, "", -1)
+	if err != nil || flow.State == "" || c.Query("state") != flow.State {
+		h.oidcFail(c, "Sign-in session expired or invalid, please try again")
+		return
+	}
+	if providerError := c.Query("error"); providerError != "" {
+		log.Printf("AuthHandler.OIDCCallback: identity provider returned %s: %s", providerError, c.Query("error_description"))
+		h.oidcFail(c, "Sign-in was cancelled or refused by the identity provider")
+		return
+	}
+
+	claims, err := h.oidc.Exchange(c.Request.Context(), c.Query("code"), flow.Verifier, flow.Nonce)
+	if err != nil {
+		log.Printf("AuthHandler.OIDCCallback: %v", err)
+		h.oidcFail(c, "Sign-in with the identity provider failed")
+		return
+	}
+
+	user, err := h.oidcUser(c, claims)
+	if err != nil {
+		var signInErr oidcSignInError
+		if errors.As(err, &signInErr) {
+			h.oidcFail(c, signInErr.Error())
+			return
+		}
+		log.Printf("AuthHandler.OIDCCallback: error signing in subject %q: %v", claims.Subject, err)
+		h.oidcFail(c, "Sign-in failed, please try again later")
+		return
+	}
+
+	// The web app redeems the code for tokens, so they never appear in a URL
+	code, err := h.mails.issueUserToken(c.Request.Context(), user, models.UserTokenOIDCLogin, oidcCodeTTL)
+	if err != nil {
+		h.oidcFail(c, "Sign-in failed, please try again later")
+		return
+	}
+	c.Redirect(http.StatusFound, h.mails.appLink("oidc_code", code))
+}
+
+// OIDCToken godoc
+// @Summary Finish single sign-on
+// @Description Exchange the oidc_code of the callback for tokens. Users with two-factor authentication get a challenge token instead, as with the password login.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body dto.OIDCTokenRequest true "Login code"
+// @Success 200 {object} dto.AuthResponse
+// @Success 202 {object} dto.TwoFactorChallengeResponse
+// @Failure 400 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Router /auth/oidc/token [post]
+func (h *AuthHandler) OIDCToken(c *gin.Context) {
+	var req dto.OIDCTokenRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, validation.FormatValidationErrors(err))
+		return
+	}
+
+	token, err := h.userTokenRepo.Consume(c.Request.Context(), models.UserTokenOIDCLogin, middleware.HashToken(req.Code), time.Now())
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired login code"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	user, err := h.userRepo.GetByID(c.Request.Context(), token.UserID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired login code"})
+		return
+	}
+	if !h.checkAccount(c, user) {
+		return
+	}
+	if user.HasTwoFactor() {
+		h.startTwoFactorLogin(c, user)
+		return
+	}
+
+	response, err := h.issueTokens(c.Request.Context(), user, "")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *AuthHandler) setOIDCCookie(c *gin.Context, value string, maxAge int) {
+	// Lax, because the callback is a top-level navigation from the provider
+	c.SetSameSite(http.SameSiteLaxMode)
+	secure := strings.HasPrefix(h.config.AppBaseURL, "https://")
+	c.SetCookie(oidcCookieName, value, maxAge, "/auth/oidc", "", secure, true)
+}
+
+// oidcFail sends the browser back to the web app, which shows the message
+func (h *AuthHandler) oidcFail(c *gin.Context, message string) {
+	c.Redirect(http.StatusFound, h.mails.appLink("oidc_error", message))
+}
+
+// oidcUser returns the user of the identity provider account. Unknown accounts
+// are linked to the user with the same verified email if oidc_link_by_email is
+// set, or get a new user. The role claim, if it maps to a role, sets the role
+// on every sign-in, so that the provider stays in charge of it.
+func (h *AuthHandler) oidcUser(c *gin.Context, claims *oidc.Claims) (*models.User, error) {
+	issuer := h.config.OIDCIssuer
+	user, err := h.userRepo.GetByOIDCSubject(c.Request.Context(), issuer, claims.Subject)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		user, err = h.linkOIDCUser(c, claims)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	before := snapshotUser(user)
+	changed := false
+	if role, ok := h.oidcRole(claims); ok && user.Role != role {
+		log.Printf("AuthHandler.oidcUser: role of user ID=%d changes from %s to %s by the identity provider", user.ID, user.Role, role)
+		user.Role = role
+		changed = true
+	}
+	if claims.EmailVerified && user.EmailVerifiedAt == nil && strings.EqualFold(user.Email, claims.Email) {
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+		changed = true
+	}
+	if changed {
+		if err := h.userRepo.Update(c.Request.Context(), user); err != nil {
+			return nil, err
+		}
+		h.audit.recordAs(c, user, "oidc_update", "user", user.ID, before, snapshotUser(user))
+	}
+	return user, nil
+}
+
+// linkOIDCUser links an existing user or creates one for a new provider account
+func (h *AuthHandler) linkOIDCUser(c *gin.Context, claims *oidc.Claims) (*models.User, error) {
+	if claims.Email == "" {
+		return nil, oidcSignInError("The identity provider did not share an email address")
+	}
+	issuer, subject := h.config.OIDCIssuer, claims.Subject
+
+	existing, err := h.userRepo.GetByEmail(c.Request.Context(), claims.Email)
+	switch {
+	case err == nil:
+		// An unverified address could belong to anyone, so it must not unlock the account
+		if !h.config.OIDCLinkByEmail || !claims.EmailVerified || existing.OIDCSubject != nil {
+			return nil, oidcSignInError("An account with this email address already exists and cannot be linked automatically")
+		}
+		before := snapshotUser(existing)
+		existing.OIDCIssuer, existing.OIDCSubject = &issuer, &subject
+		if err := h.userRepo.Update(c.Request.Context(), existing); err != nil {
+			return nil, err
+		}
+		log.Printf("AuthHandler.linkOIDCUser: linked user ID=%d to subject %q", existing.ID, subject)
+		h.audit.recordAs(c, existing, "link_oidc", "user", existing.ID, before, snapshotUser(existing))
+		return existing, nil
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, err
+	}
+
+	// Deleted users keep their email reserved
+	if _, emailTaken, err := h.userRepo.Taken(c.Request.Context(), "", claims.Email); err != nil {
+		return nil, err
+	} else if emailTaken {
+		return nil, oidcSignInError("The account with this email address has been deleted")
+	}
+
+	username, err := h.oidcUsername(c.Request.Context(), claims)
+	if err != nil {
+		return nil, err
+	}
+	role, ok := h.oidcRole(claims)
+	if !ok {
+		role = h.config.DefaultRole
+	}
+
+	user := &models.User{
+		Username:    username,
+		Email:       claims.Email,
+		Role:        role,
+		OIDCIssuer:  &issuer,
+		OIDCSubject: &subject,
+	}
+	if claims.EmailVerified {
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+	}
+	// The user signs in at the provider; a random password keeps the password
+	// login closed until they set one with a reset link
+	password, err := middleware.RandomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	if err := user.HashPassword(password); err != nil {
+		return nil, err
+	}
+	if err := h.userRepo.Create(c.Request.Context(), user); err != nil {
+		return nil, err
+	}
+	log.Printf("AuthHandler.linkOIDCUser: created user ID=%d (%s) for subject %q", user.ID, user.Username, subject)
+	h.audit.recordAs(c, user, "create", "user", user.ID, nil, snapshotUser(user))
+	return user, nil
+}
+
+// oidcUsername picks a free username from the username claim or the email,
+// adding a number if it is taken
+func (h *AuthHandler) oidcUsername(ctx context.Context, claims *oidc.Claims) (string, error) {
+	base := strings.TrimSpace(claims.String(h.config.OIDCUsernameClaim))
+	if base == "" {
+		base, _, _ = strings.Cut(claims.Email, "@")
+	}
+	// Lengths are counted in characters, cutting bytes could split one
+	if runes := []rune(base); len(runes) > 40 {
+		base = string(runes[:40])
+	}
+	for utf8.RuneCountInString(base) < 3 {
+		base += "_"
+	}
+
+	for i := 1; i <= 100; i++ {
+		username := base
+		if i > 1 {
+			username = fmt.Sprintf("%s%d", base, i)
+		}
+		taken, _, err := h.userRepo.Taken(ctx, username, "")
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return username, nil
+		}
+	}
+	return "", oidcSignInError("No free username for this account, ask an administrator")
+}
+
+// oidcRole maps the role claim to a role; ok is false if no entry matches
+func (h *AuthHandler) oidcRole(claims *oidc.Claims) (role string, ok bool) {
+	if h.config.OIDCRoleClaim == "" {
+		return "", false
+	}
+	values := make(map[string]bool)
+	for _, value := range claims.Strings(h.config.OIDCRoleClaim) {
+		values[value] = true
+	}
+	for _, mapping := range h.config.OIDCRoleMapping {
+		if values[mapping.Value] {
+			return mapping.Role, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"lab1/config"
+	"lab1/models"
+	"lab1/repository"
+	"lab1/validation"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AuthorsHandler struct {
+	nameHandler[models.Author, *models.Author]
+}
+
+func NewAuthorsHandler(repo repository.AuthorRepository, validator *validation.Validator, config *config.Config) *AuthorsHandler {
+	return &AuthorsHandler{newNameHandler[models.Author](repo, "author", "authors", validator, config)}
+}
+
+// @Summary Get all authors
+// @Tags authors
+// @Produce json
+// @Param q query string false "Search in name"
+// @Param sort query string false "Sort field" Enums(id, name, created_at)
+// @Param order query string false "Sort order" Enums(asc, desc)
+// @Param page query int false "Page number, starting at 1"
+// @Param page_size query int false "Page size"
+// @Success 200 {object} dto.NameListResponseDTO
+// @Failure 400 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /authors/ [get]
+func (h *AuthorsHandler) GetAll(c *gin.Context) {
+	h.getAll(c)
+}
+
+// @Summary Create a new author
+// @Tags authors
+// @Accept json
+// @Produce json
+// @Param author body dto.NameCreateDTO true "Author to create"
+// @Success 201 {object} dto.NameResponseDTO
+// @Failure 400 {object} map[string]interface{}
+// @Failure 500 {object} map[string]string
+// @Router /authors/ [post]
+func (h *AuthorsHandler) Create(c *gin.Context) {
+	h.create(c)
+}
+
+// @Summary Get author by ID
+// @Tags authors
+// @Produce json
+// @Param id path int true "Author ID"
+// @Success 200 {object} dto.NameResponseDTO
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /authors/{id} [get]
+func (h *AuthorsHandler) GetByID(c *gin.Context) {
+	h.getByID(c)
+}
+
+// @Summary Update author by ID
+// @Tags authors
+// @Accept json
+// @Param id path int true "Author ID"
+// @Param author body dto.NameUpdateDTO true "Updated author data"
+// @Success 204
+// @Failure 400 {object} map[string]interface{}
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /authors/{id} [put]
+func (h *AuthorsHandler) Update(c *gin.Context) {
+	h.update(c)
+}
+
+// @Summary Delete author by ID
+// @Description Authors still referenced by books, also those in the trash, cannot be deleted
+// @Tags authors
+// @Param id path int true "Author ID"
+// @Success 204
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /authors/{id} [delete]
+func (h *AuthorsHandler) Delete(c *gin.Context) {
+	h.delete(c)
+}
@@ -2,32 +2,95 @@ package handlers
 
 import (
 	"errors"
+	"html"
 	"lab1/config"
 	"lab1/dto"
 	"lab1/models"
+	"lab1/rbac"
 	"lab1/repository"
+	"lab1/service"
 	"lab1/validation"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
 type BooksHandler struct {
-	repo      repository.BookRepository
-	validator *validation.Validator
-	config    *config.Config
+	books         *service.BookService
+	repo          repository.BookRepository
+	itemRepo      repository.ItemRepository
+	authorRepo    repository.AuthorRepository
+	publisherRepo repository.PublisherRepository
+	subjectRepo   repository.SubjectRepository
+	audit         auditLog
+	policy        *rbac.Policy
+	validator     *validation.Validator
+	config        *config.Config
 }
 
-func NewBooksHandler(repo repository.BookRepository, validator *validation.Validator, config *config.Config) *BooksHandler {
-	return &BooksHandler{repo: repo, validator: validator, config: config}
+func NewBooksHandler(books *service.BookService, repo repository.BookRepository, itemRepo repository.ItemRepository, authorRepo repository.AuthorRepository, publisherRepo repository.PublisherRepository, subjectRepo repository.SubjectRepository, auditRepo repository.AuditRepository, policy *rbac.Policy, validator *validation.Validator, config *config.Config) *BooksHandler {
+	return &BooksHandler{
+		books:         books,
+		repo:          repo,
+		itemRepo:      itemRepo,
+		authorRepo:    authorRepo,
+		publisherRepo: publisherRepo,
+		subjectRepo:   subjectRepo,
+		audit:         auditLog{repo: auditRepo},
+		policy:        policy,
+		validator:     validator,
+		config:        config,
+	}
+}
+
+// canChangeBook reports whether the current user may change the book and its
+// copies, see service.CanChangeBook
+func canChangeBook(c *gin.Context, policy *rbac.Policy, book *models.Book) bool {
+	return service.CanChangeBook(actorOf(c, policy), book)
+}
+
+// bookToResponse converts a book to its DTO; counts come from ItemRepository.CountsByBooks
+func bookToResponse(book *models.Book, counts map[uint]repository.CopyCounts) dto.BookResponseDTO {
+	copies := counts[book.ID]
+	isbn := ""
+	if book.ISBN != nil {
+		isbn = *book.ISBN
+	}
+	return dto.BookResponseDTO{
+		ID:              book.ID,
+		Title:           book.Title,
+		Description:     book.Description,
+		ISBN:            isbn,
+		ISBN10:          validation.ISBN13To10(isbn),
+		UserID:          book.UserID,
+		Username:        book.User.Username,
+		Authors:         namesToResponse(book.Authors),
+		Publishers:      namesToResponse(book.Publishers),
+		Subjects:        namesToResponse(book.Subjects),
+		AvailableCopies: copies.Available,
+		TotalCopies:     copies.Total,
+		CreatedAt:       book.CreatedAt,
+	}
 }
 
 // @Summary Get all books
+// @Description Search, filter, sort and paginate books
 // @Tags books
 // @Produce json
-// @Success 200 {array} dto.BookResponseDTO
+// @Param q query string false "Search in title and description"
+// @Param owner query string false "Username of the owner"
+// @Param author_id query int false "Only books by this author"
+// @Param subject_id query int false "Only books filed under this subject"
+// @Param created_after query string false "RFC 3339 timestamp or YYYY-MM-DD date"
+// @Param sort query string false "Sort field" Enums(id, title, created_at, updated_at)
+// @Param order query string false "Sort order" Enums(asc, desc)
+// @Param page query int false "Page number, starting at 1"
+// @Param page_size query int false "Page size"
+// @Success 200 {object} dto.BookListResponseDTO
+// @Failure 400 {object} map[string]string
 // @Failure 403 {object} map[string]string
 // @Failure 500 {object} map[string]string
 // @Router /books/ [get]
@@ -37,25 +100,218 @@ func (h *BooksHandler) GetAll(c *gin.Context) {
 		return
 	}
 
-	books, err := h.repo.FindAll()
+	query, ok := parseListQuery(c, h.config, repository.BookSortFields)
+	if !ok {
+		return
+	}
+
+	page, err := h.repo.FindPage(c.Request.Context(), query)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve books"})
 		return
 	}
+	books := page.Books
 
-	response := make([]dto.BookResponseDTO, len(books))
+	bookIDs := make([]uint, len(books))
 	for i, book := range books {
-		response[i] = dto.BookResponseDTO{
-			ID:          book.ID,
-			Title:       book.Title,
-			Description: book.Description,
-			UserID:      book.UserID,
-			Username:    book.User.Username,
+		bookIDs[i] = book.ID
+	}
+	counts, err := h.itemRepo.CountsByBooks(c.Request.Context(), bookIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve copy counts"})
+		return
+	}
+
+	response := dto.BookListResponseDTO{Data: make([]dto.BookResponseDTO, len(books))}
+	for i := range books {
+		response.Data[i] = bookToResponse(&books[i], counts)
+	}
+	response.Meta, response.Links = pageResponse(c, query, page.Total)
+	c.JSON(http.StatusOK, response)
+}
+
+// @Summary Full-text search of books
+// @Description Relevance-ranked search in title and description. Words match as prefixes, "quoted phrases" match exactly.
+// @Tags books
+// @Produce json
+// @Param q query string true "Search query"
+// @Param owner query string false "Username of the owner"
+// @Param author_id query int false "Only books by this author"
+// @Param subject_id query int false "Only books filed under this subject"
+// @Param created_after query string false "RFC 3339 timestamp or YYYY-MM-DD date"
+// @Param page query int false "Page number, starting at 1"
+// @Param page_size query int false "Page size"
+// @Success 200 {object} dto.BookSearchResponseDTO
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /books/search [get]
+func (h *BooksHandler) Search(c *gin.Context) {
+	if !h.config.EnableGetBooks {
+		c.JSON(http.StatusForbidden, gin.H{"error": "GET /books endpoint is disabled"})
+		return
+	}
+
+	query, ok := parseListQuery(c, h.config, repository.BookSortFields)
+	if !ok {
+		return
+	}
+	if query.Q == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Query parameter q is required"})
+		return
+	}
+
+	page, err := h.repo.Search(c.Request.Context(), query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search books"})
+		return
+	}
+
+	bookIDs := make([]uint, len(page.Hits))
+	for i, hit := range page.Hits {
+		bookIDs[i] = hit.Book.ID
+	}
+	counts, err := h.itemRepo.CountsByBooks(c.Request.Context(), bookIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve copy counts"})
+		return
+	}
+
+	response := dto.BookSearchResponseDTO{Data: make([]dto.BookSearchResultDTO, len(page.Hits))}
+	for i := range page.Hits {
+		hit := &page.Hits[i]
+		response.Data[i] = dto.BookSearchResultDTO{
+			Book:           bookToResponse(&hit.Book, counts),
+			Rank:           hit.Rank,
+			TitleHighlight: markHighlights(hit.TitleHighlight),
+			Snippet:        markHighlights(hit.Snippet),
 		}
 	}
+	response.Meta, response.Links = pageResponse(c, query, page.Total)
 	c.JSON(http.StatusOK, response)
 }
 
+var highlightMarkup = strings.NewReplacer(repository.HighlightStart, "<mark>", repository.HighlightEnd, "</mark>")
+
+// markHighlights escapes book text for HTML and turns the repository's highlight markers into <mark> tags
+func markHighlights(s string) string {
+	return highlightMarkup.Replace(html.EscapeString(s))
+}
+
+// checkISBN normalizes an optional ISBN from a request and makes sure no other
+// book has it. It writes the error response itself and returns false on failure.
+func (h *BooksHandler) checkISBN(c *gin.Context, raw string, bookID uint) (*string, bool) {
+	if raw == "" {
+		return nil, true
+	}
+
+	isbn, err := validation.NormalizeISBN(raw)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ISBN"})
+		return nil, false
+	}
+
+	taken, err := h.repo.ISBNTaken(c.Request.Context(), isbn, bookID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check ISBN"})
+		return nil, false
+	}
+	if taken {
+		c.JSON(http.StatusConflict, gin.H{"error": "A book with this ISBN already exists"})
+		return nil, false
+	}
+	return &isbn, true
+}
+
+// resolveLinks loads the authors, publishers and subjects referenced by a request
+// into the book. It writes a 400 response and returns false if any ID is unknown.
+func (h *BooksHandler) resolveLinks(c *gin.Context, book *models.Book, authorIDs, publisherIDs, subjectIDs []uint) bool {
+	authors, err := h.authorRepo.FindByIDs(c.Request.Context(), authorIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve authors"})
+		return false
+	}
+	if len(authors) != len(uniqueIDs(authorIDs)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown author ID"})
+		return false
+	}
+
+	publishers, err := h.publisherRepo.FindByIDs(c.Request.Context(), publisherIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve publishers"})
+		return false
+	}
+	if len(publishers) != len(uniqueIDs(publisherIDs)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown publisher ID"})
+		return false
+	}
+
+	subjects, err := h.subjectRepo.FindByIDs(c.Request.Context(), subjectIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve subjects"})
+		return false
+	}
+	if len(subjects) != len(uniqueIDs(subjectIDs)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown subject ID"})
+		return false
+	}
+
+	book.Authors = authors
+	book.Publishers = publishers
+	book.Subjects = subjects
+	return true
+}
+
+func uniqueIDs(ids []uint) map[uint]bool {
+	set := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+	return set
+}
+
+// @Summary Get book by ISBN
+// @Description Accepts ISBN-10 or ISBN-13, with or without hyphens
+// @Tags books
+// @Produce json
+// @Param isbn path string true "ISBN"
+// @Success 200 {object} dto.BookResponseDTO
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /books/isbn/{isbn} [get]
+func (h *BooksHandler) GetByISBN(c *gin.Context) {
+	if !h.config.EnableGetBooks {
+		c.JSON(http.StatusForbidden, gin.H{"error": "GET /books endpoint is disabled"})
+		return
+	}
+
+	isbn, err := validation.NormalizeISBN(c.Param("isbn"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ISBN"})
+		return
+	}
+
+	book, err := h.repo.FindByISBN(c.Request.Context(), isbn)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Book not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve book"})
+		}
+		return
+	}
+
+	counts, err := h.itemRepo.CountsByBooks(c.Request.Context(), []uint{book.ID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve copy counts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, bookToResponse(book, counts))
+}
+
 // @Summary Create a new book
 // @Tags books
 // @Accept json
@@ -64,6 +320,7 @@ func (h *BooksHandler) GetAll(c *gin.Context) {
 // @Success 201 {object} dto.BookResponseDTO
 // @Failure 400 {object} map[string]interface{}
 // @Failure 403 {object} map[string]string
+// @Failure 409 {object} map[string]string
 // @Failure 500 {object} map[string]string
 // @Router /books/ [post]
 func (h *BooksHandler) Create(c *gin.Context) {
@@ -90,31 +347,36 @@ func (h *BooksHandler) Create(c *gin.Context) {
 		return
 	}
 
+	isbn, ok := h.checkISBN(c, bookDTO.ISBN, 0)
+	if !ok {
+		return
+	}
+
 	book := models.Book{
 		Title:       bookDTO.Title,
 		Description: bookDTO.Description,
+		ISBN:        isbn,
 		UserID:      userID.(uint),
 	}
+	if !h.resolveLinks(c, &book, bookDTO.AuthorIDs, bookDTO.PublisherIDs, bookDTO.SubjectIDs) {
+		return
+	}
 
-	if err := h.repo.Create(&book); err != nil {
+	if err := h.repo.Create(c.Request.Context(), &book); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create book"})
 		return
 	}
+	h.audit.record(c, "create", "book", book.ID, nil, snapshotBook(&book))
 
 	// Get username for response
 	username, _ := c.Get("username")
+	book.User.Username = username.(string)
 
-	response := dto.BookResponseDTO{
-		ID:          book.ID,
-		Title:       book.Title,
-		Description: book.Description,
-		UserID:      book.UserID,
-		Username:    username.(string),
-	}
-	c.JSON(http.StatusCreated, response)
+	c.JSON(http.StatusCreated, bookToResponse(&book, nil))
 }
 
 // @Summary Delete all books
+// @Description Requires the books:delete_all permission
 // @Tags books
 // @Success 204
 // @Failure 403 {object} map[string]string
@@ -126,17 +388,12 @@ func (h *BooksHandler) DeleteAll(c *gin.Context) {
 		return
 	}
 
-	// Only admin can delete all books
-	role, _ := c.Get("role")
-	if role.(string) != "admin" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Only admin can delete all books"})
-		return
-	}
-
-	if err := h.repo.DeleteAll(); err != nil {
+	if err := h.repo.DeleteAll(c.Request.Context()); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete books"})
 		return
 	}
+	h.audit.record(c, "delete_all", "book", 0, nil, nil)
+	purgeExpiredBooks(c.Request.Context(), h.repo, h.config)
 	c.Status(http.StatusNoContent)
 }
 
@@ -162,7 +419,7 @@ func (h *BooksHandler) GetByID(c *gin.Context) {
 		return
 	}
 
-	book, err := h.repo.FindByID(uint(id))
+	book, err := h.repo.FindByID(c.Request.Context(), uint(id))
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Book not found"})
@@ -172,14 +429,13 @@ func (h *BooksHandler) GetByID(c *gin.Context) {
 		return
 	}
 
-	response := dto.BookResponseDTO{
-		ID:          book.ID,
-		Title:       book.Title,
-		Description: book.Description,
-		UserID:      book.UserID,
-		Username:    book.User.Username,
+	counts, err := h.itemRepo.CountsByBooks(c.Request.Context(), []uint{book.ID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve copy counts"})
+		return
 	}
-	c.JSON(http.StatusOK, response)
+
+	c.JSON(http.StatusOK, bookToResponse(book, counts))
 }
 
 // @Summary Update book by ID
@@ -191,6 +447,7 @@ func (h *BooksHandler) GetByID(c *gin.Context) {
 // @Failure 400 {object} map[string]interface{}
 // @Failure 403 {object} map[string]string
 // @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
 // @Failure 500 {object} map[string]string
 // @Router /books/{id} [put]
 func (h *BooksHandler) Update(c *gin.Context) {
@@ -199,32 +456,12 @@ func (h *BooksHandler) Update(c *gin.Context) {
 		return
 	}
 
-	// Get current user info
-	userID, _ := c.Get("user_id")
-	role, _ := c.Get("role")
-
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
 		return
 	}
 
-	book, err := h.repo.FindByID(uint(id))
-	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Book not found"})
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve book"})
-		}
-		return
-	}
-
-	// Check ownership: only owner or admin can update
-	if book.UserID != userID.(uint) && role.(string) != "admin" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "You can only edit your own books"})
-		return
-	}
-
 	var bookDTO dto.BookUpdateDTO
 	if err := c.ShouldBindJSON(&bookDTO); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON", "details": err.Error()})
@@ -236,13 +473,38 @@ func (h *BooksHandler) Update(c *gin.Context) {
 		return
 	}
 
-	book.Title = bookDTO.Title
-	book.Description = bookDTO.Description
+	changes := service.BookChanges{Title: bookDTO.Title, Description: bookDTO.Description}
+	if bookDTO.ISBN != "" {
+		isbn, err := validation.NormalizeISBN(bookDTO.ISBN)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ISBN"})
+			return
+		}
+		changes.ISBN = &isbn
+	}
 
-	if err := h.repo.Update(book); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update book"})
+	var links models.Book
+	if !h.resolveLinks(c, &links, bookDTO.AuthorIDs, bookDTO.PublisherIDs, bookDTO.SubjectIDs) {
 		return
 	}
+	changes.Authors = links.Authors
+	changes.Publishers = links.Publishers
+	changes.Subjects = links.Subjects
+
+	before, book, err := h.books.Update(c.Request.Context(), actorOf(c, h.policy), uint(id), changes)
+	if err != nil {
+		switch {
+		case writeNotFound(c, err):
+		case errors.Is(err, service.ErrNotOwner):
+			c.JSON(http.StatusForbidden, gin.H{"error": "You can only edit your own books"})
+		case errors.Is(err, service.ErrISBNTaken):
+			c.JSON(http.StatusConflict, gin.H{"error": "A book with this ISBN already exists"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update book"})
+		}
+		return
+	}
+	h.audit.record(c, "update", "book", book.ID, snapshotBook(before), snapshotBook(book))
 
 	c.Status(http.StatusNoContent)
 }
@@ -262,36 +524,25 @@ func (h *BooksHandler) Delete(c *gin.Context) {
 		return
 	}
 
-	// Get current user info
-	userID, _ := c.Get("user_id")
-	role, _ := c.Get("role")
-
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
 		return
 	}
 
-	book, err := h.repo.FindByID(uint(id))
+	book, err := h.books.Delete(c.Request.Context(), actorOf(c, h.policy), uint(id))
 	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Book not found"})
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve book"})
+		switch {
+		case writeNotFound(c, err):
+		case errors.Is(err, service.ErrNotOwner):
+			c.JSON(http.StatusForbidden, gin.H{"error": "You can only delete your own books"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete book"})
 		}
 		return
 	}
-
-	// Check ownership: only owner or admin can delete
-	if book.UserID != userID.(uint) && role.(string) != "admin" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "You can only delete your own books"})
-		return
-	}
-
-	if err := h.repo.Delete(uint(id)); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete book"})
-		return
-	}
+	h.audit.record(c, "delete", "book", book.ID, snapshotBook(book), nil)
+	purgeExpiredBooks(c.Request.Context(), h.repo, h.config)
 
 	c.Status(http.StatusNoContent)
 }
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"lab1/models"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// load fetches the book named by the id path parameter, writing the error response if that fails
+func (h *BooksHandler) load(c *gin.Context) (*models.Book, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return nil, false
+	}
+
+	book, err := h.repo.FindByID(c.Request.Context(), uint(id))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Book not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve book"})
+		}
+		return nil, false
+	}
+	return book, true
+}
+
+// @Summary Get the history of a book
+// @Description Every saved version of the book with the fields changed since the version before; a revision is added by every update
+// @Tags books
+// @Produce json
+// @Param id path int true "Book ID"
+// @Param sort query string false "Sort field" Enums(number, created_at)
+// @Param order query string false "Sort order, newest first by default" Enums(asc, desc)
+// @Param page query int false "Page number, starting at 1"
+// @Param page_size query int false "Page size"
+// @Success 200 {object} dto.RevisionListResponseDTO
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /books/{id}/history [get]
+func (h *BooksHandler) GetHistory(c *gin.Context) {
+	if !h.config.EnableGetBooks {
+		c.JSON(http.StatusForbidden, gin.H{"error": "GET /books endpoint is disabled"})
+		return
+	}
+
+	book, ok := h.load(c)
+	if !ok {
+		return
+	}
+	revisionHistory(c, h.config, book.ID, h.repo.FindRevisions, h.repo.FindRevision)
+}
+
+// @Summary Restore a revision of a book
+// @Description Sets title, description, ISBN, authors, publishers and subjects back to those of the revision, which adds a new revision
+// @Tags books
+// @Produce json
+// @Param id path int true "Book ID"
+// @Param rev path int true "Revision number"
+// @Success 200 {object} dto.BookResponseDTO
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /books/{id}/history/{rev}/restore [post]
+func (h *BooksHandler) RestoreRevision(c *gin.Context) {
+	if !h.config.EnablePutBooks {
+		c.JSON(http.StatusForbidden, gin.H{"error": "PUT /books endpoint is disabled"})
+		return
+	}
+
+	book, ok := h.load(c)
+	if !ok {
+		return
+	}
+	if !canChangeBook(c, h.policy, book) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can only edit your own books"})
+		return
+	}
+	number, ok := parseRevision(c)
+	if !ok {
+		return
+	}
+
+	revision, err := h.repo.FindRevision(c.Request.Context(), book.ID, number)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Revision not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve revision"})
+		}
+		return
+	}
+	var version models.BookVersion
+	if err := json.Unmarshal([]byte(revision.Data), &version); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read revision"})
+		return
+	}
+
+	// Another book may have taken the ISBN since, and links may have been deleted
+	rawISBN := ""
+	if version.ISBN != nil {
+		rawISBN = *version.ISBN
+	}
+	isbn, ok := h.checkISBN(c, rawISBN, book.ID)
+	if !ok {
+		return
+	}
+
+	// FindByID may return the cached instance, so work on a copy
+	restored := *book
+	restored.Title = version.Title
+	restored.Description = version.Description
+	restored.ISBN = isbn
+	if !h.resolveLinks(c, &restored, version.AuthorIDs, version.PublisherIDs, version.SubjectIDs) {
+		return
+	}
+
+	if err := h.repo.Update(c.Request.Context(), &restored); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore book"})
+		return
+	}
+	h.audit.record(c, "restore", "book", book.ID, snapshotBook(book), snapshotBook(&restored))
+
+	counts, err := h.itemRepo.CountsByBooks(c.Request.Context(), []uint{book.ID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve copy counts"})
+		return
+	}
+	c.JSON(http.StatusOK, bookToResponse(&restored, counts))
+}
@@ -0,0 +1,430 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"lab1/dto"
+	"lab1/models"
+	"lab1/validation"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+// maxImportBytes caps the size of an uploaded import file
+const maxImportBytes = 32 << 20
+
+// exportBatchSize is the number of books read per query while exporting
+const exportBatchSize = 500
+
+// importFields are the book fields an import column can be mapped to. The
+// multi-valued ones hold names separated by importListSeparator.
+var importFields = []string{"title", "description", "isbn", "authors", "publishers", "subjects"}
+
+const importListSeparator = ";"
+
+// importRow is one book of an import file before validation
+type importRow struct {
+	Line        int // position in the file, reported with the row's errors
+	Title       string
+	Description string
+	ISBN        string
+	Authors     []string
+	Publishers  []string
+	Subjects    []string
+	MARCRecord  string // source record of a MARC import
+}
+
+// @Summary Import books from CSV
+// @Description Imports books from a CSV file, uploaded as multipart field "file" or as the request body.
+// @Description Columns are matched to the fields title, description, isbn, authors, publishers and subjects by header name;
+// @Description mapping is a JSON object from header to field for files with other headers. Other columns are ignored.
+// @Description Authors, publishers and subjects are separated by ";" and matched by name, unknown names are created.
+// @Description Nothing is stored if any row is invalid. With dry_run=true the file is only validated.
+// @Tags books
+// @Accept text/csv
+// @Accept multipart/form-data
+// @Produce json
+// @Param file formData file false "CSV file"
+// @Param mapping query string false "JSON object mapping CSV headers to book fields"
+// @Param dry_run query bool false "Only validate the file"
+// @Success 200 {object} dto.BookImportResultDTO "Dry run without errors"
+// @Success 201 {object} dto.BookImportResultDTO
+// @Failure 400 {object} dto.BookImportResultDTO
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /books/import [post]
+func (h *BooksHandler) Import(c *gin.Context) {
+	if !h.config.EnablePostBooks {
+		c.JSON(http.StatusForbidden, gin.H{"error": "POST /books endpoint is disabled"})
+		return
+	}
+
+	dryRun, ok := parseDryRun(c)
+	if !ok {
+		return
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportBytes)
+
+	mapping := map[string]string{}
+	if raw := c.DefaultQuery("mapping", c.PostForm("mapping")); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &mapping); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid mapping, expected a JSON object from CSV header to book field"})
+			return
+		}
+		for header, field := range mapping {
+			if !isImportField(field) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid mapping for %q, expected one of: %s", header, strings.Join(importFields, ", "))})
+				return
+			}
+		}
+	}
+
+	file, ok := importFile(c)
+	if !ok {
+		return
+	}
+	defer file.Close()
+
+	rows, rowErrors, err := readCSVRows(file, mapping, h.config.ImportMaxRows)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid CSV", "details": err.Error()})
+		return
+	}
+
+	h.importRows(c, rows, rowErrors, dryRun)
+}
+
+func parseDryRun(c *gin.Context) (bool, bool) {
+	raw := c.Query("dry_run")
+	if raw == "" {
+		return false, true
+	}
+	dryRun, err := strconv.ParseBool(raw)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid dry_run, expected true or false"})
+		return false, false
+	}
+	return dryRun, true
+}
+
+func isImportField(field string) bool {
+	for _, f := range importFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// importFile returns the uploaded file of a multipart request, or the request
+// body otherwise. It writes a 400 response and returns false on failure.
+func importFile(c *gin.Context) (io.ReadCloser, bool) {
+	if !strings.HasPrefix(c.ContentType(), "multipart/form-data") {
+		return c.Request.Body, true
+	}
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing import file", "details": err.Error()})
+		return nil, false
+	}
+	file, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read import file", "details": err.Error()})
+		return nil, false
+	}
+	return file, true
+}
+
+// readCSVRows parses a CSV import. Rows that cannot be read are reported as row
+// errors; an error is only returned if the file as a whole is unusable.
+func readCSVRows(r io.Reader, mapping map[string]string, maxRows int) ([]importRow, []dto.BookImportErrorDTO, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil, errors.New("the file is empty")
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Every field comes from at most one column, mapped or named after the field
+	columns := map[string]int{}
+	for i, name := range header {
+		name = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+		field, mapped := mapping[name]
+		if !mapped {
+			field = strings.ToLower(name)
+			if !isImportField(field) {
+				continue
+			}
+		}
+		if _, duplicate := columns[field]; duplicate {
+			return nil, nil, fmt.Errorf("more than one column maps to %s", field)
+		}
+		columns[field] = i
+	}
+	if _, ok := columns["title"]; !ok {
+		return nil, nil, errors.New("no column maps to title")
+	}
+
+	var rows []importRow
+	var rowErrors []dto.BookImportErrorDTO
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return nil, nil, err
+			}
+			rowErrors = append(rowErrors, dto.BookImportErrorDTO{Row: parseErr.Line, Field: "general", Message: parseErr.Err.Error()})
+			continue
+		}
+		if len(rows)+len(rowErrors) >= maxRows {
+			return nil, nil, fmt.Errorf("more than %d rows", maxRows)
+		}
+		line, _ := reader.FieldPos(0)
+
+		value := func(field string) string {
+			i, ok := columns[field]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+		rows = append(rows, importRow{
+			Line:        line,
+			Title:       value("title"),
+			Description: value("description"),
+			ISBN:        value("isbn"),
+			Authors:     splitNames(value("authors")),
+			Publishers:  splitNames(value("publishers")),
+			Subjects:    splitNames(value("subjects")),
+		})
+	}
+	return rows, rowErrors, nil
+}
+
+// splitNames splits a multi-valued column, dropping blanks and repeated names
+func splitNames(s string) []string {
+	var names []string
+	seen := map[string]bool{}
+	for _, name := range strings.Split(s, importListSeparator) {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[strings.ToLower(name)] {
+			continue
+		}
+		seen[strings.ToLower(name)] = true
+		names = append(names, name)
+	}
+	return names
+}
+
+// importRows validates the rows like BooksHandler.Create does and stores them
+// in one transaction, attributed to the calling user. If any row is invalid,
+// or on a dry run, nothing is stored.
+func (h *BooksHandler) importRows(c *gin.Context, rows []importRow, rowErrors []dto.BookImportErrorDTO, dryRun bool) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	result := dto.BookImportResultDTO{DryRun: dryRun, Rows: len(rows) + len(rowErrors), Errors: rowErrors}
+	// addErrors reports the validation errors of a row. A non-empty column
+	// stands in for the name of the DTO field, as the name DTO is checked once
+	// per author, publisher or subject.
+	addErrors := func(line int, column string, err error) {
+		var validationErrs validator.ValidationErrors
+		if !errors.As(err, &validationErrs) {
+			result.Errors = append(result.Errors, dto.BookImportErrorDTO{Row: line, Field: "general", Message: err.Error()})
+			return
+		}
+		for _, fieldErr := range validation.FormatValidationErrors(validationErrs)["errors"].([]validation.ValidationError) {
+			if column != "" {
+				fieldErr.Message = column + strings.TrimPrefix(fieldErr.Message, fieldErr.Field)
+				fieldErr.Field = column
+			}
+			result.Errors = append(result.Errors, dto.BookImportErrorDTO{Row: line, Field: fieldErr.Field, Message: fieldErr.Message})
+		}
+	}
+
+	books := make([]models.Book, 0, len(rows))
+	isbnLines := map[string]int{}
+	for _, row := range rows {
+		failed := len(result.Errors)
+
+		// Files may carry stray control characters, which are dropped rather than rejected
+		row.Title = validation.StripControl(row.Title)
+		row.Description = validation.StripControl(row.Description)
+		bookDTO := dto.BookCreateDTO{Title: row.Title, Description: row.Description, ISBN: row.ISBN}
+		if err := h.validator.ValidateStruct(bookDTO); err != nil {
+			addErrors(row.Line, "", err)
+		}
+		for _, list := range []struct {
+			field string
+			names []string
+		}{{"Authors", row.Authors}, {"Publishers", row.Publishers}, {"Subjects", row.Subjects}} {
+			for _, name := range list.names {
+				// The three kinds of records share the same name rules
+				if err := h.validator.ValidateStruct(dto.NameCreateDTO{Name: name}); err != nil {
+					addErrors(row.Line, list.field, err)
+				}
+			}
+		}
+
+		var isbn *string
+		if normalized, err := validation.NormalizeISBN(row.ISBN); row.ISBN != "" && err == nil {
+			isbn = &normalized
+			if line, ok := isbnLines[normalized]; ok {
+				result.Errors = append(result.Errors, dto.BookImportErrorDTO{Row: row.Line, Field: "ISBN", Message: fmt.Sprintf("ISBN is already used in row %d", line)})
+			} else if taken, err := h.repo.ISBNTaken(c.Request.Context(), normalized, 0); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check ISBN"})
+				return
+			} else if taken {
+				result.Errors = append(result.Errors, dto.BookImportErrorDTO{Row: row.Line, Field: "ISBN", Message: "A book with this ISBN already exists"})
+			}
+			isbnLines[normalized] = row.Line
+		}
+
+		if len(result.Errors) > failed {
+			continue
+		}
+		book := models.Book{
+			Title:       row.Title,
+			Description: row.Description,
+			ISBN:        isbn,
+			UserID:      userID.(uint),
+			MARCRecord:  row.MARCRecord,
+		}
+		for _, name := range row.Authors {
+			book.Authors = append(book.Authors, models.Author{Name: name})
+		}
+		for _, name := range row.Publishers {
+			book.Publishers = append(book.Publishers, models.Publisher{Name: name})
+		}
+		for _, name := range row.Subjects {
+			book.Subjects = append(book.Subjects, models.Subject{Name: name})
+		}
+		books = append(books, book)
+	}
+
+	if len(result.Errors) > 0 {
+		sort.SliceStable(result.Errors, func(i, j int) bool { return result.Errors[i].Row < result.Errors[j].Row })
+		c.JSON(http.StatusBadRequest, result)
+		return
+	}
+	if result.Errors == nil {
+		result.Errors = []dto.BookImportErrorDTO{}
+	}
+	if dryRun {
+		c.JSON(http.StatusOK, result)
+		return
+	}
+
+	if err := h.repo.Import(c.Request.Context(), books); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import books"})
+		return
+	}
+	events := make([]*models.AuditEvent, len(books))
+	for i := range books {
+		events[i] = h.audit.event(c, "import", "book", books[i].ID, nil, snapshotBook(&books[i]))
+	}
+	// The books are stored already, so failing to record them only shows in the log
+	if err := h.audit.repo.Record(recordContext(c), events...); err != nil {
+		log.Printf("BooksHandler.Import: error recording %d imported books: %v", len(events), err)
+	}
+	result.Imported = len(books)
+	c.JSON(http.StatusCreated, result)
+}
+
+// @Summary Export books
+// @Description Streams the whole catalogue. Authors, publishers and subjects are separated by ";", as accepted by POST /books/import.
+// @Tags books
+// @Produce text/csv
+// @Param format query string false "Export format" Enums(csv)
+// @Success 200 {file} file
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Router /books/export [get]
+func (h *BooksHandler) Export(c *gin.Context) {
+	if !h.config.EnableGetBooks {
+		c.JSON(http.StatusForbidden, gin.H{"error": "GET /books endpoint is disabled"})
+		return
+	}
+	if format := c.DefaultQuery("format", "csv"); format != "csv" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported format, expected csv"})
+		return
+	}
+
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", `attachment; filename="books.csv"`)
+	c.Status(http.StatusOK)
+
+	writer := csv.NewWriter(c.Writer)
+	header := []string{"id", "title", "description", "isbn", "authors", "publishers", "subjects", "owner", "created_at"}
+	if err := writer.Write(header); err != nil {
+		return
+	}
+
+	err := h.repo.ForEachBatch(c.Request.Context(), exportBatchSize, func(books []models.Book) error {
+		for _, book := range books {
+			isbn := ""
+			if book.ISBN != nil {
+				isbn = *book.ISBN
+			}
+			authors := make([]string, len(book.Authors))
+			for i, author := range book.Authors {
+				authors[i] = author.Name
+			}
+			publishers := make([]string, len(book.Publishers))
+			for i, publisher := range book.Publishers {
+				publishers[i] = publisher.Name
+			}
+			subjects := make([]string, len(book.Subjects))
+			for i, subject := range book.Subjects {
+				subjects[i] = subject.Name
+			}
+
+			err := writer.Write([]string{
+				strconv.FormatUint(uint64(book.ID), 10),
+				book.Title,
+				book.Description,
+				isbn,
+				strings.Join(authors, importListSeparator),
+				strings.Join(publishers, importListSeparator),
+				strings.Join(subjects, importListSeparator),
+				book.User.Username,
+				book.CreatedAt.UTC().Format(time.RFC3339),
+			})
+			if err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		c.Writer.Flush()
+		return writer.Error()
+	})
+	if err != nil {
+		// The status line is already sent, so the client only sees a truncated file
+		log.Printf("BooksHandler.Export: export aborted: %v", err)
+		return
+	}
+	writer.Flush()
+}
@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"lab1/marc"
+	"lab1/models"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// @Summary Import books from MARC
+// @Description Imports binary MARC 21 or MARCXML records, uploaded as multipart field "file" or as the request body.
+// @Description Title is read from 245, ISBN from 020, authors from 100 and 700, subjects from 650 and the description from 520.
+// @Description The whole record is kept, so GET /books/export/marc writes the unmapped fields back out.
+// @Description Nothing is stored if any record is invalid. With dry_run=true the file is only validated.
+// @Tags books
+// @Accept application/marc
+// @Accept application/marcxml+xml
+// @Accept multipart/form-data
+// @Produce json
+// @Param file formData file false "MARC file"
+// @Param format query string false "File format, detected from the content if omitted" Enums(binary, xml)
+// @Param dry_run query bool false "Only validate the file"
+// @Success 200 {object} dto.BookImportResultDTO "Dry run without errors"
+// @Success 201 {object} dto.BookImportResultDTO
+// @Failure 400 {object} dto.BookImportResultDTO
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /books/import/marc [post]
+func (h *BooksHandler) ImportMARC(c *gin.Context) {
+	if !h.config.EnablePostBooks {
+		c.JSON(http.StatusForbidden, gin.H{"error": "POST /books endpoint is disabled"})
+		return
+	}
+
+	dryRun, ok := parseDryRun(c)
+	if !ok {
+		return
+	}
+	format := c.Query("format")
+	if format != "" && format != "binary" && format != "xml" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported format, expected binary or xml"})
+		return
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportBytes)
+
+	file, ok := importFile(c)
+	if !ok {
+		return
+	}
+	defer file.Close()
+
+	input := bufio.NewReader(file)
+	if format == "" {
+		format = detectMARCFormat(input)
+	}
+	var reader marc.RecordReader = marc.NewReader(input)
+	if format == "xml" {
+		reader = marc.NewXMLReader(input)
+	}
+
+	var rows []importRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid MARC", "details": fmt.Sprintf("record %d: %v", len(rows)+1, err)})
+			return
+		}
+		if len(rows) >= h.config.ImportMaxRows {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid MARC", "details": fmt.Sprintf("more than %d records", h.config.ImportMaxRows)})
+			return
+		}
+
+		book, err := marc.ToBook(record)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid MARC", "details": fmt.Sprintf("record %d: %v", len(rows)+1, err)})
+			return
+		}
+		row := importRow{
+			Line:        len(rows) + 1,
+			Title:       book.Title,
+			Description: book.Description,
+			MARCRecord:  book.MARCRecord,
+		}
+		if book.ISBN != nil {
+			row.ISBN = *book.ISBN
+		}
+		for _, author := range book.Authors {
+			row.Authors = append(row.Authors, author.Name)
+		}
+		for _, subject := range book.Subjects {
+			row.Subjects = append(row.Subjects, subject.Name)
+		}
+		rows = append(rows, row)
+	}
+
+	h.importRows(c, rows, nil, dryRun)
+}
+
+// detectMARCFormat tells MARCXML, which starts with "<" after optional
+// whitespace, from binary MARC, which starts with the record length digits
+func detectMARCFormat(r *bufio.Reader) string {
+	for i := 1; ; i++ {
+		peeked, err := r.Peek(i)
+		if err != nil {
+			return "binary"
+		}
+		switch peeked[i-1] {
+		case ' ', '\t', '\r', '\n':
+			continue
+		case '<':
+			return "xml"
+		default:
+			return "binary"
+		}
+	}
+}
+
+// @Summary Export books as MARC
+// @Description Streams the whole catalogue as binary MARC 21 or as a MARCXML collection.
+// @Description Books imported from MARC keep the fields of their source record that have no book column.
+// @Tags books
+// @Produce application/marc
+// @Produce application/marcxml+xml
+// @Param format query string false "Export format" Enums(binary, xml)
+// @Success 200 {file} file
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Router /books/export/marc [get]
+func (h *BooksHandler) ExportMARC(c *gin.Context) {
+	if !h.config.EnableGetBooks {
+		c.JSON(http.StatusForbidden, gin.H{"error": "GET /books endpoint is disabled"})
+		return
+	}
+
+	format := c.DefaultQuery("format", "binary")
+	switch format {
+	case "binary":
+		c.Header("Content-Type", "application/marc")
+		c.Header("Content-Disposition", `attachment; filename="books.mrc"`)
+	case "xml":
+		c.Header("Content-Type", "application/marcxml+xml; charset=utf-8")
+		c.Header("Content-Disposition", `attachment; filename="books.xml"`)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported format, expected binary or xml"})
+		return
+	}
+	c.Status(http.StatusOK)
+
+	xmlWriter := marc.NewXMLWriter(c.Writer)
+	err := h.repo.ForEachBatch(c.Request.Context(), exportBatchSize, func(books []models.Book) error {
+		for i := range books {
+			record, err := marc.FromBook(&books[i])
+			if err != nil {
+				return fmt.Errorf("book %d: %w", books[i].ID, err)
+			}
+
+			if format == "xml" {
+				err = xmlWriter.Write(record)
+			} else {
+				var data []byte
+				if data, err = record.MarshalBinary(); err == nil {
+					_, err = c.Writer.Write(data)
+				}
+			}
+			if err != nil {
+				return fmt.Errorf("book %d: %w", books[i].ID, err)
+			}
+		}
+		if format == "xml" {
+			if err := xmlWriter.Flush(); err != nil {
+				return err
+			}
+		}
+		c.Writer.Flush()
+		return nil
+	})
+	if err != nil {
+		// The status line is already sent, so the client only sees a truncated file
+		log.Printf("BooksHandler.ExportMARC: export aborted: %v", err)
+		return
+	}
+	if format == "xml" {
+		if err := xmlWriter.Close(); err != nil {
+			log.Printf("BooksHandler.ExportMARC: export aborted: %v", err)
+		}
+	}
+}
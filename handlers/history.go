@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"lab1/config"
+	"lab1/dto"
+	"lab1/repository"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// revisionHistory answers GET .../history for an entity whose existence has
+// been checked: a page of its revisions, newest first by default, each with
+// the fields changed since the revision before it
+func revisionHistory(c *gin.Context, cfg *config.Config, id uint,
+	findRevisions func(ctx context.Context, id uint, query repository.ListQuery) (*repository.RevisionPage, error),
+	findRevision func(ctx context.Context, id uint, number int) (*repository.Revision, error)) {
+	query, ok := parseListQuery(c, cfg, repository.RevisionSortFields)
+	if !ok {
+		return
+	}
+	if c.Query("sort") == "" && c.Query("order") == "" {
+		query.Sort, query.Order = "number", "desc"
+	}
+
+	page, err := findRevisions(c.Request.Context(), id, query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve history"})
+		return
+	}
+
+	// Revisions are numbered without gaps, so the previous one of every
+	// revision is on the page except for the oldest
+	data := map[int]string{}
+	for _, revision := range page.Revisions {
+		data[revision.Number] = revision.Data
+	}
+	for _, revision := range page.Revisions {
+		previous := revision.Number - 1
+		if _, found := data[previous]; found || previous < 1 {
+			continue
+		}
+		found, err := findRevision(c.Request.Context(), id, previous)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve history"})
+			return
+		}
+		if found != nil {
+			data[previous] = found.Data
+		}
+	}
+
+	response := dto.RevisionListResponseDTO{Data: make([]dto.RevisionDTO, len(page.Revisions))}
+	for i, revision := range page.Revisions {
+		response.Data[i] = dto.RevisionDTO{
+			Revision:  revision.Number,
+			CreatedAt: revision.CreatedAt,
+			Data:      json.RawMessage(revision.Data),
+			Changes:   revisionChanges(data[revision.Number-1], revision.Data),
+		}
+	}
+	response.Meta, response.Links = pageResponse(c, query, page.Total)
+	c.JSON(http.StatusOK, response)
+}
+
+// revisionChanges compares two revisions field by field, in alphabetical
+// order of the fields. An empty previous stands for no revision, so that
+// every field of the first one shows as set.
+func revisionChanges(previous, current string) []dto.FieldChangeDTO {
+	var before, after map[string]json.RawMessage
+	if previous != "" {
+		json.Unmarshal([]byte(previous), &before)
+	}
+	json.Unmarshal([]byte(current), &after)
+
+	fields := make([]string, 0, len(after))
+	for field := range after {
+		fields = append(fields, field)
+	}
+	for field := range before {
+		if _, found := after[field]; !found {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+
+	changes := []dto.FieldChangeDTO{}
+	for _, field := range fields {
+		from, to := jsonOrNull(before[field]), jsonOrNull(after[field])
+		if !bytes.Equal(from, to) {
+			changes = append(changes, dto.FieldChangeDTO{Field: field, From: from, To: to})
+		}
+	}
+	return changes
+}
+
+func jsonOrNull(value json.RawMessage) json.RawMessage {
+	if value == nil {
+		return json.RawMessage("null")
+	}
+	return value
+}
+
+// parseRevision reads the rev path parameter, writing a 400 response if it is invalid
+func parseRevision(c *gin.Context) (int, bool) {
+	number, err := strconv.Atoi(c.Param("rev"))
+	if err != nil || number < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid revision"})
+		return 0, false
+	}
+	return number, true
+}
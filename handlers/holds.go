@@ -0,0 +1,245 @@
+package handlers
+
+import (
+	"errors"
+	"lab1/config"
+	"lab1/dto"
+	"lab1/models"
+	"lab1/repository"
+	"lab1/service"
+	"lab1/validation"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type HoldsHandler struct {
+	repo       repository.HoldRepository
+	bookRepo   repository.BookRepository
+	readerRepo repository.ReaderRepository
+	holds      *service.HoldService
+	validator  *validation.Validator
+	config     *config.Config
+}
+
+func NewHoldsHandler(holds *service.HoldService, repo repository.HoldRepository, bookRepo repository.BookRepository, readerRepo repository.ReaderRepository, validator *validation.Validator, config *config.Config) *HoldsHandler {
+	return &HoldsHandler{
+		repo:       repo,
+		bookRepo:   bookRepo,
+		readerRepo: readerRepo,
+		holds:      holds,
+		validator:  validator,
+		config:     config,
+	}
+}
+
+func holdToResponse(hold *models.Hold, position int) dto.HoldResponseDTO {
+	return dto.HoldResponseDTO{
+		ID:         hold.ID,
+		ReaderID:   hold.ReaderID,
+		ReaderName: hold.Reader.Name + " " + hold.Reader.Surname,
+		BookID:     hold.BookID,
+		BookTitle:  hold.Book.Title,
+		Status:     hold.Status,
+		Position:   position,
+		CreatedAt:  hold.CreatedAt,
+		ReadyAt:    hold.ReadyAt,
+		ExpiresAt:  hold.ExpiresAt,
+	}
+}
+
+// @Summary Get the hold queue of a book
+// @Tags holds
+// @Produce json
+// @Param id path int true "Book ID"
+// @Success 200 {array} dto.HoldResponseDTO
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /books/{id}/holds [get]
+func (h *HoldsHandler) GetBookQueue(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	if _, err := h.bookRepo.FindByID(c.Request.Context(), uint(id)); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Book not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve book"})
+		}
+		return
+	}
+
+	if err := h.holds.Expire(c.Request.Context(), uint(id)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update hold queue"})
+		return
+	}
+
+	queue, err := h.repo.FindQueueByBook(c.Request.Context(), uint(id))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve holds"})
+		return
+	}
+
+	response := make([]dto.HoldResponseDTO, len(queue))
+	for i := range queue {
+		response[i] = holdToResponse(&queue[i], i+1)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// @Summary Place a hold on a lent book
+// @Tags holds
+// @Accept json
+// @Produce json
+// @Param id path int true "Book ID"
+// @Param hold body dto.HoldCreateDTO true "Reader placing the hold"
+// @Success 201 {object} dto.HoldResponseDTO
+// @Failure 400 {object} map[string]interface{}
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /books/{id}/holds [post]
+func (h *HoldsHandler) Create(c *gin.Context) {
+	bookID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	var holdDTO dto.HoldCreateDTO
+	if err := c.ShouldBindJSON(&holdDTO); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON", "details": err.Error()})
+		return
+	}
+
+	if err := h.validator.ValidateStruct(holdDTO); err != nil {
+		c.JSON(http.StatusBadRequest, validation.FormatValidationErrors(err))
+		return
+	}
+
+	hold, position, err := h.holds.Place(c.Request.Context(), uint(bookID), holdDTO.ReaderID)
+	if err != nil {
+		switch {
+		case writeNotFound(c, err):
+		case errors.Is(err, service.ErrBookWithReader):
+			c.JSON(http.StatusConflict, gin.H{"error": "Reader already has this book"})
+		case errors.Is(err, service.ErrAlreadyHeld):
+			c.JSON(http.StatusConflict, gin.H{"error": "Reader already has a hold on this book"})
+		case errors.Is(err, service.ErrBookAvailable):
+			c.JSON(http.StatusConflict, gin.H{"error": "Book is available, check it out instead"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create hold"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, holdToResponse(hold, position))
+}
+
+// @Summary Get holds of a reader
+// @Tags holds
+// @Produce json
+// @Param id path int true "Reader ID"
+// @Success 200 {array} dto.HoldResponseDTO
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /readers/{id}/holds [get]
+func (h *HoldsHandler) GetReaderHolds(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	if _, err := h.readerRepo.FindByID(c.Request.Context(), uint(id)); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Reader not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve reader"})
+		}
+		return
+	}
+
+	holds, err := h.repo.FindByReader(c.Request.Context(), uint(id))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve holds"})
+		return
+	}
+
+	// Bring pickup deadlines up to date before reporting statuses
+	now := time.Now()
+	stale := false
+	for _, hold := range holds {
+		if hold.Status == models.HoldStatusReady && hold.ExpiresAt != nil && hold.ExpiresAt.Before(now) {
+			if err := h.holds.Expire(c.Request.Context(), hold.BookID); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update hold queue"})
+				return
+			}
+			stale = true
+		}
+	}
+	if stale {
+		if holds, err = h.repo.FindByReader(c.Request.Context(), uint(id)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve holds"})
+			return
+		}
+	}
+
+	response := make([]dto.HoldResponseDTO, len(holds))
+	for i := range holds {
+		position := 0
+		if holds[i].IsActive() {
+			if position, err = h.repo.QueuePosition(c.Request.Context(), &holds[i]); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve queue position"})
+				return
+			}
+		}
+		response[i] = holdToResponse(&holds[i], position)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// @Summary Cancel a reader's hold
+// @Tags holds
+// @Param id path int true "Reader ID"
+// @Param holdId path int true "Hold ID"
+// @Success 204
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /readers/{id}/holds/{holdId} [delete]
+func (h *HoldsHandler) Cancel(c *gin.Context) {
+	readerID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid reader ID"})
+		return
+	}
+
+	holdID, err := strconv.Atoi(c.Param("holdId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid hold ID"})
+		return
+	}
+
+	if err := h.holds.Cancel(c.Request.Context(), uint(readerID), uint(holdID)); err != nil {
+		switch {
+		case writeNotFound(c, err):
+		case errors.Is(err, service.ErrHoldInactive):
+			c.JSON(http.StatusConflict, gin.H{"error": "Hold is no longer active"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel hold"})
+		}
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
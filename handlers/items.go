@@ -0,0 +1,310 @@
+package handlers
+
+import (
+	"errors"
+	"lab1/config"
+	"lab1/dto"
+	"lab1/models"
+	"lab1/rbac"
+	"lab1/repository"
+	"lab1/service"
+	"lab1/validation"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type ItemsHandler struct {
+	repo      repository.ItemRepository
+	bookRepo  repository.BookRepository
+	items     *service.ItemService
+	policy    *rbac.Policy
+	validator *validation.Validator
+	config    *config.Config
+}
+
+func NewItemsHandler(items *service.ItemService, repo repository.ItemRepository, bookRepo repository.BookRepository, policy *rbac.Policy, validator *validation.Validator, config *config.Config) *ItemsHandler {
+	return &ItemsHandler{
+		repo:      repo,
+		bookRepo:  bookRepo,
+		items:     items,
+		policy:    policy,
+		validator: validator,
+		config:    config,
+	}
+}
+
+func itemToResponse(item *models.Item) dto.ItemResponseDTO {
+	return dto.ItemResponseDTO{
+		ID:            item.ID,
+		BookID:        item.BookID,
+		BookTitle:     item.Book.Title,
+		Barcode:       item.Barcode,
+		ShelfLocation: item.ShelfLocation,
+		Condition:     item.Condition,
+		Status:        item.Status,
+		AcquiredAt:    item.AcquiredAt,
+	}
+}
+
+// loadBook resolves the :id path parameter and writes the error response itself
+func (h *ItemsHandler) loadBook(c *gin.Context) (*models.Book, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return nil, false
+	}
+
+	book, err := h.bookRepo.FindByID(c.Request.Context(), uint(id))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Book not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve book"})
+		}
+		return nil, false
+	}
+	return book, true
+}
+
+// loadItem resolves the :itemId path parameter, making sure the copy belongs to the book
+func (h *ItemsHandler) loadItem(c *gin.Context, book *models.Book) (*models.Item, bool) {
+	itemID, err := strconv.Atoi(c.Param("itemId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid item ID format"})
+		return nil, false
+	}
+
+	item, err := h.repo.FindByID(c.Request.Context(), uint(itemID))
+	if err != nil || item.BookID != book.ID {
+		if err == nil || errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Item not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve item"})
+		}
+		return nil, false
+	}
+	return item, true
+}
+
+// @Summary Get all copies of a book
+// @Tags items
+// @Produce json
+// @Param id path int true "Book ID"
+// @Success 200 {array} dto.ItemResponseDTO
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /books/{id}/items [get]
+func (h *ItemsHandler) GetByBook(c *gin.Context) {
+	book, ok := h.loadBook(c)
+	if !ok {
+		return
+	}
+
+	items, err := h.repo.FindByBook(c.Request.Context(), book.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve items"})
+		return
+	}
+
+	response := make([]dto.ItemResponseDTO, len(items))
+	for i := range items {
+		response[i] = itemToResponse(&items[i])
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// @Summary Add a copy of a book
+// @Tags items
+// @Accept json
+// @Produce json
+// @Param id path int true "Book ID"
+// @Param item body dto.ItemCreateDTO true "Copy to add"
+// @Success 201 {object} dto.ItemResponseDTO
+// @Failure 400 {object} map[string]interface{}
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /books/{id}/items [post]
+func (h *ItemsHandler) Create(c *gin.Context) {
+	bookID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	var itemDTO dto.ItemCreateDTO
+	if err := c.ShouldBindJSON(&itemDTO); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON", "details": err.Error()})
+		return
+	}
+
+	if err := h.validator.ValidateStruct(itemDTO); err != nil {
+		c.JSON(http.StatusBadRequest, validation.FormatValidationErrors(err))
+		return
+	}
+
+	item := models.Item{
+		BookID:        uint(bookID),
+		Barcode:       itemDTO.Barcode,
+		ShelfLocation: itemDTO.ShelfLocation,
+		Condition:     itemDTO.Condition,
+		Status:        itemDTO.Status,
+		AcquiredAt:    itemDTO.AcquiredAt,
+	}
+	if err := h.items.Create(c.Request.Context(), actorOf(c, h.policy), &item); err != nil {
+		h.writeChangeError(c, err, "Failed to create item")
+		return
+	}
+
+	c.JSON(http.StatusCreated, itemToResponse(&item))
+}
+
+// @Summary Get a copy of a book
+// @Tags items
+// @Produce json
+// @Param id path int true "Book ID"
+// @Param itemId path int true "Item ID"
+// @Success 200 {object} dto.ItemResponseDTO
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /books/{id}/items/{itemId} [get]
+func (h *ItemsHandler) GetByID(c *gin.Context) {
+	book, ok := h.loadBook(c)
+	if !ok {
+		return
+	}
+
+	item, ok := h.loadItem(c, book)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, itemToResponse(item))
+}
+
+// @Summary Update a copy of a book
+// @Tags items
+// @Accept json
+// @Param id path int true "Book ID"
+// @Param itemId path int true "Item ID"
+// @Param item body dto.ItemUpdateDTO true "Updated copy data"
+// @Success 204
+// @Failure 400 {object} map[string]interface{}
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /books/{id}/items/{itemId} [put]
+func (h *ItemsHandler) Update(c *gin.Context) {
+	bookID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	itemID, err := strconv.Atoi(c.Param("itemId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid item ID format"})
+		return
+	}
+
+	var itemDTO dto.ItemUpdateDTO
+	if err := c.ShouldBindJSON(&itemDTO); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON", "details": err.Error()})
+		return
+	}
+
+	if err := h.validator.ValidateStruct(itemDTO); err != nil {
+		c.JSON(http.StatusBadRequest, validation.FormatValidationErrors(err))
+		return
+	}
+
+	changes := models.Item{
+		Barcode:       itemDTO.Barcode,
+		ShelfLocation: itemDTO.ShelfLocation,
+		Condition:     itemDTO.Condition,
+		Status:        itemDTO.Status,
+		AcquiredAt:    itemDTO.AcquiredAt,
+	}
+	if err := h.items.Update(c.Request.Context(), actorOf(c, h.policy), uint(bookID), uint(itemID), changes); err != nil {
+		h.writeChangeError(c, err, "Failed to update item")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// writeChangeError writes the response for an error of ItemService
+func (h *ItemsHandler) writeChangeError(c *gin.Context, err error, failure string) {
+	switch {
+	case writeNotFound(c, err):
+	case errors.Is(err, service.ErrNotOwner):
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can only manage copies of your own books"})
+	case errors.Is(err, service.ErrItemOnLoan):
+		c.JSON(http.StatusConflict, gin.H{"error": "Item is on loan"})
+	case errors.Is(err, service.ErrBarcodeExists):
+		c.JSON(http.StatusConflict, gin.H{"error": "Barcode already exists"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": failure})
+	}
+}
+
+// @Summary Delete a copy of a book
+// @Tags items
+// @Param id path int true "Book ID"
+// @Param itemId path int true "Item ID"
+// @Success 204
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /books/{id}/items/{itemId} [delete]
+func (h *ItemsHandler) Delete(c *gin.Context) {
+	bookID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	itemID, err := strconv.Atoi(c.Param("itemId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid item ID format"})
+		return
+	}
+
+	if err := h.items.Delete(c.Request.Context(), actorOf(c, h.policy), uint(bookID), uint(itemID)); err != nil {
+		h.writeChangeError(c, err, "Failed to delete item")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// @Summary Find a copy by barcode
+// @Tags items
+// @Produce json
+// @Param barcode path string true "Item barcode"
+// @Success 200 {object} dto.ItemResponseDTO
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /items/barcode/{barcode} [get]
+func (h *ItemsHandler) GetByBarcode(c *gin.Context) {
+	item, err := h.repo.FindByBarcode(c.Request.Context(), c.Param("barcode"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Item not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve item"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, itemToResponse(item))
+}
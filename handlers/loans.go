@@ -0,0 +1,274 @@
+package handlers
+
+import (
+	"errors"
+	"lab1/config"
+	"lab1/dto"
+	"lab1/models"
+	"lab1/rbac"
+	"lab1/repository"
+	"lab1/service"
+	"lab1/validation"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type LoansHandler struct {
+	loans      *service.LoanService
+	repo       repository.LoanRepository
+	readerRepo repository.ReaderRepository
+	policy     *rbac.Policy
+	validator  *validation.Validator
+	config     *config.Config
+}
+
+func NewLoansHandler(loans *service.LoanService, repo repository.LoanRepository, readerRepo repository.ReaderRepository, policy *rbac.Policy, validator *validation.Validator, config *config.Config) *LoansHandler {
+	return &LoansHandler{
+		loans:      loans,
+		repo:       repo,
+		readerRepo: readerRepo,
+		policy:     policy,
+		validator:  validator,
+		config:     config,
+	}
+}
+
+func loanToResponse(loan *models.Loan, now time.Time) dto.LoanResponseDTO {
+	barcode := ""
+	if loan.Item != nil {
+		barcode = loan.Item.Barcode
+	}
+	return dto.LoanResponseDTO{
+		ID:             loan.ID,
+		ReaderID:       loan.ReaderID,
+		ReaderName:     loan.Reader.Name + " " + loan.Reader.Surname,
+		BookID:         loan.BookID,
+		BookTitle:      loan.Book.Title,
+		ItemID:         loan.ItemID,
+		Barcode:        barcode,
+		CheckedOutAt:   loan.CheckedOutAt,
+		DueAt:          loan.DueAt,
+		ReturnedAt:     loan.ReturnedAt,
+		CheckedOutByID: loan.CheckedOutByID,
+		CheckedOutBy:   loan.CheckedOutBy.Username,
+		Status:         loan.Status(now),
+	}
+}
+
+func loansToResponse(loans []models.Loan) []dto.LoanResponseDTO {
+	now := time.Now()
+	response := make([]dto.LoanResponseDTO, len(loans))
+	for i := range loans {
+		response[i] = loanToResponse(&loans[i], now)
+	}
+	return response
+}
+
+// @Summary Get loans
+// @Tags loans
+// @Produce json
+// @Param reader_id query int false "Filter by reader ID"
+// @Param book_id query int false "Filter by book ID"
+// @Param status query string false "Filter by status (active, overdue, returned)"
+// @Success 200 {array} dto.LoanResponseDTO
+// @Failure 400 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /loans/ [get]
+func (h *LoansHandler) GetAll(c *gin.Context) {
+	var filter repository.LoanFilter
+
+	if v := c.Query("reader_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid reader ID"})
+			return
+		}
+		filter.ReaderID = uint(id)
+	}
+	if v := c.Query("book_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid book ID"})
+			return
+		}
+		filter.BookID = uint(id)
+	}
+	switch status := c.Query("status"); status {
+	case "", models.LoanStatusActive, models.LoanStatusOverdue, models.LoanStatusReturned:
+		filter.Status = status
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status, expected active, overdue or returned"})
+		return
+	}
+
+	loans, err := h.repo.FindAll(c.Request.Context(), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve loans"})
+		return
+	}
+
+	c.JSON(http.StatusOK, loansToResponse(loans))
+}
+
+// @Summary Check out a book to a reader
+// @Tags loans
+// @Accept json
+// @Produce json
+// @Param loan body dto.LoanCheckoutDTO true "Loan to create"
+// @Success 201 {object} dto.LoanResponseDTO
+// @Failure 400 {object} map[string]interface{}
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /loans/ [post]
+func (h *LoansHandler) Checkout(c *gin.Context) {
+	if _, exists := c.Get("user_id"); !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var loanDTO dto.LoanCheckoutDTO
+	if err := c.ShouldBindJSON(&loanDTO); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON", "details": err.Error()})
+		return
+	}
+
+	if err := h.validator.ValidateStruct(loanDTO); err != nil {
+		c.JSON(http.StatusBadRequest, validation.FormatValidationErrors(err))
+		return
+	}
+
+	loan, err := h.loans.Checkout(c.Request.Context(), actorOf(c, h.policy), service.Checkout{
+		ReaderID: loanDTO.ReaderID,
+		BookID:   loanDTO.BookID,
+		ItemID:   loanDTO.ItemID,
+		Barcode:  loanDTO.Barcode,
+		DueAt:    loanDTO.DueAt,
+	})
+	if err != nil {
+		var unavailable *service.CopyUnavailableError
+		switch {
+		case writeNotFound(c, err):
+		case errors.Is(err, service.ErrDueDateInPast):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Due date must be in the future"})
+		case errors.Is(err, service.ErrCopyMismatch):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Copy does not belong to the given book"})
+		case errors.Is(err, service.ErrReaderBlocked):
+			c.JSON(http.StatusForbidden, gin.H{"error": "Reader is blocked from borrowing until outstanding fines are paid"})
+		case errors.As(err, &unavailable):
+			c.JSON(http.StatusConflict, gin.H{"error": "Copy is not available (" + unavailable.Status + ")"})
+		case errors.Is(err, service.ErrNoCopiesAvailable):
+			c.JSON(http.StatusConflict, gin.H{"error": "No copies of this book are available"})
+		case errors.Is(err, service.ErrBookOnLoan):
+			c.JSON(http.StatusConflict, gin.H{"error": "Book is already on loan"})
+		case errors.Is(err, service.ErrBookReserved):
+			c.JSON(http.StatusConflict, gin.H{"error": "Book is reserved for another reader"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create loan"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, loanToResponse(loan, loan.CheckedOutAt))
+}
+
+// @Summary Get loan by ID
+// @Tags loans
+// @Produce json
+// @Param id path int true "Loan ID"
+// @Success 200 {object} dto.LoanResponseDTO
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /loans/{id} [get]
+func (h *LoansHandler) GetByID(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	loan, err := h.repo.FindByID(c.Request.Context(), uint(id))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Loan not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve loan"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, loanToResponse(loan, time.Now()))
+}
+
+// @Summary Return a lent book
+// @Tags loans
+// @Produce json
+// @Param id path int true "Loan ID"
+// @Success 200 {object} dto.LoanResponseDTO
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /loans/{id}/return [post]
+func (h *LoansHandler) Return(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	loan, fine, err := h.loans.Return(c.Request.Context(), uint(id))
+	if err != nil {
+		switch {
+		case writeNotFound(c, err):
+		case errors.Is(err, service.ErrAlreadyReturned):
+			c.JSON(http.StatusConflict, gin.H{"error": "Loan has already been returned"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to return loan"})
+		}
+		return
+	}
+
+	response := loanToResponse(loan, *loan.ReturnedAt)
+	response.FineCents = fine
+	c.JSON(http.StatusOK, response)
+}
+
+// @Summary Get loan history of a reader
+// @Tags loans
+// @Produce json
+// @Param id path int true "Reader ID"
+// @Success 200 {array} dto.LoanResponseDTO
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /readers/{id}/loans [get]
+func (h *LoansHandler) GetReaderHistory(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	if _, err := h.readerRepo.FindByID(c.Request.Context(), uint(id)); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Reader not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve reader"})
+		}
+		return
+	}
+
+	loans, err := h.repo.FindByReader(c.Request.Context(), uint(id))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve loan history"})
+		return
+	}
+
+	c.JSON(http.StatusOK, loansToResponse(loans))
+}
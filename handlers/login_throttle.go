@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"context"
+	"lab1/config"
+	"lab1/models"
+	"lab1/repository"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxUserAgentLength = 255
+
+// loginThrottle slows down password guessing, per username and per IP
+// address, and records every attempt
+type loginThrottle struct {
+	repo   repository.LoginAttemptRepository
+	config *config.Config
+}
+
+func userThrottleKey(username string) string {
+	return "user:" + strings.ToLower(username)
+}
+
+func ipThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+func (t *loginThrottle) lockout() time.Duration {
+	return time.Duration(t.config.LoginLockoutMinutes) * time.Minute
+}
+
+// backoff is the wait after the given number of consecutive failures, doubling
+// with every failure
+func (t *loginThrottle) backoff(failures int) time.Duration {
+	if failures < 1 {
+		return 0
+	}
+	wait := time.Duration(t.config.LoginBackoffBaseSeconds) * time.Second
+	limit := time.Duration(t.config.LoginBackoffMaxSeconds) * time.Second
+	for i := 1; i < failures && wait < limit; i++ {
+		wait *= 2
+	}
+	return min(wait, limit)
+}
+
+// retryAfter returns how long the username and the address have to wait
+// before the next attempt; locked is set if that is a lockout
+func (t *loginThrottle) retryAfter(ctx context.Context, username, ip string, now time.Time) (wait time.Duration, locked bool, err error) {
+	throttles, err := t.repo.GetThrottles(ctx, userThrottleKey(username), ipThrottleKey(ip))
+	if err != nil {
+		return 0, false, err
+	}
+
+	forgetBefore := now.Add(-t.lockout())
+	for _, throttle := range throttles {
+		if throttle.LockedUntil != nil && throttle.LockedUntil.After(now) {
+			wait = max(wait, throttle.LockedUntil.Sub(now))
+			locked = true
+			continue
+		}
+		if throttle.LastFailureAt.Before(forgetBefore) {
+			continue
+		}
+		failures := throttle.Failures
+		if strings.HasPrefix(throttle.Key, "ip:") {
+			// Users behind one address share it, so their typos must not slow each other down
+			failures -= t.config.LoginMaxFailures
+		}
+		wait = max(wait, throttle.LastFailureAt.Add(t.backoff(failures)).Sub(now))
+	}
+	return wait, locked, nil
+}
+
+// check rejects the attempt with 429 and a Retry-After header while the
+// username or the address is throttled. The password is not checked then, so
+// guesses during the wait tell nothing.
+func (t *loginThrottle) check(c *gin.Context, username string) bool {
+	wait, locked, err := t.retryAfter(c.Request.Context(), username, c.ClientIP(), time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return false
+	}
+	if wait <= 0 {
+		return true
+	}
+
+	t.record(c, username, nil, models.LoginThrottled)
+	seconds := int(math.Ceil(wait.Seconds()))
+	message := "Too many failed login attempts, please wait before trying again"
+	if locked {
+		message = "Too many failed login attempts, login is locked temporarily"
+	}
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": message, "retry_after": seconds})
+	return false
+}
+
+// failed counts a wrong password or unknown username and locks the username or
+// the address once it reaches its threshold
+func (t *loginThrottle) failed(c *gin.Context, username string, userID *uint) {
+	t.record(c, username, userID, models.LoginFailed)
+
+	now := time.Now()
+	limits := map[string]int{
+		userThrottleKey(username):   t.config.LoginMaxFailures,
+		ipThrottleKey(c.ClientIP()): t.config.LoginIPMaxFailures,
+	}
+	for key, maxFailures := range limits {
+		throttle, err := t.repo.RecordFailure(c.Request.Context(), key, now, now.Add(-t.lockout()))
+		if err != nil || maxFailures <= 0 || throttle.Failures < maxFailures {
+			continue
+		}
+		if err := t.repo.Lock(c.Request.Context(), key, now.Add(t.lockout())); err == nil {
+			log.Printf("loginThrottle: %s locked for %v after %d failed logins", key, t.lockout(), throttle.Failures)
+		}
+	}
+}
+
+// succeeded records a correct password; it ends the username's back-off but
+// not the address's, so that an attacker cannot reset it with an own account
+func (t *loginThrottle) succeeded(c *gin.Context, username string, userID uint) {
+	t.record(c, username, &userID, models.LoginSucceeded)
+	t.repo.ResetThrottle(c.Request.Context(), userThrottleKey(username))
+
+	// Old attempts and expired counters are purged lazily, a failure only
+	// leaves rows behind
+	now := time.Now()
+	retention := time.Duration(t.config.LoginAttemptRetentionDays) * 24 * time.Hour
+	t.repo.PurgeBefore(c.Request.Context(), now.Add(-retention))
+	t.repo.PurgeThrottles(c.Request.Context(), now, now.Add(-t.lockout()))
+}
+
+// record logs the attempt; failing to do so does not change the response
+func (t *loginThrottle) record(c *gin.Context, username string, userID *uint, result string) {
+	userAgent := c.Request.UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+	t.repo.Record(c.Request.Context(), &models.LoginAttempt{
+		Username:  strings.ToLower(username),
+		UserID:    userID,
+		IP:        c.ClientIP(),
+		UserAgent: userAgent,
+		Result:    result,
+	})
+}
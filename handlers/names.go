@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"lab1/config"
+	"lab1/dto"
+	"lab1/models"
+	"lab1/repository"
+	"lab1/validation"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// nameHandler serves the routes of the records books link to by name. The
+// authors, publishers and subjects handlers embed it and only add their docs.
+type nameHandler[T any, P models.NamedPointer[T]] struct {
+	repo      repository.NameRepository[T]
+	name      string // "author", in messages
+	plural    string // "authors"
+	validator *validation.Validator
+	config    *config.Config
+}
+
+func newNameHandler[T any, P models.NamedPointer[T]](repo repository.NameRepository[T], name, plural string, validator *validation.Validator, config *config.Config) nameHandler[T, P] {
+	return nameHandler[T, P]{repo: repo, name: name, plural: plural, validator: validator, config: config}
+}
+
+func nameToResponse(record models.Named) dto.NameResponseDTO {
+	return dto.NameResponseDTO{ID: record.GetID(), Name: record.GetName()}
+}
+
+func namesToResponse[T any, P models.NamedPointer[T]](records []T) []dto.NameResponseDTO {
+	response := make([]dto.NameResponseDTO, len(records))
+	for i := range records {
+		response[i] = nameToResponse(P(&records[i]))
+	}
+	return response
+}
+
+// label is the name of the record at the start of a message, e.g. "Author"
+func (h *nameHandler[T, P]) label() string {
+	return strings.ToUpper(h.name[:1]) + h.name[1:]
+}
+
+func (h *nameHandler[T, P]) getAll(c *gin.Context) {
+	query, ok := parseListQuery(c, h.config, repository.NameSortFields)
+	if !ok {
+		return
+	}
+
+	page, err := h.repo.FindPage(c.Request.Context(), query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve " + h.plural})
+		return
+	}
+
+	response := dto.NameListResponseDTO{Data: namesToResponse[T, P](page.Records)}
+	response.Meta, response.Links = pageResponse(c, query, page.Total)
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *nameHandler[T, P]) create(c *gin.Context) {
+	var nameDTO dto.NameCreateDTO
+	if err := c.ShouldBindJSON(&nameDTO); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON", "details": err.Error()})
+		return
+	}
+
+	if err := h.validator.ValidateStruct(nameDTO); err != nil {
+		c.JSON(http.StatusBadRequest, validation.FormatValidationErrors(err))
+		return
+	}
+
+	var record T
+	P(&record).SetName(nameDTO.Name)
+	if err := h.repo.Create(c.Request.Context(), &record); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create " + h.name})
+		return
+	}
+
+	c.JSON(http.StatusCreated, nameToResponse(P(&record)))
+}
+
+func (h *nameHandler[T, P]) getByID(c *gin.Context) {
+	record, ok := h.load(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, nameToResponse(P(record)))
+}
+
+func (h *nameHandler[T, P]) update(c *gin.Context) {
+	record, ok := h.load(c)
+	if !ok {
+		return
+	}
+
+	var nameDTO dto.NameUpdateDTO
+	if err := c.ShouldBindJSON(&nameDTO); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON", "details": err.Error()})
+		return
+	}
+
+	if err := h.validator.ValidateStruct(nameDTO); err != nil {
+		c.JSON(http.StatusBadRequest, validation.FormatValidationErrors(err))
+		return
+	}
+
+	P(record).SetName(nameDTO.Name)
+	if err := h.repo.Update(c.Request.Context(), record); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update " + h.name})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *nameHandler[T, P]) delete(c *gin.Context) {
+	record, ok := h.load(c)
+	if !ok {
+		return
+	}
+	var referenced *repository.ReferencedError
+	if err := h.repo.Delete(c.Request.Context(), P(record).GetID()); errors.As(err, &referenced) {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("%s is still referenced by %d book(s)", h.label(), referenced.Books)})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete " + h.name})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// load resolves the :id path parameter and writes the error response itself
+func (h *nameHandler[T, P]) load(c *gin.Context) (*T, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return nil, false
+	}
+
+	record, err := h.repo.FindByID(c.Request.Context(), uint(id))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": h.label() + " not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve " + h.name})
+		}
+		return nil, false
+	}
+	return record, true
+}
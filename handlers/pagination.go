@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"fmt"
+	"lab1/config"
+	"lab1/dto"
+	"lab1/repository"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// parseListQuery reads q, owner, author_id, subject_id, created_after, sort, order,
+// page and page_size from the query string. On invalid input it writes a 400 response and returns false.
+func parseListQuery(c *gin.Context, cfg *config.Config, sortFields []string) (repository.ListQuery, bool) {
+	query := repository.ListQuery{
+		Q:        strings.TrimSpace(c.Query("q")),
+		Owner:    strings.TrimSpace(c.Query("owner")),
+		Sort:     c.DefaultQuery("sort", "id"),
+		Order:    strings.ToLower(c.DefaultQuery("order", "asc")),
+		Page:     1,
+		PageSize: cfg.DefaultPageSize,
+	}
+
+	if !repository.IsSortField(sortFields, query.Sort) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid sort field, expected one of: " + strings.Join(sortFields, ", ")})
+		return query, false
+	}
+	if query.Order != "asc" && query.Order != "desc" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order, expected asc or desc"})
+		return query, false
+	}
+
+	if raw := c.Query("page"); raw != "" {
+		page, err := strconv.Atoi(raw)
+		if err != nil || page < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page"})
+			return query, false
+		}
+		query.Page = page
+	}
+	if raw := c.Query("page_size"); raw != "" {
+		size, err := strconv.Atoi(raw)
+		if err != nil || size < 1 || size > cfg.MaxPageSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid page_size, expected 1 to %d", cfg.MaxPageSize)})
+			return query, false
+		}
+		query.PageSize = size
+	}
+
+	for param, target := range map[string]*uint{"author_id": &query.AuthorID, "subject_id": &query.SubjectID} {
+		if raw := c.Query(param); raw != "" {
+			id, err := strconv.ParseUint(raw, 10, 32)
+			if err != nil || id == 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param})
+				return query, false
+			}
+			*target = uint(id)
+		}
+	}
+
+	if raw := c.Query("created_after"); raw != "" {
+		createdAfter, err := parseTimeParam(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid created_after, expected RFC 3339 timestamp or YYYY-MM-DD date"})
+			return query, false
+		}
+		query.CreatedAfter = &createdAfter
+	}
+
+	return query, true
+}
+
+func parseTimeParam(raw string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", raw)
+}
+
+// pageResponse builds the pagination metadata and links for a list response.
+// Links keep every query parameter of the request and only replace page.
+func pageResponse(c *gin.Context, query repository.ListQuery, total int64) (dto.PageMetaDTO, dto.PageLinksDTO) {
+	totalPages := int((total + int64(query.PageSize) - 1) / int64(query.PageSize))
+	meta := dto.PageMetaDTO{
+		Page:       query.Page,
+		PageSize:   query.PageSize,
+		Total:      total,
+		TotalPages: totalPages,
+	}
+
+	pageURL := func(page int) string {
+		values := c.Request.URL.Query()
+		values.Set("page", strconv.Itoa(page))
+		values.Set("page_size", strconv.Itoa(query.PageSize))
+		u := url.URL{Path: c.Request.URL.Path, RawQuery: values.Encode()}
+		return u.String()
+	}
+
+	lastPage := totalPages
+	if lastPage < 1 {
+		lastPage = 1
+	}
+	links := dto.PageLinksDTO{
+		Self:  pageURL(query.Page),
+		First: pageURL(1),
+		Last:  pageURL(lastPage),
+	}
+	if query.Page > 1 {
+		links.Prev = pageURL(min(query.Page-1, lastPage))
+	}
+	if query.Page < totalPages {
+		links.Next = pageURL(query.Page + 1)
+	}
+	return meta, links
+}
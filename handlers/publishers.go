@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"lab1/config"
+	"lab1/models"
+	"lab1/repository"
+	"lab1/validation"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PublishersHandler struct {
+	nameHandler[models.Publisher, *models.Publisher]
+}
+
+func NewPublishersHandler(repo repository.PublisherRepository, validator *validation.Validator, config *config.Config) *PublishersHandler {
+	return &PublishersHandler{newNameHandler[models.Publisher](repo, "publisher", "publishers", validator, config)}
+}
+
+// @Summary Get all publishers
+// @Tags publishers
+// @Produce json
+// @Param q query string false "Search in name"
+// @Param sort query string false "Sort field" Enums(id, name, created_at)
+// @Param order query string false "Sort order" Enums(asc, desc)
+// @Param page query int false "Page number, starting at 1"
+// @Param page_size query int false "Page size"
+// @Success 200 {object} dto.NameListResponseDTO
+// @Failure 400 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /publishers/ [get]
+func (h *PublishersHandler) GetAll(c *gin.Context) {
+	h.getAll(c)
+}
+
+// @Summary Create a new publisher
+// @Tags publishers
+// @Accept json
+// @Produce json
+// @Param publisher body dto.NameCreateDTO true "Publisher to create"
+// @Success 201 {object} dto.NameResponseDTO
+// @Failure 400 {object} map[string]interface{}
+// @Failure 500 {object} map[string]string
+// @Router /publishers/ [post]
+func (h *PublishersHandler) Create(c *gin.Context) {
+	h.create(c)
+}
+
+// @Summary Get publisher by ID
+// @Tags publishers
+// @Produce json
+// @Param id path int true "Publisher ID"
+// @Success 200 {object} dto.NameResponseDTO
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /publishers/{id} [get]
+func (h *PublishersHandler) GetByID(c *gin.Context) {
+	h.getByID(c)
+}
+
+// @Summary Update publisher by ID
+// @Tags publishers
+// @Accept json
+// @Param id path int true "Publisher ID"
+// @Param publisher body dto.NameUpdateDTO true "Updated publisher data"
+// @Success 204
+// @Failure 400 {object} map[string]interface{}
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /publishers/{id} [put]
+func (h *PublishersHandler) Update(c *gin.Context) {
+	h.update(c)
+}
+
+// @Summary Delete publisher by ID
+// @Description Publishers still referenced by books, also those in the trash, cannot be deleted
+// @Tags publishers
+// @Param id path int true "Publisher ID"
+// @Success 204
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /publishers/{id} [delete]
+func (h *PublishersHandler) Delete(c *gin.Context) {
+	h.delete(c)
+}
@@ -1,11 +1,13 @@
 package handlers
 
 import (
+	"context"
 	"errors"
 	"lab1/config"
 	"lab1/dto"
 	"lab1/models"
 	"lab1/repository"
+	"lab1/service"
 	"lab1/validation"
 	"net/http"
 	"strconv"
@@ -15,19 +17,63 @@ import (
 )
 
 type ReadersHandler struct {
+	readers   *service.ReaderService
 	repo      repository.ReaderRepository
+	itemRepo  repository.ItemRepository
+	audit     auditLog
 	validator *validation.Validator
 	config    *config.Config
 }
 
-func NewReadersHandler(repo repository.ReaderRepository, validator *validation.Validator, config *config.Config) *ReadersHandler {
-	return &ReadersHandler{repo: repo, validator: validator, config: config}
+func NewReadersHandler(readers *service.ReaderService, repo repository.ReaderRepository, itemRepo repository.ItemRepository, auditRepo repository.AuditRepository, validator *validation.Validator, config *config.Config) *ReadersHandler {
+	return &ReadersHandler{
+		readers:   readers,
+		repo:      repo,
+		itemRepo:  itemRepo,
+		audit:     auditLog{repo: auditRepo},
+		validator: validator,
+		config:    config,
+	}
+}
+
+// readerBookCounts returns copy counts for every book on the readers' reading lists
+func readerBookCounts(ctx context.Context, itemRepo repository.ItemRepository, readers ...models.Reader) (map[uint]repository.CopyCounts, error) {
+	var bookIDs []uint
+	for _, reader := range readers {
+		for _, book := range reader.CurrentlyReading {
+			bookIDs = append(bookIDs, book.ID)
+		}
+	}
+	return itemRepo.CountsByBooks(ctx, bookIDs)
+}
+
+// readerToResponse converts a reader to its DTO; counts cover the books on the reading list
+func readerToResponse(reader *models.Reader, counts map[uint]repository.CopyCounts) dto.ReaderResponseDTO {
+	books := make([]dto.BookResponseDTO, len(reader.CurrentlyReading))
+	for i := range reader.CurrentlyReading {
+		books[i] = bookToResponse(&reader.CurrentlyReading[i], counts)
+	}
+	return dto.ReaderResponseDTO{
+		ID:               reader.ID,
+		Name:             reader.Name,
+		Surname:          reader.Surname,
+		CurrentlyReading: books,
+		CreatedAt:        reader.CreatedAt,
+	}
 }
 
 // @Summary Get all readers
+// @Description Search, filter, sort and paginate readers
 // @Tags readers
 // @Produce json
-// @Success 200 {array} dto.ReaderResponseDTO
+// @Param q query string false "Search in name and surname"
+// @Param created_after query string false "RFC 3339 timestamp or YYYY-MM-DD date"
+// @Param sort query string false "Sort field" Enums(id, name, surname, created_at, updated_at)
+// @Param order query string false "Sort order" Enums(asc, desc)
+// @Param page query int false "Page number, starting at 1"
+// @Param page_size query int false "Page size"
+// @Success 200 {object} dto.ReaderListResponseDTO
+// @Failure 400 {object} map[string]string
 // @Failure 403 {object} map[string]string
 // @Failure 500 {object} map[string]string
 // @Router /readers/ [get]
@@ -37,31 +83,29 @@ func (h *ReadersHandler) GetAll(c *gin.Context) {
 		return
 	}
 
-	readers, err := h.repo.FindAll()
+	query, ok := parseListQuery(c, h.config, repository.ReaderSortFields)
+	if !ok {
+		return
+	}
+
+	page, err := h.repo.FindPage(c.Request.Context(), query)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve readers"})
 		return
 	}
+	readers := page.Readers
 
-	response := make([]dto.ReaderResponseDTO, len(readers))
-	for i, reader := range readers {
-		books := make([]dto.BookResponseDTO, len(reader.CurrentlyReading))
-		for j, book := range reader.CurrentlyReading {
-			books[j] = dto.BookResponseDTO{
-				ID:          book.ID,
-				Title:       book.Title,
-				Description: book.Description,
-				UserID:      book.UserID,
-				Username:    book.User.Username,
-			}
-		}
-		response[i] = dto.ReaderResponseDTO{
-			ID:               reader.ID,
-			Name:             reader.Name,
-			Surname:          reader.Surname,
-			CurrentlyReading: books,
-		}
+	counts, err := readerBookCounts(c.Request.Context(), h.itemRepo, readers...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve copy counts"})
+		return
 	}
+
+	response := dto.ReaderListResponseDTO{Data: make([]dto.ReaderResponseDTO, len(readers))}
+	for i := range readers {
+		response.Data[i] = readerToResponse(&readers[i], counts)
+	}
+	response.Meta, response.Links = pageResponse(c, query, page.Total)
 	c.JSON(http.StatusOK, response)
 }
 
@@ -97,15 +141,17 @@ func (h *ReadersHandler) Create(c *gin.Context) {
 		Surname: readerDTO.Surname,
 	}
 
-	if err := h.repo.Create(&reader); err != nil {
+	if err := h.repo.Create(c.Request.Context(), &reader); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create reader"})
 		return
 	}
+	h.audit.record(c, "create", "reader", reader.ID, nil, snapshotReader(&reader))
 
 	response := dto.ReaderResponseDTO{
-		ID:      reader.ID,
-		Name:    reader.Name,
-		Surname: reader.Surname,
+		ID:        reader.ID,
+		Name:      reader.Name,
+		Surname:   reader.Surname,
+		CreatedAt: reader.CreatedAt,
 	}
 	c.JSON(http.StatusCreated, response)
 }
@@ -122,10 +168,12 @@ func (h *ReadersHandler) DeleteAll(c *gin.Context) {
 		return
 	}
 
-	if err := h.repo.DeleteAll(); err != nil {
+	if err := h.repo.DeleteAll(c.Request.Context()); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete readers"})
 		return
 	}
+	h.audit.record(c, "delete_all", "reader", 0, nil, nil)
+	purgeExpiredReaders(c.Request.Context(), h.repo, h.config)
 	c.Status(http.StatusNoContent)
 }
 
@@ -151,7 +199,7 @@ func (h *ReadersHandler) GetByID(c *gin.Context) {
 		return
 	}
 
-	reader, err := h.repo.FindByID(uint(id))
+	reader, err := h.repo.FindByID(c.Request.Context(), uint(id))
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Reader not found"})
@@ -161,24 +209,13 @@ func (h *ReadersHandler) GetByID(c *gin.Context) {
 		return
 	}
 
-	books := make([]dto.BookResponseDTO, len(reader.CurrentlyReading))
-	for i, book := range reader.CurrentlyReading {
-		books[i] = dto.BookResponseDTO{
-			ID:          book.ID,
-			Title:       book.Title,
-			Description: book.Description,
-			UserID:      book.UserID,
-			Username:    book.User.Username,
-		}
+	counts, err := readerBookCounts(c.Request.Context(), h.itemRepo, *reader)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve copy counts"})
+		return
 	}
 
-	response := dto.ReaderResponseDTO{
-		ID:               reader.ID,
-		Name:             reader.Name,
-		Surname:          reader.Surname,
-		CurrentlyReading: books,
-	}
-	c.JSON(http.StatusOK, response)
+	c.JSON(http.StatusOK, readerToResponse(reader, counts))
 }
 
 // @Summary Update reader by ID
@@ -204,7 +241,7 @@ func (h *ReadersHandler) Update(c *gin.Context) {
 		return
 	}
 
-	reader, err := h.repo.FindByID(uint(id))
+	reader, err := h.repo.FindByID(c.Request.Context(), uint(id))
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Reader not found"})
@@ -225,13 +262,15 @@ func (h *ReadersHandler) Update(c *gin.Context) {
 		return
 	}
 
+	before := snapshotReader(reader)
 	reader.Name = readerDTO.Name
 	reader.Surname = readerDTO.Surname
 
-	if err := h.repo.Update(reader); err != nil {
+	if err := h.repo.Update(c.Request.Context(), reader); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update reader"})
 		return
 	}
+	h.audit.record(c, "update", "reader", reader.ID, before, snapshotReader(reader))
 
 	c.Status(http.StatusNoContent)
 }
@@ -257,20 +296,15 @@ func (h *ReadersHandler) Delete(c *gin.Context) {
 		return
 	}
 
-	_, err = h.repo.FindByID(uint(id))
+	reader, err := h.readers.Delete(c.Request.Context(), uint(id))
 	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Reader not found"})
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve reader"})
+		if !writeNotFound(c, err) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete reader"})
 		}
 		return
 	}
-
-	if err := h.repo.Delete(uint(id)); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete reader"})
-		return
-	}
+	h.audit.record(c, "delete", "reader", reader.ID, snapshotReader(reader), nil)
+	purgeExpiredReaders(c.Request.Context(), h.repo, h.config)
 
 	c.Status(http.StatusNoContent)
 }
@@ -289,17 +323,19 @@ func (h *ReadersHandler) AddCurrentlyReading(c *gin.Context) {
 		return
 	}
 
-	book := &models.Book{}
-	book.ID = uint(bookID)
-
-	if err := h.repo.AddCurrentlyReading(uint(readerID), book); err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Reader not found"})
-		} else {
+	if err := h.readers.AddCurrentlyReading(c.Request.Context(), uint(readerID), uint(bookID)); err != nil {
+		switch {
+		case writeNotFound(c, err):
+		case errors.Is(err, service.ErrReaderBlocked):
+			c.JSON(http.StatusForbidden, gin.H{"error": "Reader is blocked from borrowing until outstanding fines are paid"})
+		case errors.Is(err, service.ErrBookReserved):
+			c.JSON(http.StatusConflict, gin.H{"error": "Book is reserved for another reader"})
+		default:
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add book to reading list"})
 		}
 		return
 	}
+	h.audit.record(c, "add_currently_reading", "reader", uint(readerID), nil, gin.H{"book_id": bookID})
 
 	c.Status(http.StatusNoContent)
 }
@@ -318,14 +354,14 @@ func (h *ReadersHandler) RemoveCurrentlyReading(c *gin.Context) {
 		return
 	}
 
-	if err := h.repo.RemoveCurrentlyReading(uint(readerID), uint(bookID)); err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Reader not found"})
-		} else {
+	// The book is back on the shelf and goes to the next reader in its hold queue
+	if err := h.readers.RemoveCurrentlyReading(c.Request.Context(), uint(readerID), uint(bookID)); err != nil {
+		if !writeNotFound(c, err) {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove book from reading list"})
 		}
 		return
 	}
+	h.audit.record(c, "remove_currently_reading", "reader", uint(readerID), gin.H{"book_id": bookID}, nil)
 
 	c.Status(http.StatusNoContent)
 }
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"lab1/models"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// load fetches the reader named by the id path parameter, writing the error response if that fails
+func (h *ReadersHandler) load(c *gin.Context) (*models.Reader, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return nil, false
+	}
+
+	reader, err := h.repo.FindByID(c.Request.Context(), uint(id))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Reader not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve reader"})
+		}
+		return nil, false
+	}
+	return reader, true
+}
+
+// @Summary Get the history of a reader
+// @Description Every saved version of the reader with the fields changed since the version before; a revision is added by every update
+// @Tags readers
+// @Produce json
+// @Param id path int true "Reader ID"
+// @Param sort query string false "Sort field" Enums(number, created_at)
+// @Param order query string false "Sort order, newest first by default" Enums(asc, desc)
+// @Param page query int false "Page number, starting at 1"
+// @Param page_size query int false "Page size"
+// @Success 200 {object} dto.RevisionListResponseDTO
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /readers/{id}/history [get]
+func (h *ReadersHandler) GetHistory(c *gin.Context) {
+	if !h.config.EnableGetReaders {
+		c.JSON(http.StatusForbidden, gin.H{"error": "GET /readers endpoint is disabled"})
+		return
+	}
+
+	reader, ok := h.load(c)
+	if !ok {
+		return
+	}
+	revisionHistory(c, h.config, reader.ID, h.repo.FindRevisions, h.repo.FindRevision)
+}
+
+// @Summary Restore a revision of a reader
+// @Description Sets name and surname back to those of the revision, which adds a new revision
+// @Tags readers
+// @Produce json
+// @Param id path int true "Reader ID"
+// @Param rev path int true "Revision number"
+// @Success 200 {object} dto.ReaderResponseDTO
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /readers/{id}/history/{rev}/restore [post]
+func (h *ReadersHandler) RestoreRevision(c *gin.Context) {
+	if !h.config.EnablePutReaders {
+		c.JSON(http.StatusForbidden, gin.H{"error": "PUT /readers endpoint is disabled"})
+		return
+	}
+
+	reader, ok := h.load(c)
+	if !ok {
+		return
+	}
+	number, ok := parseRevision(c)
+	if !ok {
+		return
+	}
+
+	revision, err := h.repo.FindRevision(c.Request.Context(), reader.ID, number)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Revision not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve revision"})
+		}
+		return
+	}
+	var version models.ReaderVersion
+	if err := json.Unmarshal([]byte(revision.Data), &version); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read revision"})
+		return
+	}
+
+	// FindByID may return the cached instance, so work on a copy
+	restored := *reader
+	restored.Name = version.Name
+	restored.Surname = version.Surname
+	if err := h.repo.Update(c.Request.Context(), &restored); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore reader"})
+		return
+	}
+	h.audit.record(c, "restore", "reader", reader.ID, snapshotReader(reader), snapshotReader(&restored))
+
+	counts, err := readerBookCounts(c.Request.Context(), h.itemRepo, restored)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve copy counts"})
+		return
+	}
+	c.JSON(http.StatusOK, readerToResponse(&restored, counts))
+}
@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"errors"
+	"lab1/middleware"
+	"lab1/rbac"
+	"lab1/service"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// actorOf describes the current user to the service layer
+func actorOf(c *gin.Context, policy *rbac.Policy) service.Actor {
+	return service.Actor{
+		UserID:   c.GetUint("user_id"),
+		Username: c.GetString("username"),
+		Can: func(perm rbac.Permission) bool {
+			return middleware.HasPermission(c, policy, perm)
+		},
+	}
+}
+
+// writeNotFound writes the 404 response for a service.NotFoundError and
+// reports whether err was one
+func writeNotFound(c *gin.Context, err error) bool {
+	var missing *service.NotFoundError
+	if !errors.As(err, &missing) {
+		return false
+	}
+	c.JSON(http.StatusNotFound, gin.H{"error": missing.Message()})
+	return true
+}
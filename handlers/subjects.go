@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"lab1/config"
+	"lab1/models"
+	"lab1/repository"
+	"lab1/validation"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SubjectsHandler struct {
+	nameHandler[models.Subject, *models.Subject]
+}
+
+func NewSubjectsHandler(repo repository.SubjectRepository, validator *validation.Validator, config *config.Config) *SubjectsHandler {
+	return &SubjectsHandler{newNameHandler[models.Subject](repo, "subject", "subjects", validator, config)}
+}
+
+// @Summary Get all subjects
+// @Tags subjects
+// @Produce json
+// @Param q query string false "Search in name"
+// @Param sort query string false "Sort field" Enums(id, name, created_at)
+// @Param order query string false "Sort order" Enums(asc, desc)
+// @Param page query int false "Page number, starting at 1"
+// @Param page_size query int false "Page size"
+// @Success 200 {object} dto.NameListResponseDTO
+// @Failure 400 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /subjects/ [get]
+func (h *SubjectsHandler) GetAll(c *gin.Context) {
+	h.getAll(c)
+}
+
+// @Summary Create a new subject
+// @Tags subjects
+// @Accept json
+// @Produce json
+// @Param subject body dto.NameCreateDTO true "Subject to create"
+// @Success 201 {object} dto.NameResponseDTO
+// @Failure 400 {object} map[string]interface{}
+// @Failure 500 {object} map[string]string
+// @Router /subjects/ [post]
+func (h *SubjectsHandler) Create(c *gin.Context) {
+	h.create(c)
+}
+
+// @Summary Get subject by ID
+// @Tags subjects
+// @Produce json
+// @Param id path int true "Subject ID"
+// @Success 200 {object} dto.NameResponseDTO
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /subjects/{id} [get]
+func (h *SubjectsHandler) GetByID(c *gin.Context) {
+	h.getByID(c)
+}
+
+// @Summary Update subject by ID
+// @Tags subjects
+// @Accept json
+// @Param id path int true "Subject ID"
+// @Param subject body dto.NameUpdateDTO true "Updated subject data"
+// @Success 204
+// @Failure 400 {object} map[string]interface{}
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /subjects/{id} [put]
+func (h *SubjectsHandler) Update(c *gin.Context) {
+	h.update(c)
+}
+
+// @Summary Delete subject by ID
+// @Description Subjects still referenced by books, also those in the trash, cannot be deleted
+// @Tags subjects
+// @Param id path int true "Subject ID"
+// @Success 204
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /subjects/{id} [delete]
+func (h *SubjectsHandler) Delete(c *gin.Context) {
+	h.delete(c)
+}
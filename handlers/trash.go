@@ -0,0 +1,375 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"lab1/config"
+	"lab1/dto"
+	"lab1/repository"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// TrashHandler lists, restores and purges deleted books and readers. Deleting
+// only moves them to the trash; after trash_retention_days they are purged.
+type TrashHandler struct {
+	bookRepo   repository.BookRepository
+	readerRepo repository.ReaderRepository
+	itemRepo   repository.ItemRepository
+	audit      auditLog
+	config     *config.Config
+}
+
+func NewTrashHandler(bookRepo repository.BookRepository, readerRepo repository.ReaderRepository, itemRepo repository.ItemRepository, auditRepo repository.AuditRepository, config *config.Config) *TrashHandler {
+	return &TrashHandler{
+		bookRepo:   bookRepo,
+		readerRepo: readerRepo,
+		itemRepo:   itemRepo,
+		audit:      auditLog{repo: auditRepo},
+		config:     config,
+	}
+}
+
+// purgeAt returns when a record deleted at deletedAt is purged, nil if never
+func purgeAt(cfg *config.Config, deletedAt time.Time) *time.Time {
+	if cfg.TrashRetentionDays <= 0 {
+		return nil
+	}
+	at := deletedAt.AddDate(0, 0, cfg.TrashRetentionDays)
+	return &at
+}
+
+// purgeExpiredBooks purges the books deleted longer than trash_retention_days
+// ago. Like expired tokens, the trash is purged lazily, whenever it changes or
+// is looked at; failures are only logged.
+func purgeExpiredBooks(ctx context.Context, repo repository.BookRepository, cfg *config.Config) {
+	if cfg.TrashRetentionDays > 0 {
+		repo.PurgeDeletedBefore(ctx, time.Now().AddDate(0, 0, -cfg.TrashRetentionDays))
+	}
+}
+
+// purgeExpiredReaders is purgeExpiredBooks for readers
+func purgeExpiredReaders(ctx context.Context, repo repository.ReaderRepository, cfg *config.Config) {
+	if cfg.TrashRetentionDays > 0 {
+		repo.PurgeDeletedBefore(ctx, time.Now().AddDate(0, 0, -cfg.TrashRetentionDays))
+	}
+}
+
+// trashQuery parses the list parameters of a trash listing, newest deletions first by default
+func (h *TrashHandler) trashQuery(c *gin.Context, sortFields []string) (repository.ListQuery, bool) {
+	query, ok := parseListQuery(c, h.config, sortFields)
+	if ok && c.Query("sort") == "" && c.Query("order") == "" {
+		query.Sort, query.Order = "deleted_at", "desc"
+	}
+	return query, ok
+}
+
+// trashedID parses the id path parameter, writing the error response if that fails
+func trashedID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// @Summary List deleted books
+// @Description Books in the trash, most recently deleted first by default
+// @Tags trash
+// @Security BearerAuth
+// @Produce json
+// @Param q query string false "Search in title and description"
+// @Param sort query string false "Sort field" Enums(id, title, created_at, updated_at, deleted_at)
+// @Param order query string false "Sort order" Enums(asc, desc)
+// @Param page query int false "Page number, starting at 1"
+// @Param page_size query int false "Page size"
+// @Success 200 {object} dto.TrashedBookListResponseDTO
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /trash/books [get]
+func (h *TrashHandler) GetBooks(c *gin.Context) {
+	query, ok := h.trashQuery(c, repository.BookTrashSortFields)
+	if !ok {
+		return
+	}
+	purgeExpiredBooks(c.Request.Context(), h.bookRepo, h.config)
+
+	page, err := h.bookRepo.FindDeletedPage(c.Request.Context(), query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve deleted books"})
+		return
+	}
+
+	bookIDs := make([]uint, len(page.Books))
+	for i, book := range page.Books {
+		bookIDs[i] = book.ID
+	}
+	counts, err := h.itemRepo.CountsByBooks(c.Request.Context(), bookIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve copy counts"})
+		return
+	}
+
+	response := dto.TrashedBookListResponseDTO{Data: make([]dto.TrashedBookDTO, len(page.Books))}
+	for i := range page.Books {
+		book := &page.Books[i]
+		response.Data[i] = dto.TrashedBookDTO{
+			BookResponseDTO: bookToResponse(book, counts),
+			DeletedAt:       book.DeletedAt.Time,
+			PurgeAt:         purgeAt(h.config, book.DeletedAt.Time),
+		}
+	}
+	response.Meta, response.Links = pageResponse(c, query, page.Total)
+	c.JSON(http.StatusOK, response)
+}
+
+// @Summary Restore a deleted book
+// @Tags trash
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "Book ID"
+// @Success 200 {object} dto.BookResponseDTO
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /trash/books/{id}/restore [post]
+func (h *TrashHandler) RestoreBook(c *gin.Context) {
+	id, ok := trashedID(c)
+	if !ok {
+		return
+	}
+
+	if err := h.bookRepo.Restore(c.Request.Context(), id); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Book not found in trash"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore book"})
+		}
+		return
+	}
+
+	book, err := h.bookRepo.FindByID(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve book"})
+		return
+	}
+	h.audit.record(c, "undelete", "book", book.ID, nil, snapshotBook(book))
+
+	counts, err := h.itemRepo.CountsByBooks(c.Request.Context(), []uint{book.ID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve copy counts"})
+		return
+	}
+	c.JSON(http.StatusOK, bookToResponse(book, counts))
+}
+
+// @Summary Purge a deleted book
+// @Description Removes the book, its copies and its history for good. Books that loans or holds refer to cannot be purged.
+// @Tags trash
+// @Security BearerAuth
+// @Param id path int true "Book ID"
+// @Success 204
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /trash/books/{id} [delete]
+func (h *TrashHandler) PurgeBook(c *gin.Context) {
+	id, ok := trashedID(c)
+	if !ok {
+		return
+	}
+
+	book, err := h.bookRepo.FindDeleted(c.Request.Context(), id)
+	if err == nil {
+		err = h.bookRepo.Purge(c.Request.Context(), id)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Book not found in trash"})
+		case errors.Is(err, repository.ErrStillReferenced):
+			c.JSON(http.StatusConflict, gin.H{"error": "Book is still referenced by loans or holds"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to purge book"})
+		}
+		return
+	}
+	h.audit.record(c, "purge", "book", book.ID, snapshotBook(book), nil)
+
+	c.Status(http.StatusNoContent)
+}
+
+// @Summary Empty the book trash
+// @Description Purges every deleted book except those that loans or holds refer to
+// @Tags trash
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} dto.PurgeResponseDTO
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /trash/books [delete]
+func (h *TrashHandler) PurgeBooks(c *gin.Context) {
+	purged, err := h.bookRepo.PurgeDeletedBefore(c.Request.Context(), time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to purge books"})
+		return
+	}
+	h.audit.record(c, "purge_all", "book", 0, nil, dto.PurgeResponseDTO{Purged: purged})
+
+	c.JSON(http.StatusOK, dto.PurgeResponseDTO{Purged: purged})
+}
+
+// @Summary List deleted readers
+// @Description Readers in the trash, most recently deleted first by default
+// @Tags trash
+// @Security BearerAuth
+// @Produce json
+// @Param q query string false "Search in name and surname"
+// @Param sort query string false "Sort field" Enums(id, name, surname, created_at, updated_at, deleted_at)
+// @Param order query string false "Sort order" Enums(asc, desc)
+// @Param page query int false "Page number, starting at 1"
+// @Param page_size query int false "Page size"
+// @Success 200 {object} dto.TrashedReaderListResponseDTO
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /trash/readers [get]
+func (h *TrashHandler) GetReaders(c *gin.Context) {
+	query, ok := h.trashQuery(c, repository.ReaderTrashSortFields)
+	if !ok {
+		return
+	}
+	purgeExpiredReaders(c.Request.Context(), h.readerRepo, h.config)
+
+	page, err := h.readerRepo.FindDeletedPage(c.Request.Context(), query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve deleted readers"})
+		return
+	}
+
+	counts, err := readerBookCounts(c.Request.Context(), h.itemRepo, page.Readers...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve copy counts"})
+		return
+	}
+
+	response := dto.TrashedReaderListResponseDTO{Data: make([]dto.TrashedReaderDTO, len(page.Readers))}
+	for i := range page.Readers {
+		reader := &page.Readers[i]
+		response.Data[i] = dto.TrashedReaderDTO{
+			ReaderResponseDTO: readerToResponse(reader, counts),
+			DeletedAt:         reader.DeletedAt.Time,
+			PurgeAt:           purgeAt(h.config, reader.DeletedAt.Time),
+		}
+	}
+	response.Meta, response.Links = pageResponse(c, query, page.Total)
+	c.JSON(http.StatusOK, response)
+}
+
+// @Summary Restore a deleted reader
+// @Tags trash
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "Reader ID"
+// @Success 200 {object} dto.ReaderResponseDTO
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /trash/readers/{id}/restore [post]
+func (h *TrashHandler) RestoreReader(c *gin.Context) {
+	id, ok := trashedID(c)
+	if !ok {
+		return
+	}
+
+	if err := h.readerRepo.Restore(c.Request.Context(), id); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Reader not found in trash"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore reader"})
+		}
+		return
+	}
+
+	reader, err := h.readerRepo.FindByID(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve reader"})
+		return
+	}
+	h.audit.record(c, "undelete", "reader", reader.ID, nil, snapshotReader(reader))
+
+	counts, err := readerBookCounts(c.Request.Context(), h.itemRepo, *reader)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve copy counts"})
+		return
+	}
+	c.JSON(http.StatusOK, readerToResponse(reader, counts))
+}
+
+// @Summary Purge a deleted reader
+// @Description Removes the reader and their history for good. Readers with loans, holds or account transactions cannot be purged.
+// @Tags trash
+// @Security BearerAuth
+// @Param id path int true "Reader ID"
+// @Success 204
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /trash/readers/{id} [delete]
+func (h *TrashHandler) PurgeReader(c *gin.Context) {
+	id, ok := trashedID(c)
+	if !ok {
+		return
+	}
+
+	reader, err := h.readerRepo.FindDeleted(c.Request.Context(), id)
+	if err == nil {
+		err = h.readerRepo.Purge(c.Request.Context(), id)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Reader not found in trash"})
+		case errors.Is(err, repository.ErrStillReferenced):
+			c.JSON(http.StatusConflict, gin.H{"error": "Reader is still referenced by loans, holds or account transactions"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to purge reader"})
+		}
+		return
+	}
+	h.audit.record(c, "purge", "reader", reader.ID, snapshotReader(reader), nil)
+
+	c.Status(http.StatusNoContent)
+}
+
+// @Summary Empty the reader trash
+// @Description Purges every deleted reader except those with loans, holds or account transactions
+// @Tags trash
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} dto.PurgeResponseDTO
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /trash/readers [delete]
+func (h *TrashHandler) PurgeReaders(c *gin.Context) {
+	purged, err := h.readerRepo.PurgeDeletedBefore(c.Request.Context(), time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to purge readers"})
+		return
+	}
+	h.audit.record(c, "purge_all", "reader", 0, nil, dto.PurgeResponseDTO{Purged: purged})
+
+	c.JSON(http.StatusOK, dto.PurgeResponseDTO{Purged: purged})
+}
@@ -0,0 +1,308 @@
+// Package jwtkeys holds the keys access tokens are signed and verified with.
+// Every key has an ID (kid) that tokens carry in their header, so that several
+// keys can be valid at once while one is rotated out. The public keys are
+// published as a JSON Web Key Set for other services.
+package jwtkeys
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"lab1/config"
+	"log"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+
+	minRSABits = 2048
+)
+
+// Key is a verification key, and a signing key if Private is set
+type Key struct {
+	ID        string
+	Algorithm string
+	Method    jwt.SigningMethod
+	Public    crypto.PublicKey
+	Private   crypto.Signer
+}
+
+// KeySet signs tokens with one key and verifies them with all keys
+type KeySet struct {
+	signing *Key
+	keys    map[string]*Key
+	order   []string // key IDs in configuration order, for the published set
+}
+
+// New loads the keys of the jwt_keys option. Without keys it generates a
+// temporary Ed25519 key; tokens signed with it are invalid after a restart.
+func New(cfg *config.Config) (*KeySet, error) {
+	if len(cfg.JWTKeys) == 0 {
+		if cfg.JWTSigningKeyID != "" {
+			return nil, fmt.Errorf("jwt_signing_key_id %q set without jwt_keys", cfg.JWTSigningKeyID)
+		}
+		log.Println("WARNING: no jwt_keys configured, signing access tokens with a temporary key; they become invalid on restart")
+		return Ephemeral()
+	}
+
+	set := &KeySet{keys: make(map[string]*Key, len(cfg.JWTKeys))}
+	for _, kc := range cfg.JWTKeys {
+		key, err := loadKey(kc)
+		if err != nil {
+			return nil, err
+		}
+		if _, exists := set.keys[key.ID]; exists {
+			return nil, fmt.Errorf("jwt key %q: duplicate kid", key.ID)
+		}
+		set.keys[key.ID] = key
+		set.order = append(set.order, key.ID)
+	}
+
+	if cfg.JWTSigningKeyID != "" {
+		set.signing = set.keys[cfg.JWTSigningKeyID]
+		if set.signing == nil {
+			return nil, fmt.Errorf("jwt_signing_key_id %q is not in jwt_keys", cfg.JWTSigningKeyID)
+		}
+		if set.signing.Private == nil {
+			return nil, fmt.Errorf("jwt key %q: signing key needs private_key_file", cfg.JWTSigningKeyID)
+		}
+	} else {
+		for _, id := range set.order {
+			if set.keys[id].Private != nil {
+				set.signing = set.keys[id]
+				break
+			}
+		}
+		if set.signing == nil {
+			return nil, fmt.Errorf("jwt_keys: no key with a private_key_file to sign with")
+		}
+	}
+
+	log.Printf("Signing access tokens with key %q (%s), %d key(s) accepted", set.signing.ID, set.signing.Algorithm, len(set.keys))
+	return set, nil
+}
+
+// Ephemeral returns a set with a single, freshly generated Ed25519 key
+func Ephemeral() (*KeySet, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	kid := make([]byte, 8)
+	if _, err := rand.Read(kid); err != nil {
+		return nil, err
+	}
+
+	key := &Key{
+		ID:        "ephemeral-" + base64.RawURLEncoding.EncodeToString(kid),
+		Algorithm: AlgorithmEdDSA,
+		Method:    jwt.SigningMethodEdDSA,
+		Public:    public,
+		Private:   private,
+	}
+	return &KeySet{signing: key, keys: map[string]*Key{key.ID: key}, order: []string{key.ID}}, nil
+}
+
+func loadKey(kc config.JWTKey) (*Key, error) {
+	if kc.ID == "" {
+		return nil, fmt.Errorf("jwt key without a kid")
+	}
+	key := &Key{ID: kc.ID, Algorithm: kc.Algorithm}
+	switch kc.Algorithm {
+	case AlgorithmRS256:
+		key.Method = jwt.SigningMethodRS256
+	case AlgorithmEdDSA:
+		key.Method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("jwt key %q: unsupported algorithm %q, expected %s or %s", kc.ID, kc.Algorithm, AlgorithmRS256, AlgorithmEdDSA)
+	}
+
+	switch {
+	case kc.PrivateKeyFile != "":
+		block, err := readPEM(kc.PrivateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("jwt key %q: %w", kc.ID, err)
+		}
+		var parsed any
+		if block.Type == "RSA PRIVATE KEY" {
+			parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		} else {
+			parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("jwt key %q: %w", kc.ID, err)
+		}
+		signer, ok := parsed.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("jwt key %q: unsupported private key type %T", kc.ID, parsed)
+		}
+		key.Private = signer
+		key.Public = signer.Public()
+	case kc.PublicKeyFile != "":
+		block, err := readPEM(kc.PublicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("jwt key %q: %w", kc.ID, err)
+		}
+		key.Public, err = x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("jwt key %q: %w", kc.ID, err)
+		}
+	default:
+		return nil, fmt.Errorf("jwt key %q: private_key_file or public_key_file required", kc.ID)
+	}
+
+	// The algorithm must match the key, otherwise tokens would fail only when used
+	switch public := key.Public.(type) {
+	case *rsa.PublicKey:
+		if key.Algorithm != AlgorithmRS256 {
+			return nil, fmt.Errorf("jwt key %q: RSA key used with %s", kc.ID, key.Algorithm)
+		}
+		if public.N.BitLen() < minRSABits {
+			return nil, fmt.Errorf("jwt key %q: RSA key must have at least %d bits", kc.ID, minRSABits)
+		}
+	case ed25519.PublicKey:
+		if key.Algorithm != AlgorithmEdDSA {
+			return nil, fmt.Errorf("jwt key %q: Ed25519 key used with %s", kc.ID, key.Algorithm)
+		}
+	default:
+		return nil, fmt.Errorf("jwt key %q: unsupported key type %T", kc.ID, key.Public)
+	}
+	return key, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data", path)
+	}
+	return block, nil
+}
+
+// Sign signs the claims with the signing key and names it in the kid header
+func (s *KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(s.signing.Method, claims)
+	token.Header["kid"] = s.signing.ID
+	return token.SignedString(s.signing.Private)
+}
+
+// Keyfunc returns the verification key named by the token's kid, for
+// jwt.Parse. Tokens whose algorithm does not match the key are rejected.
+func (s *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key := s.keys[kid]
+	if key == nil {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("key %q does not sign with %s", kid, token.Method.Alg())
+	}
+	return key.Public, nil
+}
+
+// JWK is a public key in the JSON Web Key format (RFC 7517)
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`   // RSA modulus
+	E         string `json:"e,omitempty"`   // RSA exponent
+	Curve     string `json:"crv,omitempty"` // EC or OKP curve
+	X         string `json:"x,omitempty"`   // OKP public key or EC x coordinate
+	Y         string `json:"y,omitempty"`   // EC y coordinate
+}
+
+// PublicKey decodes the key, for verifying tokens of other issuers. RSA, EC
+// (P-256, P-384, P-521) and Ed25519 keys are supported.
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+	decode := base64.RawURLEncoding.DecodeString
+	switch k.KeyType {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: invalid n: %w", k.KeyID, err)
+		}
+		e, err := decode(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("key %q: invalid e", k.KeyID)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		var point ecdh.Curve
+		switch k.Curve {
+		case "P-256":
+			curve, point = elliptic.P256(), ecdh.P256()
+		case "P-384":
+			curve, point = elliptic.P384(), ecdh.P384()
+		case "P-521":
+			curve, point = elliptic.P521(), ecdh.P521()
+		default:
+			return nil, fmt.Errorf("key %q: unsupported curve %q", k.KeyID, k.Curve)
+		}
+		x, errX := decode(k.X)
+		y, errY := decode(k.Y)
+		size := (curve.Params().BitSize + 7) / 8
+		if errX != nil || errY != nil || len(x) != size || len(y) != size {
+			return nil, fmt.Errorf("key %q: invalid coordinates", k.KeyID)
+		}
+		// ecdh checks that the point is on the curve
+		if _, err := point.NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+			return nil, fmt.Errorf("key %q: %w", k.KeyID, err)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		if k.Curve != "Ed25519" {
+			return nil, fmt.Errorf("key %q: unsupported curve %q", k.KeyID, k.Curve)
+		}
+		x, err := decode(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("key %q: invalid x", k.KeyID)
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("key %q: unsupported key type %q", k.KeyID, k.KeyType)
+	}
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys, including those that only verify
+func (s *KeySet) JWKS() JWKSet {
+	set := JWKSet{Keys: make([]JWK, 0, len(s.order))}
+	for _, id := range s.order {
+		key := s.keys[id]
+		jwk := JWK{KeyID: key.ID, Use: "sig", Algorithm: key.Algorithm}
+		switch public := key.Public.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
@@ -0,0 +1,129 @@
+// Package mailer sends the application's emails, through SMTP or, for local
+// development and tests, into the log and an optional file
+package mailer
+
+import (
+	"fmt"
+	"lab1/config"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string // plain text
+}
+
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// New returns the mailer selected by the mail_driver option
+func New(cfg *config.Config) (Mailer, error) {
+	switch cfg.MailDriver {
+	case "smtp":
+		if cfg.SMTPHost == "" {
+			return nil, fmt.Errorf("mail_driver smtp requires smtp_host")
+		}
+		return NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.MailFrom), nil
+	case "log", "":
+		return NewLogMailer(cfg.MailFrom, cfg.MailFile), nil
+	default:
+		return nil, fmt.Errorf("unknown mail_driver %q, expected smtp or log", cfg.MailDriver)
+	}
+}
+
+// format renders a message with the headers of a plain text mail
+func format(from string, msg Message) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+// validHeader rejects line breaks, which would let a value add headers
+func validHeader(values ...string) error {
+	for _, v := range values {
+		if strings.ContainsAny(v, "\r\n") {
+			return fmt.Errorf("mail header contains a line break: %q", v)
+		}
+	}
+	return nil
+}
+
+type SMTPMailer struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+// NewSMTPMailer sends through the server at host:port, with PLAIN authentication
+// when a username is given. net/smtp upgrades the connection with STARTTLS when
+// the server offers it.
+func NewSMTPMailer(host string, port int, username, password, from string) *SMTPMailer {
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+	return &SMTPMailer{addr: net.JoinHostPort(host, strconv.Itoa(port)), auth: auth, from: from}
+}
+
+func (m *SMTPMailer) Send(msg Message) error {
+	if err := validHeader(msg.To, msg.Subject); err != nil {
+		return err
+	}
+	if err := smtp.SendMail(m.addr, m.auth, m.from, []string{msg.To}, format(m.from, msg)); err != nil {
+		log.Printf("SMTPMailer.Send: error sending %q to %s: %v", msg.Subject, msg.To, err)
+		return err
+	}
+	log.Printf("SMTPMailer.Send: sent %q to %s", msg.Subject, msg.To)
+	return nil
+}
+
+// LogMailer does not deliver mails. It writes them to the log and, if a path is
+// set, appends them to that file, separated by blank lines.
+type LogMailer struct {
+	from string
+	path string
+	mu   sync.Mutex
+}
+
+func NewLogMailer(from, path string) *LogMailer {
+	return &LogMailer{from: from, path: path}
+}
+
+func (m *LogMailer) Send(msg Message) error {
+	if err := validHeader(msg.To, msg.Subject); err != nil {
+		return err
+	}
+	rendered := format(m.from, msg)
+	log.Printf("LogMailer.Send: mail to %s\n%s", msg.To, rendered)
+	if m.path == "" {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, err := os.OpenFile(m.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(append(rendered, "\r\n\r\n"...)); err != nil {
+		return err
+	}
+	return nil
+}
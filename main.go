@@ -5,7 +5,9 @@ import (
 	_ "lab1/docs"
 	"lab1/handlers"
 	"lab1/middleware"
+	"lab1/rbac"
 	"log"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	swaggerFiles "github.com/swaggo/files"
@@ -13,27 +15,53 @@ import (
 )
 // @title Library API
 // @version 1.0
-// @description REST API for library management with SQLite database
+// @description REST API for library management with SQLite or PostgreSQL database
 // @host localhost:8080
 // @BasePath /
 func main() {
-	c, err := container.NewContainer("library.db", "config.json")
+	c, err := container.NewContainer("config.json")
 	if err != nil {
 		log.Fatal("Failed to initialize container:", err)
 	}
 	defer c.Close()
 
-	booksHandler := handlers.NewBooksHandler(c.BookRepository, c.Validator, c.Config)
-	readersHandler := handlers.NewReadersHandler(c.ReaderRepository, c.Validator, c.Config)
-	authHandler := handlers.NewAuthHandler(c.UserRepository, c.Validator)
+	booksHandler := handlers.NewBooksHandler(c.BookService, c.BookRepository, c.ItemRepository, c.AuthorRepository, c.PublisherRepository, c.SubjectRepository, c.AuditRepository, c.Policy, c.Validator, c.Config)
+	readersHandler := handlers.NewReadersHandler(c.ReaderService, c.ReaderRepository, c.ItemRepository, c.AuditRepository, c.Validator, c.Config)
+	authHandler := handlers.NewAuthHandler(c.UserRepository, c.TokenRepository, c.UserTokenRepository, c.LoginAttemptRepository, c.AuditRepository, c.Mailer, c.Keys, c.OIDC, c.Policy, c.Validator, c.Config)
+	loansHandler := handlers.NewLoansHandler(c.LoanService, c.LoanRepository, c.ReaderRepository, c.Policy, c.Validator, c.Config)
+	holdsHandler := handlers.NewHoldsHandler(c.HoldService, c.HoldRepository, c.BookRepository, c.ReaderRepository, c.Validator, c.Config)
+	accountsHandler := handlers.NewAccountsHandler(c.AccountService, c.AccountRepository, c.ReaderRepository, c.Validator, c.Config)
+	authorsHandler := handlers.NewAuthorsHandler(c.AuthorRepository, c.Validator, c.Config)
+	publishersHandler := handlers.NewPublishersHandler(c.PublisherRepository, c.Validator, c.Config)
+	subjectsHandler := handlers.NewSubjectsHandler(c.SubjectRepository, c.Validator, c.Config)
+	itemsHandler := handlers.NewItemsHandler(c.ItemService, c.ItemRepository, c.BookRepository, c.Policy, c.Validator, c.Config)
+	adminHandler := handlers.NewAdminHandler(c.UserRepository, c.TokenRepository, c.UserTokenRepository, c.LoginAttemptRepository, c.AuditRepository, c.Mailer, c.Policy, c.Validator, c.Config)
+	trashHandler := handlers.NewTrashHandler(c.BookRepository, c.ReaderRepository, c.ItemRepository, c.AuditRepository, c.Config)
+	apiKeysHandler := handlers.NewAPIKeysHandler(c.APIKeyRepository, c.AuditRepository, c.Policy, c.Validator, c.Config)
+
+	authenticate := middleware.AuthMiddleware(c.Keys, c.UserRepository, c.TokenRepository, c.APIKeyRepository)
+	sessionOnly := middleware.SessionOnly()
+
+	// require guards a route with a permission of the caller's role
+	require := func(perm rbac.Permission) gin.HandlerFunc {
+		return middleware.RequirePermission(c.Policy, perm)
+	}
 
 	r := gin.Default()
+	if err := r.SetTrustedProxies(c.Config.TrustedProxies); err != nil {
+		log.Fatal("Invalid trusted_proxies:", err)
+	}
+
+	r.Use(middleware.RequestID())
+	r.Use(middleware.QueryTimeout(time.Duration(c.Config.QueryTimeoutSeconds) * time.Second))
+	bulkTimeout := middleware.QueryTimeout(time.Duration(c.Config.BulkQueryTimeoutSeconds) * time.Second)
 
 	// CORS middleware for frontend
 	r.Use(func(ctx *gin.Context) {
 		ctx.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		ctx.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		ctx.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		ctx.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, "+middleware.RequestIDHeader)
+		ctx.Writer.Header().Set("Access-Control-Expose-Headers", "Retry-After, "+middleware.RequestIDHeader)
 		if ctx.Request.Method == "OPTIONS" {
 			ctx.AbortWithStatus(204)
 			return
@@ -45,38 +73,162 @@ func main() {
 	r.Static("/static", "./static")
 	r.StaticFile("/", "./static/index.html")
 
-	// Public auth routes
+	r.GET("/.well-known/jwks.json", authHandler.JWKS)
+
+	// Auth routes, public except for profile, logout, two-factor and API key management
 	auth := r.Group("/auth")
 	{
 		auth.POST("/register", authHandler.Register)
 		auth.POST("/login", authHandler.Login)
-		auth.GET("/profile", middleware.AuthMiddleware(c.UserRepository), authHandler.GetProfile)
+		auth.POST("/refresh", authHandler.Refresh)
+		auth.POST("/logout", authenticate, sessionOnly, authHandler.Logout)
+		auth.POST("/logout-all", authenticate, sessionOnly, authHandler.LogoutAll)
+		auth.POST("/password/forgot", authHandler.ForgotPassword)
+		auth.POST("/password/reset", authHandler.ResetPassword)
+		auth.POST("/email/verify", authHandler.VerifyEmail)
+		auth.POST("/email/resend", authenticate, sessionOnly, authHandler.ResendVerification)
+		auth.POST("/2fa/login", authHandler.LoginTwoFactor)
+		auth.GET("/oidc", authHandler.GetOIDCConfig)
+		auth.GET("/oidc/login", authHandler.OIDCLogin)
+		auth.GET("/oidc/callback", authHandler.OIDCCallback)
+		auth.POST("/oidc/token", authHandler.OIDCToken)
+		auth.POST("/2fa/setup", authenticate, sessionOnly, authHandler.SetupTwoFactor)
+		auth.POST("/2fa/verify", authenticate, sessionOnly, authHandler.VerifyTwoFactor)
+		auth.POST("/2fa/recovery-codes", authenticate, sessionOnly, authHandler.RegenerateRecoveryCodes)
+		auth.POST("/2fa/disable", authenticate, sessionOnly, authHandler.DisableTwoFactor)
+		auth.GET("/profile", authenticate, authHandler.GetProfile)
+		auth.GET("/api-keys", authenticate, sessionOnly, apiKeysHandler.GetAll)
+		auth.POST("/api-keys", authenticate, sessionOnly, apiKeysHandler.Create)
+		auth.DELETE("/api-keys/:id", authenticate, sessionOnly, apiKeysHandler.Delete)
 	}
 
 	// Protected book routes
 	books := r.Group("/books")
-	books.Use(middleware.AuthMiddleware(c.UserRepository))
+	books.Use(authenticate, middleware.VerifiedEmailMiddleware(c.UserRepository, c.Config), middleware.TwoFactorMiddleware(c.Config))
+	{
+		books.GET("/", require(rbac.BooksRead), booksHandler.GetAll)
+		books.GET("/search", require(rbac.BooksRead), booksHandler.Search)
+		books.GET("/isbn/:isbn", require(rbac.BooksRead), booksHandler.GetByISBN)
+		books.GET("/export", bulkTimeout, require(rbac.BooksRead), booksHandler.Export)
+		books.GET("/export/marc", bulkTimeout, require(rbac.BooksRead), booksHandler.ExportMARC)
+		books.POST("/import", bulkTimeout, require(rbac.BooksImport), booksHandler.Import)
+		books.POST("/import/marc", bulkTimeout, require(rbac.BooksImport), booksHandler.ImportMARC)
+		books.POST("/", require(rbac.BooksWrite), booksHandler.Create)
+		books.DELETE("/", require(rbac.BooksDeleteAll), booksHandler.DeleteAll)
+		books.GET("/:id", require(rbac.BooksRead), booksHandler.GetByID)
+		books.PUT("/:id", require(rbac.BooksWrite), booksHandler.Update)
+		books.DELETE("/:id", require(rbac.BooksWrite), booksHandler.Delete)
+		books.GET("/:id/history", require(rbac.BooksRead), booksHandler.GetHistory)
+		books.POST("/:id/history/:rev/restore", require(rbac.BooksWrite), booksHandler.RestoreRevision)
+		books.GET("/:id/holds", require(rbac.HoldsRead), holdsHandler.GetBookQueue)
+		books.POST("/:id/holds", require(rbac.HoldsWrite), holdsHandler.Create)
+		books.GET("/:id/items", require(rbac.BooksRead), itemsHandler.GetByBook)
+		books.POST("/:id/items", require(rbac.BooksWrite), itemsHandler.Create)
+		books.GET("/:id/items/:itemId", require(rbac.BooksRead), itemsHandler.GetByID)
+		books.PUT("/:id/items/:itemId", require(rbac.BooksWrite), itemsHandler.Update)
+		books.DELETE("/:id/items/:itemId", require(rbac.BooksWrite), itemsHandler.Delete)
+	}
+
+	// Protected catalogue routes
+	authors := r.Group("/authors")
+	authors.Use(authenticate, middleware.VerifiedEmailMiddleware(c.UserRepository, c.Config), middleware.TwoFactorMiddleware(c.Config))
 	{
-		books.GET("/", booksHandler.GetAll)
-		books.POST("/", booksHandler.Create)
-		books.DELETE("/", booksHandler.DeleteAll)
-		books.GET("/:id", booksHandler.GetByID)
-		books.PUT("/:id", booksHandler.Update)
-		books.DELETE("/:id", booksHandler.Delete)
+		authors.GET("/", require(rbac.CatalogRead), authorsHandler.GetAll)
+		authors.POST("/", require(rbac.CatalogWrite), authorsHandler.Create)
+		authors.GET("/:id", require(rbac.CatalogRead), authorsHandler.GetByID)
+		authors.PUT("/:id", require(rbac.CatalogWrite), authorsHandler.Update)
+		authors.DELETE("/:id", require(rbac.CatalogWrite), authorsHandler.Delete)
+	}
+
+	publishers := r.Group("/publishers")
+	publishers.Use(authenticate, middleware.VerifiedEmailMiddleware(c.UserRepository, c.Config), middleware.TwoFactorMiddleware(c.Config))
+	{
+		publishers.GET("/", require(rbac.CatalogRead), publishersHandler.GetAll)
+		publishers.POST("/", require(rbac.CatalogWrite), publishersHandler.Create)
+		publishers.GET("/:id", require(rbac.CatalogRead), publishersHandler.GetByID)
+		publishers.PUT("/:id", require(rbac.CatalogWrite), publishersHandler.Update)
+		publishers.DELETE("/:id", require(rbac.CatalogWrite), publishersHandler.Delete)
+	}
+
+	subjects := r.Group("/subjects")
+	subjects.Use(authenticate, middleware.VerifiedEmailMiddleware(c.UserRepository, c.Config), middleware.TwoFactorMiddleware(c.Config))
+	{
+		subjects.GET("/", require(rbac.CatalogRead), subjectsHandler.GetAll)
+		subjects.POST("/", require(rbac.CatalogWrite), subjectsHandler.Create)
+		subjects.GET("/:id", require(rbac.CatalogRead), subjectsHandler.GetByID)
+		subjects.PUT("/:id", require(rbac.CatalogWrite), subjectsHandler.Update)
+		subjects.DELETE("/:id", require(rbac.CatalogWrite), subjectsHandler.Delete)
+	}
+
+	// Protected item routes
+	items := r.Group("/items")
+	items.Use(authenticate, middleware.VerifiedEmailMiddleware(c.UserRepository, c.Config), middleware.TwoFactorMiddleware(c.Config))
+	{
+		items.GET("/barcode/:barcode", require(rbac.BooksRead), itemsHandler.GetByBarcode)
 	}
 
 	// Protected reader routes
 	readers := r.Group("/readers")
-	readers.Use(middleware.AuthMiddleware(c.UserRepository))
+	readers.Use(authenticate, middleware.VerifiedEmailMiddleware(c.UserRepository, c.Config), middleware.TwoFactorMiddleware(c.Config))
+	{
+		readers.GET("/", require(rbac.ReadersRead), readersHandler.GetAll)
+		readers.POST("/", require(rbac.ReadersWrite), readersHandler.Create)
+		readers.DELETE("/", require(rbac.ReadersDeleteAll), readersHandler.DeleteAll)
+		readers.GET("/:id", require(rbac.ReadersRead), readersHandler.GetByID)
+		readers.PUT("/:id", require(rbac.ReadersWrite), readersHandler.Update)
+		readers.DELETE("/:id", require(rbac.ReadersWrite), readersHandler.Delete)
+		readers.GET("/:id/history", require(rbac.ReadersRead), readersHandler.GetHistory)
+		readers.POST("/:id/history/:rev/restore", require(rbac.ReadersWrite), readersHandler.RestoreRevision)
+		readers.POST("/:id/books/:bookId", require(rbac.ReadersWrite), readersHandler.AddCurrentlyReading)
+		readers.DELETE("/:id/books/:bookId", require(rbac.ReadersWrite), readersHandler.RemoveCurrentlyReading)
+		readers.GET("/:id/loans", require(rbac.LoansRead), loansHandler.GetReaderHistory)
+		readers.GET("/:id/holds", require(rbac.HoldsRead), holdsHandler.GetReaderHolds)
+		readers.DELETE("/:id/holds/:holdId", require(rbac.HoldsWrite), holdsHandler.Cancel)
+		readers.GET("/:id/account", require(rbac.AccountsRead), accountsHandler.GetAccount)
+		readers.POST("/:id/account/payments", require(rbac.AccountsPayment), accountsHandler.CreatePayment)
+		readers.POST("/:id/account/waivers", require(rbac.AccountsWaive), accountsHandler.CreateWaiver)
+	}
+
+	// Protected loan routes
+	loans := r.Group("/loans")
+	loans.Use(authenticate, middleware.VerifiedEmailMiddleware(c.UserRepository, c.Config), middleware.TwoFactorMiddleware(c.Config))
+	{
+		loans.GET("/", require(rbac.LoansRead), loansHandler.GetAll)
+		loans.POST("/", require(rbac.LoansCheckout), loansHandler.Checkout)
+		loans.GET("/:id", require(rbac.LoansRead), loansHandler.GetByID)
+		loans.POST("/:id/return", require(rbac.LoansReturn), loansHandler.Return)
+	}
+
+	// Admin routes
+	admin := r.Group("/admin")
+	admin.Use(authenticate, middleware.TwoFactorMiddleware(c.Config), middleware.AdminMiddleware(c.Policy))
+	{
+		admin.GET("/roles", adminHandler.GetRoles)
+		admin.GET("/users", adminHandler.GetUsers)
+		admin.GET("/users/:id", adminHandler.GetUser)
+		admin.DELETE("/users/:id", adminHandler.DeleteUser)
+		admin.PUT("/users/:id/role", adminHandler.AssignRole)
+		admin.POST("/users/:id/disable", adminHandler.Disable)
+		admin.POST("/users/:id/enable", adminHandler.Enable)
+		admin.POST("/users/:id/password-reset", adminHandler.ForcePasswordReset)
+		admin.POST("/users/:id/2fa/reset", adminHandler.ResetTwoFactor)
+		admin.POST("/users/:id/unlock", adminHandler.Unlock)
+		admin.GET("/login-attempts", adminHandler.GetLoginAttempts)
+		admin.GET("/audit", adminHandler.GetAuditEvents)
+	}
+
+	// Trash of deleted books and readers, admin only like the admin routes
+	trash := r.Group("/trash")
+	trash.Use(authenticate, middleware.TwoFactorMiddleware(c.Config), middleware.AdminMiddleware(c.Policy))
 	{
-		readers.GET("/", readersHandler.GetAll)
-		readers.POST("/", readersHandler.Create)
-		readers.DELETE("/", readersHandler.DeleteAll)
-		readers.GET("/:id", readersHandler.GetByID)
-		readers.PUT("/:id", readersHandler.Update)
-		readers.DELETE("/:id", readersHandler.Delete)
-		readers.POST("/:id/books/:bookId", readersHandler.AddCurrentlyReading)
-		readers.DELETE("/:id/books/:bookId", readersHandler.RemoveCurrentlyReading)
+		trash.GET("/books", trashHandler.GetBooks)
+		trash.DELETE("/books", trashHandler.PurgeBooks)
+		trash.POST("/books/:id/restore", trashHandler.RestoreBook)
+		trash.DELETE("/books/:id", trashHandler.PurgeBook)
+		trash.GET("/readers", trashHandler.GetReaders)
+		trash.DELETE("/readers", trashHandler.PurgeReaders)
+		trash.POST("/readers/:id/restore", trashHandler.RestoreReader)
+		trash.DELETE("/readers/:id", trashHandler.PurgeReader)
 	}
 
 	r.GET("/swagger", func(c *gin.Context) {
@@ -0,0 +1,185 @@
+package marc
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ISO 2709 delimiters
+const (
+	subfieldDelimiter = 0x1F
+	fieldTerminator   = 0x1E
+	recordTerminator  = 0x1D
+)
+
+// maxRecordLength is imposed by the five digit record length of the leader
+const maxRecordLength = 99999
+
+// Reader reads binary MARC 21 records
+type Reader struct {
+	r *bufio.Reader
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Read returns the next record. Line breaks between records, which some tools
+// add, are skipped.
+func (r *Reader) Read() (*Record, error) {
+	for {
+		b, err := r.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b != '\n' && b != '\r' && b != ' ' {
+			r.r.UnreadByte()
+			break
+		}
+	}
+
+	prefix := make([]byte, 5)
+	if _, err := io.ReadFull(r.r, prefix); err != nil {
+		return nil, fmt.Errorf("%w: truncated leader", ErrInvalidRecord)
+	}
+	length, ok := parseDigits(prefix)
+	if !ok || length < 26 {
+		return nil, fmt.Errorf("%w: bad record length %q", ErrInvalidRecord, prefix)
+	}
+
+	data := make([]byte, length)
+	copy(data, prefix)
+	if _, err := io.ReadFull(r.r, data[5:]); err != nil {
+		return nil, fmt.Errorf("%w: record shorter than its length %d", ErrInvalidRecord, length)
+	}
+	return parseBinary(data)
+}
+
+func parseBinary(data []byte) (*Record, error) {
+	if data[len(data)-1] != recordTerminator {
+		return nil, fmt.Errorf("%w: missing record terminator", ErrInvalidRecord)
+	}
+
+	record := &Record{Leader: string(data[:24])}
+	base, ok := parseDigits(data[12:17])
+	if !ok || base < 25 || base > len(data) {
+		return nil, fmt.Errorf("%w: bad base address %q", ErrInvalidRecord, data[12:17])
+	}
+
+	directory := data[24 : base-1]
+	if len(directory)%12 != 0 {
+		return nil, fmt.Errorf("%w: bad directory length %d", ErrInvalidRecord, len(directory))
+	}
+	for i := 0; i < len(directory); i += 12 {
+		entry := directory[i : i+12]
+		tag := string(entry[:3])
+		length, okLength := parseDigits(entry[3:7])
+		start, okStart := parseDigits(entry[7:12])
+		if !okLength || !okStart || length < 1 || base+start < base || base+start+length > len(data) {
+			return nil, fmt.Errorf("%w: bad directory entry %q", ErrInvalidRecord, entry)
+		}
+
+		raw := bytes.TrimSuffix(data[base+start:base+start+length], []byte{fieldTerminator})
+		field := Field{Tag: tag}
+		if field.IsControl() {
+			field.Value = toText(raw)
+			record.Fields = append(record.Fields, field)
+			continue
+		}
+
+		if len(raw) < 2 {
+			return nil, fmt.Errorf("%w: field %s without indicators", ErrInvalidRecord, tag)
+		}
+		field.Ind1 = string(raw[0])
+		field.Ind2 = string(raw[1])
+		// Anything between the indicators and the first delimiter is not a subfield
+		for _, part := range bytes.Split(raw[2:], []byte{subfieldDelimiter})[1:] {
+			if len(part) == 0 {
+				continue
+			}
+			field.Subfields = append(field.Subfields, Subfield{Code: string(part[0]), Value: toText(part[1:])})
+		}
+		record.Fields = append(record.Fields, field)
+	}
+	return record, nil
+}
+
+// parseDigits parses a number of the leader or the directory. Unlike
+// strconv.Atoi it accepts nothing but digits, so offsets cannot be negative.
+func parseDigits(b []byte) (int, bool) {
+	if len(b) == 0 {
+		return 0, false
+	}
+	n := 0
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		n = n*10 + int(c-'0')
+	}
+	return n, true
+}
+
+// toText converts field data to a string. Records are expected in UTF-8; bytes
+// of other encodings such as MARC-8 are replaced rather than mangling the text.
+func toText(b []byte) string {
+	return strings.ToValidUTF8(string(b), "\uFFFD")
+}
+
+// MarshalBinary encodes the record in the ISO 2709 format, computing the
+// record length, base address and directory
+func (r *Record) MarshalBinary() ([]byte, error) {
+	var directory, body bytes.Buffer
+	for _, field := range r.Fields {
+		if len(field.Tag) != 3 {
+			return nil, fmt.Errorf("%w: bad tag %q", ErrInvalidRecord, field.Tag)
+		}
+
+		start := body.Len()
+		if field.IsControl() {
+			body.WriteString(field.Value)
+		} else {
+			body.WriteString(indicator(field.Ind1))
+			body.WriteString(indicator(field.Ind2))
+			for _, sf := range field.Subfields {
+				body.WriteByte(subfieldDelimiter)
+				body.WriteString(sf.Code)
+				body.WriteString(sf.Value)
+			}
+		}
+		body.WriteByte(fieldTerminator)
+		if body.Len()-start > 9999 {
+			return nil, fmt.Errorf("%w: field %s longer than 9999 bytes", ErrInvalidRecord, field.Tag)
+		}
+		fmt.Fprintf(&directory, "%s%04d%05d", field.Tag, body.Len()-start, start)
+	}
+	directory.WriteByte(fieldTerminator)
+	body.WriteByte(recordTerminator)
+
+	base := 24 + directory.Len()
+	length := base + body.Len()
+	if length > maxRecordLength {
+		return nil, fmt.Errorf("%w: record longer than %d bytes", ErrInvalidRecord, maxRecordLength)
+	}
+
+	leader := []byte(r.leader())
+	copy(leader[0:5], fmt.Sprintf("%05d", length))
+	copy(leader[10:12], "22")
+	copy(leader[12:17], fmt.Sprintf("%05d", base))
+	copy(leader[20:24], "4500")
+
+	out := make([]byte, 0, length)
+	out = append(out, leader...)
+	out = append(out, directory.Bytes()...)
+	return append(out, body.Bytes()...), nil
+}
+
+func indicator(ind string) string {
+	if len(ind) != 1 {
+		return " "
+	}
+	return ind
+}
@@ -0,0 +1,281 @@
+package marc
+
+import (
+	"lab1/models"
+	"lab1/validation"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ToBook maps a record to a book: the title from 245, the first valid ISBN of
+// 020, the author of 100 followed by the added authors of 700, subjects from 650
+// and the description from 520. Authors and subjects only carry their names.
+// The whole record is kept in MARCRecord, so that FromBook can write the fields
+// without a book column back out.
+func ToBook(record *Record) (*models.Book, error) {
+	stored, err := MarshalRecordXML(record)
+	if err != nil {
+		return nil, err
+	}
+
+	book := &models.Book{MARCRecord: stored, Description: descriptionOf(record)}
+	if fields := record.FieldsByTag("245"); len(fields) > 0 {
+		book.Title = titleOf(fields[0])
+	}
+	if i, isbn := primaryISBN(record); i >= 0 {
+		book.ISBN = &isbn
+	}
+
+	authors := map[string]bool{}
+	addAuthor := func(name string) {
+		if name != "" && !authors[strings.ToLower(name)] {
+			authors[strings.ToLower(name)] = true
+			book.Authors = append(book.Authors, models.Author{Name: name})
+		}
+	}
+	if fields := record.FieldsByTag("100"); len(fields) > 0 {
+		addAuthor(nameOf(fields[0]))
+	}
+	for _, field := range record.FieldsByTag("700") {
+		if isAddedAuthor(field) {
+			addAuthor(nameOf(field))
+		}
+	}
+
+	subjects := map[string]bool{}
+	for _, field := range record.FieldsByTag("650") {
+		name := subjectOf(field)
+		if name != "" && !subjects[strings.ToLower(name)] {
+			subjects[strings.ToLower(name)] = true
+			book.Subjects = append(book.Subjects, models.Subject{Name: name})
+		}
+	}
+	return book, nil
+}
+
+// FromBook builds the record of a book. Fields of the record the book was
+// imported from are kept where the book still has the value they map to, and
+// unmapped fields are always kept; new values get freshly built fields. 001
+// holds the book ID and 005 the time of the last change.
+func FromBook(book *models.Book) (*Record, error) {
+	original := &Record{Leader: DefaultLeader}
+	if book.MARCRecord != "" {
+		parsed, err := UnmarshalRecordXML(book.MARCRecord)
+		if err != nil {
+			return nil, err
+		}
+		original = parsed
+	}
+
+	record := &Record{Leader: original.leader()}
+	record.Fields = append(record.Fields,
+		Field{Tag: "001", Value: strconv.FormatUint(uint64(book.ID), 10)},
+		Field{Tag: "005", Value: book.UpdatedAt.UTC().Format("20060102150405.0")},
+	)
+
+	var mainAuthor string
+	var addedAuthors, subjects []string
+	for i, author := range book.Authors {
+		if i == 0 {
+			mainAuthor = author.Name
+		} else {
+			addedAuthors = append(addedAuthors, author.Name)
+		}
+	}
+	for _, subject := range book.Subjects {
+		subjects = append(subjects, subject.Name)
+	}
+	missingAdded := nameSet(addedAuthors)
+	missingSubjects := nameSet(subjects)
+
+	isbnIndex, _ := primaryISBN(original)
+	keepDescription := descriptionOf(original) == book.Description
+	var isbnWritten, mainWritten, titleWritten, descriptionWritten bool
+
+	for i, field := range original.Fields {
+		switch field.Tag {
+		case "001", "005":
+			continue
+		case "020":
+			if i != isbnIndex {
+				record.Fields = append(record.Fields, field)
+			} else if book.ISBN != nil {
+				if _, isbn := primaryISBN(&Record{Fields: []Field{field}}); isbn != *book.ISBN {
+					field = DataField("020", " ", " ", "a", *book.ISBN)
+				}
+				record.Fields = append(record.Fields, field)
+				isbnWritten = true
+			}
+		case "100":
+			if mainAuthor == "" || mainWritten {
+				continue
+			}
+			if !strings.EqualFold(nameOf(&field), mainAuthor) {
+				field = DataField("100", "1", " ", "a", mainAuthor)
+			}
+			record.Fields = append(record.Fields, field)
+			mainWritten = true
+		case "245":
+			if titleWritten {
+				continue
+			}
+			if titleOf(&field) != book.Title {
+				field = retitle(field, book.Title)
+			}
+			record.Fields = append(record.Fields, field)
+			titleWritten = true
+		case "520":
+			if keepDescription {
+				record.Fields = append(record.Fields, field)
+			} else if !descriptionWritten && book.Description != "" {
+				record.Fields = append(record.Fields, DataField("520", " ", " ", "a", book.Description))
+				descriptionWritten = true
+			}
+		case "650":
+			if name := strings.ToLower(subjectOf(&field)); missingSubjects[name] {
+				record.Fields = append(record.Fields, field)
+				delete(missingSubjects, name)
+			}
+		case "700":
+			if !isAddedAuthor(&field) {
+				record.Fields = append(record.Fields, field)
+			} else if name := strings.ToLower(nameOf(&field)); missingAdded[name] {
+				record.Fields = append(record.Fields, field)
+				delete(missingAdded, name)
+			}
+		default:
+			record.Fields = append(record.Fields, field)
+		}
+	}
+
+	if book.ISBN != nil && !isbnWritten {
+		record.Fields = append(record.Fields, DataField("020", " ", " ", "a", *book.ISBN))
+	}
+	if mainAuthor != "" && !mainWritten {
+		record.Fields = append(record.Fields, DataField("100", "1", " ", "a", mainAuthor))
+	}
+	if !titleWritten {
+		ind1 := "0"
+		if mainAuthor != "" {
+			ind1 = "1"
+		}
+		record.Fields = append(record.Fields, DataField("245", ind1, "0", "a", book.Title))
+	}
+	if !keepDescription && !descriptionWritten && book.Description != "" {
+		record.Fields = append(record.Fields, DataField("520", " ", " ", "a", book.Description))
+	}
+	for _, name := range subjects {
+		if missingSubjects[strings.ToLower(name)] {
+			record.Fields = append(record.Fields, DataField("650", " ", "4", "a", name))
+		}
+	}
+	for _, name := range addedAuthors {
+		if missingAdded[strings.ToLower(name)] {
+			record.Fields = append(record.Fields, DataField("700", "1", " ", "a", name))
+		}
+	}
+
+	// Fields are ordered by tag; a stable sort keeps the order within a tag
+	sort.SliceStable(record.Fields, func(i, j int) bool {
+		return record.Fields[i].Tag < record.Fields[j].Tag
+	})
+	return record, nil
+}
+
+// primaryISBN returns the index and normalized value of the first 020 field
+// with a valid ISBN in $a, or -1. $a may carry a qualifier such as "(pbk.)".
+func primaryISBN(record *Record) (int, string) {
+	for i, field := range record.Fields {
+		if field.Tag != "020" {
+			continue
+		}
+		words := strings.Fields(field.Subfield("a"))
+		if len(words) == 0 {
+			continue
+		}
+		if isbn, err := validation.NormalizeISBN(words[0]); err == nil {
+			return i, isbn
+		}
+	}
+	return -1, ""
+}
+
+// titleOf joins the title proper ($a) and the remainder of the title ($b)
+func titleOf(field *Field) string {
+	title := trimPunctuation(field.Subfield("a"))
+	if rest := trimPunctuation(field.Subfield("b")); rest != "" {
+		title += " : " + rest
+	}
+	return title
+}
+
+// retitle replaces $a and $b of a 245 field, keeping the other subfields
+func retitle(field Field, title string) Field {
+	subfields := []Subfield{{Code: "a", Value: title}}
+	for _, sf := range field.Subfields {
+		if sf.Code != "a" && sf.Code != "b" {
+			subfields = append(subfields, sf)
+		}
+	}
+	field.Subfields = subfields
+	return field
+}
+
+func nameOf(field *Field) string {
+	return trimPunctuation(field.Subfield("a"))
+}
+
+// isAddedAuthor tells 700 fields naming a person apart from name/title entries
+// of contained works, which carry a title in $t
+func isAddedAuthor(field *Field) bool {
+	return field.Subfield("a") != "" && field.Subfield("t") == ""
+}
+
+// subjectOf joins the topical term with its subdivisions, as in "Dune (Imaginary
+// place) -- Fiction"
+func subjectOf(field *Field) string {
+	var parts []string
+	for _, sf := range field.Subfields {
+		if strings.Contains("avxyz", sf.Code) {
+			if part := trimPunctuation(sf.Value); part != "" {
+				parts = append(parts, part)
+			}
+		}
+	}
+	return strings.Join(parts, " -- ")
+}
+
+// descriptionOf joins the summaries of all 520 fields
+func descriptionOf(record *Record) string {
+	var summaries []string
+	for _, field := range record.FieldsByTag("520") {
+		if summary := strings.TrimSpace(field.Subfield("a")); summary != "" {
+			summaries = append(summaries, summary)
+		}
+	}
+	return strings.Join(summaries, "\n\n")
+}
+
+// trimPunctuation removes the ISBD punctuation that separates subfields, such as
+// the " /" ending a title or the "," after a name. A final period is only removed
+// after a lowercase letter, which keeps initials like "Le Guin, Ursula K.".
+func trimPunctuation(s string) string {
+	s = strings.TrimRight(strings.TrimSpace(s), " ,:;/=")
+	if strings.HasSuffix(s, ".") {
+		if r, _ := utf8.DecodeLastRuneInString(s[:len(s)-1]); unicode.IsLower(r) {
+			s = s[:len(s)-1]
+		}
+	}
+	return s
+}
+
+func nameSet(names []string) map[string]bool {
+	set := make(map[string]bool, len(names))
+	for _, name := range names {
+		set[strings.ToLower(name)] = true
+	}
+	return set
+}
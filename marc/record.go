@@ -0,0 +1,86 @@
+// Package marc reads and writes MARC 21 bibliographic records, in the binary
+// ISO 2709 transmission format as well as in MARCXML, and maps them to books.
+package marc
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrInvalidRecord is returned for records that do not follow the MARC structure
+var ErrInvalidRecord = errors.New("invalid MARC record")
+
+// DefaultLeader is used for records that are not based on an imported one:
+// a new record (n) of language material (a), monograph (m), Unicode (a), RDA (i).
+// The record length and base address are filled in when the record is written.
+const DefaultLeader = "00000nam a2200000 i 4500"
+
+type Subfield struct {
+	Code  string
+	Value string
+}
+
+// Field is a control field (tags 001 to 009), which only has a Value, or a data
+// field with two indicators and subfields
+type Field struct {
+	Tag       string
+	Value     string
+	Ind1      string
+	Ind2      string
+	Subfields []Subfield
+}
+
+type Record struct {
+	Leader string
+	Fields []Field
+}
+
+// IsControl reports whether the field is a control field
+func (f *Field) IsControl() bool {
+	return strings.HasPrefix(f.Tag, "00")
+}
+
+// Subfield returns the value of the first subfield with the code, or ""
+func (f *Field) Subfield(code string) string {
+	for _, sf := range f.Subfields {
+		if sf.Code == code {
+			return sf.Value
+		}
+	}
+	return ""
+}
+
+// DataField builds a data field; subfields are given as code, value pairs
+func DataField(tag, ind1, ind2 string, subfields ...string) Field {
+	field := Field{Tag: tag, Ind1: ind1, Ind2: ind2}
+	for i := 0; i+1 < len(subfields); i += 2 {
+		field.Subfields = append(field.Subfields, Subfield{Code: subfields[i], Value: subfields[i+1]})
+	}
+	return field
+}
+
+// FieldsByTag returns pointers to the fields with the tag, in record order
+func (r *Record) FieldsByTag(tag string) []*Field {
+	var fields []*Field
+	for i := range r.Fields {
+		if r.Fields[i].Tag == tag {
+			fields = append(fields, &r.Fields[i])
+		}
+	}
+	return fields
+}
+
+// leader returns the record's leader, falling back to DefaultLeader if it is
+// missing or malformed
+func (r *Record) leader() string {
+	if len(r.Leader) != 24 {
+		return DefaultLeader
+	}
+	return r.Leader
+}
+
+// RecordReader is implemented by the binary and the MARCXML reader.
+// Read returns io.EOF after the last record.
+type RecordReader interface {
+	Read() (*Record, error)
+}
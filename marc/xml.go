@@ -0,0 +1,178 @@
+package marc
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Namespace is the MARCXML namespace of the Library of Congress
+const Namespace = "http://www.loc.gov/MARC21/slim"
+
+type xmlRecord struct {
+	XMLName       xml.Name          `xml:"record"`
+	Leader        string            `xml:"leader"`
+	ControlFields []xmlControlField `xml:"controlfield"`
+	DataFields    []xmlDataField    `xml:"datafield"`
+}
+
+type xmlControlField struct {
+	Tag   string `xml:"tag,attr"`
+	Value string `xml:",chardata"`
+}
+
+type xmlDataField struct {
+	Tag       string        `xml:"tag,attr"`
+	Ind1      string        `xml:"ind1,attr"`
+	Ind2      string        `xml:"ind2,attr"`
+	Subfields []xmlSubfield `xml:"subfield"`
+}
+
+type xmlSubfield struct {
+	Code  string `xml:"code,attr"`
+	Value string `xml:",chardata"`
+}
+
+// XMLReader reads the records of a MARCXML document, which may be a single
+// record or a collection. Elements are matched by local name, so prefixed and
+// unprefixed documents are both accepted.
+type XMLReader struct {
+	decoder *xml.Decoder
+}
+
+func NewXMLReader(r io.Reader) *XMLReader {
+	return &XMLReader{decoder: xml.NewDecoder(r)}
+}
+
+func (r *XMLReader) Read() (*Record, error) {
+	for {
+		token, err := r.decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "record" {
+			continue
+		}
+
+		var raw xmlRecord
+		if err := r.decoder.DecodeElement(&raw, &start); err != nil {
+			return nil, err
+		}
+		return fromXML(&raw), nil
+	}
+}
+
+// Control fields come first in MARC records, so decoding them separately from
+// the data fields keeps the field order
+func fromXML(raw *xmlRecord) *Record {
+	record := &Record{Leader: raw.Leader}
+	for _, cf := range raw.ControlFields {
+		record.Fields = append(record.Fields, Field{Tag: cf.Tag, Value: cf.Value})
+	}
+	for _, df := range raw.DataFields {
+		field := Field{Tag: df.Tag, Ind1: indicator(df.Ind1), Ind2: indicator(df.Ind2)}
+		for _, sf := range df.Subfields {
+			field.Subfields = append(field.Subfields, Subfield{Code: sf.Code, Value: sf.Value})
+		}
+		record.Fields = append(record.Fields, field)
+	}
+	return record
+}
+
+func toXML(record *Record) *xmlRecord {
+	raw := &xmlRecord{Leader: record.leader()}
+	for _, field := range record.Fields {
+		if field.IsControl() {
+			raw.ControlFields = append(raw.ControlFields, xmlControlField{Tag: field.Tag, Value: field.Value})
+			continue
+		}
+		df := xmlDataField{Tag: field.Tag, Ind1: indicator(field.Ind1), Ind2: indicator(field.Ind2)}
+		for _, sf := range field.Subfields {
+			df.Subfields = append(df.Subfields, xmlSubfield{Code: sf.Code, Value: sf.Value})
+		}
+		raw.DataFields = append(raw.DataFields, df)
+	}
+	return raw
+}
+
+// XMLWriter writes records as a MARCXML collection. Close must be called to
+// end the document.
+type XMLWriter struct {
+	w       *bufio.Writer
+	encoder *xml.Encoder
+	started bool
+}
+
+func NewXMLWriter(w io.Writer) *XMLWriter {
+	buffered := bufio.NewWriter(w)
+	encoder := xml.NewEncoder(buffered)
+	encoder.Indent("", "  ")
+	return &XMLWriter{w: buffered, encoder: encoder}
+}
+
+func (w *XMLWriter) start() error {
+	if w.started {
+		return nil
+	}
+	w.started = true
+	if _, err := w.w.WriteString(xml.Header); err != nil {
+		return err
+	}
+	return w.encoder.EncodeToken(xml.StartElement{
+		Name: xml.Name{Local: "collection"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "xmlns"}, Value: Namespace}},
+	})
+}
+
+func (w *XMLWriter) Write(record *Record) error {
+	if err := w.start(); err != nil {
+		return err
+	}
+	return w.encoder.Encode(toXML(record))
+}
+
+// Flush writes buffered records to the underlying writer
+func (w *XMLWriter) Flush() error {
+	if err := w.encoder.Flush(); err != nil {
+		return err
+	}
+	return w.w.Flush()
+}
+
+// Close ends the collection and flushes it
+func (w *XMLWriter) Close() error {
+	if err := w.start(); err != nil {
+		return err
+	}
+	if err := w.encoder.EncodeToken(xml.EndElement{Name: xml.Name{Local: "collection"}}); err != nil {
+		return err
+	}
+	if err := w.encoder.Flush(); err != nil {
+		return err
+	}
+	if _, err := w.w.WriteString("\n"); err != nil {
+		return err
+	}
+	return w.w.Flush()
+}
+
+// MarshalRecordXML encodes a single record as a MARCXML document
+func MarshalRecordXML(record *Record) (string, error) {
+	out, err := xml.Marshal(toXML(record))
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// UnmarshalRecordXML decodes the first record of a MARCXML document
+func UnmarshalRecordXML(s string) (*Record, error) {
+	record, err := NewXMLReader(strings.NewReader(s)).Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("%w: no record element", ErrInvalidRecord)
+	}
+	return record, err
+}
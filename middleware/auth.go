@@ -1,6 +1,15 @@
 package middleware
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"lab1/config"
+	"lab1/jwtkeys"
+	"lab1/models"
+	"lab1/rbac"
 	"lab1/repository"
 	"net/http"
 	"strings"
@@ -8,10 +17,9 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
 )
 
-var jwtSecret = []byte("your-secret-key-change-in-production") // In production, use environment variable
-
 type Claims struct {
 	UserID   uint   `json:"user_id"`
 	Username string `json:"username"`
@@ -19,24 +27,46 @@ type Claims struct {
 	jwt.RegisteredClaims
 }
 
-// GenerateToken creates a new JWT token for a user
-func GenerateToken(userID uint, username, role string) (string, error) {
-	claims := Claims{
+// GenerateToken creates a new access token for a user. Its ID (jti) is what
+// logout and session revocation put on the revocation list.
+func GenerateToken(keys *jwtkeys.KeySet, userID uint, username, role string, ttl time.Duration) (string, *Claims, error) {
+	jti, err := RandomToken(16)
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now()
+	claims := &Claims{
 		UserID:   userID,
 		Username: username,
 		Role:     role,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ID:        jti,
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+			IssuedAt:  jwt.NewNumericDate(now),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	signed, err := keys.Sign(claims)
+	if err != nil {
+		return "", nil, err
+	}
+	return signed, claims, nil
 }
 
-// AuthMiddleware validates JWT tokens
-func AuthMiddleware(userRepo repository.UserRepository) gin.HandlerFunc {
+// RandomToken returns n random bytes, base64url encoded
+func RandomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// AuthMiddleware authenticates the request with a JWT access token
+// ("Authorization: Bearer <token>") or an API key ("Authorization: ApiKey
+// <key>"). Revoked tokens, unknown or expired keys and disabled users are rejected.
+func AuthMiddleware(keys *jwtkeys.KeySet, userRepo repository.UserRepository, tokenRepo repository.TokenRepository, apiKeyRepo repository.APIKeyRepository) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -45,47 +75,191 @@ func AuthMiddleware(userRepo repository.UserRepository) gin.HandlerFunc {
 			return
 		}
 
-		// Extract token from "Bearer <token>"
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-		if tokenString == authHeader {
+		var userID uint
+		var ok bool
+		if tokenString, found := strings.CutPrefix(authHeader, "Bearer "); found {
+			userID, ok = authenticateToken(c, keys, tokenRepo, tokenString)
+		} else if key, found := strings.CutPrefix(authHeader, "ApiKey "); found {
+			userID, ok = authenticateAPIKey(c, apiKeyRepo, key)
+		} else {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid authorization format"})
+		}
+		if !ok {
 			c.Abort()
 			return
 		}
 
-		// Parse and validate token
-		token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-			return jwtSecret, nil
-		})
-
-		if err != nil || !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+		// The role is read from the user, so that a new role applies at once
+		// and not only to tokens issued afterwards
+		user, err := userRepo.GetByID(c.Request.Context(), userID)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
 			c.Abort()
 			return
 		}
-
-		claims, ok := token.Claims.(*Claims)
-		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
+		if user.IsDisabled() {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Account is disabled"})
 			c.Abort()
 			return
 		}
 
 		// Set user info in context
-		c.Set("user_id", claims.UserID)
-		c.Set("username", claims.Username)
-		c.Set("role", claims.Role)
+		c.Set("user_id", user.ID)
+		c.Set("username", user.Username)
+		c.Set("role", user.Role)
+		c.Set("user", user)
+
+		c.Next()
+	}
+}
+
+// authenticateToken validates an access token and returns its user. It writes
+// the error response and returns false if the token is not accepted.
+func authenticateToken(c *gin.Context, keys *jwtkeys.KeySet, tokenRepo repository.TokenRepository, tokenString string) (uint, bool) {
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, keys.Keyfunc)
+	if err != nil || !token.Valid {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+		return 0, false
+	}
+
+	claims, ok := token.Claims.(*Claims)
+	if !ok || claims.ID == "" || claims.ExpiresAt == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
+		return 0, false
+	}
+
+	revoked, err := tokenRepo.IsRevoked(c.Request.Context(), claims.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check token"})
+		return 0, false
+	}
+	if revoked {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Token has been revoked"})
+		return 0, false
+	}
+
+	c.Set("token_id", claims.ID)
+	c.Set("token_expires_at", claims.ExpiresAt.Time)
+	return claims.UserID, true
+}
+
+// authenticateAPIKey looks up an API key and returns its owner; the key's
+// scopes limit the permissions of the request
+func authenticateAPIKey(c *gin.Context, apiKeyRepo repository.APIKeyRepository, key string) (uint, bool) {
+	apiKey, err := apiKeyRepo.FindByHash(c.Request.Context(), HashToken(key))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check API key"})
+		}
+		return 0, false
+	}
+
+	now := time.Now()
+	if apiKey.IsExpired(now) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "API key expired"})
+		return 0, false
+	}
+	// A failed update only loses a usage timestamp
+	apiKeyRepo.Touch(c.Request.Context(), apiKey.ID, now)
+
+	scopes := make(map[rbac.Permission]bool)
+	for _, scope := range apiKey.ScopeList() {
+		scopes[rbac.Permission(scope)] = true
+	}
+	c.Set("api_key_id", apiKey.ID)
+	c.Set("scopes", scopes)
+	return apiKey.UserID, true
+}
 
+// SessionOnly rejects requests authenticated with an API key, for endpoints
+// that manage the login session or the credentials themselves. It must run
+// after AuthMiddleware.
+func SessionOnly() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, isAPIKey := c.Get("api_key_id"); isAPIKey {
+			c.JSON(http.StatusForbidden, gin.H{"error": "This endpoint cannot be used with an API key"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// HashToken returns the SHA-256 of a token, hex encoded; opaque tokens and
+// keys are only stored this way
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// RequirePermission lets the request through only if the user's role grants
+// all of the permissions. It must run after AuthMiddleware.
+func RequirePermission(policy *rbac.Policy, perms ...rbac.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for _, perm := range perms {
+			if !HasPermission(c, policy, perm) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied", "permission": perm})
+				c.Abort()
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// HasPermission reports whether the current user's role grants the permission,
+// for checks that depend on the requested record, such as ownership. With an
+// API key the permission must also be one of the key's scopes.
+func HasPermission(c *gin.Context, policy *rbac.Policy, perm rbac.Permission) bool {
+	if !policy.Has(c.GetString("role"), perm) {
+		return false
+	}
+	if scopes, isAPIKey := c.Get("scopes"); isAPIKey {
+		return scopes.(map[rbac.Permission]bool)[perm]
+	}
+	return true
+}
+
+// AdminMiddleware admits users who may manage other users
+func AdminMiddleware(policy *rbac.Policy) gin.HandlerFunc {
+	return RequirePermission(policy, rbac.UsersManage)
+}
+
+// VerifiedEmailMiddleware lets users whose email address is not verified only
+// read, when require_email_verification is on. It must run after AuthMiddleware.
+func VerifiedEmailMiddleware(userRepo repository.UserRepository, cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		method := c.Request.Method
+		if !cfg.RequireEmailVerification || method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions {
+			c.Next()
+			return
+		}
+
+		user, err := userRepo.GetByID(c.Request.Context(), c.GetUint("user_id"))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+			c.Abort()
+			return
+		}
+		if user.EmailVerifiedAt == nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Email address not verified"})
+			c.Abort()
+			return
+		}
 		c.Next()
 	}
 }
 
-// AdminMiddleware checks if user has admin role
-func AdminMiddleware() gin.HandlerFunc {
+// TwoFactorMiddleware rejects users whose role requires two-factor
+// authentication until they have enrolled, leaving them only the /auth
+// routes to do so. It must run after AuthMiddleware.
+func TwoFactorMiddleware(cfg *config.Config) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		role, exists := c.Get("role")
-		if !exists || role != "admin" {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+		user := c.MustGet("user").(*models.User)
+		if cfg.RequiresTwoFactor(user.Role) && !user.HasTwoFactor() {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Two-factor authentication is required for your role, set it up with POST /auth/2fa/setup"})
 			c.Abort()
 			return
 		}
@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestIDHeader carries the request ID in both directions
+const RequestIDHeader = "X-Request-ID"
+
+// validRequestID accepts the IDs of common proxies and tracing tools but
+// nothing that could forge log lines
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,64}$`)
+
+// RequestID tags every request with an ID, stored as "request_id" and echoed
+// in the X-Request-ID response header. An ID set by a proxy in front is kept,
+// so that its logs and the audit log can be matched.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			var err error
+			if id, err = RandomToken(12); err != nil {
+				id = ""
+			}
+		}
+		c.Set("request_id", id)
+		if id != "" {
+			c.Header(RequestIDHeader, id)
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// QueryTimeout gives the handlers timeout to answer; the repositories stop
+// their queries once it passes. An error written after the deadline or
+// after the client went away is replaced by 504 Gateway Timeout or 503
+// Service Unavailable: handlers report failed lookups as, for example, 404,
+// which would be wrong when the lookup was only cut short. Set on a route
+// after the global one, it replaces the timeout instead of shortening it.
+// A timeout of 0 means no limit.
+func QueryTimeout(timeout time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		w, ok := c.Writer.(*timeoutWriter)
+		if !ok {
+			w = &timeoutWriter{ResponseWriter: c.Writer, c: c, base: c.Request.Context()}
+			c.Writer = w
+		}
+
+		ctx := w.base
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(w.base, timeout)
+			defer cancel()
+		}
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// timeoutWriter rewrites error responses caused by the request context ending
+type timeoutWriter struct {
+	gin.ResponseWriter
+	c    *gin.Context
+	base context.Context // the request context without a deadline
+
+	replacement []byte // the body written instead of the handler's
+	replaced    bool
+}
+
+func (w *timeoutWriter) WriteHeader(code int) {
+	if code >= http.StatusBadRequest && !w.Written() && w.replacement == nil {
+		switch err := w.c.Request.Context().Err(); {
+		case errors.Is(err, context.DeadlineExceeded):
+			code, w.replacement = http.StatusGatewayTimeout, []byte(`{"error":"Request timed out"}`)
+		case errors.Is(err, context.Canceled):
+			code, w.replacement = http.StatusServiceUnavailable, []byte(`{"error":"Request cancelled"}`)
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *timeoutWriter) Write(data []byte) (int, error) {
+	if w.replacement == nil {
+		return w.ResponseWriter.Write(data)
+	}
+	if !w.replaced {
+		w.replaced = true
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if _, err := w.ResponseWriter.Write(w.replacement); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+func (w *timeoutWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
@@ -0,0 +1,30 @@
+package models
+
+import "gorm.io/gorm"
+
+const (
+	TransactionCharge  = "charge"  // overdue fine, increases the balance
+	TransactionPayment = "payment" // money received from the reader
+	TransactionWaiver  = "waiver"  // charge forgiven by an admin
+)
+
+// AccountTransaction is a single entry of a reader's fines ledger
+type AccountTransaction struct {
+	gorm.Model
+	ReaderID    uint   `gorm:"not null;index"`
+	Reader      Reader `gorm:"foreignKey:ReaderID;constraint:OnDelete:CASCADE"`
+	LoanID      *uint  `gorm:"index"` // loan that caused the charge
+	Type        string `gorm:"not null"`
+	AmountCents int64  `gorm:"not null"` // always positive, Type decides the sign
+	Note        string
+	CreatedByID *uint // staff member who recorded the payment or waived the charge
+	CreatedBy   *User `gorm:"foreignKey:CreatedByID"`
+}
+
+// SignedAmount returns the effect of the transaction on the reader's balance
+func (t *AccountTransaction) SignedAmount() int64 {
+	if t.Type == TransactionCharge {
+		return t.AmountCents
+	}
+	return -t.AmountCents
+}
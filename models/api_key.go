@@ -0,0 +1,29 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// APIKey lets scripts authenticate as their owner with "Authorization: ApiKey
+// <key>", limited to the permissions in Scopes
+type APIKey struct {
+	ID         uint `gorm:"primarykey"`
+	CreatedAt  time.Time
+	UserID     uint       `gorm:"not null;index"`
+	User       User       `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+	Name       string     `gorm:"not null"`
+	Prefix     string     `gorm:"not null"`             // start of the key, shown to tell keys apart
+	KeyHash    string     `gorm:"uniqueIndex;not null"` // SHA-256 of the key, the key itself is only shown once
+	Scopes     string     `gorm:"not null"`             // space-separated permissions
+	ExpiresAt  *time.Time // nil for keys that do not expire
+	LastUsedAt *time.Time
+}
+
+func (k *APIKey) ScopeList() []string {
+	return strings.Fields(k.Scopes)
+}
+
+func (k *APIKey) IsExpired(now time.Time) bool {
+	return k.ExpiresAt != nil && !now.Before(*k.ExpiresAt)
+}
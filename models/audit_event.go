@@ -0,0 +1,38 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrAuditEventImmutable is returned when an audit event would be changed or deleted
+var ErrAuditEventImmutable = errors.New("audit events are append-only")
+
+// AuditEvent records one change made through the API: who did what to which
+// entity, with JSON snapshots of the entity before and after. Events are never
+// updated or deleted.
+type AuditEvent struct {
+	ID         uint      `gorm:"primarykey"`
+	CreatedAt  time.Time `gorm:"index"`
+	ActorID    *uint     `gorm:"index"` // nil for anonymous requests, e.g. a password reset by mail
+	ActorName  string    // username at the time, kept when the user is deleted
+	Action     string    `gorm:"index;not null"` // create, update, delete or a more specific verb
+	EntityType string    `gorm:"index:idx_audit_events_entity;not null"`
+	EntityID   *uint     `gorm:"index:idx_audit_events_entity"` // nil for actions on many entities
+	Before     string    // JSON, empty if the entity did not exist before
+	After      string    // JSON, empty if the entity does not exist afterwards
+	IP         string
+	RequestID  string `gorm:"index"`
+}
+
+// BeforeUpdate keeps GORM from changing recorded events
+func (e *AuditEvent) BeforeUpdate(tx *gorm.DB) error {
+	return ErrAuditEventImmutable
+}
+
+// BeforeDelete keeps GORM from deleting recorded events
+func (e *AuditEvent) BeforeDelete(tx *gorm.DB) error {
+	return ErrAuditEventImmutable
+}
@@ -0,0 +1,9 @@
+package models
+
+import "gorm.io/gorm"
+
+type Author struct {
+	gorm.Model
+	Name  string `gorm:"not null;index"`
+	Books []Book `gorm:"many2many:book_authors"`
+}
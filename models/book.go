@@ -6,6 +6,12 @@ type Book struct {
 	gorm.Model
 	Title       string `gorm:"not null"`
 	Description string
-	UserID      uint   `gorm:"not null"` // Owner of the book
-	User        User   `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+	ISBN        *string     `gorm:"uniqueIndex"` // normalized ISBN-13, nil when unknown
+	UserID      uint        `gorm:"not null"`    // Owner of the book
+	User        User        `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+	Items       []Item      `gorm:"foreignKey:BookID"` // Physical copies
+	Authors     []Author    `gorm:"many2many:book_authors"`
+	Publishers  []Publisher `gorm:"many2many:book_publishers"`
+	Subjects    []Subject   `gorm:"many2many:book_subjects"`
+	MARCRecord  string      // MARCXML of the imported record, keeps its unmapped fields for export
 }
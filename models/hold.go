@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	HoldStatusWaiting   = "waiting"   // in the queue, book is still lent out
+	HoldStatusReady     = "ready"     // book was released and waits for pickup
+	HoldStatusFulfilled = "fulfilled" // reader picked the book up
+	HoldStatusCancelled = "cancelled"
+	HoldStatusExpired   = "expired" // book was not picked up in time
+)
+
+type Hold struct {
+	gorm.Model
+	ReaderID  uint       `gorm:"not null;index"`
+	Reader    Reader     `gorm:"foreignKey:ReaderID;constraint:OnDelete:CASCADE"`
+	BookID    uint       `gorm:"not null;index"`
+	Book      Book       `gorm:"foreignKey:BookID;constraint:OnDelete:CASCADE"`
+	Status    string     `gorm:"not null;default:'waiting';index"`
+	ReadyAt   *time.Time // when the book became available for this reader
+	ExpiresAt *time.Time // pickup deadline, set together with ReadyAt
+}
+
+// IsActive reports whether the hold still occupies a place in the queue
+func (h *Hold) IsActive() bool {
+	return h.Status == HoldStatusWaiting || h.Status == HoldStatusReady
+}
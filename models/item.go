@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	ItemStatusAvailable = "available"
+	ItemStatusOnLoan    = "on-loan"
+	ItemStatusLost      = "lost"
+	ItemStatusInRepair  = "in-repair"
+	ItemStatusWithdrawn = "withdrawn"
+)
+
+// Item is a physical copy of a book
+type Item struct {
+	gorm.Model
+	BookID        uint   `gorm:"not null;index"`
+	Book          Book   `gorm:"foreignKey:BookID;constraint:OnDelete:CASCADE"`
+	Barcode       string `gorm:"uniqueIndex;not null"`
+	ShelfLocation string
+	Condition     string
+	Status        string `gorm:"not null;default:'available';index"`
+	AcquiredAt    *time.Time
+}
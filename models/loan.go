@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	LoanStatusActive   = "active"
+	LoanStatusOverdue  = "overdue"
+	LoanStatusReturned = "returned"
+)
+
+type Loan struct {
+	gorm.Model
+	ReaderID       uint       `gorm:"not null;index"`
+	Reader         Reader     `gorm:"foreignKey:ReaderID;constraint:OnDelete:CASCADE"`
+	BookID         uint       `gorm:"not null;index"`
+	Book           Book       `gorm:"foreignKey:BookID;constraint:OnDelete:CASCADE"`
+	ItemID         *uint      `gorm:"index"` // lent copy, nil for books without registered copies
+	Item           *Item      `gorm:"foreignKey:ItemID"`
+	CheckedOutAt   time.Time  `gorm:"not null"`
+	DueAt          time.Time  `gorm:"not null"`
+	ReturnedAt     *time.Time `gorm:"index"`    // nil while the book is still out
+	CheckedOutByID uint       `gorm:"not null"` // User who registered the checkout
+	CheckedOutBy   User       `gorm:"foreignKey:CheckedOutByID"`
+}
+
+// Status returns the loan state ('active', 'overdue' or 'returned') at the given time
+func (l *Loan) Status(now time.Time) string {
+	if l.ReturnedAt != nil {
+		return LoanStatusReturned
+	}
+	if now.After(l.DueAt) {
+		return LoanStatusOverdue
+	}
+	return LoanStatusActive
+}
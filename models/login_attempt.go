@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+// Results of a login attempt
+const (
+	LoginSucceeded = "success"
+	LoginFailed    = "failure"   // unknown user or wrong password
+	LoginThrottled = "throttled" // rejected without checking the password
+)
+
+// LoginAttempt records a password login, so that administrators can spot
+// guessing. Rows are kept for login_attempt_retention_days.
+type LoginAttempt struct {
+	ID        uint      `gorm:"primarykey"`
+	CreatedAt time.Time `gorm:"index"`
+	Username  string    `gorm:"index;not null"` // as entered, lowercased
+	UserID    *uint     `gorm:"index"`          // nil for unknown usernames
+	IP        string    `gorm:"index;not null"`
+	UserAgent string
+	Result    string `gorm:"not null"`
+}
+
+// LoginThrottle counts consecutive failed logins of a username or an IP
+// address; Key is "user:<username>" or "ip:<address>"
+type LoginThrottle struct {
+	Key           string `gorm:"primaryKey"`
+	Failures      int    `gorm:"not null;default:0"`
+	LastFailureAt time.Time
+	LockedUntil   *time.Time
+}
@@ -0,0 +1,26 @@
+package models
+
+// Named is a record books link to by its name alone: an author, a publisher
+// or a subject. The repository and handlers serve all three through it.
+type Named interface {
+	GetID() uint
+	GetName() string
+	SetName(name string)
+}
+
+func (a *Author) GetID() uint            { return a.ID }
+func (a *Author) GetName() string        { return a.Name }
+func (a *Author) SetName(name string)    { a.Name = name }
+func (p *Publisher) GetID() uint         { return p.ID }
+func (p *Publisher) GetName() string     { return p.Name }
+func (p *Publisher) SetName(name string) { p.Name = name }
+func (s *Subject) GetID() uint           { return s.ID }
+func (s *Subject) GetName() string       { return s.Name }
+func (s *Subject) SetName(name string)   { s.Name = name }
+
+// NamedPointer is the type parameter constraint of generic code working on
+// named records of type T
+type NamedPointer[T any] interface {
+	*T
+	Named
+}
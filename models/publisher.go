@@ -0,0 +1,9 @@
+package models
+
+import "gorm.io/gorm"
+
+type Publisher struct {
+	gorm.Model
+	Name  string `gorm:"not null;index"`
+	Books []Book `gorm:"many2many:book_publishers"`
+}
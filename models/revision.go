@@ -0,0 +1,63 @@
+package models
+
+import "time"
+
+// BookVersion is the editable state of a book, as kept by its revisions
+type BookVersion struct {
+	Title        string  `json:"title"`
+	Description  string  `json:"description"`
+	ISBN         *string `json:"isbn"`
+	AuthorIDs    []uint  `json:"author_ids"`
+	PublisherIDs []uint  `json:"publisher_ids"`
+	SubjectIDs   []uint  `json:"subject_ids"`
+}
+
+func NewBookVersion(book *Book) BookVersion {
+	v := BookVersion{
+		Title:        book.Title,
+		Description:  book.Description,
+		ISBN:         book.ISBN,
+		AuthorIDs:    []uint{},
+		PublisherIDs: []uint{},
+		SubjectIDs:   []uint{},
+	}
+	for _, author := range book.Authors {
+		v.AuthorIDs = append(v.AuthorIDs, author.ID)
+	}
+	for _, publisher := range book.Publishers {
+		v.PublisherIDs = append(v.PublisherIDs, publisher.ID)
+	}
+	for _, subject := range book.Subjects {
+		v.SubjectIDs = append(v.SubjectIDs, subject.ID)
+	}
+	return v
+}
+
+// ReaderVersion is the editable state of a reader, as kept by its revisions
+type ReaderVersion struct {
+	Name    string `json:"name"`
+	Surname string `json:"surname"`
+}
+
+func NewReaderVersion(reader *Reader) ReaderVersion {
+	return ReaderVersion{Name: reader.Name, Surname: reader.Surname}
+}
+
+// BookRevision is a saved version of a book. Revisions are numbered from 1 per
+// book; the first one is the state before the first update.
+type BookRevision struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	BookID    uint   `gorm:"uniqueIndex:idx_book_revisions_number;not null"`
+	Number    int    `gorm:"uniqueIndex:idx_book_revisions_number;not null"`
+	Data      string `gorm:"not null"` // JSON of a BookVersion
+}
+
+// ReaderRevision is a saved version of a reader, numbered like BookRevision
+type ReaderRevision struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	ReaderID  uint   `gorm:"uniqueIndex:idx_reader_revisions_number;not null"`
+	Number    int    `gorm:"uniqueIndex:idx_reader_revisions_number;not null"`
+	Data      string `gorm:"not null"` // JSON of a ReaderVersion
+}
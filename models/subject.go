@@ -0,0 +1,10 @@
+package models
+
+import "gorm.io/gorm"
+
+// Subject is a subject heading books are catalogued under
+type Subject struct {
+	gorm.Model
+	Name  string `gorm:"not null;index"`
+	Books []Book `gorm:"many2many:book_subjects"`
+}
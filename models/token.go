@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// RefreshToken is one refresh token of a login session. Every refresh replaces
+// it with a new token of the same family; a replaced token that is presented
+// again must have been copied, so the whole family is revoked.
+type RefreshToken struct {
+	ID              uint `gorm:"primarykey"`
+	CreatedAt       time.Time
+	UserID          uint       `gorm:"not null;index"`
+	User            User       `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+	TokenHash       string     `gorm:"uniqueIndex;not null"` // SHA-256 of the token, the token itself is never stored
+	FamilyID        string     `gorm:"not null;index"`       // shared by all tokens descending from one login
+	AccessJTI       string     `gorm:"index"`                // ID of the access token issued together with this one
+	AccessExpiresAt time.Time  // revoking the access token is only needed until then
+	ExpiresAt       time.Time  `gorm:"not null"`
+	RotatedAt       *time.Time // set when the token was exchanged for a new one
+	RevokedAt       *time.Time
+}
+
+// RevokedToken is the ID (jti) of an access token that is rejected although it
+// has not expired yet. Rows can be dropped once ExpiresAt has passed.
+type RevokedToken struct {
+	JTI       string    `gorm:"primaryKey"`
+	ExpiresAt time.Time `gorm:"not null;index"`
+}
@@ -1,6 +1,8 @@
 package models
 
 import (
+	"time"
+
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
@@ -10,7 +12,26 @@ type User struct {
 	Username string `gorm:"uniqueIndex;not null"`
 	Email    string `gorm:"uniqueIndex;not null"`
 	Password string `gorm:"not null"`
-	Role     string `gorm:"default:'user'"` // 'user' or 'admin'
+	Role     string `gorm:"default:'member'"` // one of the roles in the roles config
+	// EmailVerifiedAt is set once the user followed the link of the verification mail
+	EmailVerifiedAt *time.Time
+	// DisabledAt is set while an administrator has disabled the account
+	DisabledAt *time.Time
+	// PasswordResetRequired blocks logging in until the password was reset by mail
+	PasswordResetRequired bool `gorm:"not null;default:false"`
+	// TOTPSecret is the base32 secret shared with the authenticator app. Setup
+	// stores it; it only protects the login once TOTPEnabledAt is set.
+	TOTPSecret      string
+	TOTPEnabledAt   *time.Time
+	TOTPLastCounter int64 `gorm:"not null;default:0"` // time step of the last accepted code, each code works once
+	// OIDCIssuer and OIDCSubject identify the account at the identity provider
+	// once the user has signed in with single sign-on
+	OIDCIssuer  *string `gorm:"column:oidc_issuer;uniqueIndex:idx_users_oidc"`
+	OIDCSubject *string `gorm:"column:oidc_subject;uniqueIndex:idx_users_oidc"`
+}
+
+func (u *User) IsDisabled() bool {
+	return u.DisabledAt != nil
 }
 
 // HashPassword hashes the user's password
@@ -27,3 +48,7 @@ func (u *User) HashPassword(password string) error {
 func (u *User) CheckPassword(password string) error {
 	return bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password))
 }
+
+func (u *User) HasTwoFactor() bool {
+	return u.TOTPEnabledAt != nil
+}
@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+const (
+	UserTokenPasswordReset     = "password_reset"
+	UserTokenEmailVerification = "email_verification"
+	UserTokenTwoFactorLogin    = "two_factor_login" // password checked, second factor pending
+	UserTokenOIDCLogin         = "oidc_login"       // signed in at the identity provider, tokens not issued yet
+)
+
+// UserToken is a single-use token mailed to a user, to reset the password or
+// to confirm the email address, or the challenge token of a two-step login
+type UserToken struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	UserID    uint      `gorm:"not null;index"`
+	User      User      `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+	Purpose   string    `gorm:"not null"`
+	TokenHash string    `gorm:"uniqueIndex;not null"` // SHA-256 of the token, the token itself is only sent to the user
+	ExpiresAt time.Time `gorm:"not null"`
+	UsedAt    *time.Time
+}
+
+// RecoveryCode is a single-use code that replaces the authenticator app when
+// logging in with two-factor authentication
+type RecoveryCode struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	UserID    uint   `gorm:"not null;index"`
+	User      User   `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+	CodeHash  string `gorm:"not null;index"` // SHA-256 of the normalized code
+	UsedAt    *time.Time
+}
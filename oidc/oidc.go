@@ -0,0 +1,351 @@
+// Package oidc is the relying party side of OpenID Connect: the authorization
+// code flow with PKCE against a provider found by discovery, and validation of
+// the ID tokens it returns.
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"lab1/jwtkeys"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	clockSkew        = time.Minute
+	jwksRefreshDelay = time.Minute // unknown key IDs refetch the key set at most this often
+	maxResponseSize  = 1 << 20
+)
+
+// signingMethods are the ID token algorithms accepted; "none" and HMAC are not
+var signingMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string // empty for a public client
+	RedirectURL  string
+	Scopes       []string
+}
+
+// Metadata is the part of the discovery document the flow needs
+type Metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider talks to one identity provider. Discovery and the key set are
+// fetched on first use and cached, so the provider may be down at startup.
+type Provider struct {
+	config Config
+	client *http.Client
+
+	mu            sync.Mutex
+	metadata      *Metadata
+	keys          map[string]jwtkeys.JWK
+	keysFetchedAt time.Time
+}
+
+func NewProvider(config Config) *Provider {
+	return &Provider{
+		config: config,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Claims are the ID token claims used for signing in
+type Claims struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUsername string
+	Name              string
+	raw               jwt.MapClaims
+}
+
+// Strings returns a claim as a list, accepting a single string or an array of
+// strings, as providers send group or role claims either way
+func (c *Claims) Strings(name string) []string {
+	switch value := c.raw[name].(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// String returns a string claim, or "" if it is missing or not a string
+func (c *Claims) String(name string) string {
+	s, _ := c.raw[name].(string)
+	return s
+}
+
+// NewVerifier returns a PKCE code verifier (RFC 7636)
+func NewVerifier() (string, error) {
+	return randomString(32)
+}
+
+// challenge is the S256 code challenge of a verifier
+func challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// NewState returns a random value for the state or nonce parameter
+func NewState() (string, error) {
+	return randomString(24)
+}
+
+// AuthCodeURL returns the provider's login page for a new flow
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	values := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(p.config.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {challenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(metadata.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return metadata.AuthorizationEndpoint + separator + values.Encode(), nil
+}
+
+// Exchange redeems the authorization code at the token endpoint and returns
+// the validated claims of the ID token
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (*Claims, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"code_verifier": {verifier},
+	}
+	if p.config.ClientSecret == "" {
+		form.Set("client_id", p.config.ClientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		// RFC 6749 section 2.3.1 form-encodes the credentials before basic auth
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	var response struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	status, err := p.doJSON(req, &response)
+	if err != nil {
+		return nil, fmt.Errorf("token request: %w", err)
+	}
+	if status != http.StatusOK || response.Error != "" {
+		return nil, fmt.Errorf("token request failed with status %d: %s %s", status, response.Error, response.ErrorDescription)
+	}
+	if response.IDToken == "" {
+		return nil, errors.New("token response without id_token")
+	}
+
+	return p.verify(ctx, metadata, response.IDToken, nonce)
+}
+
+// verify checks the signature, issuer, audience, lifetime and nonce of an ID
+// token (OpenID Connect Core section 3.1.3.7)
+func (p *Provider) verify(ctx context.Context, metadata *Metadata, rawToken, nonce string) (*Claims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(rawToken, claims,
+		func(token *jwt.Token) (interface{}, error) { return p.publicKey(ctx, metadata, token) },
+		jwt.WithValidMethods(signingMethods),
+		jwt.WithIssuer(metadata.Issuer),
+		jwt.WithAudience(p.config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(clockSkew),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+
+	audience, _ := claims.GetAudience()
+	if azp, ok := claims["azp"].(string); (len(audience) > 1 || ok) && azp != p.config.ClientID {
+		return nil, errors.New("invalid ID token: authorized party is not this client")
+	}
+	if tokenNonce, _ := claims["nonce"].(string); tokenNonce == "" || tokenNonce != nonce {
+		return nil, errors.New("invalid ID token: nonce does not match")
+	}
+
+	result := &Claims{raw: claims}
+	result.Subject, _ = claims.GetSubject()
+	if result.Subject == "" {
+		return nil, errors.New("invalid ID token: no subject")
+	}
+	result.Email = result.String("email")
+	result.PreferredUsername = result.String("preferred_username")
+	result.Name = result.String("name")
+	// Some providers send the boolean as a string
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		result.EmailVerified = verified
+	case string:
+		result.EmailVerified = verified == "true"
+	}
+	return result, nil
+}
+
+// publicKey finds the provider key that signed the token, refetching the key
+// set once if the provider has rotated to a key not seen yet
+func (p *Provider) publicKey(ctx context.Context, metadata *Metadata, token *jwt.Token) (crypto.PublicKey, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	jwk, found := p.findKey(kid)
+	if !found && time.Since(p.keysFetchedAt) >= jwksRefreshDelay {
+		if err := p.fetchKeys(ctx, metadata); err != nil {
+			return nil, err
+		}
+		jwk, found = p.findKey(kid)
+	}
+	if !found {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if jwk.Algorithm != "" && jwk.Algorithm != token.Method.Alg() {
+		return nil, fmt.Errorf("key %q does not sign with %s", kid, token.Method.Alg())
+	}
+	return jwk.PublicKey()
+}
+
+// findKey looks up a key by ID; tokens without kid are accepted only while
+// the provider has a single key
+func (p *Provider) findKey(kid string) (jwtkeys.JWK, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, jwk := range p.keys {
+			return jwk, true
+		}
+	}
+	jwk, ok := p.keys[kid]
+	return jwk, ok
+}
+
+func (p *Provider) fetchKeys(ctx context.Context, metadata *Metadata) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, metadata.JWKSURI, nil)
+	if err != nil {
+		return err
+	}
+	var set jwtkeys.JWKSet
+	status, err := p.doJSON(req, &set)
+	if err == nil && status != http.StatusOK {
+		err = fmt.Errorf("status %d", status)
+	}
+	if err != nil {
+		return fmt.Errorf("fetching provider keys: %w", err)
+	}
+
+	p.keys = make(map[string]jwtkeys.JWK, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use == "" || jwk.Use == "sig" {
+			p.keys[jwk.KeyID] = jwk
+		}
+	}
+	p.keysFetchedAt = time.Now()
+	return nil
+}
+
+// discover loads the provider metadata once. A failed attempt is retried on
+// the next login.
+func (p *Provider) discover(ctx context.Context) (*Metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	issuer := strings.TrimRight(p.config.Issuer, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	var metadata Metadata
+	status, err := p.doJSON(req, &metadata)
+	if err == nil && status != http.StatusOK {
+		err = fmt.Errorf("status %d", status)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("discovery: %w", err)
+	}
+
+	// The document must be about the configured issuer (OpenID Connect Discovery section 4.3)
+	if strings.TrimRight(metadata.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("discovery: issuer %q does not match %q", metadata.Issuer, p.config.Issuer)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, errors.New("discovery: document lacks authorization_endpoint, token_endpoint or jwks_uri")
+	}
+	p.metadata = &metadata
+	return p.metadata, nil
+}
+
+func (p *Provider) doJSON(req *http.Request, v interface{}) (int, error) {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if err := json.Unmarshal(body, v); err != nil && resp.StatusCode == http.StatusOK {
+		return resp.StatusCode, err
+	}
+	return resp.StatusCode, nil
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,140 @@
+// Package rbac maps roles to the permissions they grant. Roles are defined in
+// the configuration; the permissions are fixed by the routes that check them.
+package rbac
+
+import (
+	"fmt"
+	"sort"
+)
+
+type Permission string
+
+const (
+	BooksRead      Permission = "books:read"
+	BooksWrite     Permission = "books:write"     // create books and change the own ones and their copies
+	BooksWriteAny  Permission = "books:write_any" // change books and copies of other users
+	BooksImport    Permission = "books:import"
+	BooksDeleteAll Permission = "books:delete_all"
+
+	CatalogRead  Permission = "catalog:read" // authors, publishers and subjects
+	CatalogWrite Permission = "catalog:write"
+
+	ReadersRead      Permission = "readers:read"
+	ReadersWrite     Permission = "readers:write"
+	ReadersDeleteAll Permission = "readers:delete_all"
+
+	LoansRead     Permission = "loans:read"
+	LoansCheckout Permission = "loans:checkout"
+	LoansReturn   Permission = "loans:return"
+
+	HoldsRead  Permission = "holds:read"
+	HoldsWrite Permission = "holds:write"
+
+	AccountsRead    Permission = "accounts:read"
+	AccountsPayment Permission = "accounts:payment"
+	AccountsWaive   Permission = "accounts:waive"
+
+	UsersManage Permission = "users:manage" // list users and assign roles
+)
+
+// Wildcard grants every permission
+const Wildcard = "*"
+
+// All lists every permission, in the order they are documented
+var All = []Permission{
+	BooksRead, BooksWrite, BooksWriteAny, BooksImport, BooksDeleteAll,
+	CatalogRead, CatalogWrite,
+	ReadersRead, ReadersWrite, ReadersDeleteAll,
+	LoansRead, LoansCheckout, LoansReturn,
+	HoldsRead, HoldsWrite,
+	AccountsRead, AccountsPayment, AccountsWaive,
+	UsersManage,
+}
+
+// DefaultRoles is the permission matrix used when the configuration does not
+// define a role
+func DefaultRoles() map[string][]string {
+	guest := []string{string(BooksRead), string(CatalogRead)}
+	member := append(guest[:len(guest):len(guest)],
+		string(BooksWrite), string(CatalogWrite),
+		string(ReadersRead), string(ReadersWrite),
+		string(LoansRead), string(HoldsRead), string(HoldsWrite), string(AccountsRead))
+	librarian := append(member[:len(member):len(member)],
+		string(BooksWriteAny), string(BooksImport),
+		string(LoansCheckout), string(LoansReturn),
+		string(AccountsPayment), string(AccountsWaive))
+
+	return map[string][]string{
+		"admin":     {Wildcard},
+		"librarian": librarian,
+		"member":    member,
+		"guest":     guest,
+	}
+}
+
+// Policy answers which permissions a role has
+type Policy struct {
+	roles map[string]map[Permission]bool
+}
+
+// NewPolicy builds a policy from role names mapped to permission names. Unknown
+// permissions are an error, so that a typo cannot silently deny or grant access.
+func NewPolicy(roles map[string][]string) (*Policy, error) {
+	known := make(map[Permission]bool, len(All))
+	for _, perm := range All {
+		known[perm] = true
+	}
+
+	p := &Policy{roles: make(map[string]map[Permission]bool, len(roles))}
+	for role, perms := range roles {
+		if role == "" {
+			return nil, fmt.Errorf("role without a name")
+		}
+		granted := make(map[Permission]bool, len(perms))
+		for _, name := range perms {
+			if name == Wildcard {
+				for _, perm := range All {
+					granted[perm] = true
+				}
+				continue
+			}
+			if !known[Permission(name)] {
+				return nil, fmt.Errorf("role %s: unknown permission %q", role, name)
+			}
+			granted[Permission(name)] = true
+		}
+		p.roles[role] = granted
+	}
+	return p, nil
+}
+
+// Has reports whether the role grants the permission; unknown roles grant nothing
+func (p *Policy) Has(role string, perm Permission) bool {
+	return p.roles[role][perm]
+}
+
+func (p *Policy) IsRole(role string) bool {
+	_, ok := p.roles[role]
+	return ok
+}
+
+// Roles returns the role names, sorted
+func (p *Policy) Roles() []string {
+	roles := make([]string, 0, len(p.roles))
+	for role := range p.roles {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+// Permissions returns what the role grants, in the order of All
+func (p *Policy) Permissions(role string) []Permission {
+	perms := []Permission{}
+	for _, perm := range All {
+		if p.roles[role][perm] {
+			perms = append(perms, perm)
+		}
+	}
+	return perms
+}
@@ -0,0 +1,86 @@
+package repository
+
+import (
+	"context"
+	"lab1/models"
+	"log"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type AccountRepository interface {
+	Create(ctx context.Context, transaction *models.AccountTransaction) error
+	FindByReader(ctx context.Context, readerID uint) ([]models.AccountTransaction, error)
+	Balance(ctx context.Context, readerID uint) (int64, error)
+	Lock(ctx context.Context, readerID uint) error
+	WithTx(tx *gorm.DB) AccountRepository
+}
+
+type accountRepository struct {
+	db *gorm.DB
+}
+
+func NewAccountRepository(db *gorm.DB) AccountRepository {
+	return &accountRepository{db: db}
+}
+
+// WithTx returns the repository working in the transaction tx
+func (r *accountRepository) WithTx(tx *gorm.DB) AccountRepository {
+	return &accountRepository{db: tx}
+}
+
+func (r *accountRepository) Create(ctx context.Context, transaction *models.AccountTransaction) error {
+	log.Printf("AccountRepository.Create: recording %s of %d cents for reader ID=%d", transaction.Type, transaction.AmountCents, transaction.ReaderID)
+	if err := r.db.WithContext(ctx).Create(transaction).Error; err != nil {
+		log.Printf("AccountRepository.Create: error recording transaction: %v", err)
+		return err
+	}
+	log.Printf("AccountRepository.Create: transaction created successfully with ID=%d", transaction.ID)
+	return nil
+}
+
+// FindByReader returns the ledger of a reader, newest entries first
+func (r *accountRepository) FindByReader(ctx context.Context, readerID uint) ([]models.AccountTransaction, error) {
+	var transactions []models.AccountTransaction
+	err := r.db.WithContext(ctx).Preload("CreatedBy").
+		Where("reader_id = ?", readerID).
+		Order("created_at DESC, id DESC").
+		Find(&transactions).Error
+	if err != nil {
+		log.Printf("AccountRepository.FindByReader: error fetching ledger of reader ID=%d: %v", readerID, err)
+	}
+	return transactions, err
+}
+
+// Balance returns what the reader owes in cents: charges minus payments and waivers
+func (r *accountRepository) Balance(ctx context.Context, readerID uint) (int64, error) {
+	var balance int64
+	err := r.db.WithContext(ctx).Model(&models.AccountTransaction{}).
+		Select("COALESCE(SUM(CASE WHEN type = ? THEN amount_cents ELSE -amount_cents END), 0)", models.TransactionCharge).
+		Where("reader_id = ?", readerID).
+		Scan(&balance).Error
+	if err != nil {
+		log.Printf("AccountRepository.Balance: error computing balance of reader ID=%d: %v", readerID, err)
+	}
+	return balance, err
+}
+
+// Lock holds the account of a reader until the transaction ends, so that a
+// concurrent transaction checking the balance waits for this one. It only
+// works on a repository bound to a transaction.
+func (r *accountRepository) Lock(ctx context.Context, readerID uint) error {
+	db := r.db.WithContext(ctx)
+	var err error
+	if db.Dialector.Name() == "postgres" {
+		var readers []models.Reader
+		err = db.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").Where("id = ?", readerID).Find(&readers).Error
+	} else {
+		// SQLite has no row locks; the first write takes the database lock
+		err = db.Exec("UPDATE readers SET id = id WHERE id = ?", readerID).Error
+	}
+	if err != nil {
+		log.Printf("AccountRepository.Lock: error locking account of reader ID=%d: %v", readerID, err)
+	}
+	return err
+}
@@ -0,0 +1,79 @@
+package repository
+
+import (
+	"context"
+	"lab1/models"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// apiKeyTouchInterval limits how often LastUsedAt is written for a busy key
+const apiKeyTouchInterval = time.Minute
+
+type APIKeyRepository interface {
+	Create(ctx context.Context, key *models.APIKey) error
+	FindByUser(ctx context.Context, userID uint) ([]models.APIKey, error)
+	FindByHash(ctx context.Context, keyHash string) (*models.APIKey, error)
+	Touch(ctx context.Context, id uint, now time.Time) error
+	Delete(ctx context.Context, userID, id uint) error
+}
+
+type apiKeyRepository struct {
+	db *gorm.DB
+}
+
+func NewAPIKeyRepository(db *gorm.DB) APIKeyRepository {
+	return &apiKeyRepository{db: db}
+}
+
+func (r *apiKeyRepository) Create(ctx context.Context, key *models.APIKey) error {
+	log.Printf("APIKeyRepository.Create: creating API key '%s' for user ID=%d", key.Name, key.UserID)
+	if err := r.db.WithContext(ctx).Create(key).Error; err != nil {
+		log.Printf("APIKeyRepository.Create: error creating API key: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (r *apiKeyRepository) FindByUser(ctx context.Context, userID uint) ([]models.APIKey, error) {
+	var keys []models.APIKey
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).Order("id ASC").Find(&keys).Error
+	if err != nil {
+		log.Printf("APIKeyRepository.FindByUser: error fetching API keys of user ID=%d: %v", userID, err)
+	}
+	return keys, err
+}
+
+func (r *apiKeyRepository) FindByHash(ctx context.Context, keyHash string) (*models.APIKey, error) {
+	var key models.APIKey
+	if err := r.db.WithContext(ctx).Where("key_hash = ?", keyHash).First(&key).Error; err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// Touch records that the key was used, at most once per apiKeyTouchInterval
+func (r *apiKeyRepository) Touch(ctx context.Context, id uint, now time.Time) error {
+	err := r.db.WithContext(ctx).Model(&models.APIKey{}).
+		Where("id = ? AND (last_used_at IS NULL OR last_used_at < ?)", id, now.Add(-apiKeyTouchInterval)).
+		Update("last_used_at", now).Error
+	if err != nil {
+		log.Printf("APIKeyRepository.Touch: error updating API key ID=%d: %v", id, err)
+	}
+	return err
+}
+
+// Delete removes a key of the user; keys of other users give gorm.ErrRecordNotFound
+func (r *apiKeyRepository) Delete(ctx context.Context, userID, id uint) error {
+	result := r.db.WithContext(ctx).Where("user_id = ?", userID).Delete(&models.APIKey{}, id)
+	if result.Error != nil {
+		log.Printf("APIKeyRepository.Delete: error deleting API key ID=%d: %v", id, result.Error)
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
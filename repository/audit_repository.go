@@ -0,0 +1,118 @@
+package repository
+
+import (
+	"context"
+	"lab1/models"
+	"log"
+
+	"gorm.io/gorm"
+)
+
+// AuditEventSortFields are the columns GET /admin/audit can be sorted by
+var AuditEventSortFields = []string{"id", "created_at", "actor_id", "action", "entity_type"}
+
+// AuditEventPage is one page of the audit log together with the total number of matches
+type AuditEventPage struct {
+	Events []models.AuditEvent
+	Total  int64
+}
+
+// AuditRepository appends to the audit log and reads it; there is
+// deliberately no way to change or remove events
+type AuditRepository interface {
+	Record(ctx context.Context, events ...*models.AuditEvent) error
+	FindPage(ctx context.Context, query ListQuery) (*AuditEventPage, error)
+}
+
+const auditBatchSize = 500
+
+type auditRepository struct {
+	db *gorm.DB
+}
+
+func NewAuditRepository(db *gorm.DB) AuditRepository {
+	setupAuditLog(db)
+	return &auditRepository{db: db}
+}
+
+// setupAuditLog adds triggers that reject changes to recorded events, so that
+// the log stays append-only also for SQL that bypasses the model hooks
+func setupAuditLog(db *gorm.DB) {
+	message := models.ErrAuditEventImmutable.Error()
+	var statements []string
+	switch db.Dialector.Name() {
+	case "sqlite":
+		for _, operation := range []string{"UPDATE", "DELETE"} {
+			statements = append(statements, "CREATE TRIGGER IF NOT EXISTS audit_events_no_"+operation+" BEFORE "+operation+" ON audit_events "+
+				"BEGIN SELECT RAISE(ABORT, '"+message+"'); END")
+		}
+	case "postgres":
+		statements = append(statements, "CREATE OR REPLACE FUNCTION audit_events_immutable() RETURNS trigger LANGUAGE plpgsql AS "+
+			"$$ BEGIN RAISE EXCEPTION '"+message+"'; END $$")
+		for _, operation := range []string{"UPDATE", "DELETE"} {
+			statements = append(statements, "DROP TRIGGER IF EXISTS audit_events_no_"+operation+" ON audit_events",
+				"CREATE TRIGGER audit_events_no_"+operation+" BEFORE "+operation+" ON audit_events "+
+					"FOR EACH ROW EXECUTE FUNCTION audit_events_immutable()")
+		}
+	}
+	// One transaction, so that a second instance starting at the same time
+	// does not see the triggers half replaced
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, statement := range statements {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("AuditRepository: error creating triggers: %v", err)
+	}
+}
+
+// Record appends the events, in batches for the many events of an import
+func (r *auditRepository) Record(ctx context.Context, events ...*models.AuditEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	if err := r.db.WithContext(ctx).CreateInBatches(events, auditBatchSize).Error; err != nil {
+		log.Printf("AuditRepository.Record: error recording %s of %s: %v", events[0].Action, events[0].EntityType, err)
+		return err
+	}
+	return nil
+}
+
+// FindPage lists events; ActorID, Action, EntityType and EntityID select
+// events, CreatedAfter and CreatedBefore the time range
+func (r *auditRepository) FindPage(ctx context.Context, query ListQuery) (*AuditEventPage, error) {
+	db := r.db.WithContext(ctx).Model(&models.AuditEvent{})
+	if query.ActorID != 0 {
+		db = db.Where("audit_events.actor_id = ?", query.ActorID)
+	}
+	if query.Action != "" {
+		db = db.Where("audit_events.action = ?", query.Action)
+	}
+	if query.EntityType != "" {
+		db = db.Where("audit_events.entity_type = ?", query.EntityType)
+	}
+	if query.EntityID != 0 {
+		db = db.Where("audit_events.entity_id = ?", query.EntityID)
+	}
+	if query.CreatedAfter != nil {
+		db = db.Where("audit_events.created_at > ?", *query.CreatedAfter)
+	}
+	if query.CreatedBefore != nil {
+		db = db.Where("audit_events.created_at < ?", *query.CreatedBefore)
+	}
+
+	page := &AuditEventPage{}
+	if err := db.Count(&page.Total).Error; err != nil {
+		log.Printf("AuditRepository.FindPage: error counting audit events: %v", err)
+		return nil, err
+	}
+	if err := paginate(db, "audit_events", AuditEventSortFields, query).Find(&page.Events).Error; err != nil {
+		log.Printf("AuditRepository.FindPage: error fetching audit events: %v", err)
+		return nil, err
+	}
+	return page, nil
+}
@@ -0,0 +1,106 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"lab1/cache"
+	"lab1/models"
+	"log"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Import creates all books in a single transaction: either every book is stored
+// or none is. Authors, publishers and subjects without an ID are matched by name,
+// case-insensitively, and created when no record has that name yet.
+func (r *bookRepository) Import(ctx context.Context, books []models.Book) error {
+	log.Printf("BookRepository.Import: importing %d books", len(books))
+	if len(books) == 0 {
+		return nil
+	}
+
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		authors := map[string]models.Author{}
+		publishers := map[string]models.Publisher{}
+		subjects := map[string]models.Subject{}
+
+		ids := make([]uint, 0, len(books))
+		for i := range books {
+			book := &books[i]
+			for j := range book.Authors {
+				if err := findOrCreateByName(tx, authors, &book.Authors[j], book.Authors[j].Name); err != nil {
+					return err
+				}
+			}
+			for j := range book.Publishers {
+				if err := findOrCreateByName(tx, publishers, &book.Publishers[j], book.Publishers[j].Name); err != nil {
+					return err
+				}
+			}
+			for j := range book.Subjects {
+				if err := findOrCreateByName(tx, subjects, &book.Subjects[j], book.Subjects[j].Name); err != nil {
+					return err
+				}
+			}
+
+			if err := tx.Create(book).Error; err != nil {
+				return err
+			}
+			ids = append(ids, book.ID)
+		}
+
+		if !r.fullText {
+			return nil
+		}
+		return reindexBooks(tx, "books.id IN ?", ids)
+	})
+	if err != nil {
+		log.Printf("BookRepository.Import: error importing books: %v", err)
+		return err
+	}
+
+	log.Printf("BookRepository.Import: %d books imported successfully", len(books))
+	r.cache.InvalidatePattern(cache.BookListKey())
+	for _, table := range []string{"authors", "publishers", "subjects"} {
+		r.cache.InvalidatePattern(cache.NameListKey(table))
+	}
+	return nil
+}
+
+// findOrCreateByName fills record with the stored row of that name, creating it
+// if needed. seen remembers the rows already resolved in this transaction.
+func findOrCreateByName[T models.Author | models.Publisher | models.Subject](tx *gorm.DB, seen map[string]T, record *T, name string) error {
+	key := strings.ToLower(strings.TrimSpace(name))
+	if stored, ok := seen[key]; ok {
+		*record = stored
+		return nil
+	}
+
+	err := tx.Where("LOWER(name) = ?", key).Order("id").First(record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = tx.Create(record).Error
+	}
+	if err != nil {
+		return err
+	}
+	seen[key] = *record
+	return nil
+}
+
+// ForEachBatch calls fn with all books, in ID order and batches of size, so that
+// the whole catalogue can be streamed without loading it at once. It stops at
+// the first error returned by fn.
+func (r *bookRepository) ForEachBatch(ctx context.Context, size int, fn func(books []models.Book) error) error {
+	log.Printf("BookRepository.ForEachBatch: reading books in batches of %d", size)
+	var books []models.Book
+	result := withBookRelations(r.db.WithContext(ctx)).Order("books.id").FindInBatches(&books, size, func(tx *gorm.DB, batch int) error {
+		return fn(books)
+	})
+	if result.Error != nil {
+		log.Printf("BookRepository.ForEachBatch: error reading books: %v", result.Error)
+		return result.Error
+	}
+	log.Printf("BookRepository.ForEachBatch: read %d books", result.RowsAffected)
+	return nil
+}
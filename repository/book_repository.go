@@ -1,44 +1,98 @@
 package repository
 
 import (
+	"context"
 	"lab1/cache"
 	"lab1/models"
+	"lab1/validation"
 	"log"
+	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// BookSortFields are the columns GET /books can be sorted by
+var BookSortFields = []string{"id", "title", "created_at", "updated_at"}
+
+// BookPage is one page of a book listing together with the total number of matches
+type BookPage struct {
+	Books []models.Book
+	Total int64
+}
+
 type BookRepository interface {
-	Create(book *models.Book) error
-	FindAll() ([]models.Book, error)
-	FindByID(id uint) (*models.Book, error)
-	Update(book *models.Book) error
-	Delete(id uint) error
-	DeleteAll() error
+	Create(ctx context.Context, book *models.Book) error
+	FindAll(ctx context.Context) ([]models.Book, error)
+	FindPage(ctx context.Context, query ListQuery) (*BookPage, error)
+	Search(ctx context.Context, query ListQuery) (*BookSearchPage, error)
+	FindByID(ctx context.Context, id uint) (*models.Book, error)
+	FindByISBN(ctx context.Context, isbn string) (*models.Book, error)
+	ISBNTaken(ctx context.Context, isbn string, exceptID uint) (bool, error)
+	Update(ctx context.Context, book *models.Book) error
+	FindRevisions(ctx context.Context, bookID uint, query ListQuery) (*RevisionPage, error)
+	FindRevision(ctx context.Context, bookID uint, number int) (*Revision, error)
+	Delete(ctx context.Context, id uint) error
+	DeleteAll(ctx context.Context) error
+	FindDeletedPage(ctx context.Context, query ListQuery) (*BookPage, error)
+	FindDeleted(ctx context.Context, id uint) (*models.Book, error)
+	Restore(ctx context.Context, id uint) error
+	Purge(ctx context.Context, id uint) error
+	PurgeDeletedBefore(ctx context.Context, before time.Time) (int64, error)
+	Import(ctx context.Context, books []models.Book) error
+	ForEachBatch(ctx context.Context, size int, fn func(books []models.Book) error) error
+	FullText() bool
+	WithTx(tx *gorm.DB, store cache.Store) BookRepository
+}
+
+// withBookRelations preloads everything a book response shows
+func withBookRelations(db *gorm.DB) *gorm.DB {
+	return db.Preload("User").Preload("Authors").Preload("Publishers").Preload("Subjects")
 }
 
 type bookRepository struct {
-	db    *gorm.DB
-	cache *cache.Cache
+	db       *gorm.DB
+	cache    cache.Store
+	fullText bool // full-text index books_fts is available and kept in sync
+}
+
+// NewBookRepository sets up the full-text index unless fullText is false.
+// Without the index Search falls back to substring matching.
+func NewBookRepository(db *gorm.DB, cache *cache.Cache, fullText bool) BookRepository {
+	if !fullText {
+		dropBookSearch(db)
+	}
+	return &bookRepository{db: db, cache: cache, fullText: fullText && setupBookSearch(db)}
+}
+
+// FullText reports whether Search uses the full-text index
+func (r *bookRepository) FullText() bool {
+	return r.fullText
 }
 
-func NewBookRepository(db *gorm.DB, cache *cache.Cache) BookRepository {
-	return &bookRepository{db: db, cache: cache}
+// WithTx returns the repository working in the transaction tx, with the cache seen through store
+func (r *bookRepository) WithTx(tx *gorm.DB, store cache.Store) BookRepository {
+	return &bookRepository{db: tx, cache: store, fullText: r.fullText}
 }
 
-func (r *bookRepository) Create(book *models.Book) error {
+func (r *bookRepository) Create(ctx context.Context, book *models.Book) error {
 	log.Printf("BookRepository.Create: creating book with title='%s'", book.Title)
-	err := r.db.Create(book).Error
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(book).Error; err != nil {
+			return err
+		}
+		return r.indexBook(tx, book)
+	})
 	if err != nil {
 		log.Printf("BookRepository.Create: error creating book: %v", err)
 		return err
 	}
 	log.Printf("BookRepository.Create: book created successfully with ID=%d", book.ID)
-	r.cache.Invalidate(cache.BookListKey())
+	r.cache.InvalidatePattern(cache.BookListKey())
 	return nil
 }
 
-func (r *bookRepository) FindAll() ([]models.Book, error) {
+func (r *bookRepository) FindAll(ctx context.Context) ([]models.Book, error) {
 	log.Printf("BookRepository.FindAll: fetching all books")
 	if cached, found := r.cache.Get(cache.BookListKey()); found {
 		log.Printf("BookRepository.FindAll: returning cached books")
@@ -46,7 +100,7 @@ func (r *bookRepository) FindAll() ([]models.Book, error) {
 	}
 
 	var books []models.Book
-	err := r.db.Preload("User").Find(&books).Error
+	err := withBookRelations(r.db.WithContext(ctx)).Find(&books).Error
 	if err != nil {
 		log.Printf("BookRepository.FindAll: error fetching books: %v", err)
 		return books, err
@@ -57,55 +111,197 @@ func (r *bookRepository) FindAll() ([]models.Book, error) {
 	return books, nil
 }
 
-func (r *bookRepository) FindByID(id uint) (*models.Book, error) {
+func (r *bookRepository) FindPage(ctx context.Context, query ListQuery) (*BookPage, error) {
+	log.Printf("BookRepository.FindPage: fetching books (%s)", query.Key())
+	key := cache.BookQueryKey(query.Key())
+	if cached, found := r.cache.Get(key); found {
+		log.Printf("BookRepository.FindPage: returning cached page")
+		return cached.(*BookPage), nil
+	}
+
+	db := r.db.WithContext(ctx).Model(&models.Book{})
+	if query.Q != "" {
+		pattern := likePattern(query.Q)
+		where := r.db.WithContext(ctx).Where(`LOWER(books.title) LIKE ? ESCAPE '\' OR LOWER(books.description) LIKE ? ESCAPE '\'`, pattern, pattern)
+		// A scanned ISBN finds its book whichever form it is typed in
+		if isbn, err := validation.NormalizeISBN(query.Q); err == nil {
+			where = where.Or("books.isbn = ?", isbn)
+		}
+		db = db.Where(where)
+	}
+	if query.Owner != "" {
+		db = db.Where("books.user_id IN (?)", r.db.WithContext(ctx).Model(&models.User{}).Select("id").Where("username = ?", query.Owner))
+	}
+	if query.CreatedAfter != nil {
+		db = db.Where("books.created_at > ?", *query.CreatedAfter)
+	}
+	db = filterBookLinks(db, query)
+
+	page := &BookPage{}
+	if err := db.Count(&page.Total).Error; err != nil {
+		log.Printf("BookRepository.FindPage: error counting books: %v", err)
+		return nil, err
+	}
+	if err := withBookRelations(paginate(db, "books", BookSortFields, query)).Find(&page.Books).Error; err != nil {
+		log.Printf("BookRepository.FindPage: error fetching books: %v", err)
+		return nil, err
+	}
+
+	log.Printf("BookRepository.FindPage: found %d of %d books from database", len(page.Books), page.Total)
+	r.cache.Set(key, page)
+	return page, nil
+}
+
+func (r *bookRepository) FindByID(ctx context.Context, id uint) (*models.Book, error) {
 	log.Printf("BookRepository.FindByID: fetching book with ID=%d", id)
 	if cached, found := r.cache.Get(cache.BookIDKey(id)); found {
 		log.Printf("BookRepository.FindByID: returning cached book with ID=%d", id)
-		book := cached.(*models.Book)
-		return book, nil
+		// The cache holds a value, so that callers changing their book, even
+		// in a transaction that rolls back, cannot change the cached one
+		book := cached.(models.Book)
+		return &book, nil
 	}
 
 	var book models.Book
-	err := r.db.Preload("User").First(&book, id).Error
+	err := withBookRelations(r.db.WithContext(ctx)).First(&book, id).Error
 	if err != nil {
 		log.Printf("BookRepository.FindByID: error fetching book with ID=%d: %v", id, err)
 		return nil, err
 	}
 
 	log.Printf("BookRepository.FindByID: found book with ID=%d from database", id)
-	r.cache.Set(cache.BookIDKey(id), &book)
+	r.cache.Set(cache.BookIDKey(id), book)
+	return &book, nil
+}
+
+// FindByISBN looks a book up by its normalized ISBN-13
+func (r *bookRepository) FindByISBN(ctx context.Context, isbn string) (*models.Book, error) {
+	log.Printf("BookRepository.FindByISBN: fetching book with ISBN=%s", isbn)
+	if cached, found := r.cache.Get(cache.BookISBNKey(isbn)); found {
+		log.Printf("BookRepository.FindByISBN: returning cached book with ISBN=%s", isbn)
+		book := cached.(models.Book)
+		return &book, nil
+	}
+
+	var book models.Book
+	err := withBookRelations(r.db.WithContext(ctx)).Where("isbn = ?", isbn).First(&book).Error
+	if err != nil {
+		log.Printf("BookRepository.FindByISBN: error fetching book with ISBN=%s: %v", isbn, err)
+		return nil, err
+	}
+
+	log.Printf("BookRepository.FindByISBN: found book with ID=%d from database", book.ID)
+	r.cache.Set(cache.BookISBNKey(isbn), book)
 	return &book, nil
 }
 
-func (r *bookRepository) Update(book *models.Book) error {
+// ISBNTaken reports whether another book already has the ISBN. Deleted books
+// count as well, since the unique index still covers them.
+func (r *bookRepository) ISBNTaken(ctx context.Context, isbn string, exceptID uint) (bool, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Unscoped().Model(&models.Book{}).Where("isbn = ? AND id <> ?", isbn, exceptID).Count(&count).Error
+	return count > 0, err
+}
+
+func (r *bookRepository) Update(ctx context.Context, book *models.Book) error {
 	log.Printf("BookRepository.Update: updating book with ID=%d, title='%s'", book.ID, book.Title)
-	err := r.db.Save(book).Error
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := bookRevisions.baseline(tx, book.ID, func() (interface{}, error) {
+			var stored models.Book
+			err := tx.Preload("Authors").Preload("Publishers").Preload("Subjects").First(&stored, book.ID).Error
+			return models.NewBookVersion(&stored), err
+		})
+		if err != nil {
+			return err
+		}
+		if err := tx.Omit(clause.Associations).Save(book).Error; err != nil {
+			return err
+		}
+		if err := replaceBookLinks(tx, book); err != nil {
+			return err
+		}
+		if err := bookRevisions.record(tx, book.ID, models.NewBookVersion(book)); err != nil {
+			return err
+		}
+		return r.indexBook(tx, book)
+	})
 	if err != nil {
 		log.Printf("BookRepository.Update: error updating book with ID=%d: %v", book.ID, err)
 		return err
 	}
 	log.Printf("BookRepository.Update: book with ID=%d updated successfully", book.ID)
 	r.cache.Invalidate(cache.BookIDKey(book.ID))
-	r.cache.Invalidate(cache.BookListKey())
+	r.cache.InvalidatePattern(cache.BookISBNKey(""))
+	r.cache.InvalidatePattern(cache.BookListKey())
 	return nil
 }
 
-func (r *bookRepository) Delete(id uint) error {
+// FindRevisions lists the saved versions of a book; Update adds one with every change
+func (r *bookRepository) FindRevisions(ctx context.Context, bookID uint, query ListQuery) (*RevisionPage, error) {
+	page, err := bookRevisions.page(r.db.WithContext(ctx), bookID, query)
+	if err != nil {
+		log.Printf("BookRepository.FindRevisions: error fetching revisions of book ID=%d: %v", bookID, err)
+	}
+	return page, err
+}
+
+func (r *bookRepository) FindRevision(ctx context.Context, bookID uint, number int) (*Revision, error) {
+	revision, err := bookRevisions.find(r.db.WithContext(ctx), bookID, number)
+	if err != nil {
+		log.Printf("BookRepository.FindRevision: error fetching revision %d of book ID=%d: %v", number, bookID, err)
+	}
+	return revision, err
+}
+
+// replaceBookLinks makes the stored authors, publishers and subjects match the book's slices
+func replaceBookLinks(tx *gorm.DB, book *models.Book) error {
+	if err := tx.Model(book).Association("Authors").Replace(book.Authors); err != nil {
+		return err
+	}
+	if err := tx.Model(book).Association("Publishers").Replace(book.Publishers); err != nil {
+		return err
+	}
+	return tx.Model(book).Association("Subjects").Replace(book.Subjects)
+}
+
+// filterBookLinks restricts a book query to the author and subject of the list query
+func filterBookLinks(db *gorm.DB, query ListQuery) *gorm.DB {
+	if query.AuthorID != 0 {
+		db = db.Where("books.id IN (SELECT book_id FROM book_authors WHERE author_id = ?)", query.AuthorID)
+	}
+	if query.SubjectID != 0 {
+		db = db.Where("books.id IN (SELECT book_id FROM book_subjects WHERE subject_id = ?)", query.SubjectID)
+	}
+	return db
+}
+
+func (r *bookRepository) Delete(ctx context.Context, id uint) error {
 	log.Printf("BookRepository.Delete: deleting book with ID=%d", id)
-	err := r.db.Delete(&models.Book{}, id).Error
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&models.Book{}, id).Error; err != nil {
+			return err
+		}
+		return r.unindexBook(tx, id)
+	})
 	if err != nil {
 		log.Printf("BookRepository.Delete: error deleting book with ID=%d: %v", id, err)
 		return err
 	}
 	log.Printf("BookRepository.Delete: book with ID=%d deleted successfully", id)
 	r.cache.Invalidate(cache.BookIDKey(id))
-	r.cache.Invalidate(cache.BookListKey())
+	r.cache.InvalidatePattern(cache.BookISBNKey(""))
+	r.cache.InvalidatePattern(cache.BookListKey())
 	return nil
 }
 
-func (r *bookRepository) DeleteAll() error {
+func (r *bookRepository) DeleteAll(ctx context.Context) error {
 	log.Printf("BookRepository.DeleteAll: deleting all books")
-	err := r.db.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&models.Book{}).Error
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&models.Book{}).Error; err != nil {
+			return err
+		}
+		return r.unindexAllBooks(tx)
+	})
 	if err != nil {
 		log.Printf("BookRepository.DeleteAll: error deleting all books: %v", err)
 		return err
@@ -114,3 +310,123 @@ func (r *bookRepository) DeleteAll() error {
 	r.cache.InvalidatePattern("books:") // Invalidate ALL book-related cache entries
 	return nil
 }
+
+// FindDeletedPage lists the books in the trash, i.e. the soft-deleted ones.
+// The trash is not cached.
+func (r *bookRepository) FindDeletedPage(ctx context.Context, query ListQuery) (*BookPage, error) {
+	log.Printf("BookRepository.FindDeletedPage: fetching deleted books (%s)", query.Key())
+	db := trashed(r.db.WithContext(ctx).Model(&models.Book{}), "books")
+	if query.Q != "" {
+		pattern := likePattern(query.Q)
+		db = db.Where(`LOWER(books.title) LIKE ? ESCAPE '\' OR LOWER(books.description) LIKE ? ESCAPE '\'`, pattern, pattern)
+	}
+
+	page := &BookPage{}
+	if err := db.Count(&page.Total).Error; err != nil {
+		log.Printf("BookRepository.FindDeletedPage: error counting deleted books: %v", err)
+		return nil, err
+	}
+	if err := withBookRelations(paginate(db, "books", BookTrashSortFields, query)).Find(&page.Books).Error; err != nil {
+		log.Printf("BookRepository.FindDeletedPage: error fetching deleted books: %v", err)
+		return nil, err
+	}
+	return page, nil
+}
+
+// FindDeleted fetches a book from the trash
+func (r *bookRepository) FindDeleted(ctx context.Context, id uint) (*models.Book, error) {
+	var book models.Book
+	err := withBookRelations(trashed(r.db.WithContext(ctx), "books")).First(&book, id).Error
+	if err != nil {
+		log.Printf("BookRepository.FindDeleted: error fetching deleted book with ID=%d: %v", id, err)
+		return nil, err
+	}
+	return &book, nil
+}
+
+// Restore takes a book out of the trash, gorm.ErrRecordNotFound if it is not there
+func (r *bookRepository) Restore(ctx context.Context, id uint) error {
+	log.Printf("BookRepository.Restore: restoring book with ID=%d", id)
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := trashed(tx.Model(&models.Book{}), "books").Where("id = ?", id).Update("deleted_at", nil)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return r.indexBook(tx, &models.Book{Model: gorm.Model{ID: id}})
+	})
+	if err != nil {
+		log.Printf("BookRepository.Restore: error restoring book with ID=%d: %v", id, err)
+		return err
+	}
+	log.Printf("BookRepository.Restore: book with ID=%d restored successfully", id)
+	r.cache.Invalidate(cache.BookIDKey(id))
+	r.cache.InvalidatePattern(cache.BookISBNKey(""))
+	r.cache.InvalidatePattern(cache.BookListKey())
+	return nil
+}
+
+// Purge removes a book from the trash for good, together with its copies, links
+// and revisions. Books that loans or holds refer to fail with ErrStillReferenced.
+func (r *bookRepository) Purge(ctx context.Context, id uint) error {
+	log.Printf("BookRepository.Purge: purging book with ID=%d", id)
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := purgeable(tx, &models.Book{}, "books", "book_id", id, bookReferences...); err != nil {
+			return err
+		}
+		return r.purgeBooks(tx, []uint{id})
+	})
+	if err != nil {
+		log.Printf("BookRepository.Purge: error purging book with ID=%d: %v", id, err)
+		return err
+	}
+	log.Printf("BookRepository.Purge: book with ID=%d purged successfully", id)
+	return nil
+}
+
+// PurgeDeletedBefore purges the books deleted before the given moment, skipping
+// those still referenced, and returns how many were purged
+func (r *bookRepository) PurgeDeletedBefore(ctx context.Context, before time.Time) (int64, error) {
+	var ids []uint
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		db := trashed(tx.Model(&models.Book{}), "books").Where("books.deleted_at < ?", before)
+		if err := unreferenced(db, "books", "book_id", bookReferences...).Pluck("books.id", &ids).Error; err != nil {
+			return err
+		}
+		return r.purgeBooks(tx, ids)
+	})
+	if err != nil {
+		log.Printf("BookRepository.PurgeDeletedBefore: error purging books deleted before %s: %v", before.Format(time.RFC3339), err)
+		return 0, err
+	}
+	if len(ids) > 0 {
+		log.Printf("BookRepository.PurgeDeletedBefore: purged %d books deleted before %s", len(ids), before.Format(time.RFC3339))
+	}
+	return int64(len(ids)), nil
+}
+
+// purgeBooks hard-deletes books along with everything that only exists for them
+func (r *bookRepository) purgeBooks(tx *gorm.DB, ids []uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	for _, table := range []string{"book_authors", "book_publishers", "book_subjects", "reader_books"} {
+		if err := tx.Exec("DELETE FROM "+table+" WHERE book_id IN ?", ids).Error; err != nil {
+			return err
+		}
+	}
+	if err := tx.Unscoped().Where("book_id IN ?", ids).Delete(&models.Item{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("book_id IN ?", ids).Delete(&models.BookRevision{}).Error; err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := r.unindexBook(tx, id); err != nil {
+			return err
+		}
+	}
+	return tx.Unscoped().Delete(&models.Book{}, ids).Error
+}
@@ -0,0 +1,510 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"lab1/cache"
+	"lab1/models"
+	"log"
+	"strings"
+	"unicode"
+
+	"gorm.io/gorm"
+)
+
+// Highlighted fragments in BookSearchHit are wrapped in these markers; they
+// cannot occur in user text, so callers can escape the text before turning
+// them into markup
+const (
+	HighlightStart = "\x02"
+	HighlightEnd   = "\x03"
+)
+
+// bookSearchColumns are the columns of the full-text index and the SQL that
+// computes each of them for a row of books. Changing the list rebuilds the
+// index on the next start. Title and description must stay first, highlight
+// and snippet refer to them by position. PostgreSQL folds the columns into
+// one document, where weight is the class the column's words rank in.
+var bookSearchColumns = []struct {
+	name   string
+	source string
+	weight string
+}{
+	{"title", "books.title", "A"},
+	{"description", "books.description", "D"},
+	{"authors", relatedNames("authors", "book_authors", "author_id"), "B"},
+	{"publishers", relatedNames("publishers", "book_publishers", "publisher_id"), "C"},
+	{"subjects", relatedNames("subjects", "book_subjects", "subject_id"), "C"},
+}
+
+// bookSearchWeights are the bm25 weights of the columns: title hits rank highest
+const bookSearchWeights = "10.0, 1.0, 5.0, 2.0, 3.0"
+
+func relatedNames(table, joinTable, foreignKey string) string {
+	return fmt.Sprintf("(SELECT string_agg(%[1]s.name, ' ') FROM %[2]s JOIN %[1]s ON %[1]s.id = %[2]s.%[3]s "+
+		"WHERE %[2]s.book_id = books.id AND %[1]s.deleted_at IS NULL)", table, joinTable, foreignKey)
+}
+
+func bookSearchColumnList() string {
+	names := make([]string, len(bookSearchColumns))
+	for i, column := range bookSearchColumns {
+		names[i] = column.name
+	}
+	return strings.Join(names, ", ")
+}
+
+func bookSearchSourceList() string {
+	sources := make([]string, len(bookSearchColumns))
+	for i, column := range bookSearchColumns {
+		sources[i] = column.source
+	}
+	return strings.Join(sources, ", ")
+}
+
+// snippetWords is the size of the description excerpt around the first hit
+const snippetWords = 16
+
+type BookSearchHit struct {
+	Book           models.Book
+	Rank           float64 // lower is better
+	TitleHighlight string
+	Snippet        string
+}
+
+type BookSearchPage struct {
+	Hits  []BookSearchHit
+	Total int64
+}
+
+// searchTerm is a word or a quoted phrase of a search query
+type searchTerm struct {
+	text   string
+	phrase bool
+}
+
+// parseSearchTerms splits a query into words and "quoted phrases".
+// An unterminated quote runs to the end of the query.
+func parseSearchTerms(q string) []searchTerm {
+	var terms []searchTerm
+	for {
+		q = strings.TrimSpace(q)
+		if q == "" {
+			return terms
+		}
+		if q[0] == '"' {
+			end := strings.IndexByte(q[1:], '"')
+			if end < 0 {
+				end = len(q) - 1
+			}
+			if phrase := strings.TrimSpace(q[1 : end+1]); phrase != "" {
+				terms = append(terms, searchTerm{text: phrase, phrase: true})
+			}
+			q = q[min(end+2, len(q)):]
+			continue
+		}
+		end := strings.IndexAny(q, " \t\n\"")
+		if end < 0 {
+			end = len(q)
+		}
+		terms = append(terms, searchTerm{text: q[:end]})
+		q = q[end:]
+	}
+}
+
+// searchable reports whether the term has letters or digits; the others are
+// dropped since the tokenizers ignore them anyway
+func (t searchTerm) searchable() bool {
+	return strings.ContainsFunc(t.text, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) })
+}
+
+// ftsMatchQuery translates search terms into an FTS5 query: every term must
+// match, words match as prefixes and phrases match exactly
+func ftsMatchQuery(terms []searchTerm) string {
+	parts := make([]string, 0, len(terms))
+	for _, term := range terms {
+		if !term.searchable() {
+			continue
+		}
+		quoted := `"` + strings.ReplaceAll(term.text, `"`, `""`) + `"`
+		if !term.phrase {
+			quoted += "*"
+		}
+		parts = append(parts, quoted)
+	}
+	return strings.Join(parts, " ")
+}
+
+// setupBookSearch creates the full-text index over books and fills it when it
+// is new or out of step with the table: an FTS5 table with SQLite, a tsvector
+// table with PostgreSQL. It reports false when the SQLite build lacks FTS5
+// (build with -tags sqlite_fts5), in which case search falls back to LIKE.
+func setupBookSearch(db *gorm.DB) bool {
+	var err error
+	switch db.Dialector.Name() {
+	case "sqlite":
+		err = createSQLiteBookSearch(db)
+	case "postgres":
+		err = createPostgresBookSearch(db)
+	default:
+		return false
+	}
+	if err != nil {
+		log.Printf("WARNING: full-text search unavailable, /books/search falls back to substring matching "+
+			"(SQLite needs a build with -tags sqlite_fts5): %v", err)
+		return false
+	}
+
+	var indexed, books int64
+	db.Table("books_fts").Count(&indexed)
+	db.Model(&models.Book{}).Count(&books)
+	if indexed != books {
+		log.Printf("BookRepository: rebuilding search index (%d of %d books indexed)", indexed, books)
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec("DELETE FROM books_fts").Error; err != nil {
+				return err
+			}
+			return reindexBooks(tx, "1 = 1")
+		})
+		if err != nil {
+			log.Printf("BookRepository: error rebuilding search index: %v", err)
+			return false
+		}
+	}
+
+	log.Printf("BookRepository: full-text search enabled")
+	return true
+}
+
+// dropBookSearch removes the index when full-text search is turned off, so
+// that nothing keeps it up to date and turning it on again rebuilds it
+func dropBookSearch(db *gorm.DB) {
+	log.Printf("BookRepository: full-text search turned off, /books/search uses substring matching")
+	if err := db.Exec("DROP TABLE IF EXISTS books_fts").Error; err != nil {
+		log.Printf("BookRepository: error dropping search index: %v", err)
+	}
+}
+
+func createSQLiteBookSearch(db *gorm.DB) error {
+	var columns []string
+	if err := db.Raw("SELECT name FROM pragma_table_info('books_fts')").Scan(&columns).Error; err == nil && len(columns) > 0 {
+		if strings.Join(columns, ", ") != bookSearchColumnList() {
+			log.Printf("BookRepository: search index columns changed, rebuilding")
+			if err := db.Exec("DROP TABLE books_fts").Error; err != nil {
+				return err
+			}
+		}
+	}
+
+	return db.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS books_fts USING fts5(" +
+		bookSearchColumnList() + ", tokenize = 'unicode61 remove_diacritics 2')").Error
+}
+
+// hasBookSearchIndex reports whether setupBookSearch has created the index.
+// Repositories of related entities use it to keep the index up to date.
+func hasBookSearchIndex(db *gorm.DB) bool {
+	switch db.Dialector.Name() {
+	case "sqlite", "postgres":
+		return db.Migrator().HasTable("books_fts")
+	}
+	return false
+}
+
+// bookSearchKey is the column of books_fts that holds the book ID
+func bookSearchKey(db *gorm.DB) string {
+	if db.Dialector.Name() == "postgres" {
+		return "book_id"
+	}
+	return "rowid"
+}
+
+// reindexBooks recomputes the index entries of the books matching the condition.
+// It must run after the book and its links have been written.
+func reindexBooks(tx *gorm.DB, condition string, args ...interface{}) error {
+	err := tx.Exec("DELETE FROM books_fts WHERE "+bookSearchKey(tx)+" IN (SELECT books.id FROM books WHERE "+condition+")", args...).Error
+	if err != nil {
+		return err
+	}
+	if tx.Dialector.Name() == "postgres" {
+		return tx.Exec("INSERT INTO books_fts (book_id, document) "+
+			"SELECT books.id, "+postgresBookDocument()+" FROM books WHERE books.deleted_at IS NULL AND ("+condition+")", args...).Error
+	}
+	return tx.Exec("INSERT INTO books_fts (rowid, "+bookSearchColumnList()+") "+
+		"SELECT books.id, "+bookSearchSourceList()+" FROM books WHERE books.deleted_at IS NULL AND ("+condition+")", args...).Error
+}
+
+// indexBook adds or replaces the search index entry of a book
+func (r *bookRepository) indexBook(tx *gorm.DB, book *models.Book) error {
+	if !r.fullText {
+		return nil
+	}
+	return reindexBooks(tx, "books.id = ?", book.ID)
+}
+
+func (r *bookRepository) unindexBook(tx *gorm.DB, id uint) error {
+	if !r.fullText {
+		return nil
+	}
+	return tx.Exec("DELETE FROM books_fts WHERE "+bookSearchKey(tx)+" = ?", id).Error
+}
+
+func (r *bookRepository) unindexAllBooks(tx *gorm.DB) error {
+	if !r.fullText {
+		return nil
+	}
+	return tx.Exec("DELETE FROM books_fts").Error
+}
+
+// Search returns books ranked by relevance to query.Q. The other filters
+// apply like in FindPage; Sort and Order are ignored.
+func (r *bookRepository) Search(ctx context.Context, query ListQuery) (*BookSearchPage, error) {
+	log.Printf("BookRepository.Search: searching books (%s)", query.Key())
+	key := cache.BookSearchKey(query.Key())
+	if cached, found := r.cache.Get(key); found {
+		log.Printf("BookRepository.Search: returning cached results")
+		return cached.(*BookSearchPage), nil
+	}
+
+	var page *BookSearchPage
+	var err error
+	terms := parseSearchTerms(query.Q)
+	if r.fullText {
+		page, err = r.searchFullText(ctx, query, terms)
+	} else {
+		page, err = r.searchLike(ctx, query, terms)
+	}
+	if err != nil {
+		log.Printf("BookRepository.Search: error searching books: %v", err)
+		return nil, err
+	}
+
+	log.Printf("BookRepository.Search: found %d of %d matching books", len(page.Hits), page.Total)
+	r.cache.Set(key, page)
+	return page, nil
+}
+
+func (r *bookRepository) filterBooks(db *gorm.DB, query ListQuery) *gorm.DB {
+	db = db.Where("books.deleted_at IS NULL")
+	if query.Owner != "" {
+		db = db.Where("books.user_id IN (?)", r.db.Model(&models.User{}).Select("id").Where("username = ?", query.Owner))
+	}
+	if query.CreatedAfter != nil {
+		db = db.Where("books.created_at > ?", *query.CreatedAfter)
+	}
+	return filterBookLinks(db, query)
+}
+
+func (r *bookRepository) searchFullText(ctx context.Context, query ListQuery, terms []searchTerm) (*BookSearchPage, error) {
+	page := &BookSearchPage{}
+	match := matchBooksSQLite
+	if r.db.Dialector.Name() == "postgres" {
+		match = matchBooksPostgres
+	}
+	db, columns, args := match(r.db.WithContext(ctx), terms)
+	if db == nil {
+		return page, nil
+	}
+
+	db = r.filterBooks(db, query)
+	if err := db.Count(&page.Total).Error; err != nil {
+		return nil, err
+	}
+
+	var rows []struct {
+		ID             uint
+		Rank           float64
+		TitleHighlight string
+		Snippet        string
+	}
+	err := db.Select(columns, args...).
+		Order("rank, books.id").
+		Offset(query.Offset()).Limit(query.PageSize).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]uint, len(rows))
+	for i, row := range rows {
+		ids[i] = row.ID
+	}
+	books, err := r.findBooksByIDs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		book, ok := books[row.ID]
+		if !ok {
+			continue
+		}
+		page.Hits = append(page.Hits, BookSearchHit{
+			Book:           book,
+			Rank:           row.Rank,
+			TitleHighlight: row.TitleHighlight,
+			Snippet:        row.Snippet,
+		})
+	}
+	return page, nil
+}
+
+// matchBooksSQLite restricts db to the books matching the terms in the FTS5
+// index. It returns the columns id, rank, title_highlight and snippet to
+// select with their arguments, or a nil db when no term can match.
+func matchBooksSQLite(db *gorm.DB, terms []searchTerm) (*gorm.DB, string, []interface{}) {
+	match := ftsMatchQuery(terms)
+	if match == "" {
+		return nil, "", nil
+	}
+	db = db.Table("books_fts").
+		Joins("JOIN books ON books.id = books_fts.rowid").
+		Where("books_fts MATCH ?", match)
+	columns := "books.id AS id, bm25(books_fts, " + bookSearchWeights + ") AS rank, " +
+		"highlight(books_fts, 0, ?, ?) AS title_highlight, " +
+		"snippet(books_fts, 1, ?, ?, '…', ?) AS snippet"
+	return db, columns, []interface{}{HighlightStart, HighlightEnd, HighlightStart, HighlightEnd, snippetWords}
+}
+
+// searchLike is the fallback without a full-text index: every term has to occur in the title
+// or the description, and books whose title contains the whole query come first
+func (r *bookRepository) searchLike(ctx context.Context, query ListQuery, terms []searchTerm) (*BookSearchPage, error) {
+	page := &BookSearchPage{}
+	if len(terms) == 0 {
+		return page, nil
+	}
+
+	db := r.filterBooks(r.db.WithContext(ctx).Model(&models.Book{}), query)
+	for _, term := range terms {
+		pattern := likePattern(term.text)
+		db = db.Where(`(LOWER(books.title) LIKE ? ESCAPE '\' OR LOWER(books.description) LIKE ? ESCAPE '\')`, pattern, pattern)
+	}
+	if err := db.Count(&page.Total).Error; err != nil {
+		return nil, err
+	}
+
+	var books []models.Book
+	err := withBookRelations(db).
+		Order(gorm.Expr(`CASE WHEN LOWER(books.title) LIKE ? ESCAPE '\' THEN 0 ELSE 1 END`, likePattern(query.Q))).
+		Order("books.title, books.id").
+		Offset(query.Offset()).Limit(query.PageSize).
+		Find(&books).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, book := range books {
+		page.Hits = append(page.Hits, BookSearchHit{
+			Book:           book,
+			TitleHighlight: highlightTerms(book.Title, terms, 0),
+			Snippet:        highlightTerms(book.Description, terms, snippetWords),
+		})
+	}
+	return page, nil
+}
+
+func (r *bookRepository) findBooksByIDs(ctx context.Context, ids []uint) (map[uint]models.Book, error) {
+	books := make(map[uint]models.Book, len(ids))
+	if len(ids) == 0 {
+		return books, nil
+	}
+	var found []models.Book
+	if err := withBookRelations(r.db.WithContext(ctx)).Where("id IN ?", ids).Find(&found).Error; err != nil {
+		return nil, err
+	}
+	for _, book := range found {
+		books[book.ID] = book
+	}
+	return books, nil
+}
+
+// highlightTerms marks case-insensitive occurrences of the terms in text.
+// With maxWords > 0 the text is cut down to that many words around the first hit.
+func highlightTerms(text string, terms []searchTerm, maxWords int) string {
+	lower := strings.ToLower(text)
+	marked := make([]bool, len(text))
+	first := -1
+	for _, term := range terms {
+		needle := strings.ToLower(term.text)
+		if needle == "" || len(needle) != len(term.text) {
+			continue // case folding changed the length, offsets would not line up
+		}
+		for from := 0; ; {
+			i := strings.Index(lower[from:], needle)
+			if i < 0 {
+				break
+			}
+			i += from
+			for j := i; j < i+len(needle); j++ {
+				marked[j] = true
+			}
+			if first < 0 || i < first {
+				first = i
+			}
+			from = i + len(needle)
+		}
+	}
+
+	start, end := 0, len(text)
+	prefix, suffix := "", ""
+	if maxWords > 0 {
+		words := strings.Fields(text)
+		if len(words) > maxWords {
+			// Find the word index of the first hit and centre the window on it
+			hitWord := 0
+			if first > 0 {
+				hitWord = len(strings.Fields(text[:first]))
+			}
+			from := max(0, min(hitWord-maxWords/2, len(words)-maxWords))
+			start = wordOffset(text, from)
+			end = wordOffset(text, from+maxWords)
+			for end > start && unicode.IsSpace(rune(text[end-1])) {
+				end--
+			}
+			if from > 0 {
+				prefix = "…"
+			}
+			if from+maxWords < len(words) {
+				suffix = "…"
+			}
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(prefix)
+	in := false
+	for i := start; i < end; i++ {
+		if marked[i] != in {
+			in = marked[i]
+			if in {
+				b.WriteString(HighlightStart)
+			} else {
+				b.WriteString(HighlightEnd)
+			}
+		}
+		b.WriteByte(text[i])
+	}
+	if in {
+		b.WriteString(HighlightEnd)
+	}
+	b.WriteString(suffix)
+	return b.String()
+}
+
+// wordOffset returns the byte offset of the n-th whitespace separated word
+func wordOffset(text string, n int) int {
+	count := 0
+	inWord := false
+	for i, r := range text {
+		if unicode.IsSpace(r) {
+			inWord = false
+			continue
+		}
+		if !inWord {
+			if count == n {
+				return i
+			}
+			count++
+			inWord = true
+		}
+	}
+	return len(text)
+}
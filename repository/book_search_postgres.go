@@ -0,0 +1,102 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// postgresSearchConfig is the text search configuration of the index. Like
+// the FTS5 tokenizer it lowercases words but does not stem them.
+const postgresSearchConfig = "'simple'"
+
+// postgresSearchWeights are the ts_rank weights of the classes D, C, B and A,
+// the bm25 weights of bookSearchWeights scaled to 1
+const postgresSearchWeights = "'{0.1, 0.25, 0.5, 1.0}'"
+
+// createPostgresBookSearch creates books_fts, which holds one weighted
+// tsvector per book. The table comment records the column list, so that
+// changing it rebuilds the index as with SQLite.
+func createPostgresBookSearch(db *gorm.DB) error {
+	var columns sql.NullString
+	if err := db.Raw("SELECT obj_description(to_regclass('books_fts'), 'pg_class')").Row().Scan(&columns); err != nil {
+		return err
+	}
+	if columns.Valid && columns.String != bookSearchColumnList() {
+		log.Printf("BookRepository: search index columns changed, rebuilding")
+		if err := db.Exec("DROP TABLE books_fts").Error; err != nil {
+			return err
+		}
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		statements := []string{
+			"CREATE TABLE IF NOT EXISTS books_fts (book_id bigint PRIMARY KEY, document tsvector NOT NULL)",
+			"CREATE INDEX IF NOT EXISTS idx_books_fts_document ON books_fts USING gin (document)",
+			"COMMENT ON TABLE books_fts IS '" + bookSearchColumnList() + "'",
+		}
+		for _, statement := range statements {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// postgresBookDocument is the SQL that computes the tsvector of a row of books
+func postgresBookDocument() string {
+	parts := make([]string, len(bookSearchColumns))
+	for i, column := range bookSearchColumns {
+		parts[i] = fmt.Sprintf("setweight(to_tsvector(%s, COALESCE(%s, '')), '%s')", postgresSearchConfig, column.source, column.weight)
+	}
+	return strings.Join(parts, " || ")
+}
+
+// postgresMatchQuery translates search terms into a tsquery expression with
+// its arguments: every term must match, words match as prefixes and phrases
+// match exactly. It returns an empty expression when no term can match.
+func postgresMatchQuery(terms []searchTerm) (string, []interface{}) {
+	var parts []string
+	var args []interface{}
+	for _, term := range terms {
+		if !term.searchable() {
+			continue
+		}
+		if term.phrase {
+			parts = append(parts, "phraseto_tsquery("+postgresSearchConfig+", ?)")
+			args = append(args, term.text)
+			continue
+		}
+		// Quoted, the word is a lexeme and not tsquery syntax
+		quoted := "'" + strings.NewReplacer(`\`, `\\`, `'`, `''`).Replace(term.text) + "':*"
+		parts = append(parts, "to_tsquery("+postgresSearchConfig+", ?)")
+		args = append(args, quoted)
+	}
+	return strings.Join(parts, " && "), args
+}
+
+// matchBooksPostgres is matchBooksSQLite for the tsvector index
+func matchBooksPostgres(db *gorm.DB, terms []searchTerm) (*gorm.DB, string, []interface{}) {
+	match, args := postgresMatchQuery(terms)
+	if match == "" {
+		return nil, "", nil
+	}
+	db = db.Table("books_fts").
+		Joins("JOIN books ON books.id = books_fts.book_id").
+		Joins("CROSS JOIN (SELECT "+match+" AS query) AS search_query", args...).
+		Where("books_fts.document @@ search_query.query")
+
+	titleOptions := fmt.Sprintf(`StartSel="%s", StopSel="%s", HighlightAll=true`, HighlightStart, HighlightEnd)
+	snippetOptions := fmt.Sprintf(`StartSel="%s", StopSel="%s", MaxWords=%d, MinWords=%d, ShortWord=0`,
+		HighlightStart, HighlightEnd, snippetWords, snippetWords/2)
+	// ts_rank is higher for better hits, rank is lower like bm25
+	columns := "books.id AS id, " +
+		"-ts_rank(" + postgresSearchWeights + ", books_fts.document, search_query.query) AS rank, " +
+		"ts_headline(" + postgresSearchConfig + ", books.title, search_query.query, ?) AS title_highlight, " +
+		"ts_headline(" + postgresSearchConfig + ", books.description, search_query.query, ?) AS snippet"
+	return db, columns, []interface{}{titleOptions, snippetOptions}
+}
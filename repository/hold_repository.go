@@ -0,0 +1,201 @@
+package repository
+
+import (
+	"context"
+	"lab1/models"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type HoldRepository interface {
+	Create(ctx context.Context, hold *models.Hold) error
+	FindByID(ctx context.Context, id uint) (*models.Hold, error)
+	FindQueueByBook(ctx context.Context, bookID uint) ([]models.Hold, error)
+	FindByReader(ctx context.Context, readerID uint) ([]models.Hold, error)
+	FindActive(ctx context.Context, readerID uint, bookID uint) (*models.Hold, error)
+	QueuePosition(ctx context.Context, hold *models.Hold) (int, error)
+	UpdateStatus(ctx context.Context, hold *models.Hold, status string) error
+	PromoteNext(ctx context.Context, bookID uint, slots int64, readyAt time.Time, expiresAt time.Time) ([]models.Hold, error)
+	ExpireStale(ctx context.Context, bookID uint, now time.Time) (int64, error)
+	Requeue(ctx context.Context, bookID uint, slots int64) ([]models.Hold, error)
+	WithTx(tx *gorm.DB) HoldRepository
+}
+
+type holdRepository struct {
+	db *gorm.DB
+}
+
+func NewHoldRepository(db *gorm.DB) HoldRepository {
+	return &holdRepository{db: db}
+}
+
+// WithTx returns the repository working in the transaction tx
+func (r *holdRepository) WithTx(tx *gorm.DB) HoldRepository {
+	return &holdRepository{db: tx}
+}
+
+var activeHoldStatuses = []string{models.HoldStatusWaiting, models.HoldStatusReady}
+
+func (r *holdRepository) Create(ctx context.Context, hold *models.Hold) error {
+	log.Printf("HoldRepository.Create: reader ID=%d places hold on book ID=%d", hold.ReaderID, hold.BookID)
+	if err := r.db.WithContext(ctx).Create(hold).Error; err != nil {
+		log.Printf("HoldRepository.Create: error creating hold: %v", err)
+		return err
+	}
+	log.Printf("HoldRepository.Create: hold created successfully with ID=%d", hold.ID)
+	return nil
+}
+
+func (r *holdRepository) FindByID(ctx context.Context, id uint) (*models.Hold, error) {
+	var hold models.Hold
+	err := r.db.WithContext(ctx).Preload("Reader").Preload("Book").First(&hold, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &hold, nil
+}
+
+// FindQueueByBook returns the active holds of a book in FIFO order
+func (r *holdRepository) FindQueueByBook(ctx context.Context, bookID uint) ([]models.Hold, error) {
+	var holds []models.Hold
+	err := r.db.WithContext(ctx).Preload("Reader").Preload("Book").
+		Where("book_id = ? AND status IN ?", bookID, activeHoldStatuses).
+		Order("created_at ASC, id ASC").
+		Find(&holds).Error
+	return holds, err
+}
+
+// FindByReader returns every hold of a reader, newest first
+func (r *holdRepository) FindByReader(ctx context.Context, readerID uint) ([]models.Hold, error) {
+	var holds []models.Hold
+	err := r.db.WithContext(ctx).Preload("Reader").Preload("Book").
+		Where("reader_id = ?", readerID).
+		Order("created_at DESC, id DESC").
+		Find(&holds).Error
+	return holds, err
+}
+
+// FindActive returns the waiting or ready hold a reader has on a book
+func (r *holdRepository) FindActive(ctx context.Context, readerID uint, bookID uint) (*models.Hold, error) {
+	var hold models.Hold
+	err := r.db.WithContext(ctx).Where("reader_id = ? AND book_id = ? AND status IN ?", readerID, bookID, activeHoldStatuses).
+		First(&hold).Error
+	if err != nil {
+		return nil, err
+	}
+	return &hold, nil
+}
+
+// QueuePosition returns the 1-based place of an active hold in its book's queue
+func (r *holdRepository) QueuePosition(ctx context.Context, hold *models.Hold) (int, error) {
+	var ahead int64
+	err := r.db.WithContext(ctx).Model(&models.Hold{}).
+		Where("book_id = ? AND status IN ?", hold.BookID, activeHoldStatuses).
+		Where("created_at < ? OR (created_at = ? AND id < ?)", hold.CreatedAt, hold.CreatedAt, hold.ID).
+		Count(&ahead).Error
+	return int(ahead) + 1, err
+}
+
+func (r *holdRepository) UpdateStatus(ctx context.Context, hold *models.Hold, status string) error {
+	log.Printf("HoldRepository.UpdateStatus: hold ID=%d %s -> %s", hold.ID, hold.Status, status)
+	if err := r.db.WithContext(ctx).Model(hold).Update("status", status).Error; err != nil {
+		log.Printf("HoldRepository.UpdateStatus: error updating hold ID=%d: %v", hold.ID, err)
+		return err
+	}
+	return nil
+}
+
+// PromoteNext marks waiting holds of a book as ready for pickup, in queue order,
+// until the number of ready holds reaches slots (the copies on the shelf).
+func (r *holdRepository) PromoteNext(ctx context.Context, bookID uint, slots int64, readyAt time.Time, expiresAt time.Time) ([]models.Hold, error) {
+	var promoted []models.Hold
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var ready int64
+		if err := tx.Model(&models.Hold{}).Where("book_id = ? AND status = ?", bookID, models.HoldStatusReady).Count(&ready).Error; err != nil {
+			return err
+		}
+		if ready >= slots {
+			return nil
+		}
+
+		var next []models.Hold
+		err := tx.Where("book_id = ? AND status = ?", bookID, models.HoldStatusWaiting).
+			Order("created_at ASC, id ASC").
+			Limit(int(slots - ready)).
+			Find(&next).Error
+		if err != nil {
+			return err
+		}
+
+		for i := range next {
+			next[i].Status = models.HoldStatusReady
+			next[i].ReadyAt = &readyAt
+			next[i].ExpiresAt = &expiresAt
+			if err := tx.Save(&next[i]).Error; err != nil {
+				return err
+			}
+		}
+		promoted = next
+		return nil
+	})
+	if err != nil {
+		log.Printf("HoldRepository.PromoteNext: error promoting holds for book ID=%d: %v", bookID, err)
+		return nil, err
+	}
+	for _, hold := range promoted {
+		log.Printf("HoldRepository.PromoteNext: hold ID=%d of reader ID=%d is ready for pickup", hold.ID, hold.ReaderID)
+	}
+	return promoted, nil
+}
+
+// ExpireStale marks ready holds of a book whose pickup deadline has passed as expired
+func (r *holdRepository) ExpireStale(ctx context.Context, bookID uint, now time.Time) (int64, error) {
+	result := r.db.WithContext(ctx).Model(&models.Hold{}).
+		Where("book_id = ? AND status = ? AND expires_at < ?", bookID, models.HoldStatusReady, now).
+		Update("status", models.HoldStatusExpired)
+	if result.Error != nil {
+		log.Printf("HoldRepository.ExpireStale: error expiring holds for book ID=%d: %v", bookID, result.Error)
+		return 0, result.Error
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("HoldRepository.ExpireStale: expired %d holds for book ID=%d", result.RowsAffected, bookID)
+	}
+	return result.RowsAffected, nil
+}
+
+// Requeue puts ready holds of a book back to waiting, last in queue order
+// first, until no more than slots (the copies on the shelf) are ready
+func (r *holdRepository) Requeue(ctx context.Context, bookID uint, slots int64) ([]models.Hold, error) {
+	var requeued []models.Hold
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var ready []models.Hold
+		err := tx.Where("book_id = ? AND status = ?", bookID, models.HoldStatusReady).
+			Order("created_at ASC, id ASC").
+			Find(&ready).Error
+		if err != nil || int64(len(ready)) <= slots {
+			return err
+		}
+
+		for i := range ready[slots:] {
+			hold := &ready[slots+int64(i)]
+			hold.Status = models.HoldStatusWaiting
+			hold.ReadyAt = nil
+			hold.ExpiresAt = nil
+			if err := tx.Save(hold).Error; err != nil {
+				return err
+			}
+			requeued = append(requeued, *hold)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("HoldRepository.Requeue: error requeueing holds for book ID=%d: %v", bookID, err)
+		return nil, err
+	}
+	for _, hold := range requeued {
+		log.Printf("HoldRepository.Requeue: hold ID=%d of reader ID=%d is waiting again", hold.ID, hold.ReaderID)
+	}
+	return requeued, nil
+}
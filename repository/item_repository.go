@@ -0,0 +1,154 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"lab1/models"
+	"log"
+
+	"gorm.io/gorm"
+)
+
+// ErrItemLent is returned by Delete when the copy is on loan
+var ErrItemLent = errors.New("item is on loan")
+
+// CopyCounts summarises the physical copies of a book
+type CopyCounts struct {
+	Total     int64
+	Available int64
+}
+
+type ItemRepository interface {
+	Create(ctx context.Context, item *models.Item) error
+	FindByID(ctx context.Context, id uint) (*models.Item, error)
+	FindByBook(ctx context.Context, bookID uint) ([]models.Item, error)
+	FindByBarcode(ctx context.Context, barcode string) (*models.Item, error)
+	FindAvailableByBook(ctx context.Context, bookID uint) (*models.Item, error)
+	BarcodeExists(ctx context.Context, barcode string) (bool, error)
+	CountsByBooks(ctx context.Context, bookIDs []uint) (map[uint]CopyCounts, error)
+	Update(ctx context.Context, item *models.Item) error
+	Delete(ctx context.Context, id uint) error
+	WithTx(tx *gorm.DB) ItemRepository
+}
+
+type itemRepository struct {
+	db *gorm.DB
+}
+
+func NewItemRepository(db *gorm.DB) ItemRepository {
+	return &itemRepository{db: db}
+}
+
+// WithTx returns the repository working in the transaction tx
+func (r *itemRepository) WithTx(tx *gorm.DB) ItemRepository {
+	return &itemRepository{db: tx}
+}
+
+func (r *itemRepository) Create(ctx context.Context, item *models.Item) error {
+	log.Printf("ItemRepository.Create: creating copy '%s' of book ID=%d", item.Barcode, item.BookID)
+	if err := r.db.WithContext(ctx).Create(item).Error; err != nil {
+		log.Printf("ItemRepository.Create: error creating item: %v", err)
+		return err
+	}
+	log.Printf("ItemRepository.Create: item created successfully with ID=%d", item.ID)
+	return nil
+}
+
+func (r *itemRepository) FindByID(ctx context.Context, id uint) (*models.Item, error) {
+	var item models.Item
+	if err := r.db.WithContext(ctx).Preload("Book").First(&item, id).Error; err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+func (r *itemRepository) FindByBook(ctx context.Context, bookID uint) ([]models.Item, error) {
+	var items []models.Item
+	err := r.db.WithContext(ctx).Preload("Book").Where("book_id = ?", bookID).Order("id ASC").Find(&items).Error
+	if err != nil {
+		log.Printf("ItemRepository.FindByBook: error fetching items of book ID=%d: %v", bookID, err)
+	}
+	return items, err
+}
+
+func (r *itemRepository) FindByBarcode(ctx context.Context, barcode string) (*models.Item, error) {
+	var item models.Item
+	if err := r.db.WithContext(ctx).Preload("Book").Where("barcode = ?", barcode).First(&item).Error; err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+// FindAvailableByBook returns any copy of the book that can be lent right now
+func (r *itemRepository) FindAvailableByBook(ctx context.Context, bookID uint) (*models.Item, error) {
+	var item models.Item
+	err := r.db.WithContext(ctx).Preload("Book").
+		Where("book_id = ? AND status = ?", bookID, models.ItemStatusAvailable).
+		Order("id ASC").
+		First(&item).Error
+	if err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+// BarcodeExists also looks at deleted copies, since the unique index covers them too
+func (r *itemRepository) BarcodeExists(ctx context.Context, barcode string) (bool, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Unscoped().Model(&models.Item{}).Where("barcode = ?", barcode).Count(&count).Error
+	return count > 0, err
+}
+
+// CountsByBooks returns total and available copy counts keyed by book ID.
+// Books without any copies are absent from the result.
+func (r *itemRepository) CountsByBooks(ctx context.Context, bookIDs []uint) (map[uint]CopyCounts, error) {
+	counts := make(map[uint]CopyCounts)
+	if len(bookIDs) == 0 {
+		return counts, nil
+	}
+
+	var rows []struct {
+		BookID    uint
+		Total     int64
+		Available int64
+	}
+	err := r.db.WithContext(ctx).Model(&models.Item{}).
+		Select("book_id, COUNT(*) AS total, SUM(CASE WHEN status = ? THEN 1 ELSE 0 END) AS available", models.ItemStatusAvailable).
+		Where("book_id IN ? AND status <> ?", bookIDs, models.ItemStatusWithdrawn).
+		Group("book_id").
+		Scan(&rows).Error
+	if err != nil {
+		log.Printf("ItemRepository.CountsByBooks: error counting items: %v", err)
+		return nil, err
+	}
+
+	for _, row := range rows {
+		counts[row.BookID] = CopyCounts{Total: row.Total, Available: row.Available}
+	}
+	return counts, nil
+}
+
+func (r *itemRepository) Update(ctx context.Context, item *models.Item) error {
+	log.Printf("ItemRepository.Update: updating item with ID=%d, status='%s'", item.ID, item.Status)
+	if err := r.db.WithContext(ctx).Omit("Book").Save(item).Error; err != nil {
+		log.Printf("ItemRepository.Update: error updating item with ID=%d: %v", item.ID, err)
+		return err
+	}
+	return nil
+}
+
+// Delete removes a copy unless it is on loan, which it checks in the same
+// statement so that a checkout cannot lend it in between
+func (r *itemRepository) Delete(ctx context.Context, id uint) error {
+	log.Printf("ItemRepository.Delete: deleting item with ID=%d", id)
+	result := r.db.WithContext(ctx).Where("status <> ?", models.ItemStatusOnLoan).Delete(&models.Item{}, id)
+	if result.Error != nil {
+		log.Printf("ItemRepository.Delete: error deleting item with ID=%d: %v", id, result.Error)
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		log.Printf("ItemRepository.Delete: item with ID=%d is on loan", id)
+		return ErrItemLent
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package repository
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ListQuery describes a filtered, sorted and paginated list request.
+// Sort must be one of the repository's sort fields (BookSortFields, ReaderSortFields, UserSortFields).
+type ListQuery struct {
+	Q             string     // case-insensitive substring search
+	Owner         string     // username of the owning user (books only)
+	AuthorID      uint       // books only, 0 for any
+	SubjectID     uint       // books only, 0 for any
+	Role          string     // users only
+	Status        string     // users: "active", "disabled" or empty for all; login attempts: the result
+	ActorID       uint       // audit events only, 0 for any
+	Action        string     // audit events only
+	EntityType    string     // audit events only
+	EntityID      uint       // audit events only, 0 for any
+	CreatedAfter  *time.Time // only rows created strictly after this moment
+	CreatedBefore *time.Time // audit events only: rows created strictly before this moment
+	Sort          string     // column to sort by
+	Order         string     // "asc" or "desc"
+	Page          int        // 1-based page number
+	PageSize      int
+}
+
+// Offset returns the number of rows skipped before the requested page
+func (q ListQuery) Offset() int {
+	if q.Page < 1 {
+		return 0
+	}
+	return (q.Page - 1) * q.PageSize
+}
+
+// Key is a stable representation of the query, used to build cache keys
+func (q ListQuery) Key() string {
+	format := func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.UTC().Format(time.RFC3339Nano)
+	}
+	return fmt.Sprintf("q=%s|owner=%s|author=%d|subject=%d|role=%s|status=%s|actor=%d|action=%s|entity=%s:%d|created_after=%s|created_before=%s|sort=%s|order=%s|page=%d|size=%d",
+		q.Q, q.Owner, q.AuthorID, q.SubjectID, q.Role, q.Status, q.ActorID, q.Action, q.EntityType, q.EntityID,
+		format(q.CreatedAfter), format(q.CreatedBefore), q.Sort, q.Order, q.Page, q.PageSize)
+}
+
+// likePattern turns user input into a LIKE pattern matching it anywhere,
+// escaping the LIKE wildcards so that they are matched literally
+func likePattern(s string) string {
+	s = strings.ToLower(s)
+	s = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+	return "%" + s + "%"
+}
+
+// IsSortField reports whether field is one of the allowed sort fields
+func IsSortField(fields []string, field string) bool {
+	for _, f := range fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// paginate applies sorting and the page window. Unknown sort fields fall back
+// to ID, which is also always the final sort key so that pages are stable
+// when the sort column has duplicates.
+func paginate(db *gorm.DB, table string, sortFields []string, q ListQuery) *gorm.DB {
+	order := "ASC"
+	if strings.EqualFold(q.Order, "desc") {
+		order = "DESC"
+	}
+	sort := q.Sort
+	if !IsSortField(sortFields, sort) {
+		sort = "id"
+	}
+
+	db = db.Order(fmt.Sprintf("%s.%s %s", table, sort, order))
+	if sort != "id" {
+		db = db.Order(fmt.Sprintf("%s.id %s", table, order))
+	}
+	if q.PageSize > 0 {
+		db = db.Offset(q.Offset()).Limit(q.PageSize)
+	}
+	return db
+}
@@ -0,0 +1,239 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"lab1/cache"
+	"lab1/models"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrAlreadyLent is returned by Checkout when the copy, or the book
+	// without copies, has been lent since it was found available
+	ErrAlreadyLent = errors.New("already lent")
+	// ErrLoanReturned is returned by Return when the loan has been returned
+	// since it was read
+	ErrLoanReturned = errors.New("loan already returned")
+)
+
+// LoanFilter narrows down the loans returned by LoanRepository.FindAll.
+// Zero values mean "no restriction".
+type LoanFilter struct {
+	ReaderID uint
+	BookID   uint
+	Status   string // one of models.LoanStatus*
+}
+
+type LoanRepository interface {
+	Checkout(ctx context.Context, loan *models.Loan) error
+	Return(ctx context.Context, loan *models.Loan, returnedAt time.Time) error
+	FindAll(ctx context.Context, filter LoanFilter) ([]models.Loan, error)
+	FindByID(ctx context.Context, id uint) (*models.Loan, error)
+	FindActiveByBook(ctx context.Context, bookID uint) (*models.Loan, error)
+	FindByReader(ctx context.Context, readerID uint) ([]models.Loan, error)
+	WithTx(tx *gorm.DB, store cache.Store) LoanRepository
+}
+
+type loanRepository struct {
+	db    *gorm.DB
+	cache cache.Store
+}
+
+func NewLoanRepository(db *gorm.DB, cache *cache.Cache) LoanRepository {
+	setupLoanIndexes(db)
+	return &loanRepository{db: db, cache: cache}
+}
+
+// setupLoanIndexes allows one active loan per copy, and per book for books
+// without copies. Checkouts read the availability before they write, so two
+// concurrent ones would otherwise both succeed.
+func setupLoanIndexes(db *gorm.DB) {
+	statements := []string{
+		"CREATE UNIQUE INDEX IF NOT EXISTS idx_loans_active_item ON loans (item_id) " +
+			"WHERE returned_at IS NULL AND deleted_at IS NULL AND item_id IS NOT NULL",
+		"CREATE UNIQUE INDEX IF NOT EXISTS idx_loans_active_book ON loans (book_id) " +
+			"WHERE returned_at IS NULL AND deleted_at IS NULL AND item_id IS NULL",
+	}
+	for _, statement := range statements {
+		if err := db.Exec(statement).Error; err != nil {
+			log.Printf("LoanRepository: error creating active loan indexes: %v", err)
+		}
+	}
+}
+
+// isDuplicateKey reports whether err is the violation of a unique index
+func isDuplicateKey(db *gorm.DB, err error) bool {
+	if translator, ok := db.Dialector.(gorm.ErrorTranslator); ok {
+		return errors.Is(translator.Translate(err), gorm.ErrDuplicatedKey)
+	}
+	return false
+}
+
+// WithTx returns the repository working in the transaction tx, with the cache seen through store
+func (r *loanRepository) WithTx(tx *gorm.DB, store cache.Store) LoanRepository {
+	return &loanRepository{db: tx, cache: store}
+}
+
+func (r *loanRepository) withAssociations(ctx context.Context) *gorm.DB {
+	return r.db.WithContext(ctx).Preload("Reader").Preload("Book.User").Preload("Item").Preload("CheckedOutBy")
+}
+
+// Checkout stores the loan, marks the copy as lent and puts the book on the reader's
+// currently reading list in one transaction. It returns ErrAlreadyLent when the
+// copy is not available (any more).
+func (r *loanRepository) Checkout(ctx context.Context, loan *models.Loan) error {
+	log.Printf("LoanRepository.Checkout: lending book ID=%d to reader ID=%d", loan.BookID, loan.ReaderID)
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Reader", "Book", "Item", "CheckedOutBy").Create(loan).Error; err != nil {
+			if isDuplicateKey(tx, err) {
+				return ErrAlreadyLent
+			}
+			return err
+		}
+		if loan.ItemID != nil {
+			result := tx.Model(&models.Item{}).
+				Where("id = ? AND status = ?", *loan.ItemID, models.ItemStatusAvailable).
+				Update("status", models.ItemStatusOnLoan)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return ErrAlreadyLent
+			}
+		}
+		reader := models.Reader{Model: gorm.Model{ID: loan.ReaderID}}
+		book := models.Book{Model: gorm.Model{ID: loan.BookID}}
+		return tx.Model(&reader).Association("CurrentlyReading").Append(&book)
+	})
+	if err != nil {
+		log.Printf("LoanRepository.Checkout: error creating loan: %v", err)
+		return err
+	}
+	log.Printf("LoanRepository.Checkout: loan created successfully with ID=%d", loan.ID)
+	r.invalidate(loan)
+	return nil
+}
+
+// Return closes the loan, puts the copy back on the shelf and removes the book from
+// the reader's currently reading list in one transaction. It returns
+// ErrLoanReturned when the loan is closed already.
+func (r *loanRepository) Return(ctx context.Context, loan *models.Loan, returnedAt time.Time) error {
+	log.Printf("LoanRepository.Return: returning loan ID=%d", loan.ID)
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.Loan{}).Where("id = ? AND returned_at IS NULL", loan.ID).Update("returned_at", returnedAt)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrLoanReturned
+		}
+		if loan.ItemID != nil {
+			if err := tx.Model(&models.Item{}).Where("id = ?", *loan.ItemID).Update("status", models.ItemStatusAvailable).Error; err != nil {
+				return err
+			}
+		}
+		reader := models.Reader{Model: gorm.Model{ID: loan.ReaderID}}
+		book := models.Book{Model: gorm.Model{ID: loan.BookID}}
+		return tx.Model(&reader).Association("CurrentlyReading").Delete(&book)
+	})
+	if err != nil {
+		log.Printf("LoanRepository.Return: error returning loan ID=%d: %v", loan.ID, err)
+		return err
+	}
+	loan.ReturnedAt = &returnedAt
+	log.Printf("LoanRepository.Return: loan ID=%d returned successfully", loan.ID)
+	r.invalidate(loan)
+	return nil
+}
+
+func (r *loanRepository) FindAll(ctx context.Context, filter LoanFilter) ([]models.Loan, error) {
+	log.Printf("LoanRepository.FindAll: fetching loans (reader=%d, book=%d, status='%s')", filter.ReaderID, filter.BookID, filter.Status)
+
+	query := r.withAssociations(ctx)
+	if filter.ReaderID != 0 {
+		query = query.Where("reader_id = ?", filter.ReaderID)
+	}
+	if filter.BookID != 0 {
+		query = query.Where("book_id = ?", filter.BookID)
+	}
+	switch filter.Status {
+	case models.LoanStatusActive:
+		query = query.Where("returned_at IS NULL")
+	case models.LoanStatusOverdue:
+		query = query.Where("returned_at IS NULL AND due_at < ?", time.Now())
+	case models.LoanStatusReturned:
+		query = query.Where("returned_at IS NOT NULL")
+	}
+
+	var loans []models.Loan
+	if err := query.Order("checked_out_at DESC").Find(&loans).Error; err != nil {
+		log.Printf("LoanRepository.FindAll: error fetching loans: %v", err)
+		return loans, err
+	}
+
+	log.Printf("LoanRepository.FindAll: found %d loans", len(loans))
+	return loans, nil
+}
+
+func (r *loanRepository) FindByID(ctx context.Context, id uint) (*models.Loan, error) {
+	log.Printf("LoanRepository.FindByID: fetching loan with ID=%d", id)
+	if cached, found := r.cache.Get(cache.LoanIDKey(id)); found {
+		log.Printf("LoanRepository.FindByID: returning cached loan with ID=%d", id)
+		// The cache holds a value, so that callers changing their loan, as
+		// Return does, cannot change the cached one
+		loan := cached.(models.Loan)
+		return &loan, nil
+	}
+
+	var loan models.Loan
+	if err := r.withAssociations(ctx).First(&loan, id).Error; err != nil {
+		log.Printf("LoanRepository.FindByID: error fetching loan with ID=%d: %v", id, err)
+		return nil, err
+	}
+
+	log.Printf("LoanRepository.FindByID: found loan with ID=%d from database", id)
+	r.cache.Set(cache.LoanIDKey(id), loan)
+	return &loan, nil
+}
+
+// FindActiveByBook returns an active loan of the book, or gorm.ErrRecordNotFound if none of it is lent
+func (r *loanRepository) FindActiveByBook(ctx context.Context, bookID uint) (*models.Loan, error) {
+	log.Printf("LoanRepository.FindActiveByBook: fetching active loan for book ID=%d", bookID)
+	var loan models.Loan
+	err := r.withAssociations(ctx).Where("book_id = ? AND returned_at IS NULL", bookID).First(&loan).Error
+	if err != nil {
+		return nil, err
+	}
+	return &loan, nil
+}
+
+// FindByReader returns the full loan history of a reader, including returned loans
+func (r *loanRepository) FindByReader(ctx context.Context, readerID uint) ([]models.Loan, error) {
+	log.Printf("LoanRepository.FindByReader: fetching loan history for reader ID=%d", readerID)
+	if cached, found := r.cache.Get(cache.ReaderLoansKey(readerID)); found {
+		log.Printf("LoanRepository.FindByReader: returning cached loan history for reader ID=%d", readerID)
+		return cached.([]models.Loan), nil
+	}
+
+	var loans []models.Loan
+	err := r.withAssociations(ctx).Where("reader_id = ?", readerID).Order("checked_out_at DESC").Find(&loans).Error
+	if err != nil {
+		log.Printf("LoanRepository.FindByReader: error fetching loans for reader ID=%d: %v", readerID, err)
+		return loans, err
+	}
+
+	log.Printf("LoanRepository.FindByReader: found %d loans for reader ID=%d", len(loans), readerID)
+	r.cache.Set(cache.ReaderLoansKey(readerID), loans)
+	return loans, nil
+}
+
+func (r *loanRepository) invalidate(loan *models.Loan) {
+	r.cache.Invalidate(cache.LoanIDKey(loan.ID))
+	r.cache.Invalidate(cache.ReaderLoansKey(loan.ReaderID))
+	r.cache.Invalidate(cache.ReaderIDKey(loan.ReaderID))
+	r.cache.InvalidatePattern(cache.ReaderListKey())
+}
@@ -0,0 +1,146 @@
+package repository
+
+import (
+	"context"
+	"lab1/models"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// LoginAttemptSortFields are the columns GET /admin/login-attempts can be sorted by
+var LoginAttemptSortFields = []string{"id", "created_at", "username", "ip", "result"}
+
+// LoginAttemptPage is one page of the login attempts together with the total number of matches
+type LoginAttemptPage struct {
+	Attempts []models.LoginAttempt
+	Total    int64
+}
+
+// LoginAttemptRepository keeps the login attempt log and the failure counters
+// that throttle password guessing
+type LoginAttemptRepository interface {
+	Record(ctx context.Context, attempt *models.LoginAttempt) error
+	FindPage(ctx context.Context, query ListQuery) (*LoginAttemptPage, error)
+	PurgeBefore(ctx context.Context, t time.Time) error
+
+	GetThrottles(ctx context.Context, keys ...string) ([]models.LoginThrottle, error)
+	RecordFailure(ctx context.Context, key string, now, resetBefore time.Time) (*models.LoginThrottle, error)
+	Lock(ctx context.Context, key string, until time.Time) error
+	ResetThrottle(ctx context.Context, key string) error
+	PurgeThrottles(ctx context.Context, now, forgetBefore time.Time) error
+}
+
+type loginAttemptRepository struct {
+	db *gorm.DB
+}
+
+func NewLoginAttemptRepository(db *gorm.DB) LoginAttemptRepository {
+	return &loginAttemptRepository{db: db}
+}
+
+func (r *loginAttemptRepository) Record(ctx context.Context, attempt *models.LoginAttempt) error {
+	if err := r.db.WithContext(ctx).Create(attempt).Error; err != nil {
+		log.Printf("LoginAttemptRepository.Record: error recording login attempt of '%s': %v", attempt.Username, err)
+		return err
+	}
+	return nil
+}
+
+// FindPage lists attempts; Q matches username or IP address, Status the result
+func (r *loginAttemptRepository) FindPage(ctx context.Context, query ListQuery) (*LoginAttemptPage, error) {
+	db := r.db.WithContext(ctx).Model(&models.LoginAttempt{})
+	if query.Q != "" {
+		pattern := likePattern(query.Q)
+		db = db.Where(`(LOWER(login_attempts.username) LIKE ? ESCAPE '\' OR login_attempts.ip LIKE ? ESCAPE '\')`, pattern, pattern)
+	}
+	if query.Status != "" {
+		db = db.Where("login_attempts.result = ?", query.Status)
+	}
+	if query.CreatedAfter != nil {
+		db = db.Where("login_attempts.created_at > ?", *query.CreatedAfter)
+	}
+
+	page := &LoginAttemptPage{}
+	if err := db.Count(&page.Total).Error; err != nil {
+		log.Printf("LoginAttemptRepository.FindPage: error counting login attempts: %v", err)
+		return nil, err
+	}
+	if err := paginate(db, "login_attempts", LoginAttemptSortFields, query).Find(&page.Attempts).Error; err != nil {
+		log.Printf("LoginAttemptRepository.FindPage: error fetching login attempts: %v", err)
+		return nil, err
+	}
+	return page, nil
+}
+
+func (r *loginAttemptRepository) PurgeBefore(ctx context.Context, t time.Time) error {
+	err := r.db.WithContext(ctx).Where("created_at < ?", t).Delete(&models.LoginAttempt{}).Error
+	if err != nil {
+		log.Printf("LoginAttemptRepository.PurgeBefore: error deleting old login attempts: %v", err)
+	}
+	return err
+}
+
+// GetThrottles returns the counters of the keys that have failures; keys
+// without a row have none
+func (r *loginAttemptRepository) GetThrottles(ctx context.Context, keys ...string) ([]models.LoginThrottle, error) {
+	var throttles []models.LoginThrottle
+	err := r.db.WithContext(ctx).Where("key IN ?", keys).Find(&throttles).Error
+	if err != nil {
+		log.Printf("LoginAttemptRepository.GetThrottles: error fetching throttles: %v", err)
+	}
+	return throttles, err
+}
+
+// RecordFailure counts a failed login of the key and returns the new counter.
+// Counters whose last failure is before resetBefore start again at one. The
+// increment happens in the database, so concurrent guesses are all counted.
+func (r *loginAttemptRepository) RecordFailure(ctx context.Context, key string, now, resetBefore time.Time) (*models.LoginThrottle, error) {
+	throttle := models.LoginThrottle{Key: key, Failures: 1, LastFailureAt: now}
+	err := r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "key"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"failures":        gorm.Expr("CASE WHEN login_throttles.last_failure_at < ? THEN 1 ELSE login_throttles.failures + 1 END", resetBefore),
+			"last_failure_at": now,
+		}),
+	}).Create(&throttle).Error
+	if err == nil {
+		err = r.db.WithContext(ctx).Where("key = ?", key).First(&throttle).Error
+	}
+	if err != nil {
+		log.Printf("LoginAttemptRepository.RecordFailure: error counting failure of %s: %v", key, err)
+		return nil, err
+	}
+	return &throttle, nil
+}
+
+func (r *loginAttemptRepository) Lock(ctx context.Context, key string, until time.Time) error {
+	err := r.db.WithContext(ctx).Model(&models.LoginThrottle{}).Where("key = ?", key).Update("locked_until", until).Error
+	if err != nil {
+		log.Printf("LoginAttemptRepository.Lock: error locking %s: %v", key, err)
+	}
+	return err
+}
+
+// ResetThrottle forgets the failures of the key, which also lifts a lockout
+func (r *loginAttemptRepository) ResetThrottle(ctx context.Context, key string) error {
+	err := r.db.WithContext(ctx).Where("key = ?", key).Delete(&models.LoginThrottle{}).Error
+	if err != nil {
+		log.Printf("LoginAttemptRepository.ResetThrottle: error resetting %s: %v", key, err)
+	}
+	return err
+}
+
+// PurgeThrottles deletes the counters that no longer slow anyone down: the
+// last failure is before forgetBefore and a lockout, if any, has ended
+func (r *loginAttemptRepository) PurgeThrottles(ctx context.Context, now, forgetBefore time.Time) error {
+	err := r.db.WithContext(ctx).
+		Where("last_failure_at < ? AND (locked_until IS NULL OR locked_until <= ?)", forgetBefore, now).
+		Delete(&models.LoginThrottle{}).Error
+	if err != nil {
+		log.Printf("LoginAttemptRepository.PurgeThrottles: error deleting expired throttles: %v", err)
+	}
+	return err
+}
@@ -0,0 +1,213 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"lab1/cache"
+	"lab1/models"
+	"log"
+
+	"gorm.io/gorm"
+)
+
+// ReferencedError is returned by NameRepository.Delete while books link to the record
+type ReferencedError struct {
+	Books int64 // the linked books, including those in the trash
+}
+
+func (e *ReferencedError) Error() string {
+	return fmt.Sprintf("record is still referenced by %d books", e.Books)
+}
+
+func (e *ReferencedError) Is(target error) bool {
+	return target == ErrStillReferenced
+}
+
+// NameSortFields are the columns GET /authors, /publishers and /subjects can be sorted by
+var NameSortFields = []string{"id", "name", "created_at"}
+
+// NamePage is one page of a listing of named records together with the total number of matches
+type NamePage[T any] struct {
+	Records []T
+	Total   int64
+}
+
+// NameRepository stores the records books link to by name, see models.Named
+type NameRepository[T any] interface {
+	Create(ctx context.Context, record *T) error
+	FindPage(ctx context.Context, query ListQuery) (*NamePage[T], error)
+	FindByID(ctx context.Context, id uint) (*T, error)
+	FindByIDs(ctx context.Context, ids []uint) ([]T, error)
+	Update(ctx context.Context, record *T) error
+	Delete(ctx context.Context, id uint) error
+}
+
+type (
+	AuthorRepository    = NameRepository[models.Author]
+	PublisherRepository = NameRepository[models.Publisher]
+	SubjectRepository   = NameRepository[models.Subject]
+)
+
+// nameTable describes where one kind of named record is stored
+type nameTable struct {
+	label  string // "Author", for log messages
+	table  string // "authors", also the prefix of its cache keys
+	links  string // the table linking it to books, "book_authors"
+	column string // its column in links, "author_id"
+}
+
+type nameRepository[T any, P models.NamedPointer[T]] struct {
+	db       *gorm.DB
+	cache    *cache.Cache
+	fullText bool
+	nameTable
+}
+
+func newNameRepository[T any, P models.NamedPointer[T]](db *gorm.DB, cache *cache.Cache, table nameTable) NameRepository[T] {
+	return &nameRepository[T, P]{db: db, cache: cache, fullText: hasBookSearchIndex(db), nameTable: table}
+}
+
+func NewAuthorRepository(db *gorm.DB, cache *cache.Cache) AuthorRepository {
+	return newNameRepository[models.Author](db, cache, nameTable{label: "Author", table: "authors", links: "book_authors", column: "author_id"})
+}
+
+func NewPublisherRepository(db *gorm.DB, cache *cache.Cache) PublisherRepository {
+	return newNameRepository[models.Publisher](db, cache, nameTable{label: "Publisher", table: "publishers", links: "book_publishers", column: "publisher_id"})
+}
+
+func NewSubjectRepository(db *gorm.DB, cache *cache.Cache) SubjectRepository {
+	return newNameRepository[models.Subject](db, cache, nameTable{label: "Subject", table: "subjects", links: "book_subjects", column: "subject_id"})
+}
+
+func (r *nameRepository[T, P]) logf(format string, args ...interface{}) {
+	log.Printf(r.label+"Repository."+format, args...)
+}
+
+func (r *nameRepository[T, P]) Create(ctx context.Context, record *T) error {
+	r.logf("Create: creating record with name='%s'", P(record).GetName())
+	err := r.db.WithContext(ctx).Create(record).Error
+	if err != nil {
+		r.logf("Create: error creating record: %v", err)
+		return err
+	}
+	r.logf("Create: record created successfully with ID=%d", P(record).GetID())
+	r.cache.InvalidatePattern(cache.NameListKey(r.table))
+	return nil
+}
+
+func (r *nameRepository[T, P]) FindPage(ctx context.Context, query ListQuery) (*NamePage[T], error) {
+	r.logf("FindPage: fetching %s (%s)", r.table, query.Key())
+	key := cache.NameQueryKey(r.table, query.Key())
+	if cached, found := r.cache.Get(key); found {
+		r.logf("FindPage: returning cached page")
+		return cached.(*NamePage[T]), nil
+	}
+
+	db := r.db.WithContext(ctx).Model(new(T))
+	if query.Q != "" {
+		db = db.Where(`LOWER(`+r.table+`.name) LIKE ? ESCAPE '\'`, likePattern(query.Q))
+	}
+	if query.CreatedAfter != nil {
+		db = db.Where(r.table+".created_at > ?", *query.CreatedAfter)
+	}
+
+	page := &NamePage[T]{}
+	if err := db.Count(&page.Total).Error; err != nil {
+		r.logf("FindPage: error counting %s: %v", r.table, err)
+		return nil, err
+	}
+	if err := paginate(db, r.table, NameSortFields, query).Find(&page.Records).Error; err != nil {
+		r.logf("FindPage: error fetching %s: %v", r.table, err)
+		return nil, err
+	}
+
+	r.logf("FindPage: found %d of %d %s from database", len(page.Records), page.Total, r.table)
+	r.cache.Set(key, page)
+	return page, nil
+}
+
+func (r *nameRepository[T, P]) FindByID(ctx context.Context, id uint) (*T, error) {
+	r.logf("FindByID: fetching record with ID=%d", id)
+	if cached, found := r.cache.Get(cache.NameIDKey(r.table, id)); found {
+		r.logf("FindByID: returning cached record with ID=%d", id)
+		// The cache holds a value, so that callers renaming their record cannot change the cached one
+		record := cached.(T)
+		return &record, nil
+	}
+
+	var record T
+	err := r.db.WithContext(ctx).First(&record, id).Error
+	if err != nil {
+		r.logf("FindByID: error fetching record with ID=%d: %v", id, err)
+		return nil, err
+	}
+
+	r.logf("FindByID: found record with ID=%d from database", id)
+	r.cache.Set(cache.NameIDKey(r.table, id), record)
+	return &record, nil
+}
+
+// FindByIDs returns the existing records among ids; callers compare lengths to detect unknown IDs
+func (r *nameRepository[T, P]) FindByIDs(ctx context.Context, ids []uint) ([]T, error) {
+	var records []T
+	if len(ids) == 0 {
+		return records, nil
+	}
+	err := r.db.WithContext(ctx).Where("id IN ?", ids).Order("id ASC").Find(&records).Error
+	if err != nil {
+		r.logf("FindByIDs: error fetching %s: %v", r.table, err)
+	}
+	return records, err
+}
+
+func (r *nameRepository[T, P]) Update(ctx context.Context, record *T) error {
+	id := P(record).GetID()
+	r.logf("Update: updating record with ID=%d, name='%s'", id, P(record).GetName())
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Books").Save(record).Error; err != nil {
+			return err
+		}
+		// The name is part of the search index of every book linked to the record
+		if !r.fullText {
+			return nil
+		}
+		return reindexBooks(tx, fmt.Sprintf("books.id IN (SELECT book_id FROM %s WHERE %s = ?)", r.links, r.column), id)
+	})
+	if err != nil {
+		r.logf("Update: error updating record with ID=%d: %v", id, err)
+		return err
+	}
+	r.logf("Update: record with ID=%d updated successfully", id)
+	r.invalidate(id)
+	return nil
+}
+
+// Delete removes a record no book links to. Books in the trash count, since
+// restoring them would bring back the link.
+func (r *nameRepository[T, P]) Delete(ctx context.Context, id uint) error {
+	r.logf("Delete: deleting record with ID=%d", id)
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var books int64
+		if err := tx.Table(r.links).Where(r.column+" = ?", id).Count(&books).Error; err != nil {
+			return err
+		}
+		if books > 0 {
+			return &ReferencedError{Books: books}
+		}
+		return tx.Delete(new(T), id).Error
+	})
+	if err != nil {
+		r.logf("Delete: error deleting record with ID=%d: %v", id, err)
+		return err
+	}
+	r.logf("Delete: record with ID=%d deleted successfully", id)
+	r.invalidate(id)
+	return nil
+}
+
+func (r *nameRepository[T, P]) invalidate(id uint) {
+	r.cache.Invalidate(cache.NameIDKey(r.table, id))
+	r.cache.InvalidatePattern(cache.NameListKey(r.table))
+	r.cache.InvalidatePattern("books:") // book responses embed the names
+	r.cache.InvalidatePattern("readers:")
+}
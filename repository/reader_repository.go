@@ -1,46 +1,80 @@
 package repository
 
 import (
+	"context"
 	"lab1/cache"
 	"lab1/models"
 	"log"
+	"time"
 
 	"gorm.io/gorm"
 )
 
+// ReaderSortFields are the columns GET /readers can be sorted by
+var ReaderSortFields = []string{"id", "name", "surname", "created_at", "updated_at"}
+
+// ReaderPage is one page of a reader listing together with the total number of matches
+type ReaderPage struct {
+	Readers []models.Reader
+	Total   int64
+}
+
 type ReaderRepository interface {
-	Create(reader *models.Reader) error
-	FindAll() ([]models.Reader, error)
-	FindByID(id uint) (*models.Reader, error)
-	Update(reader *models.Reader) error
-	Delete(id uint) error
-	DeleteAll() error
-	AddCurrentlyReading(readerID uint, book *models.Book) error
-	RemoveCurrentlyReading(readerID uint, bookID uint) error
+	Create(ctx context.Context, reader *models.Reader) error
+	FindAll(ctx context.Context) ([]models.Reader, error)
+	FindPage(ctx context.Context, query ListQuery) (*ReaderPage, error)
+	FindByID(ctx context.Context, id uint) (*models.Reader, error)
+	Update(ctx context.Context, reader *models.Reader) error
+	FindRevisions(ctx context.Context, readerID uint, query ListQuery) (*RevisionPage, error)
+	FindRevision(ctx context.Context, readerID uint, number int) (*Revision, error)
+	Delete(ctx context.Context, id uint) error
+	DeleteAll(ctx context.Context) error
+	FindDeletedPage(ctx context.Context, query ListQuery) (*ReaderPage, error)
+	FindDeleted(ctx context.Context, id uint) (*models.Reader, error)
+	Restore(ctx context.Context, id uint) error
+	Purge(ctx context.Context, id uint) error
+	PurgeDeletedBefore(ctx context.Context, before time.Time) (int64, error)
+	AddCurrentlyReading(ctx context.Context, readerID uint, book *models.Book) error
+	RemoveCurrentlyReading(ctx context.Context, readerID uint, bookID uint) error
+	IsBookTaken(ctx context.Context, bookID uint) (bool, error)
+	WithTx(tx *gorm.DB, store cache.Store) ReaderRepository
+}
+
+// withReadingList preloads the books a reader response shows
+func withReadingList(db *gorm.DB) *gorm.DB {
+	return db.Preload("CurrentlyReading.User").
+		Preload("CurrentlyReading.Authors").
+		Preload("CurrentlyReading.Publishers").
+		Preload("CurrentlyReading.Subjects")
 }
 
 type readerRepository struct {
 	db    *gorm.DB
-	cache *cache.Cache
+	cache cache.Store
 }
 
 func NewReaderRepository(db *gorm.DB, cache *cache.Cache) ReaderRepository {
 	return &readerRepository{db: db, cache: cache}
 }
 
-func (r *readerRepository) Create(reader *models.Reader) error {
+// WithTx returns the repository working in the transaction tx, with the cache seen through store
+func (r *readerRepository) WithTx(tx *gorm.DB, store cache.Store) ReaderRepository {
+	return &readerRepository{db: tx, cache: store}
+}
+
+func (r *readerRepository) Create(ctx context.Context, reader *models.Reader) error {
 	log.Printf("ReaderRepository.Create: creating reader with name='%s %s'", reader.Name, reader.Surname)
-	err := r.db.Create(reader).Error
+	err := r.db.WithContext(ctx).Create(reader).Error
 	if err != nil {
 		log.Printf("ReaderRepository.Create: error creating reader: %v", err)
 		return err
 	}
 	log.Printf("ReaderRepository.Create: reader created successfully with ID=%d", reader.ID)
-	r.cache.Invalidate(cache.ReaderListKey())
+	r.cache.InvalidatePattern(cache.ReaderListKey())
 	return nil
 }
 
-func (r *readerRepository) FindAll() ([]models.Reader, error) {
+func (r *readerRepository) FindAll(ctx context.Context) ([]models.Reader, error) {
 	log.Printf("ReaderRepository.FindAll: fetching all readers")
 	if cached, found := r.cache.Get(cache.ReaderListKey()); found {
 		log.Printf("ReaderRepository.FindAll: returning cached readers")
@@ -48,7 +82,7 @@ func (r *readerRepository) FindAll() ([]models.Reader, error) {
 	}
 
 	var readers []models.Reader
-	err := r.db.Preload("CurrentlyReading.User").Find(&readers).Error
+	err := withReadingList(r.db.WithContext(ctx)).Find(&readers).Error
 	if err != nil {
 		log.Printf("ReaderRepository.FindAll: error fetching readers: %v", err)
 		return readers, err
@@ -59,55 +93,119 @@ func (r *readerRepository) FindAll() ([]models.Reader, error) {
 	return readers, nil
 }
 
-func (r *readerRepository) FindByID(id uint) (*models.Reader, error) {
+func (r *readerRepository) FindPage(ctx context.Context, query ListQuery) (*ReaderPage, error) {
+	log.Printf("ReaderRepository.FindPage: fetching readers (%s)", query.Key())
+	key := cache.ReaderQueryKey(query.Key())
+	if cached, found := r.cache.Get(key); found {
+		log.Printf("ReaderRepository.FindPage: returning cached page")
+		return cached.(*ReaderPage), nil
+	}
+
+	db := r.db.WithContext(ctx).Model(&models.Reader{})
+	if query.Q != "" {
+		pattern := likePattern(query.Q)
+		db = db.Where(`LOWER(readers.name) LIKE ? ESCAPE '\' OR LOWER(readers.surname) LIKE ? ESCAPE '\' OR LOWER(readers.name || ' ' || readers.surname) LIKE ? ESCAPE '\'`, pattern, pattern, pattern)
+	}
+	if query.CreatedAfter != nil {
+		db = db.Where("readers.created_at > ?", *query.CreatedAfter)
+	}
+
+	page := &ReaderPage{}
+	if err := db.Count(&page.Total).Error; err != nil {
+		log.Printf("ReaderRepository.FindPage: error counting readers: %v", err)
+		return nil, err
+	}
+	if err := withReadingList(paginate(db, "readers", ReaderSortFields, query)).Find(&page.Readers).Error; err != nil {
+		log.Printf("ReaderRepository.FindPage: error fetching readers: %v", err)
+		return nil, err
+	}
+
+	log.Printf("ReaderRepository.FindPage: found %d of %d readers from database", len(page.Readers), page.Total)
+	r.cache.Set(key, page)
+	return page, nil
+}
+
+func (r *readerRepository) FindByID(ctx context.Context, id uint) (*models.Reader, error) {
 	log.Printf("ReaderRepository.FindByID: fetching reader with ID=%d", id)
 	if cached, found := r.cache.Get(cache.ReaderIDKey(id)); found {
 		log.Printf("ReaderRepository.FindByID: returning cached reader with ID=%d", id)
-		reader := cached.(*models.Reader)
-		return reader, nil
+		// The cache holds a value, so that callers changing their reader, even
+		// in a transaction that rolls back, cannot change the cached one
+		reader := cached.(models.Reader)
+		return &reader, nil
 	}
 
 	var reader models.Reader
-	err := r.db.Preload("CurrentlyReading.User").First(&reader, id).Error
+	err := withReadingList(r.db.WithContext(ctx)).First(&reader, id).Error
 	if err != nil {
 		log.Printf("ReaderRepository.FindByID: error fetching reader with ID=%d: %v", id, err)
 		return nil, err
 	}
 
 	log.Printf("ReaderRepository.FindByID: found reader with ID=%d from database", id)
-	r.cache.Set(cache.ReaderIDKey(id), &reader)
+	r.cache.Set(cache.ReaderIDKey(id), reader)
 	return &reader, nil
 }
 
-func (r *readerRepository) Update(reader *models.Reader) error {
+func (r *readerRepository) Update(ctx context.Context, reader *models.Reader) error {
 	log.Printf("ReaderRepository.Update: updating reader with ID=%d, name='%s %s'", reader.ID, reader.Name, reader.Surname)
-	err := r.db.Save(reader).Error
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := readerRevisions.baseline(tx, reader.ID, func() (interface{}, error) {
+			var stored models.Reader
+			err := tx.First(&stored, reader.ID).Error
+			return models.NewReaderVersion(&stored), err
+		})
+		if err != nil {
+			return err
+		}
+		if err := tx.Save(reader).Error; err != nil {
+			return err
+		}
+		return readerRevisions.record(tx, reader.ID, models.NewReaderVersion(reader))
+	})
 	if err != nil {
 		log.Printf("ReaderRepository.Update: error updating reader with ID=%d: %v", reader.ID, err)
 		return err
 	}
 	log.Printf("ReaderRepository.Update: reader with ID=%d updated successfully", reader.ID)
 	r.cache.Invalidate(cache.ReaderIDKey(reader.ID))
-	r.cache.Invalidate(cache.ReaderListKey())
+	r.cache.InvalidatePattern(cache.ReaderListKey())
 	return nil
 }
 
-func (r *readerRepository) Delete(id uint) error {
+// FindRevisions lists the saved versions of a reader; Update adds one with every change
+func (r *readerRepository) FindRevisions(ctx context.Context, readerID uint, query ListQuery) (*RevisionPage, error) {
+	page, err := readerRevisions.page(r.db.WithContext(ctx), readerID, query)
+	if err != nil {
+		log.Printf("ReaderRepository.FindRevisions: error fetching revisions of reader ID=%d: %v", readerID, err)
+	}
+	return page, err
+}
+
+func (r *readerRepository) FindRevision(ctx context.Context, readerID uint, number int) (*Revision, error) {
+	revision, err := readerRevisions.find(r.db.WithContext(ctx), readerID, number)
+	if err != nil {
+		log.Printf("ReaderRepository.FindRevision: error fetching revision %d of reader ID=%d: %v", number, readerID, err)
+	}
+	return revision, err
+}
+
+func (r *readerRepository) Delete(ctx context.Context, id uint) error {
 	log.Printf("ReaderRepository.Delete: deleting reader with ID=%d", id)
-	err := r.db.Delete(&models.Reader{}, id).Error
+	err := r.db.WithContext(ctx).Delete(&models.Reader{}, id).Error
 	if err != nil {
 		log.Printf("ReaderRepository.Delete: error deleting reader with ID=%d: %v", id, err)
 		return err
 	}
 	log.Printf("ReaderRepository.Delete: reader with ID=%d deleted successfully", id)
 	r.cache.Invalidate(cache.ReaderIDKey(id))
-	r.cache.Invalidate(cache.ReaderListKey())
+	r.cache.InvalidatePattern(cache.ReaderListKey())
 	return nil
 }
 
-func (r *readerRepository) DeleteAll() error {
+func (r *readerRepository) DeleteAll(ctx context.Context) error {
 	log.Printf("ReaderRepository.DeleteAll: deleting all readers")
-	err := r.db.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&models.Reader{}).Error
+	err := r.db.WithContext(ctx).Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&models.Reader{}).Error
 	if err != nil {
 		log.Printf("ReaderRepository.DeleteAll: error deleting all readers: %v", err)
 		return err
@@ -117,31 +215,136 @@ func (r *readerRepository) DeleteAll() error {
 	return nil
 }
 
-func (r *readerRepository) AddCurrentlyReading(readerID uint, book *models.Book) error {
+// FindDeletedPage lists the readers in the trash, i.e. the soft-deleted ones.
+// The trash is not cached.
+func (r *readerRepository) FindDeletedPage(ctx context.Context, query ListQuery) (*ReaderPage, error) {
+	log.Printf("ReaderRepository.FindDeletedPage: fetching deleted readers (%s)", query.Key())
+	db := trashed(r.db.WithContext(ctx).Model(&models.Reader{}), "readers")
+	if query.Q != "" {
+		pattern := likePattern(query.Q)
+		db = db.Where(`LOWER(readers.name) LIKE ? ESCAPE '\' OR LOWER(readers.surname) LIKE ? ESCAPE '\'`, pattern, pattern)
+	}
+
+	page := &ReaderPage{}
+	if err := db.Count(&page.Total).Error; err != nil {
+		log.Printf("ReaderRepository.FindDeletedPage: error counting deleted readers: %v", err)
+		return nil, err
+	}
+	if err := withReadingList(paginate(db, "readers", ReaderTrashSortFields, query)).Find(&page.Readers).Error; err != nil {
+		log.Printf("ReaderRepository.FindDeletedPage: error fetching deleted readers: %v", err)
+		return nil, err
+	}
+	return page, nil
+}
+
+// FindDeleted fetches a reader from the trash
+func (r *readerRepository) FindDeleted(ctx context.Context, id uint) (*models.Reader, error) {
+	var reader models.Reader
+	err := withReadingList(trashed(r.db.WithContext(ctx), "readers")).First(&reader, id).Error
+	if err != nil {
+		log.Printf("ReaderRepository.FindDeleted: error fetching deleted reader with ID=%d: %v", id, err)
+		return nil, err
+	}
+	return &reader, nil
+}
+
+// Restore takes a reader out of the trash, gorm.ErrRecordNotFound if it is not there
+func (r *readerRepository) Restore(ctx context.Context, id uint) error {
+	log.Printf("ReaderRepository.Restore: restoring reader with ID=%d", id)
+	result := trashed(r.db.WithContext(ctx).Model(&models.Reader{}), "readers").Where("id = ?", id).Update("deleted_at", nil)
+	err := result.Error
+	if err == nil && result.RowsAffected == 0 {
+		err = gorm.ErrRecordNotFound
+	}
+	if err != nil {
+		log.Printf("ReaderRepository.Restore: error restoring reader with ID=%d: %v", id, err)
+		return err
+	}
+	log.Printf("ReaderRepository.Restore: reader with ID=%d restored successfully", id)
+	r.cache.Invalidate(cache.ReaderIDKey(id))
+	r.cache.InvalidatePattern(cache.ReaderListKey())
+	return nil
+}
+
+// Purge removes a reader from the trash for good, together with their reading
+// list and revisions. Readers with loans, holds or account transactions fail
+// with ErrStillReferenced.
+func (r *readerRepository) Purge(ctx context.Context, id uint) error {
+	log.Printf("ReaderRepository.Purge: purging reader with ID=%d", id)
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := purgeable(tx, &models.Reader{}, "readers", "reader_id", id, readerReferences...); err != nil {
+			return err
+		}
+		return purgeReaders(tx, []uint{id})
+	})
+	if err != nil {
+		log.Printf("ReaderRepository.Purge: error purging reader with ID=%d: %v", id, err)
+		return err
+	}
+	log.Printf("ReaderRepository.Purge: reader with ID=%d purged successfully", id)
+	return nil
+}
+
+// PurgeDeletedBefore purges the readers deleted before the given moment, skipping
+// those still referenced, and returns how many were purged
+func (r *readerRepository) PurgeDeletedBefore(ctx context.Context, before time.Time) (int64, error) {
+	var ids []uint
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		db := trashed(tx.Model(&models.Reader{}), "readers").Where("readers.deleted_at < ?", before)
+		if err := unreferenced(db, "readers", "reader_id", readerReferences...).Pluck("readers.id", &ids).Error; err != nil {
+			return err
+		}
+		return purgeReaders(tx, ids)
+	})
+	if err != nil {
+		log.Printf("ReaderRepository.PurgeDeletedBefore: error purging readers deleted before %s: %v", before.Format(time.RFC3339), err)
+		return 0, err
+	}
+	if len(ids) > 0 {
+		log.Printf("ReaderRepository.PurgeDeletedBefore: purged %d readers deleted before %s", len(ids), before.Format(time.RFC3339))
+	}
+	return int64(len(ids)), nil
+}
+
+// purgeReaders hard-deletes readers along with their reading lists and revisions
+func purgeReaders(tx *gorm.DB, ids []uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	if err := tx.Exec("DELETE FROM reader_books WHERE reader_id IN ?", ids).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("reader_id IN ?", ids).Delete(&models.ReaderRevision{}).Error; err != nil {
+		return err
+	}
+	return tx.Unscoped().Delete(&models.Reader{}, ids).Error
+}
+
+func (r *readerRepository) AddCurrentlyReading(ctx context.Context, readerID uint, book *models.Book) error {
 	log.Printf("ReaderRepository.AddCurrentlyReading: adding book ID=%d to reader ID=%d", book.ID, readerID)
 	
 	var reader models.Reader
-	if err := r.db.First(&reader, readerID).Error; err != nil {
+	if err := r.db.WithContext(ctx).First(&reader, readerID).Error; err != nil {
 		log.Printf("ReaderRepository.AddCurrentlyReading: error finding reader: %v", err)
 		return err
 	}
 
-	if err := r.db.Model(&reader).Association("CurrentlyReading").Append(book); err != nil {
+	if err := r.db.WithContext(ctx).Model(&reader).Association("CurrentlyReading").Append(book); err != nil {
 		log.Printf("ReaderRepository.AddCurrentlyReading: error adding book: %v", err)
 		return err
 	}
 
 	log.Printf("ReaderRepository.AddCurrentlyReading: book added successfully")
 	r.cache.Invalidate(cache.ReaderIDKey(readerID))
-	r.cache.Invalidate(cache.ReaderListKey())
+	r.cache.InvalidatePattern(cache.ReaderListKey())
 	return nil
 }
 
-func (r *readerRepository) RemoveCurrentlyReading(readerID uint, bookID uint) error {
+func (r *readerRepository) RemoveCurrentlyReading(ctx context.Context, readerID uint, bookID uint) error {
 	log.Printf("ReaderRepository.RemoveCurrentlyReading: removing book ID=%d from reader ID=%d", bookID, readerID)
 	
 	var reader models.Reader
-	if err := r.db.First(&reader, readerID).Error; err != nil {
+	if err := r.db.WithContext(ctx).First(&reader, readerID).Error; err != nil {
 		log.Printf("ReaderRepository.RemoveCurrentlyReading: error finding reader: %v", err)
 		return err
 	}
@@ -149,13 +352,27 @@ func (r *readerRepository) RemoveCurrentlyReading(readerID uint, bookID uint) er
 	var book models.Book
 	book.ID = bookID
 
-	if err := r.db.Model(&reader).Association("CurrentlyReading").Delete(&book); err != nil {
+	if err := r.db.WithContext(ctx).Model(&reader).Association("CurrentlyReading").Delete(&book); err != nil {
 		log.Printf("ReaderRepository.RemoveCurrentlyReading: error removing book: %v", err)
 		return err
 	}
 
 	log.Printf("ReaderRepository.RemoveCurrentlyReading: book removed successfully")
 	r.cache.Invalidate(cache.ReaderIDKey(readerID))
-	r.cache.Invalidate(cache.ReaderListKey())
+	r.cache.InvalidatePattern(cache.ReaderListKey())
 	return nil
 }
+
+// IsBookTaken reports whether any reader currently has the book on their reading list
+func (r *readerRepository) IsBookTaken(ctx context.Context, bookID uint) (bool, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Table("reader_books").
+		Joins("JOIN readers ON readers.id = reader_books.reader_id AND readers.deleted_at IS NULL").
+		Where("reader_books.book_id = ?", bookID).
+		Count(&count).Error
+	if err != nil {
+		log.Printf("ReaderRepository.IsBookTaken: error checking book ID=%d: %v", bookID, err)
+		return false, err
+	}
+	return count > 0, nil
+}
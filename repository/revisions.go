@@ -0,0 +1,112 @@
+package repository
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RevisionSortFields are the columns a history listing can be sorted by
+var RevisionSortFields = []string{"id", "number", "created_at"}
+
+// Revision is a saved version of a book or reader; Data is the JSON of its
+// models.BookVersion or models.ReaderVersion
+type Revision struct {
+	Number    int
+	CreatedAt time.Time
+	Data      string
+}
+
+// RevisionPage is one page of a history together with the total number of revisions
+type RevisionPage struct {
+	Revisions []Revision
+	Total     int64
+}
+
+// revisionStore reads and appends the revisions of one entity type, which
+// live in table with the entity's ID in column
+type revisionStore struct {
+	table  string
+	column string
+}
+
+var (
+	bookRevisions   = revisionStore{table: "book_revisions", column: "book_id"}
+	readerRevisions = revisionStore{table: "reader_revisions", column: "reader_id"}
+)
+
+func (s revisionStore) of(db *gorm.DB, id uint) *gorm.DB {
+	return db.Table(s.table).Where(s.table+"."+s.column+" = ?", id)
+}
+
+// baseline makes the stored state the first revision of an entity that has
+// none yet, because it was created or last changed before revisions existed.
+// It must run before the update, current loads the stored version.
+func (s revisionStore) baseline(tx *gorm.DB, id uint, current func() (interface{}, error)) error {
+	var count int64
+	if err := s.of(tx, id).Count(&count).Error; err != nil || count > 0 {
+		return err
+	}
+	version, err := current()
+	if err != nil {
+		return err
+	}
+	_, err = s.insert(tx, id, 1, version)
+	return err
+}
+
+// record appends the version as the next revision unless it equals the latest one
+func (s revisionStore) record(tx *gorm.DB, id uint, version interface{}) error {
+	var latest Revision
+	err := s.of(tx, id).Select("number", "data").Order("number DESC").Limit(1).Take(&latest).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	data, err := json.Marshal(version)
+	if err != nil {
+		return err
+	}
+	if string(data) == latest.Data {
+		return nil
+	}
+	_, err = s.insert(tx, id, latest.Number+1, version)
+	return err
+}
+
+func (s revisionStore) insert(tx *gorm.DB, id uint, number int, version interface{}) (Revision, error) {
+	data, err := json.Marshal(version)
+	if err != nil {
+		return Revision{}, err
+	}
+	revision := Revision{Number: number, CreatedAt: time.Now(), Data: string(data)}
+	err = tx.Table(s.table).Create(map[string]interface{}{
+		s.column:     id,
+		"number":     revision.Number,
+		"created_at": revision.CreatedAt,
+		"data":       revision.Data,
+	}).Error
+	return revision, err
+}
+
+func (s revisionStore) page(db *gorm.DB, id uint, query ListQuery) (*RevisionPage, error) {
+	page := &RevisionPage{}
+	if err := s.of(db, id).Count(&page.Total).Error; err != nil {
+		return nil, err
+	}
+	err := paginate(s.of(db, id), s.table, RevisionSortFields, query).Select("number", "created_at", "data").Find(&page.Revisions).Error
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+func (s revisionStore) find(db *gorm.DB, id uint, number int) (*Revision, error) {
+	var revision Revision
+	if err := s.of(db, id).Where("number = ?", number).Select("number", "created_at", "data").Take(&revision).Error; err != nil {
+		return nil, err
+	}
+	return &revision, nil
+}
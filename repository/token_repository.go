@@ -0,0 +1,169 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"lab1/models"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrRefreshTokenExpired = errors.New("refresh token expired")
+	ErrRefreshTokenRevoked = errors.New("refresh token revoked")
+	// ErrRefreshTokenReused means an already rotated token was presented;
+	// its family has been revoked when it is returned
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+)
+
+type TokenRepository interface {
+	Create(ctx context.Context, token *models.RefreshToken) error
+	Rotate(ctx context.Context, tokenHash string, now time.Time) (*models.RefreshToken, error)
+	FindByAccessJTI(ctx context.Context, jti string) (*models.RefreshToken, error)
+	FindByHash(ctx context.Context, tokenHash string) (*models.RefreshToken, error)
+	RevokeAccess(ctx context.Context, jti string, expiresAt time.Time) error
+	RevokeFamily(ctx context.Context, familyID string, now time.Time) error
+	RevokeUser(ctx context.Context, userID uint, now time.Time) error
+	IsRevoked(ctx context.Context, jti string) (bool, error)
+	PurgeExpired(ctx context.Context, now time.Time) error
+}
+
+type tokenRepository struct {
+	db *gorm.DB
+}
+
+func NewTokenRepository(db *gorm.DB) TokenRepository {
+	return &tokenRepository{db: db}
+}
+
+func (r *tokenRepository) Create(ctx context.Context, token *models.RefreshToken) error {
+	if err := r.db.WithContext(ctx).Create(token).Error; err != nil {
+		log.Printf("TokenRepository.Create: error creating refresh token for user ID=%d: %v", token.UserID, err)
+		return err
+	}
+	return nil
+}
+
+// Rotate marks the token with the hash as used and returns it, so that a new
+// token of the same family can be issued. The check and the update are one
+// conditional statement, so two concurrent refreshes cannot both succeed.
+func (r *tokenRepository) Rotate(ctx context.Context, tokenHash string, now time.Time) (*models.RefreshToken, error) {
+	token, err := r.FindByHash(ctx, tokenHash)
+	if err != nil {
+		return nil, err
+	}
+
+	// A revoked token's whole family is revoked already
+	if token.RevokedAt != nil {
+		return nil, ErrRefreshTokenRevoked
+	}
+	if token.RotatedAt == nil {
+		if !now.Before(token.ExpiresAt) {
+			return nil, ErrRefreshTokenExpired
+		}
+		result := r.db.WithContext(ctx).Model(&models.RefreshToken{}).
+			Where("id = ? AND rotated_at IS NULL AND revoked_at IS NULL", token.ID).
+			Update("rotated_at", now)
+		if result.Error != nil {
+			log.Printf("TokenRepository.Rotate: error rotating refresh token ID=%d: %v", token.ID, result.Error)
+			return nil, result.Error
+		}
+		if result.RowsAffected == 1 {
+			token.RotatedAt = &now
+			return token, nil
+		}
+	}
+
+	log.Printf("TokenRepository.Rotate: refresh token ID=%d reused, revoking family %s of user ID=%d", token.ID, token.FamilyID, token.UserID)
+	if err := r.RevokeFamily(ctx, token.FamilyID, now); err != nil {
+		return nil, err
+	}
+	return nil, ErrRefreshTokenReused
+}
+
+// FindByAccessJTI returns the refresh token issued together with an access token
+func (r *tokenRepository) FindByAccessJTI(ctx context.Context, jti string) (*models.RefreshToken, error) {
+	var token models.RefreshToken
+	if err := r.db.WithContext(ctx).Where("access_jti = ?", jti).First(&token).Error; err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (r *tokenRepository) FindByHash(ctx context.Context, tokenHash string) (*models.RefreshToken, error) {
+	var token models.RefreshToken
+	if err := r.db.WithContext(ctx).Where("token_hash = ?", tokenHash).First(&token).Error; err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (r *tokenRepository) RevokeAccess(ctx context.Context, jti string, expiresAt time.Time) error {
+	err := r.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&models.RevokedToken{JTI: jti, ExpiresAt: expiresAt}).Error
+	if err != nil {
+		log.Printf("TokenRepository.RevokeAccess: error revoking access token %s: %v", jti, err)
+	}
+	return err
+}
+
+// RevokeFamily revokes all tokens descending from one login, together with the
+// access tokens issued alongside them
+func (r *tokenRepository) RevokeFamily(ctx context.Context, familyID string, now time.Time) error {
+	return r.revoke(ctx, now, "family_id = ?", familyID)
+}
+
+// RevokeUser ends every session of the user
+func (r *tokenRepository) RevokeUser(ctx context.Context, userID uint, now time.Time) error {
+	return r.revoke(ctx, now, "user_id = ?", userID)
+}
+
+func (r *tokenRepository) revoke(ctx context.Context, now time.Time, condition string, args ...interface{}) error {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var live []models.RefreshToken
+		err := tx.Where(condition, args...).
+			Where("access_jti <> '' AND access_expires_at > ?", now).
+			Find(&live).Error
+		if err != nil {
+			return err
+		}
+		for _, token := range live {
+			revoked := models.RevokedToken{JTI: token.AccessJTI, ExpiresAt: token.AccessExpiresAt}
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&revoked).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.Model(&models.RefreshToken{}).
+			Where(condition, args...).
+			Where("revoked_at IS NULL").
+			Update("revoked_at", now).Error
+	})
+	if err != nil {
+		log.Printf("TokenRepository.revoke: error revoking tokens (%s %v): %v", condition, args, err)
+	}
+	return err
+}
+
+func (r *tokenRepository) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&models.RevokedToken{}).Where("jti = ?", jti).Count(&count).Error
+	return count > 0, err
+}
+
+// PurgeExpired drops refresh tokens and revocations that can no longer matter.
+// Rotated tokens are kept until they expire, since they are needed to detect reuse.
+func (r *tokenRepository) PurgeExpired(ctx context.Context, now time.Time) error {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("expires_at <= ?", now).Delete(&models.RefreshToken{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("expires_at <= ?", now).Delete(&models.RevokedToken{}).Error
+	})
+	if err != nil {
+		log.Printf("TokenRepository.PurgeExpired: error purging tokens: %v", err)
+	}
+	return err
+}
@@ -0,0 +1,63 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// ErrStillReferenced is returned when purging a deleted record that loans,
+// holds or account transactions still refer to
+var ErrStillReferenced = errors.New("record is still referenced")
+
+// BookTrashSortFields are the columns GET /trash/books can be sorted by
+var BookTrashSortFields = []string{"id", "title", "created_at", "updated_at", "deleted_at"}
+
+// ReaderTrashSortFields are the columns GET /trash/readers can be sorted by
+var ReaderTrashSortFields = []string{"id", "name", "surname", "created_at", "updated_at", "deleted_at"}
+
+// trashed narrows db to the soft-deleted rows of table
+func trashed(db *gorm.DB, table string) *gorm.DB {
+	return db.Unscoped().Where(table + ".deleted_at IS NOT NULL")
+}
+
+// unreferenced narrows db to the rows of table that no row of the referencing
+// tables points to through column. Soft-deleted references count as well, since
+// purging would leave them dangling.
+func unreferenced(db *gorm.DB, table, column string, referencing ...string) *gorm.DB {
+	for _, other := range referencing {
+		db = db.Where(fmt.Sprintf("NOT EXISTS (SELECT 1 FROM %s WHERE %s.%s = %s.id)", other, other, column, table))
+	}
+	return db
+}
+
+// Rows of these tables keep a deleted book or reader from being purged
+var (
+	bookReferences   = []string{"loans", "holds"}
+	readerReferences = []string{"loans", "holds", "account_transactions"}
+)
+
+// purgeable checks that the row id of table is in the trash, failing with
+// gorm.ErrRecordNotFound when it is not and with ErrStillReferenced when a row
+// of the referencing tables points to it
+func purgeable(tx *gorm.DB, model interface{}, table, column string, id uint, referencing ...string) error {
+	var found int64
+	if err := trashed(tx.Model(model), table).Where(table+".id = ?", id).Count(&found).Error; err != nil {
+		return err
+	}
+	if found == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	var free int64
+	err := unreferenced(trashed(tx.Model(model), table), table, column, referencing...).
+		Where(table+".id = ?", id).
+		Count(&free).Error
+	if err != nil {
+		return err
+	}
+	if free == 0 {
+		return ErrStillReferenced
+	}
+	return nil
+}
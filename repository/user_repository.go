@@ -1,17 +1,34 @@
 package repository
 
 import (
+	"context"
 	"lab1/models"
+	"log"
 
 	"gorm.io/gorm"
 )
 
+// UserSortFields are the columns GET /admin/users can be sorted by
+var UserSortFields = []string{"id", "username", "email", "role", "created_at"}
+
+// UserPage is one page of a user listing together with the total number of matches
+type UserPage struct {
+	Users []models.User
+	Total int64
+}
+
 type UserRepository interface {
-	Create(user *models.User) error
-	GetByUsername(username string) (*models.User, error)
-	GetByEmail(email string) (*models.User, error)
-	GetByID(id uint) (*models.User, error)
-	GetAll() ([]models.User, error)
+	Create(ctx context.Context, user *models.User) error
+	GetByUsername(ctx context.Context, username string) (*models.User, error)
+	GetByEmail(ctx context.Context, email string) (*models.User, error)
+	GetByOIDCSubject(ctx context.Context, issuer, subject string) (*models.User, error)
+	GetByID(ctx context.Context, id uint) (*models.User, error)
+	GetAll(ctx context.Context) ([]models.User, error)
+	FindPage(ctx context.Context, query ListQuery) (*UserPage, error)
+	Taken(ctx context.Context, username, email string) (usernameTaken, emailTaken bool, err error)
+	Update(ctx context.Context, user *models.User) error
+	UseTOTPCounter(ctx context.Context, id uint, counter int64) (bool, error)
+	Delete(ctx context.Context, id uint) error
 }
 
 type userRepository struct {
@@ -22,39 +39,126 @@ func NewUserRepository(db *gorm.DB) UserRepository {
 	return &userRepository{db: db}
 }
 
-func (r *userRepository) Create(user *models.User) error {
-	return r.db.Create(user).Error
+func (r *userRepository) Create(ctx context.Context, user *models.User) error {
+	return r.db.WithContext(ctx).Create(user).Error
 }
 
-func (r *userRepository) GetByUsername(username string) (*models.User, error) {
+func (r *userRepository) GetByUsername(ctx context.Context, username string) (*models.User, error) {
 	var user models.User
-	err := r.db.Where("username = ?", username).First(&user).Error
+	err := r.db.WithContext(ctx).Where("username = ?", username).First(&user).Error
 	if err != nil {
 		return nil, err
 	}
 	return &user, nil
 }
 
-func (r *userRepository) GetByEmail(email string) (*models.User, error) {
+func (r *userRepository) GetByEmail(ctx context.Context, email string) (*models.User, error) {
 	var user models.User
-	err := r.db.Where("email = ?", email).First(&user).Error
+	err := r.db.WithContext(ctx).Where("email = ?", email).First(&user).Error
 	if err != nil {
 		return nil, err
 	}
 	return &user, nil
 }
 
-func (r *userRepository) GetByID(id uint) (*models.User, error) {
+// GetByOIDCSubject finds the user linked to an account at an identity provider
+func (r *userRepository) GetByOIDCSubject(ctx context.Context, issuer, subject string) (*models.User, error) {
 	var user models.User
-	err := r.db.First(&user, id).Error
+	err := r.db.WithContext(ctx).Where("oidc_issuer = ? AND oidc_subject = ?", issuer, subject).First(&user).Error
 	if err != nil {
 		return nil, err
 	}
 	return &user, nil
 }
 
-func (r *userRepository) GetAll() ([]models.User, error) {
+func (r *userRepository) GetByID(ctx context.Context, id uint) (*models.User, error) {
+	var user models.User
+	err := r.db.WithContext(ctx).First(&user, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *userRepository) GetAll(ctx context.Context) ([]models.User, error) {
 	var users []models.User
-	err := r.db.Find(&users).Error
+	err := r.db.WithContext(ctx).Find(&users).Error
 	return users, err
 }
+
+func (r *userRepository) FindPage(ctx context.Context, query ListQuery) (*UserPage, error) {
+	db := r.db.WithContext(ctx).Model(&models.User{})
+	if query.Q != "" {
+		pattern := likePattern(query.Q)
+		db = db.Where(`(LOWER(users.username) LIKE ? ESCAPE '\' OR LOWER(users.email) LIKE ? ESCAPE '\')`, pattern, pattern)
+	}
+	if query.Role != "" {
+		db = db.Where("users.role = ?", query.Role)
+	}
+	switch query.Status {
+	case "active":
+		db = db.Where("users.disabled_at IS NULL")
+	case "disabled":
+		db = db.Where("users.disabled_at IS NOT NULL")
+	}
+	if query.CreatedAfter != nil {
+		db = db.Where("users.created_at > ?", *query.CreatedAfter)
+	}
+
+	page := &UserPage{}
+	if err := db.Count(&page.Total).Error; err != nil {
+		log.Printf("UserRepository.FindPage: error counting users: %v", err)
+		return nil, err
+	}
+	if err := paginate(db, "users", UserSortFields, query).Find(&page.Users).Error; err != nil {
+		log.Printf("UserRepository.FindPage: error fetching users: %v", err)
+		return nil, err
+	}
+	return page, nil
+}
+
+// Taken reports whether the username or email belong to a user, including
+// deleted users, whose names stay reserved
+func (r *userRepository) Taken(ctx context.Context, username, email string) (usernameTaken, emailTaken bool, err error) {
+	var users []models.User
+	err = r.db.WithContext(ctx).Unscoped().Select("username", "email").
+		Where("username = ? OR email = ?", username, email).
+		Find(&users).Error
+	for _, user := range users {
+		usernameTaken = usernameTaken || user.Username == username
+		emailTaken = emailTaken || user.Email == email
+	}
+	return usernameTaken, emailTaken, err
+}
+
+func (r *userRepository) Update(ctx context.Context, user *models.User) error {
+	return r.db.WithContext(ctx).Save(user).Error
+}
+
+// UseTOTPCounter records that the code of the time step was used. It reports
+// false if that or a later step was used already, so a code works only once
+// even when two logins race.
+func (r *userRepository) UseTOTPCounter(ctx context.Context, id uint, counter int64) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&models.User{}).
+		Where("id = ? AND totp_last_counter < ?", id, counter).
+		Update("totp_last_counter", counter)
+	if result.Error != nil {
+		log.Printf("UserRepository.UseTOTPCounter: error updating user ID=%d: %v", id, result.Error)
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// Delete soft-deletes the user. Their books keep them as owner and their
+// username and email stay reserved.
+func (r *userRepository) Delete(ctx context.Context, id uint) error {
+	result := r.db.WithContext(ctx).Delete(&models.User{}, id)
+	if result.Error != nil {
+		log.Printf("UserRepository.Delete: error deleting user ID=%d: %v", id, result.Error)
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"context"
+	"lab1/models"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type UserTokenRepository interface {
+	Create(ctx context.Context, token *models.UserToken) error
+	Consume(ctx context.Context, purpose, tokenHash string, now time.Time) (*models.UserToken, error)
+	DeleteByUser(ctx context.Context, userID uint, purpose string) error
+	ReplaceRecoveryCodes(ctx context.Context, userID uint, codeHashes []string) error
+	ConsumeRecoveryCode(ctx context.Context, userID uint, codeHash string, now time.Time) error
+	CountRecoveryCodes(ctx context.Context, userID uint) (int64, error)
+	DeleteRecoveryCodes(ctx context.Context, userID uint) error
+}
+
+type userTokenRepository struct {
+	db *gorm.DB
+}
+
+func NewUserTokenRepository(db *gorm.DB) UserTokenRepository {
+	return &userTokenRepository{db: db}
+}
+
+func (r *userTokenRepository) Create(ctx context.Context, token *models.UserToken) error {
+	log.Printf("UserTokenRepository.Create: issuing %s token for user ID=%d", token.Purpose, token.UserID)
+	if err := r.db.WithContext(ctx).Create(token).Error; err != nil {
+		log.Printf("UserTokenRepository.Create: error creating token: %v", err)
+		return err
+	}
+	return nil
+}
+
+// Consume marks an unused, unexpired token of the purpose as used and returns it.
+// Unknown, used and expired tokens all give gorm.ErrRecordNotFound.
+func (r *userTokenRepository) Consume(ctx context.Context, purpose, tokenHash string, now time.Time) (*models.UserToken, error) {
+	var token models.UserToken
+	err := r.db.WithContext(ctx).Where("purpose = ? AND token_hash = ? AND used_at IS NULL AND expires_at > ?", purpose, tokenHash, now).
+		First(&token).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// The condition is checked again so that a token can only be used once
+	result := r.db.WithContext(ctx).Model(&models.UserToken{}).Where("id = ? AND used_at IS NULL", token.ID).Update("used_at", now)
+	if result.Error != nil {
+		log.Printf("UserTokenRepository.Consume: error using token ID=%d: %v", token.ID, result.Error)
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	token.UsedAt = &now
+	return &token, nil
+}
+
+// DeleteByUser drops the user's tokens of the purpose, so that only the most
+// recently mailed one is valid
+func (r *userTokenRepository) DeleteByUser(ctx context.Context, userID uint, purpose string) error {
+	err := r.db.WithContext(ctx).Where("user_id = ? AND purpose = ?", userID, purpose).Delete(&models.UserToken{}).Error
+	if err != nil {
+		log.Printf("UserTokenRepository.DeleteByUser: error deleting %s tokens of user ID=%d: %v", purpose, userID, err)
+	}
+	return err
+}
+
+// ReplaceRecoveryCodes drops the user's recovery codes and stores new ones
+func (r *userTokenRepository) ReplaceRecoveryCodes(ctx context.Context, userID uint, codeHashes []string) error {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		codes := make([]models.RecoveryCode, len(codeHashes))
+		for i, hash := range codeHashes {
+			codes[i] = models.RecoveryCode{UserID: userID, CodeHash: hash}
+		}
+		return tx.Create(&codes).Error
+	})
+	if err != nil {
+		log.Printf("UserTokenRepository.ReplaceRecoveryCodes: error storing recovery codes of user ID=%d: %v", userID, err)
+	}
+	return err
+}
+
+// ConsumeRecoveryCode marks an unused recovery code of the user as used.
+// Unknown and used codes give gorm.ErrRecordNotFound.
+func (r *userTokenRepository) ConsumeRecoveryCode(ctx context.Context, userID uint, codeHash string, now time.Time) error {
+	var code models.RecoveryCode
+	err := r.db.WithContext(ctx).Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, codeHash).First(&code).Error
+	if err != nil {
+		return err
+	}
+
+	result := r.db.WithContext(ctx).Model(&models.RecoveryCode{}).Where("id = ? AND used_at IS NULL", code.ID).Update("used_at", now)
+	if result.Error != nil {
+		log.Printf("UserTokenRepository.ConsumeRecoveryCode: error using recovery code of user ID=%d: %v", userID, result.Error)
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	log.Printf("UserTokenRepository.ConsumeRecoveryCode: user ID=%d used a recovery code", userID)
+	return nil
+}
+
+// CountRecoveryCodes returns how many unused recovery codes the user has left
+func (r *userTokenRepository) CountRecoveryCodes(ctx context.Context, userID uint) (int64, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&models.RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", userID).Count(&count).Error
+	return count, err
+}
+
+func (r *userTokenRepository) DeleteRecoveryCodes(ctx context.Context, userID uint) error {
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error
+	if err != nil {
+		log.Printf("UserTokenRepository.DeleteRecoveryCodes: error deleting recovery codes of user ID=%d: %v", userID, err)
+	}
+	return err
+}
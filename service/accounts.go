@@ -0,0 +1,49 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"lab1/models"
+)
+
+// BalanceExceededError is returned when a payment or waiver is more than the reader owes
+type BalanceExceededError struct {
+	Balance int64 // what the reader owes in cents
+}
+
+func (e *BalanceExceededError) Error() string {
+	return fmt.Sprintf("amount exceeds outstanding balance of %d cents", e.Balance)
+}
+
+// AccountService keeps the fine accounts of readers
+type AccountService struct {
+	uow *UnitOfWork
+}
+
+func NewAccountService(uow *UnitOfWork) *AccountService {
+	return &AccountService{uow: uow}
+}
+
+// Credit records a payment or waiver. The account is locked while the
+// balance is checked, so that concurrent credits cannot take it below zero.
+func (s *AccountService) Credit(ctx context.Context, transaction *models.AccountTransaction) error {
+	return s.uow.Do(ctx, func(repos *Repositories) error {
+		// Locking comes first: on SQLite a transaction that has read cannot
+		// wait for the lock of another one
+		if err := repos.Accounts.Lock(ctx, transaction.ReaderID); err != nil {
+			return err
+		}
+		if _, err := repos.Readers.FindByID(ctx, transaction.ReaderID); err != nil {
+			return notFound(err, "reader")
+		}
+
+		balance, err := repos.Accounts.Balance(ctx, transaction.ReaderID)
+		if err != nil {
+			return err
+		}
+		if transaction.AmountCents > balance {
+			return &BalanceExceededError{Balance: balance}
+		}
+		return repos.Accounts.Create(ctx, transaction)
+	})
+}
@@ -0,0 +1,53 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"lab1/models"
+	"lab1/rbac"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Actor is the user a service acts for
+type Actor struct {
+	UserID   uint
+	Username string
+	// Can reports whether the actor holds a permission; for API keys it also
+	// takes the key's scopes into account
+	Can func(perm rbac.Permission) bool
+}
+
+// NotFoundError names the record an operation needed but could not find.
+// It matches gorm.ErrRecordNotFound with errors.Is.
+type NotFoundError struct {
+	Entity string // "book", "reader", "copy", "item", "loan" or "hold"
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("%s not found", e.Entity)
+}
+
+func (e *NotFoundError) Is(target error) bool {
+	return target == gorm.ErrRecordNotFound
+}
+
+// Message is the error as shown to clients, e.g. "Book not found"
+func (e *NotFoundError) Message() string {
+	return strings.ToUpper(e.Entity[:1]) + e.Entity[1:] + " not found"
+}
+
+// notFound turns gorm.ErrRecordNotFound into a NotFoundError for entity
+func notFound(err error, entity string) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &NotFoundError{Entity: entity}
+	}
+	return err
+}
+
+// CanChangeBook reports whether the actor may change the book and its copies:
+// its owner can, other users need books:write_any
+func CanChangeBook(actor Actor, book *models.Book) bool {
+	return book.UserID == actor.UserID || actor.Can(rbac.BooksWriteAny)
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"lab1/models"
+)
+
+var (
+	// ErrNotOwner is returned when an actor changes a book that CanChangeBook denies them
+	ErrNotOwner  = errors.New("book belongs to another user")
+	ErrISBNTaken = errors.New("a book with this ISBN already exists")
+)
+
+// BookChanges are the new details of a book. The caller normalizes the ISBN
+// and loads the linked records.
+type BookChanges struct {
+	Title       string
+	Description string
+	ISBN        *string
+	Authors     []models.Author
+	Publishers  []models.Publisher
+	Subjects    []models.Subject
+}
+
+// BookService changes books on behalf of users, enforcing that only owners
+// and users with books:write_any change a book
+type BookService struct {
+	uow *UnitOfWork
+}
+
+func NewBookService(uow *UnitOfWork) *BookService {
+	return &BookService{uow: uow}
+}
+
+// Update replaces the details of a book and returns it as it was before and after
+func (s *BookService) Update(ctx context.Context, actor Actor, id uint, changes BookChanges) (*models.Book, *models.Book, error) {
+	var before, after *models.Book
+	err := s.uow.Do(ctx, func(repos *Repositories) error {
+		var err error
+		if before, err = repos.Books.FindByID(ctx, id); err != nil {
+			return notFound(err, "book")
+		}
+		if !CanChangeBook(actor, before) {
+			return ErrNotOwner
+		}
+		if changes.ISBN != nil {
+			if taken, err := repos.Books.ISBNTaken(ctx, *changes.ISBN, id); err != nil {
+				return err
+			} else if taken {
+				return ErrISBNTaken
+			}
+		}
+
+		updated := *before
+		updated.Title = changes.Title
+		updated.Description = changes.Description
+		updated.ISBN = changes.ISBN
+		updated.Authors = changes.Authors
+		updated.Publishers = changes.Publishers
+		updated.Subjects = changes.Subjects
+		after = &updated
+		return repos.Books.Update(ctx, after)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return before, after, nil
+}
+
+// Delete moves a book to the trash and returns it as it was
+func (s *BookService) Delete(ctx context.Context, actor Actor, id uint) (*models.Book, error) {
+	var book *models.Book
+	err := s.uow.Do(ctx, func(repos *Repositories) error {
+		var err error
+		if book, err = repos.Books.FindByID(ctx, id); err != nil {
+			return notFound(err, "book")
+		}
+		if !CanChangeBook(actor, book) {
+			return ErrNotOwner
+		}
+		return repos.Books.Delete(ctx, id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return book, nil
+}
@@ -0,0 +1,232 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"lab1/config"
+	"lab1/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrBookReserved is returned when every available copy of a book is promised
+	// to readers in its hold queue
+	ErrBookReserved   = errors.New("book is reserved for another reader")
+	ErrBookWithReader = errors.New("reader already has this book")
+	ErrAlreadyHeld    = errors.New("reader already has a hold on this book")
+	ErrBookAvailable  = errors.New("book is available, check it out instead")
+	ErrHoldInactive   = errors.New("hold is no longer active")
+)
+
+// HoldQueue applies the hold rules of a book. Availability is counted in copies;
+// a book without registered copies counts as a single copy that is available
+// while no reader has it. It uses the Holds, Items and Readers repositories.
+type HoldQueue struct {
+	repos  *Repositories
+	config *config.Config
+}
+
+func NewHoldQueue(repos *Repositories, config *config.Config) HoldQueue {
+	return HoldQueue{repos: repos, config: config}
+}
+
+// AvailableCopies returns how many copies of the book are on the shelf
+func (q HoldQueue) AvailableCopies(ctx context.Context, bookID uint) (int64, error) {
+	counts, err := q.repos.Items.CountsByBooks(ctx, []uint{bookID})
+	if err != nil {
+		return 0, err
+	}
+	if copies, ok := counts[bookID]; ok {
+		return copies.Available, nil
+	}
+
+	taken, err := q.repos.Readers.IsBookTaken(ctx, bookID)
+	if err != nil || taken {
+		return 0, err
+	}
+	return 1, nil
+}
+
+// Release reserves the copies on the shelf for the readers at the head of the queue.
+// It is called whenever a copy comes back.
+func (q HoldQueue) Release(ctx context.Context, bookID uint) error {
+	available, err := q.AvailableCopies(ctx, bookID)
+	if err != nil || available == 0 {
+		return err
+	}
+	now := time.Now()
+	_, err = q.repos.Holds.PromoteNext(ctx, bookID, available, now, now.AddDate(0, 0, q.config.HoldExpiryDays))
+	return err
+}
+
+// Rebalance matches the ready holds to the copies on the shelf after a copy
+// left it: holds without a copy wait again, and freed copies go to the queue
+func (q HoldQueue) Rebalance(ctx context.Context, bookID uint) error {
+	available, err := q.AvailableCopies(ctx, bookID)
+	if err != nil {
+		return err
+	}
+	if _, err := q.repos.Holds.Requeue(ctx, bookID, available); err != nil {
+		return err
+	}
+	return q.Release(ctx, bookID)
+}
+
+// Expire drops ready holds that were not picked up in time and passes the copies on
+func (q HoldQueue) Expire(ctx context.Context, bookID uint) error {
+	expired, err := q.repos.Holds.ExpireStale(ctx, bookID, time.Now())
+	if err != nil || expired == 0 {
+		return err
+	}
+	return q.Release(ctx, bookID)
+}
+
+// Claim checks the hold queue before a book is handed to a reader.
+// It returns ErrBookReserved when every available copy is promised to
+// somebody else and marks the reader's own hold as fulfilled otherwise.
+func (q HoldQueue) Claim(ctx context.Context, bookID uint, readerID uint) error {
+	if err := q.Expire(ctx, bookID); err != nil {
+		return err
+	}
+
+	queue, err := q.repos.Holds.FindQueueByBook(ctx, bookID)
+	if err != nil || len(queue) == 0 {
+		return err
+	}
+
+	var own *models.Hold
+	ready := 0
+	for i := range queue {
+		if queue[i].Status == models.HoldStatusReady {
+			ready++
+		}
+		if queue[i].ReaderID == readerID {
+			own = &queue[i]
+		}
+	}
+
+	if own == nil || own.Status != models.HoldStatusReady {
+		available, err := q.AvailableCopies(ctx, bookID)
+		if err != nil {
+			return err
+		}
+		// Only copies that are not waiting for pickup by readers in the queue are up for grabs
+		if available <= int64(ready) {
+			return ErrBookReserved
+		}
+	}
+
+	if own != nil {
+		return q.repos.Holds.UpdateStatus(ctx, own, models.HoldStatusFulfilled)
+	}
+	return nil
+}
+
+// HoldService places and cancels holds, keeping the hold queue of the book up to date
+type HoldService struct {
+	uow    *UnitOfWork
+	config *config.Config
+}
+
+func NewHoldService(uow *UnitOfWork, config *config.Config) *HoldService {
+	return &HoldService{uow: uow, config: config}
+}
+
+// Place puts the reader at the end of the hold queue of the book. It returns
+// the hold and its position in the queue.
+func (s *HoldService) Place(ctx context.Context, bookID uint, readerID uint) (*models.Hold, int, error) {
+	var hold *models.Hold
+	var position int
+	err := s.uow.Do(ctx, func(repos *Repositories) error {
+		book, err := repos.Books.FindByID(ctx, bookID)
+		if err != nil {
+			return notFound(err, "book")
+		}
+		reader, err := repos.Readers.FindByID(ctx, readerID)
+		if err != nil {
+			return notFound(err, "reader")
+		}
+		for _, current := range reader.CurrentlyReading {
+			if current.ID == book.ID {
+				return ErrBookWithReader
+			}
+		}
+
+		if _, err := repos.Holds.FindActive(ctx, reader.ID, book.ID); err == nil {
+			return ErrAlreadyHeld
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		queue := NewHoldQueue(repos, s.config)
+		if err := queue.Expire(ctx, book.ID); err != nil {
+			return err
+		}
+
+		// Holds only make sense while all copies are out or already promised to someone else
+		available, err := queue.AvailableCopies(ctx, book.ID)
+		if err != nil {
+			return err
+		}
+		waiting, err := repos.Holds.FindQueueByBook(ctx, book.ID)
+		if err != nil {
+			return err
+		}
+		if available > 0 && len(waiting) == 0 {
+			return ErrBookAvailable
+		}
+
+		hold = &models.Hold{
+			ReaderID: reader.ID,
+			BookID:   book.ID,
+			Status:   models.HoldStatusWaiting,
+		}
+		if err := repos.Holds.Create(ctx, hold); err != nil {
+			return err
+		}
+		hold.Reader = *reader
+		hold.Book = *book
+		position = len(waiting) + 1
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return hold, position, nil
+}
+
+// Cancel withdraws a hold of the reader. A copy that was waiting for the
+// reader goes to the next one in the queue.
+func (s *HoldService) Cancel(ctx context.Context, readerID uint, holdID uint) error {
+	return s.uow.Do(ctx, func(repos *Repositories) error {
+		hold, err := repos.Holds.FindByID(ctx, holdID)
+		if err == nil && hold.ReaderID != readerID {
+			err = gorm.ErrRecordNotFound
+		}
+		if err != nil {
+			return notFound(err, "hold")
+		}
+		if !hold.IsActive() {
+			return ErrHoldInactive
+		}
+
+		wasReady := hold.Status == models.HoldStatusReady
+		if err := repos.Holds.UpdateStatus(ctx, hold, models.HoldStatusCancelled); err != nil {
+			return err
+		}
+		if !wasReady {
+			return nil
+		}
+		return NewHoldQueue(repos, s.config).Release(ctx, hold.BookID)
+	})
+}
+
+// Expire drops the ready holds of the book that were not picked up in time
+// and passes the copies on
+func (s *HoldService) Expire(ctx context.Context, bookID uint) error {
+	return s.uow.Do(ctx, func(repos *Repositories) error {
+		return NewHoldQueue(repos, s.config).Expire(ctx, bookID)
+	})
+}
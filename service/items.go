@@ -0,0 +1,141 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"lab1/config"
+	"lab1/models"
+	"lab1/repository"
+)
+
+var (
+	ErrBarcodeExists = errors.New("barcode already exists")
+	// ErrItemOnLoan is returned when changing a lent copy; its status is owned by the loan
+	ErrItemOnLoan = errors.New("item is on loan")
+)
+
+// ItemService adds and changes the copies of a book. A copy that comes on the
+// shelf is reserved for the next reader in the hold queue.
+type ItemService struct {
+	uow    *UnitOfWork
+	config *config.Config
+}
+
+func NewItemService(uow *UnitOfWork, config *config.Config) *ItemService {
+	return &ItemService{uow: uow, config: config}
+}
+
+// Create adds item as a copy of its book on behalf of actor
+func (s *ItemService) Create(ctx context.Context, actor Actor, item *models.Item) error {
+	return s.uow.Do(ctx, func(repos *Repositories) error {
+		book, err := repos.Books.FindByID(ctx, item.BookID)
+		if err != nil {
+			return notFound(err, "book")
+		}
+		if !CanChangeBook(actor, book) {
+			return ErrNotOwner
+		}
+
+		if exists, err := repos.Items.BarcodeExists(ctx, item.Barcode); err != nil {
+			return err
+		} else if exists {
+			return ErrBarcodeExists
+		}
+		if item.Status == "" {
+			item.Status = models.ItemStatusAvailable
+		}
+		if err := repos.Items.Create(ctx, item); err != nil {
+			return err
+		}
+		item.Book = *book
+
+		// A new copy on the shelf may satisfy the next hold in the queue
+		if item.Status != models.ItemStatusAvailable {
+			return nil
+		}
+		return NewHoldQueue(repos, s.config).Release(ctx, book.ID)
+	})
+}
+
+// Update replaces the details of a copy of the book with those of changes on
+// behalf of actor
+func (s *ItemService) Update(ctx context.Context, actor Actor, bookID uint, itemID uint, changes models.Item) error {
+	return s.uow.Do(ctx, func(repos *Repositories) error {
+		book, err := repos.Books.FindByID(ctx, bookID)
+		if err != nil {
+			return notFound(err, "book")
+		}
+		if !CanChangeBook(actor, book) {
+			return ErrNotOwner
+		}
+
+		item, err := repos.Items.FindByID(ctx, itemID)
+		if err != nil {
+			return notFound(err, "item")
+		}
+		if item.BookID != book.ID {
+			return &NotFoundError{Entity: "item"}
+		}
+		if item.Status == models.ItemStatusOnLoan {
+			return ErrItemOnLoan
+		}
+
+		if changes.Barcode != item.Barcode {
+			if exists, err := repos.Items.BarcodeExists(ctx, changes.Barcode); err != nil {
+				return err
+			} else if exists {
+				return ErrBarcodeExists
+			}
+		}
+
+		wasAvailable := item.Status == models.ItemStatusAvailable
+		item.Barcode = changes.Barcode
+		item.ShelfLocation = changes.ShelfLocation
+		item.Condition = changes.Condition
+		item.Status = changes.Status
+		item.AcquiredAt = changes.AcquiredAt
+		if err := repos.Items.Update(ctx, item); err != nil {
+			return err
+		}
+
+		// A copy that came on the shelf goes to the queue, one that left it
+		// takes back the hold it was waiting for
+		if wasAvailable == (item.Status == models.ItemStatusAvailable) {
+			return nil
+		}
+		return NewHoldQueue(repos, s.config).Rebalance(ctx, book.ID)
+	})
+}
+
+// Delete removes a copy of the book on behalf of actor. Holds the copy was
+// waiting for go back to the queue.
+func (s *ItemService) Delete(ctx context.Context, actor Actor, bookID uint, itemID uint) error {
+	return s.uow.Do(ctx, func(repos *Repositories) error {
+		book, err := repos.Books.FindByID(ctx, bookID)
+		if err != nil {
+			return notFound(err, "book")
+		}
+		if !CanChangeBook(actor, book) {
+			return ErrNotOwner
+		}
+
+		item, err := repos.Items.FindByID(ctx, itemID)
+		if err != nil {
+			return notFound(err, "item")
+		}
+		if item.BookID != book.ID {
+			return &NotFoundError{Entity: "item"}
+		}
+		if item.Status == models.ItemStatusOnLoan {
+			return ErrItemOnLoan
+		}
+
+		// A checkout may have lent the copy since it was read
+		if err := repos.Items.Delete(ctx, item.ID); errors.Is(err, repository.ErrItemLent) {
+			return ErrItemOnLoan
+		} else if err != nil {
+			return err
+		}
+		return NewHoldQueue(repos, s.config).Rebalance(ctx, book.ID)
+	})
+}
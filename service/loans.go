@@ -0,0 +1,228 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"lab1/config"
+	"lab1/fines"
+	"lab1/models"
+	"lab1/repository"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrReaderBlocked is returned when a reader owes more than fine_block_threshold_cents
+	ErrReaderBlocked     = errors.New("reader has outstanding fines")
+	ErrDueDateInPast     = errors.New("due date must be in the future")
+	ErrCopyMismatch      = errors.New("copy does not belong to the given book")
+	ErrNoCopiesAvailable = errors.New("no copies of this book are available")
+	ErrBookOnLoan        = errors.New("book is already on loan")
+	ErrAlreadyReturned   = errors.New("loan has already been returned")
+)
+
+// CopyUnavailableError is returned when lending a copy that is not on the shelf
+type CopyUnavailableError struct {
+	Status string // the copy's status, e.g. models.ItemStatusOnLoan
+}
+
+func (e *CopyUnavailableError) Error() string {
+	return fmt.Sprintf("copy is not available (%s)", e.Status)
+}
+
+// checkAccountStanding returns ErrReaderBlocked when the reader owes more than the configured threshold
+func checkAccountStanding(ctx context.Context, accounts repository.AccountRepository, cfg *config.Config, readerID uint) error {
+	balance, err := accounts.Balance(ctx, readerID)
+	if err != nil {
+		return err
+	}
+	if balance > cfg.FineBlockThresholdCents {
+		return fmt.Errorf("%w: balance is %d cents", ErrReaderBlocked, balance)
+	}
+	return nil
+}
+
+// Checkout describes a loan to make. The copy is picked by Barcode, ItemID or,
+// failing both, as any available copy of BookID.
+type Checkout struct {
+	ReaderID uint
+	BookID   uint
+	ItemID   uint
+	Barcode  string
+	DueAt    *time.Time // defaults to loan_period_days from now
+}
+
+// LoanService lends and takes back books, applying the lending rules: readers
+// with too many unpaid fines cannot borrow, and readers in the hold queue come first
+type LoanService struct {
+	uow    *UnitOfWork
+	config *config.Config
+}
+
+func NewLoanService(uow *UnitOfWork, config *config.Config) *LoanService {
+	return &LoanService{uow: uow, config: config}
+}
+
+// Checkout lends a copy to a reader on behalf of actor
+func (s *LoanService) Checkout(ctx context.Context, actor Actor, request Checkout) (*models.Loan, error) {
+	now := time.Now()
+	dueAt := now.AddDate(0, 0, s.config.LoanPeriodDays)
+	if request.DueAt != nil {
+		if !request.DueAt.After(now) {
+			return nil, ErrDueDateInPast
+		}
+		dueAt = *request.DueAt
+	}
+
+	var loan *models.Loan
+	err := s.uow.Do(ctx, func(repos *Repositories) error {
+		reader, err := repos.Readers.FindByID(ctx, request.ReaderID)
+		if err != nil {
+			return notFound(err, "reader")
+		}
+		if err := checkAccountStanding(ctx, repos.Accounts, s.config, reader.ID); err != nil {
+			return err
+		}
+
+		item, book, err := s.resolveCopy(ctx, repos, request)
+		if err != nil {
+			return err
+		}
+
+		// Readers waiting in the hold queue come first
+		if err := NewHoldQueue(repos, s.config).Claim(ctx, book.ID, reader.ID); err != nil {
+			return err
+		}
+
+		loan = &models.Loan{
+			ReaderID:       reader.ID,
+			BookID:         book.ID,
+			CheckedOutAt:   now,
+			DueAt:          dueAt,
+			CheckedOutByID: actor.UserID,
+		}
+		if item != nil {
+			loan.ItemID = &item.ID
+		}
+		// Another checkout may have taken the copy since resolveCopy saw it
+		if err := repos.Loans.Checkout(ctx, loan); errors.Is(err, repository.ErrAlreadyLent) {
+			if item != nil {
+				return &CopyUnavailableError{Status: models.ItemStatusOnLoan}
+			}
+			return ErrBookOnLoan
+		} else if err != nil {
+			return err
+		}
+
+		loan.Reader = *reader
+		loan.Book = *book
+		loan.Item = item
+		loan.CheckedOutBy = models.User{Username: actor.Username}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return loan, nil
+}
+
+// resolveCopy finds the copy to lend. For books without registered copies it
+// returns a nil item and makes sure the title is not lent already.
+func (s *LoanService) resolveCopy(ctx context.Context, repos *Repositories, request Checkout) (*models.Item, *models.Book, error) {
+	var item *models.Item
+	var err error
+
+	switch {
+	case request.Barcode != "":
+		item, err = repos.Items.FindByBarcode(ctx, request.Barcode)
+	case request.ItemID != 0:
+		item, err = repos.Items.FindByID(ctx, request.ItemID)
+	}
+	if err != nil {
+		return nil, nil, notFound(err, "copy")
+	}
+
+	if item != nil {
+		if request.BookID != 0 && request.BookID != item.BookID {
+			return nil, nil, ErrCopyMismatch
+		}
+		if item.Status != models.ItemStatusAvailable {
+			return nil, nil, &CopyUnavailableError{Status: item.Status}
+		}
+		return item, &item.Book, nil
+	}
+
+	book, err := repos.Books.FindByID(ctx, request.BookID)
+	if err != nil {
+		return nil, nil, notFound(err, "book")
+	}
+
+	counts, err := repos.Items.CountsByBooks(ctx, []uint{book.ID})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if _, hasCopies := counts[book.ID]; hasCopies {
+		item, err = repos.Items.FindAvailableByBook(ctx, book.ID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrNoCopiesAvailable
+		}
+		return item, book, err
+	}
+
+	// Without registered copies a book can only be lent to one reader at a time
+	if _, err := repos.Loans.FindActiveByBook(ctx, book.ID); err == nil {
+		return nil, nil, ErrBookOnLoan
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, err
+	}
+	return nil, book, nil
+}
+
+// Return takes a lent book back, charges the fine for returning it late and
+// reserves the copy for the next reader in the hold queue. It returns the
+// loan and the fine in cents.
+func (s *LoanService) Return(ctx context.Context, loanID uint) (*models.Loan, int64, error) {
+	var loan *models.Loan
+	var fine int64
+	err := s.uow.Do(ctx, func(repos *Repositories) error {
+		var err error
+		if loan, err = repos.Loans.FindByID(ctx, loanID); err != nil {
+			return notFound(err, "loan")
+		}
+		if loan.ReturnedAt != nil {
+			return ErrAlreadyReturned
+		}
+
+		now := time.Now()
+		if err := repos.Loans.Return(ctx, loan, now); errors.Is(err, repository.ErrLoanReturned) {
+			return ErrAlreadyReturned
+		} else if err != nil {
+			return err
+		}
+
+		// Charge the reader for every started day past the due date
+		if fine = fines.Calculate(loan.DueAt, now, s.config.FineDailyRateCents, s.config.FineMaxCents); fine > 0 {
+			loanID := loan.ID
+			charge := models.AccountTransaction{
+				ReaderID:    loan.ReaderID,
+				LoanID:      &loanID,
+				Type:        models.TransactionCharge,
+				AmountCents: fine,
+				Note:        fmt.Sprintf("Overdue by %d days: %s", fines.DaysLate(loan.DueAt, now), loan.Book.Title),
+			}
+			if err := repos.Accounts.Create(ctx, &charge); err != nil {
+				return err
+			}
+		}
+
+		// The copy is back on the shelf, reserve it for the next reader in the queue
+		return NewHoldQueue(repos, s.config).Release(ctx, loan.BookID)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return loan, fine, nil
+}
@@ -0,0 +1,64 @@
+package service
+
+import (
+	"context"
+	"lab1/config"
+	"lab1/models"
+)
+
+// ReaderService changes readers and their reading lists. A book on a reading
+// list counts as lent, so adding one follows the lending rules of LoanService.
+type ReaderService struct {
+	uow    *UnitOfWork
+	config *config.Config
+}
+
+func NewReaderService(uow *UnitOfWork, config *config.Config) *ReaderService {
+	return &ReaderService{uow: uow, config: config}
+}
+
+// Delete moves a reader to the trash and returns it as it was
+func (s *ReaderService) Delete(ctx context.Context, id uint) (*models.Reader, error) {
+	var reader *models.Reader
+	err := s.uow.Do(ctx, func(repos *Repositories) error {
+		var err error
+		if reader, err = repos.Readers.FindByID(ctx, id); err != nil {
+			return notFound(err, "reader")
+		}
+		return repos.Readers.Delete(ctx, id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reader, nil
+}
+
+// AddCurrentlyReading puts a book on a reader's reading list
+func (s *ReaderService) AddCurrentlyReading(ctx context.Context, readerID uint, bookID uint) error {
+	return s.uow.Do(ctx, func(repos *Repositories) error {
+		if err := checkAccountStanding(ctx, repos.Accounts, s.config, readerID); err != nil {
+			return err
+		}
+		book, err := repos.Books.FindByID(ctx, bookID)
+		if err != nil {
+			return notFound(err, "book")
+		}
+
+		// Readers waiting in the hold queue come first
+		if err := NewHoldQueue(repos, s.config).Claim(ctx, book.ID, readerID); err != nil {
+			return err
+		}
+		return notFound(repos.Readers.AddCurrentlyReading(ctx, readerID, book), "reader")
+	})
+}
+
+// RemoveCurrentlyReading takes a book off a reader's reading list and
+// reserves it for the next reader in its hold queue
+func (s *ReaderService) RemoveCurrentlyReading(ctx context.Context, readerID uint, bookID uint) error {
+	return s.uow.Do(ctx, func(repos *Repositories) error {
+		if err := repos.Readers.RemoveCurrentlyReading(ctx, readerID, bookID); err != nil {
+			return notFound(err, "reader")
+		}
+		return NewHoldQueue(repos, s.config).Release(ctx, bookID)
+	})
+}
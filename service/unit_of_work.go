@@ -0,0 +1,55 @@
+package service
+
+import (
+	"context"
+	"lab1/cache"
+	"lab1/repository"
+
+	"gorm.io/gorm"
+)
+
+// Repositories are the repositories the services work with, either on the
+// database itself or bound to one transaction
+type Repositories struct {
+	Books    repository.BookRepository
+	Readers  repository.ReaderRepository
+	Items    repository.ItemRepository
+	Loans    repository.LoanRepository
+	Holds    repository.HoldRepository
+	Accounts repository.AccountRepository
+}
+
+// UnitOfWork runs several repository calls as one database transaction.
+// Cache invalidations made inside it are deferred until the commit, and
+// dropped when it rolls back.
+type UnitOfWork struct {
+	db    *gorm.DB
+	cache *cache.Cache
+	repos Repositories
+}
+
+func NewUnitOfWork(db *gorm.DB, cache *cache.Cache, repos Repositories) *UnitOfWork {
+	return &UnitOfWork{db: db, cache: cache, repos: repos}
+}
+
+// Do calls fn with repositories bound to a new transaction, which commits if
+// fn returns nil and rolls back otherwise. fn must only use the repositories
+// it is given, the others do not see the transaction.
+func (u *UnitOfWork) Do(ctx context.Context, fn func(repos *Repositories) error) error {
+	store := u.cache.Defer()
+	err := u.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(&Repositories{
+			Books:    u.repos.Books.WithTx(tx, store),
+			Readers:  u.repos.Readers.WithTx(tx, store),
+			Items:    u.repos.Items.WithTx(tx),
+			Loans:    u.repos.Loans.WithTx(tx, store),
+			Holds:    u.repos.Holds.WithTx(tx),
+			Accounts: u.repos.Accounts.WithTx(tx),
+		})
+	})
+	if err != nil {
+		return err
+	}
+	store.Commit()
+	return nil
+}
@@ -14,6 +14,11 @@ async function apiRequest(endpoint, options = {}) {
             ...options
         });
 
+        // An expired access token is renewed once with the refresh token
+        if (response.status === 401 && !options.skipAuth && !options.retried && await Auth.refresh()) {
+            return await apiRequest(endpoint, { ...options, retried: true });
+        }
+
         if (response.status === 204) {
             return { success: true };
         }
@@ -24,7 +29,8 @@ async function apiRequest(endpoint, options = {}) {
             if (response.status === 401) {
                 Auth.clearToken();
                 UI.showAuthContainer();
-                throw new Error('Session expired. Please login again.');
+                // Requests without a session, like logging in, report their own reason
+                throw new Error(options.skipAuth && data.error ? data.error : 'Session expired. Please login again.');
             } else if (response.status === 403) {
                 throw new Error(data.error || 'Access denied');
             } else if (response.status === 404) {
@@ -56,8 +62,8 @@ async function apiRequest(endpoint, options = {}) {
 }
 
 const BooksAPI = {
-    async getAll() {
-        return await apiRequest('/books/');
+    async getAll(params = {}) {
+        return await apiRequest(`/books/${buildQueryString(params)}`);
     },
 
     async getById(id) {
@@ -92,8 +98,8 @@ const BooksAPI = {
 };
 
 const ReadersAPI = {
-    async getAll() {
-        return await apiRequest('/readers/');
+    async getAll(params = {}) {
+        return await apiRequest(`/readers/${buildQueryString(params)}`);
     },
 
     async getById(id) {
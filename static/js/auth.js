@@ -11,9 +11,16 @@ const Auth = {
         localStorage.setItem('authToken', token);
     },
 
+    // Stores the access and refresh token of a login or refresh response
+    setTokens(response) {
+        this.setToken(response.token);
+        localStorage.setItem('refreshToken', response.refresh_token);
+    },
+
     clearToken() {
         AppState.authToken = null;
         localStorage.removeItem('authToken');
+        localStorage.removeItem('refreshToken');
     },
 
     isAuthenticated() {
@@ -36,11 +43,92 @@ const Auth = {
         });
     },
 
+    async loginTwoFactor(challengeToken, code) {
+        return await apiRequest('/auth/2fa/login', {
+            method: 'POST',
+            body: JSON.stringify({ challenge_token: challengeToken, code }),
+            skipAuth: true
+        });
+    },
+
+    async getOIDCConfig() {
+        return await apiRequest('/auth/oidc', { skipAuth: true });
+    },
+
+    async oidcToken(code) {
+        return await apiRequest('/auth/oidc/token', {
+            method: 'POST',
+            body: JSON.stringify({ code }),
+            skipAuth: true
+        });
+    },
+
+    async setupTwoFactor() {
+        return await apiRequest('/auth/2fa/setup', { method: 'POST' });
+    },
+
+    async verifyTwoFactor(code) {
+        return await apiRequest('/auth/2fa/verify', {
+            method: 'POST',
+            body: JSON.stringify({ code })
+        });
+    },
+
+    async forgotPassword(email) {
+        return await apiRequest('/auth/password/forgot', {
+            method: 'POST',
+            body: JSON.stringify({ email }),
+            skipAuth: true
+        });
+    },
+
+    async resetPassword(token, password) {
+        return await apiRequest('/auth/password/reset', {
+            method: 'POST',
+            body: JSON.stringify({ token, password }),
+            skipAuth: true
+        });
+    },
+
+    async verifyEmail(token) {
+        return await apiRequest('/auth/email/verify', {
+            method: 'POST',
+            body: JSON.stringify({ token }),
+            skipAuth: true
+        });
+    },
+
     async getProfile() {
         return await apiRequest('/auth/profile');
     },
 
-    logout() {
+    // Exchanges the refresh token for new tokens; resolves to false if the session is over
+    async refresh() {
+        const refreshToken = localStorage.getItem('refreshToken');
+        if (!refreshToken) {
+            return false;
+        }
+        const response = await fetch(`${API_BASE}/auth/refresh`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ refresh_token: refreshToken })
+        });
+        if (!response.ok) {
+            return false;
+        }
+        this.setTokens(await response.json());
+        return true;
+    },
+
+    async logout() {
+        try {
+            await apiRequest('/auth/logout', {
+                method: 'POST',
+                body: JSON.stringify({ refresh_token: localStorage.getItem('refreshToken') || '' })
+            });
+        } catch (error) {
+            // The session ends locally even if the server cannot be reached
+        }
         this.clearToken();
         AppState.currentUser = null;
         UI.showAuthContainer();
@@ -76,18 +164,13 @@ async function handleLoginSubmit(e) {
 
     try {
         const response = await Auth.login(username, password);
-        Auth.setToken(response.token);
-        AppState.currentUser = {
-            id: response.user_id,
-            username: response.username,
-            email: response.email,
-            role: response.role
-        };
-
-        document.getElementById('username-display').textContent = response.username;
-        UI.showAppContainer();
-        UI.showNotification(`Welcome back, ${response.username}!`, 'success');
-        await Books.load();
+        if (response.two_factor_required) {
+            AppState.twoFactorChallenge = response.challenge_token;
+            switchAuthTab('two-factor');
+            document.getElementById('two-factor-code').focus();
+            return;
+        }
+        await completeLogin(response, `Welcome back, ${response.username}!`);
     } catch (error) {
         if (error.validationErrors) {
             UI.displayValidationErrors(error.validationErrors, 'login');
@@ -99,6 +182,76 @@ async function handleLoginSubmit(e) {
     }
 }
 
+// completeLogin stores the tokens of a login response and opens the app
+async function completeLogin(response, message) {
+    Auth.setTokens(response);
+    AppState.currentUser = {
+        id: response.user_id,
+        username: response.username,
+        email: response.email,
+        role: response.role,
+        permissions: response.permissions || []
+    };
+
+    document.getElementById('username-display').textContent = response.username;
+    UI.showAppContainer();
+    UI.showNotification(message, 'success');
+    await Books.load();
+}
+
+async function handleTwoFactorSubmit(e) {
+    e.preventDefault();
+
+    const submitBtn = document.getElementById('two-factor-submit-btn');
+    const codeInput = document.getElementById('two-factor-code');
+    submitBtn.disabled = true;
+
+    UI.clearFormErrors('two-factor');
+
+    try {
+        const response = await Auth.loginTwoFactor(AppState.twoFactorChallenge, codeInput.value.trim());
+        await completeLogin(response, `Welcome back, ${response.username}!`);
+    } catch (error) {
+        // The challenge is used up by a wrong code, so the login starts over
+        switchAuthTab('login');
+        UI.showNotification(error.message, 'error');
+    } finally {
+        AppState.twoFactorChallenge = null;
+        codeInput.value = '';
+        submitBtn.disabled = false;
+    }
+}
+
+async function handleTwoFactorEnable() {
+    try {
+        const setup = await Auth.setupTwoFactor();
+        document.getElementById('two-factor-secret').textContent = setup.secret;
+        document.getElementById('two-factor-uri').href = setup.otpauth_uri;
+        document.getElementById('two-factor-enable-btn').classList.add('hidden');
+        document.getElementById('two-factor-enrol').classList.remove('hidden');
+    } catch (error) {
+        UI.showNotification(error.message, 'error');
+    }
+}
+
+async function handleTwoFactorVerifySubmit(e) {
+    e.preventDefault();
+
+    const codeInput = document.getElementById('two-factor-verify-code');
+    try {
+        const response = await Auth.verifyTwoFactor(codeInput.value.trim());
+        document.getElementById('two-factor-enrol').classList.add('hidden');
+        document.getElementById('two-factor-recovery-codes').textContent = response.recovery_codes.join('\n');
+        document.getElementById('two-factor-recovery').classList.remove('hidden');
+        document.getElementById('profile-two-factor').textContent = 'Enabled';
+        UI.showNotification('Two-factor authentication enabled', 'success');
+    } catch (error) {
+        UI.showNotification(error.message, 'error');
+    } finally {
+        codeInput.value = '';
+    }
+}
+
 async function handleRegisterSubmit(e) {
     e.preventDefault();
 
@@ -122,18 +275,7 @@ async function handleRegisterSubmit(e) {
 
     try {
         const response = await Auth.register(username, email, password);
-        Auth.setToken(response.token);
-        AppState.currentUser = {
-            id: response.user_id,
-            username: response.username,
-            email: response.email,
-            role: response.role
-        };
-
-        document.getElementById('username-display').textContent = response.username;
-        UI.showAppContainer();
-        UI.showNotification(`Welcome, ${response.username}! Your account has been created.`, 'success');
-        await Books.load();
+        await completeLogin(response, `Welcome, ${response.username}! Your account has been created.`);
     } catch (error) {
         if (error.validationErrors) {
             UI.displayValidationErrors(error.validationErrors, 'register');
@@ -144,3 +286,124 @@ async function handleRegisterSubmit(e) {
         submitBtn.textContent = 'Register';
     }
 }
+
+async function handleForgotSubmit(e) {
+    e.preventDefault();
+
+    const submitBtn = document.getElementById('forgot-submit-btn');
+    submitBtn.disabled = true;
+
+    UI.clearFormErrors('forgot');
+
+    try {
+        const response = await Auth.forgotPassword(document.getElementById('forgot-email').value.trim());
+        UI.showNotification(response.message, 'success');
+        switchAuthTab('login');
+    } catch (error) {
+        if (error.validationErrors) {
+            UI.displayValidationErrors(error.validationErrors, 'forgot');
+        }
+        UI.showNotification(error.message, 'error');
+    } finally {
+        submitBtn.disabled = false;
+    }
+}
+
+async function handleResetSubmit(e) {
+    e.preventDefault();
+
+    const password = document.getElementById('reset-password').value;
+    const passwordConfirm = document.getElementById('reset-password-confirm').value;
+
+    UI.clearFormErrors('reset');
+    if (password !== passwordConfirm) {
+        document.getElementById('reset-password-confirm-error').textContent = 'Passwords do not match';
+        return;
+    }
+
+    const submitBtn = document.getElementById('reset-submit-btn');
+    submitBtn.disabled = true;
+
+    try {
+        await Auth.resetPassword(AppState.resetToken, password);
+        AppState.resetToken = null;
+        UI.showNotification('Your password has been changed. Please log in.', 'success');
+        switchAuthTab('login');
+    } catch (error) {
+        if (error.validationErrors) {
+            UI.displayValidationErrors(error.validationErrors, 'reset');
+        }
+        UI.showNotification(error.message, 'error');
+    } finally {
+        submitBtn.disabled = false;
+    }
+}
+
+// handleMailLinks acts on the tokens of password reset and verification mails,
+// which open the app with ?reset_token= or ?verify_token=, and on the result
+// of a single sign-on, ?oidc_code= or ?oidc_error=
+async function handleMailLinks() {
+    const params = new URLSearchParams(window.location.search);
+    const resetToken = params.get('reset_token');
+    const verifyToken = params.get('verify_token');
+    const oidcCode = params.get('oidc_code');
+    const oidcError = params.get('oidc_error');
+    if (!resetToken && !verifyToken && !oidcCode && !oidcError) {
+        return;
+    }
+    window.history.replaceState(null, '', window.location.pathname);
+
+    if (oidcCode || oidcError) {
+        await handleSingleSignOn(oidcCode, oidcError);
+        return;
+    }
+
+    if (resetToken) {
+        AppState.resetToken = resetToken;
+        Auth.clearToken();
+        UI.showAuthContainer();
+        switchAuthTab('reset');
+        return;
+    }
+
+    try {
+        await Auth.verifyEmail(verifyToken);
+        UI.showNotification('Your email address has been confirmed', 'success');
+    } catch (error) {
+        UI.showNotification(error.message, 'error');
+    }
+}
+
+// handleSingleSignOn redeems the login code the identity provider callback
+// sent the browser back with
+async function handleSingleSignOn(code, error) {
+    Auth.clearToken();
+    UI.showAuthContainer();
+    if (error) {
+        UI.showNotification(error, 'error');
+        return;
+    }
+
+    try {
+        const response = await Auth.oidcToken(code);
+        if (response.two_factor_required) {
+            AppState.twoFactorChallenge = response.challenge_token;
+            switchAuthTab('two-factor');
+            document.getElementById('two-factor-code').focus();
+            return;
+        }
+        await completeLogin(response, `Welcome, ${response.username}!`);
+    } catch (err) {
+        UI.showNotification(err.message, 'error');
+    }
+}
+
+// showSingleSignOn offers the organisation login if the server has it configured
+async function showSingleSignOn() {
+    try {
+        const config = await Auth.getOIDCConfig();
+        document.getElementById('sso-login-btn').classList.toggle('hidden', !config.enabled);
+    } catch (error) {
+        // Without the status the password login is still there
+    }
+}
@@ -1,41 +1,13 @@
 const Books = {
     applyFilters() {
-        const search = AppState.bookFilters.search.toLowerCase();
-
-        AppState.filteredBooks = AppState.allBooks.filter(book => {
-            const matchesSearch = !search ||
-                book.title.toLowerCase().includes(search) ||
-                (book.description && book.description.toLowerCase().includes(search));
-            return matchesSearch;
-        });
-
-        const [field, direction] = AppState.bookFilters.sortBy.split('-');
-        AppState.filteredBooks.sort((a, b) => {
-            let aVal, bVal;
-
-            if (field === 'id') {
-                aVal = a.id;
-                bVal = b.id;
-            } else if (field === 'title') {
-                aVal = a.title.toLowerCase();
-                bVal = b.title.toLowerCase();
-            }
-
-            if (direction === 'asc') {
-                return aVal > bVal ? 1 : -1;
-            } else {
-                return aVal < bVal ? 1 : -1;
-            }
-        });
-
         AppState.booksCurrentPage = 1;
-        this.render();
+        this.load();
     },
 
     render() {
         const container = document.getElementById('books-list');
 
-        if (!AppState.filteredBooks || AppState.filteredBooks.length === 0) {
+        if (!AppState.books || AppState.books.length === 0) {
             container.innerHTML = `
                 <div class="empty-state">
                     <h3>No Books Found</h3>
@@ -46,16 +18,11 @@ const Books = {
             return;
         }
 
-        // Pagination logic
-        const startIndex = (AppState.booksCurrentPage - 1) * AppState.booksItemsPerPage;
-        const endIndex = startIndex + AppState.booksItemsPerPage;
-        const paginatedBooks = AppState.filteredBooks.slice(startIndex, endIndex);
-
         const html = `
             <div class="items-grid">
-                ${paginatedBooks.map(book => {
+                ${AppState.books.map(book => {
                     const canEdit = AppState.currentUser &&
-                                   (book.user_id === AppState.currentUser.id || AppState.currentUser.role === 'admin');
+                                   (book.user_id === AppState.currentUser.id || hasPermission('books:write_any'));
                     return `
                         <div class="item-card" data-book-id="${book.id}">
                             <div class="item-card-header">
@@ -63,6 +30,7 @@ const Books = {
                                 <span class="item-owner">by ${escapeHtml(book.username || 'Unknown')}</span>
                             </div>
                             <h4>${escapeHtml(book.title)}</h4>
+                            ${book.isbn ? `<span class="item-isbn">ISBN ${escapeHtml(book.isbn)}</span>` : ''}
                             <p>${escapeHtml(book.description || 'No description')}</p>
                             <div class="item-card-actions">
                                 ${canEdit ? `
@@ -82,7 +50,7 @@ const Books = {
 
     renderPagination() {
         const container = document.getElementById('books-pagination');
-        const totalPages = Math.ceil(AppState.filteredBooks.length / AppState.booksItemsPerPage);
+        const totalPages = AppState.booksTotalPages;
 
         if (totalPages <= 1) {
             container.innerHTML = '';
@@ -108,11 +76,10 @@ const Books = {
         container.innerHTML = html;
     },
 
-    changePage(page) {
-        const totalPages = Math.ceil(AppState.filteredBooks.length / AppState.booksItemsPerPage);
-        if (page >= 1 && page <= totalPages) {
+    async changePage(page) {
+        if (page >= 1 && page <= AppState.booksTotalPages) {
             AppState.booksCurrentPage = page;
-            this.render();
+            await this.load();
             document.getElementById('books-list').scrollIntoView({ behavior: 'smooth' });
         }
     },
@@ -121,16 +88,27 @@ const Books = {
         const loading = document.getElementById('books-loading');
         UI.showLoading(loading);
 
+        // Search, sorting and pagination are done by the server
+        const [sort, order] = AppState.bookFilters.sortBy.split('-');
+
         try {
-            AppState.allBooks = await BooksAPI.getAll();
-            AppState.filteredBooks = [...AppState.allBooks];
-            this.applyFilters();
+            const page = await BooksAPI.getAll({
+                q: AppState.bookFilters.search.trim(),
+                sort,
+                order,
+                page: AppState.booksCurrentPage,
+                page_size: AppState.booksItemsPerPage
+            });
+            AppState.books = page.data;
+            AppState.booksTotal = page.meta.total;
+            AppState.booksTotalPages = page.meta.total_pages;
         } catch (error) {
             UI.showNotification(error.message, 'error');
-            AppState.allBooks = [];
-            AppState.filteredBooks = [];
-            this.render();
+            AppState.books = [];
+            AppState.booksTotal = 0;
+            AppState.booksTotalPages = 0;
         } finally {
+            this.render();
             UI.hideLoading(loading);
         }
     },
@@ -201,6 +179,7 @@ const Books = {
 
     updateReviewStep() {
         document.getElementById('review-title').textContent = document.getElementById('book-title').value || '-';
+        document.getElementById('review-isbn').textContent = document.getElementById('book-isbn').value || '-';
         document.getElementById('review-category').textContent = document.getElementById('book-category').value || '-';
         document.getElementById('review-description').textContent = document.getElementById('book-description').value || '-';
         document.getElementById('review-date').textContent = document.getElementById('book-published-date').value || '-';
@@ -227,6 +206,7 @@ const Books = {
 
             document.getElementById('book-id').value = book.id;
             document.getElementById('book-title').value = book.title;
+            document.getElementById('book-isbn').value = book.isbn || '';
             document.getElementById('book-description').value = book.description || '';
 
             AppState.editingBookId = id;
@@ -244,6 +224,7 @@ const Books = {
 
         const bookData = {
             title: document.getElementById('book-title').value.trim(),
+            isbn: document.getElementById('book-isbn').value.trim(),
             description: document.getElementById('book-description').value.trim()
         };
 
@@ -304,7 +285,7 @@ const Books = {
     },
 
     export() {
-        const csv = convertBooksToCSV(AppState.filteredBooks);
+        const csv = convertBooksToCSV(AppState.books);
         downloadCSV(csv, 'books.csv');
         UI.showNotification('Books exported successfully', 'success');
     }
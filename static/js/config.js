@@ -3,10 +3,13 @@ const API_BASE = 'http://localhost:8080';
 const AppState = {
     authToken: null,
     currentUser: null,
+    resetToken: null,
+    twoFactorChallenge: null,
     currentTab: 'books',
 
-    allBooks: [],
-    filteredBooks: [],
+    books: [],
+    booksTotal: 0,
+    booksTotalPages: 0,
     booksCurrentPage: 1,
     booksItemsPerPage: 10,
     bookFilters: {
@@ -16,8 +19,9 @@ const AppState = {
     editingBookId: null,
     currentFormStep: 1,
 
-    allReaders: [],
-    filteredReaders: [],
+    readers: [],
+    readersTotal: 0,
+    readersTotalPages: 0,
     readersCurrentPage: 1,
     readersItemsPerPage: 10,
     readerFilters: {
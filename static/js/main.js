@@ -35,6 +35,33 @@ document.addEventListener('DOMContentLoaded', () => {
     if (loginForm) loginForm.addEventListener('submit', handleLoginSubmit);
     if (registerForm) registerForm.addEventListener('submit', handleRegisterSubmit);
 
+    const twoFactorForm = document.getElementById('two-factor-form');
+    const twoFactorVerifyForm = document.getElementById('two-factor-verify-form');
+    const twoFactorEnableBtn = document.getElementById('two-factor-enable-btn');
+
+    if (twoFactorForm) twoFactorForm.addEventListener('submit', handleTwoFactorSubmit);
+    if (twoFactorVerifyForm) twoFactorVerifyForm.addEventListener('submit', handleTwoFactorVerifySubmit);
+    if (twoFactorEnableBtn) twoFactorEnableBtn.addEventListener('click', handleTwoFactorEnable);
+
+    const forgotForm = document.getElementById('forgot-form');
+    const resetForm = document.getElementById('reset-form');
+    const forgotPasswordLink = document.getElementById('forgot-password-link');
+
+    if (forgotForm) forgotForm.addEventListener('submit', handleForgotSubmit);
+    if (resetForm) resetForm.addEventListener('submit', handleResetSubmit);
+    if (forgotPasswordLink) {
+        forgotPasswordLink.addEventListener('click', (e) => {
+            e.preventDefault();
+            switchAuthTab('forgot');
+        });
+    }
+    document.querySelectorAll('.back-to-login-link').forEach(link => {
+        link.addEventListener('click', (e) => {
+            e.preventDefault();
+            switchAuthTab('login');
+        });
+    });
+
     const userMenuBtn = document.getElementById('user-menu-btn');
     const userDropdown = document.getElementById('user-dropdown');
     const profileMenuItem = document.getElementById('profile-menu-item');
@@ -100,10 +127,10 @@ document.addEventListener('DOMContentLoaded', () => {
     const bookItemsPerPage = document.getElementById('book-items-per-page');
 
     if (bookSearchInput) {
-        bookSearchInput.addEventListener('input', () => {
+        bookSearchInput.addEventListener('input', debounce(() => {
             AppState.bookFilters.search = bookSearchInput.value;
             Books.applyFilters();
-        });
+        }, 300));
     }
 
     if (bookSortBy) {
@@ -142,10 +169,10 @@ document.addEventListener('DOMContentLoaded', () => {
     const readerItemsPerPage = document.getElementById('reader-items-per-page');
 
     if (readerSearchInput) {
-        readerSearchInput.addEventListener('input', () => {
+        readerSearchInput.addEventListener('input', debounce(() => {
             AppState.readerFilters.search = readerSearchInput.value;
             Readers.applyFilters();
-        });
+        }, 300));
     }
 
     if (readerSortBy) {
@@ -165,6 +192,9 @@ document.addEventListener('DOMContentLoaded', () => {
     const refreshStatsBtn = document.getElementById('refresh-stats-btn');
     if (refreshStatsBtn) refreshStatsBtn.addEventListener('click', () => Statistics.load());
 
+    showSingleSignOn();
+    handleMailLinks();
+
     if (Auth.isAuthenticated()) {
         Auth.getProfile().then(profile => {
             AppState.currentUser = profile;
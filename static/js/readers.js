@@ -1,41 +1,13 @@
 const Readers = {
     applyFilters() {
-        const search = AppState.readerFilters.search.toLowerCase();
-
-        AppState.filteredReaders = AppState.allReaders.filter(reader => {
-            const matchesSearch = !search ||
-                reader.name.toLowerCase().includes(search) ||
-                reader.surname.toLowerCase().includes(search);
-            return matchesSearch;
-        });
-
-        const [field, direction] = AppState.readerFilters.sortBy.split('-');
-        AppState.filteredReaders.sort((a, b) => {
-            let aVal, bVal;
-
-            if (field === 'id') {
-                aVal = a.id;
-                bVal = b.id;
-            } else if (field === 'name') {
-                aVal = a.name.toLowerCase();
-                bVal = b.name.toLowerCase();
-            }
-
-            if (direction === 'asc') {
-                return aVal > bVal ? 1 : -1;
-            } else {
-                return aVal < bVal ? 1 : -1;
-            }
-        });
-
         AppState.readersCurrentPage = 1;
-        this.render();
+        this.load();
     },
 
     render() {
         const container = document.getElementById('readers-list');
 
-        if (!AppState.filteredReaders || AppState.filteredReaders.length === 0) {
+        if (!AppState.readers || AppState.readers.length === 0) {
             container.innerHTML = `
                 <div class="empty-state">
                     <h3>No Readers Found</h3>
@@ -46,14 +18,9 @@ const Readers = {
             return;
         }
 
-        // Pagination logic
-        const startIndex = (AppState.readersCurrentPage - 1) * AppState.readersItemsPerPage;
-        const endIndex = startIndex + AppState.readersItemsPerPage;
-        const paginatedReaders = AppState.filteredReaders.slice(startIndex, endIndex);
-
         const html = `
             <div class="items-grid">
-                ${paginatedReaders.map(reader => `
+                ${AppState.readers.map(reader => `
                     <div class="item-card" data-reader-id="${reader.id}">
                         <div class="item-card-header">
                             <span class="item-id">#${reader.id}</span>
@@ -88,7 +55,7 @@ const Readers = {
 
     renderPagination() {
         const container = document.getElementById('readers-pagination');
-        const totalPages = Math.ceil(AppState.filteredReaders.length / AppState.readersItemsPerPage);
+        const totalPages = AppState.readersTotalPages;
 
         if (totalPages <= 1) {
             container.innerHTML = '';
@@ -114,11 +81,10 @@ const Readers = {
         container.innerHTML = html;
     },
 
-    changePage(page) {
-        const totalPages = Math.ceil(AppState.filteredReaders.length / AppState.readersItemsPerPage);
-        if (page >= 1 && page <= totalPages) {
+    async changePage(page) {
+        if (page >= 1 && page <= AppState.readersTotalPages) {
             AppState.readersCurrentPage = page;
-            this.render();
+            await this.load();
             document.getElementById('readers-list').scrollIntoView({ behavior: 'smooth' });
         }
     },
@@ -127,16 +93,27 @@ const Readers = {
         const loading = document.getElementById('readers-loading');
         UI.showLoading(loading);
 
+        // Search, sorting and pagination are done by the server
+        const [sort, order] = AppState.readerFilters.sortBy.split('-');
+
         try {
-            AppState.allReaders = await ReadersAPI.getAll();
-            AppState.filteredReaders = [...AppState.allReaders];
-            this.applyFilters();
+            const page = await ReadersAPI.getAll({
+                q: AppState.readerFilters.search.trim(),
+                sort,
+                order,
+                page: AppState.readersCurrentPage,
+                page_size: AppState.readersItemsPerPage
+            });
+            AppState.readers = page.data;
+            AppState.readersTotal = page.meta.total;
+            AppState.readersTotalPages = page.meta.total_pages;
         } catch (error) {
             UI.showNotification(error.message, 'error');
-            AppState.allReaders = [];
-            AppState.filteredReaders = [];
-            this.render();
+            AppState.readers = [];
+            AppState.readersTotal = 0;
+            AppState.readersTotalPages = 0;
         } finally {
+            this.render();
             UI.hideLoading(loading);
         }
     },
@@ -243,7 +220,7 @@ const Readers = {
     },
 
     export() {
-        const csv = convertReadersToCSV(AppState.filteredReaders);
+        const csv = convertReadersToCSV(AppState.readers);
         downloadCSV(csv, 'readers.csv');
         UI.showNotification('Readers exported successfully', 'success');
     },
@@ -253,8 +230,9 @@ const Readers = {
 
         // Get all books
         try {
-            const books = await BooksAPI.getAll();
-            const reader = AppState.allReaders.find(r => r.id === readerID);
+            const page = await BooksAPI.getAll({ sort: 'title', order: 'asc', page_size: 100 });
+            const books = page.data;
+            const reader = AppState.readers.find(r => r.id === readerID);
             const currentlyReadingIds = reader.currently_reading.map(b => b.id);
 
             // Filter out books already being read
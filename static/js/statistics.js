@@ -1,17 +1,22 @@
 const Statistics = {
     async load() {
         try {
-            const books = await BooksAPI.getAll();
-            const readers = await ReadersAPI.getAll();
+            // Only the totals are needed, so ask for the smallest possible pages
+            const startOfToday = new Date();
+            startOfToday.setHours(0, 0, 0, 0);
+            const createdAfter = startOfToday.toISOString();
 
-            const today = new Date().toDateString();
-            const booksToday = books.filter(b => new Date(b.CreatedAt).toDateString() === today).length;
-            const readersToday = readers.filter(r => new Date(r.CreatedAt).toDateString() === today).length;
+            const [books, readers, booksToday, readersToday] = await Promise.all([
+                BooksAPI.getAll({ page_size: 1 }),
+                ReadersAPI.getAll({ page_size: 1 }),
+                BooksAPI.getAll({ page_size: 1, created_after: createdAfter }),
+                ReadersAPI.getAll({ page_size: 1, created_after: createdAfter })
+            ]);
 
-            document.getElementById('stat-total-books').textContent = books.length;
-            document.getElementById('stat-total-readers').textContent = readers.length;
-            document.getElementById('stat-books-today').textContent = booksToday;
-            document.getElementById('stat-readers-today').textContent = readersToday;
+            document.getElementById('stat-total-books').textContent = books.meta.total;
+            document.getElementById('stat-total-readers').textContent = readers.meta.total;
+            document.getElementById('stat-books-today').textContent = booksToday.meta.total;
+            document.getElementById('stat-readers-today').textContent = readersToday.meta.total;
         } catch (error) {
             UI.showNotification('Failed to load statistics', 'error');
         }
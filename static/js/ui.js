@@ -89,6 +89,10 @@ const UI = {
             document.getElementById('profile-username').textContent = profile.username;
             document.getElementById('profile-email').textContent = profile.email;
             document.getElementById('profile-role').textContent = profile.role;
+            document.getElementById('profile-two-factor').textContent = profile.two_factor_enabled ? 'Enabled' : 'Disabled';
+            document.getElementById('two-factor-enable-btn').classList.toggle('hidden', profile.two_factor_enabled);
+            document.getElementById('two-factor-enrol').classList.add('hidden');
+            document.getElementById('two-factor-recovery').classList.add('hidden');
             document.getElementById('profile-modal').classList.remove('hidden');
         } catch (error) {
             this.showNotification('Failed to load profile', 'error');
@@ -4,6 +4,31 @@ function escapeHtml(text) {
     return div.innerHTML;
 }
 
+// hasPermission checks the permissions the server reported for the current user's role
+function hasPermission(permission) {
+    const permissions = (AppState.currentUser && AppState.currentUser.permissions) || [];
+    return permissions.includes(permission);
+}
+
+function buildQueryString(params) {
+    const query = new URLSearchParams();
+    Object.entries(params).forEach(([key, value]) => {
+        if (value !== undefined && value !== null && value !== '') {
+            query.set(key, value);
+        }
+    });
+    const str = query.toString();
+    return str ? `?${str}` : '';
+}
+
+function debounce(fn, delay) {
+    let timer = null;
+    return (...args) => {
+        clearTimeout(timer);
+        timer = setTimeout(() => fn(...args), delay);
+    };
+}
+
 function convertBooksToCSV(books) {
     const headers = ['ID', 'Title', 'Description'];
     const rows = books.map(book => [
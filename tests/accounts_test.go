@@ -0,0 +1,67 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"lab1/config"
+	"lab1/models"
+	"lab1/service"
+	"sync"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestConcurrentCreditsStayWithinTheBalance(t *testing.T) {
+	c := newTestContainer(t, config.DefaultConfig())
+	ctx := context.Background()
+	reader, _ := newLendingFixture(t, c)
+	charge := &models.AccountTransaction{ReaderID: reader.ID, Type: models.TransactionCharge, AmountCents: 500}
+	if err := c.AccountRepository.Create(ctx, charge); err != nil {
+		t.Fatalf("charging: %v", err)
+	}
+
+	credit := func(transactionType string, amount int64) error {
+		createdByID := uint(1)
+		return c.AccountService.Credit(ctx, &models.AccountTransaction{ReaderID: reader.ID, Type: transactionType, AmountCents: amount, CreatedByID: &createdByID})
+	}
+
+	// Each credit settles the whole balance, only one of them may be recorded
+	var wg sync.WaitGroup
+	errs := make([]error, 6)
+	for i := range errs {
+		transactionType := models.TransactionPayment
+		if i%2 == 1 {
+			transactionType = models.TransactionWaiver
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = credit(transactionType, 500)
+		}(i)
+	}
+	wg.Wait()
+
+	recorded := 0
+	for _, err := range errs {
+		var exceeded *service.BalanceExceededError
+		switch {
+		case err == nil:
+			recorded++
+		case !errors.As(err, &exceeded) || exceeded.Balance != 0:
+			t.Errorf("expected the balance of 0 to be exceeded, got %v", err)
+		}
+	}
+	if recorded != 1 {
+		t.Errorf("expected one credit to be recorded, got %d", recorded)
+	}
+	if balance, err := c.AccountRepository.Balance(ctx, reader.ID); err != nil || balance != 0 {
+		t.Errorf("expected a balance of 0, got %d, %v", balance, err)
+	}
+
+	createdByID := uint(1)
+	unknown := &models.AccountTransaction{ReaderID: 999, Type: models.TransactionPayment, AmountCents: 1, CreatedByID: &createdByID}
+	if err := c.AccountService.Credit(ctx, unknown); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("expected an unknown reader to be not found, got %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+package tests
+
+import (
+	"context"
+	"lab1/config"
+	"lab1/models"
+	"net/http"
+	"testing"
+)
+
+// createAPIKey creates a key for the session and returns it
+func createAPIKey(t *testing.T, app, access string, scopes ...string) string {
+	t.Helper()
+	status, body := callAPI(t, http.MethodPost, app+"/auth/api-keys", "Bearer "+access, map[string]interface{}{"name": "script", "scopes": scopes})
+	if status != http.StatusCreated {
+		t.Fatalf("creating API key with %v: status %d, %v", scopes, status, body)
+	}
+	return body["key"].(string)
+}
+
+func TestAPIKeyScopesAreLimitedByRole(t *testing.T) {
+	c := newTestContainer(t, config.DefaultConfig())
+	app := newAuthApp(t, c)
+	ctx := context.Background()
+
+	member := &models.User{Username: "member", Email: "member@example.com", Role: "member"}
+	if err := member.HashPassword("password"); err != nil {
+		t.Fatalf("hashing password: %v", err)
+	}
+	if err := c.UserRepository.Create(ctx, member); err != nil {
+		t.Fatalf("creating user: %v", err)
+	}
+	memberAccess, _ := login(t, app, "member", "password")
+	status, body := callAPI(t, http.MethodPost, app+"/auth/api-keys", "Bearer "+memberAccess, map[string]interface{}{"name": "script", "scopes": []string{"books:read", "books:import"}})
+	if status != http.StatusBadRequest {
+		t.Errorf("expected a scope beyond the role to be refused, got %d %v", status, body)
+	}
+
+	access, _ := login(t, app, "admin", "password")
+	readOnly := createAPIKey(t, app, access, "books:read")
+	importer := createAPIKey(t, app, access, "books:read", "books:import")
+
+	for _, tc := range []struct {
+		name, authorization, method, path string
+		status                            int
+	}{
+		{"session", "Bearer " + access, http.MethodPost, "/books/import", http.StatusNoContent},
+		{"key in scope", "ApiKey " + readOnly, http.MethodGet, "/books", http.StatusNoContent},
+		{"key out of scope", "ApiKey " + readOnly, http.MethodPost, "/books/import", http.StatusForbidden},
+		{"key with the scope", "ApiKey " + importer, http.MethodPost, "/books/import", http.StatusNoContent},
+		{"unknown key", "ApiKey lib_unknown", http.MethodGet, "/books", http.StatusUnauthorized},
+	} {
+		if status, body := callAPI(t, tc.method, app+tc.path, tc.authorization, nil); status != tc.status {
+			t.Errorf("%s: expected %d, got %d %v", tc.name, tc.status, status, body)
+		}
+	}
+
+	// Scopes grant nothing the role does not: once the owner is demoted the
+	// key loses what the new role lacks
+	admin, err := c.UserRepository.GetByID(ctx, 1)
+	if err != nil {
+		t.Fatalf("finding admin: %v", err)
+	}
+	admin.Role = "member"
+	if err := c.UserRepository.Update(ctx, admin); err != nil {
+		t.Fatalf("changing role: %v", err)
+	}
+	if status, _ := callAPI(t, http.MethodPost, app+"/books/import", "ApiKey "+importer, nil); status != http.StatusForbidden {
+		t.Errorf("expected the key to lose a permission of the old role, got %d", status)
+	}
+	if status, _ := callAPI(t, http.MethodGet, app+"/books", "ApiKey "+importer, nil); status != http.StatusNoContent {
+		t.Errorf("expected the key to keep a permission of the new role, got %d", status)
+	}
+}
+
+func TestAPIKeysCannotUseSessionRoutes(t *testing.T) {
+	c := newTestContainer(t, config.DefaultConfig())
+	app := newAuthApp(t, c)
+	access, _ := login(t, app, "admin", "password")
+	key := "ApiKey " + createAPIKey(t, app, access, "books:read", "users:manage")
+
+	for _, tc := range []struct {
+		method, path string
+		body         interface{}
+	}{
+		{http.MethodGet, "/auth/api-keys", nil},
+		{http.MethodPost, "/auth/api-keys", map[string]interface{}{"name": "copy", "scopes": []string{"books:read"}}},
+		{http.MethodDelete, "/auth/api-keys/1", nil},
+		{http.MethodPost, "/auth/logout", nil},
+		{http.MethodPost, "/auth/logout-all", nil},
+		{http.MethodPost, "/auth/2fa/setup", nil},
+	} {
+		if status, body := callAPI(t, tc.method, app+tc.path, key, tc.body); status != http.StatusForbidden {
+			t.Errorf("%s %s with an API key: expected 403, got %d %v", tc.method, tc.path, status, body)
+		}
+	}
+
+	// The key is still valid for the routes it may use
+	if status, _ := callAPI(t, http.MethodGet, app+"/auth/profile", key, nil); status != http.StatusOK {
+		t.Errorf("profile with an API key: expected 200, got %d", status)
+	}
+	var keys int64
+	c.DB.Model(&models.APIKey{}).Count(&keys)
+	if keys != 1 {
+		t.Errorf("expected the key to be neither deleted nor copied, got %d keys", keys)
+	}
+}
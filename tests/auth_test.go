@@ -0,0 +1,167 @@
+package tests
+
+import (
+	"lab1/config"
+	"lab1/container"
+	"lab1/handlers"
+	"lab1/middleware"
+	"lab1/rbac"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newAuthApp serves the auth routes of c behind the real authentication
+// middleware, the way main.go does. GET /books and POST /books/import stand in
+// for routes guarded by a permission, and answer 204 to whoever gets through.
+func newAuthApp(t *testing.T, c *container.Container) string {
+	gin.SetMode(gin.TestMode)
+	h := handlers.NewAuthHandler(c.UserRepository, c.TokenRepository, c.UserTokenRepository, c.LoginAttemptRepository, c.AuditRepository, c.Mailer, c.Keys, c.OIDC, c.Policy, c.Validator, c.Config)
+	apiKeys := handlers.NewAPIKeysHandler(c.APIKeyRepository, c.AuditRepository, c.Policy, c.Validator, c.Config)
+	authenticate := middleware.AuthMiddleware(c.Keys, c.UserRepository, c.TokenRepository, c.APIKeyRepository)
+	sessionOnly := middleware.SessionOnly()
+	granted := func(c *gin.Context) { c.Status(http.StatusNoContent) }
+
+	r := gin.New()
+	auth := r.Group("/auth")
+	auth.POST("/login", h.Login)
+	auth.POST("/refresh", h.Refresh)
+	auth.POST("/logout", authenticate, sessionOnly, h.Logout)
+	auth.POST("/logout-all", authenticate, sessionOnly, h.LogoutAll)
+	auth.GET("/profile", authenticate, h.GetProfile)
+	auth.POST("/2fa/login", h.LoginTwoFactor)
+	auth.POST("/2fa/setup", authenticate, sessionOnly, h.SetupTwoFactor)
+	auth.POST("/2fa/verify", authenticate, sessionOnly, h.VerifyTwoFactor)
+	auth.POST("/2fa/disable", authenticate, sessionOnly, h.DisableTwoFactor)
+	auth.GET("/api-keys", authenticate, sessionOnly, apiKeys.GetAll)
+	auth.POST("/api-keys", authenticate, sessionOnly, apiKeys.Create)
+	auth.DELETE("/api-keys/:id", authenticate, sessionOnly, apiKeys.Delete)
+	r.GET("/books", authenticate, middleware.RequirePermission(c.Policy, rbac.BooksRead), granted)
+	r.POST("/books/import", authenticate, middleware.RequirePermission(c.Policy, rbac.BooksImport), granted)
+
+	app := httptest.NewServer(r)
+	t.Cleanup(app.Close)
+	return app.URL
+}
+
+// login signs in and returns the access and the refresh token
+func login(t *testing.T, app, username, password string) (string, string) {
+	t.Helper()
+	status, body := callAPI(t, http.MethodPost, app+"/auth/login", "", map[string]string{"username": username, "password": password})
+	if status != http.StatusOK {
+		t.Fatalf("login as %s: status %d, %v", username, status, body)
+	}
+	return body["token"].(string), body["refresh_token"].(string)
+}
+
+// refresh exchanges the refresh token, returning the status and the new tokens
+func refresh(t *testing.T, app, refreshToken string) (int, string, string) {
+	t.Helper()
+	status, body := callAPI(t, http.MethodPost, app+"/auth/refresh", "", map[string]string{"refresh_token": refreshToken})
+	if status != http.StatusOK {
+		return status, "", ""
+	}
+	return status, body["token"].(string), body["refresh_token"].(string)
+}
+
+// authorized tells whether the access token is still accepted
+func authorized(t *testing.T, app, token string) bool {
+	t.Helper()
+	status, _ := callAPI(t, http.MethodGet, app+"/auth/profile", "Bearer "+token, nil)
+	return status == http.StatusOK
+}
+
+func TestRefreshRotatesTokens(t *testing.T) {
+	app := newAuthApp(t, newTestContainer(t, config.DefaultConfig()))
+	access, first := login(t, app, "admin", "password")
+
+	status, newAccess, second := refresh(t, app, first)
+	if status != http.StatusOK || second == first {
+		t.Fatalf("refresh: expected a new refresh token, got status %d", status)
+	}
+	if !authorized(t, app, access) || !authorized(t, app, newAccess) {
+		t.Error("expected both access tokens to stay valid until they expire")
+	}
+	if status, _, _ := refresh(t, app, "not-a-token"); status != http.StatusUnauthorized {
+		t.Errorf("unknown refresh token: expected 401, got %d", status)
+	}
+
+	// The new token can be rotated in turn
+	if status, _, third := refresh(t, app, second); status != http.StatusOK || third == second {
+		t.Errorf("second refresh: expected a new refresh token, got status %d", status)
+	}
+}
+
+func TestRefreshTokenReuseRevokesFamily(t *testing.T) {
+	app := newAuthApp(t, newTestContainer(t, config.DefaultConfig()))
+	_, stolen := login(t, app, "admin", "password")
+	otherAccess, otherRefresh := login(t, app, "admin", "password")
+
+	_, access, current := refresh(t, app, stolen)
+	if current == "" {
+		t.Fatal("refresh failed")
+	}
+
+	// Presenting the rotated token again means it was copied
+	status, body := callAPI(t, http.MethodPost, app+"/auth/refresh", "", map[string]string{"refresh_token": stolen})
+	if status != http.StatusUnauthorized {
+		t.Fatalf("reused token: expected 401, got %d %v", status, body)
+	}
+	if status, _, _ := refresh(t, app, current); status != http.StatusUnauthorized {
+		t.Errorf("expected the current token of the family to be revoked, got %d", status)
+	}
+	if authorized(t, app, access) {
+		t.Error("expected the access token of the family to be revoked")
+	}
+
+	// Other sessions of the user are not affected
+	if !authorized(t, app, otherAccess) {
+		t.Error("expected the access token of the other session to stay valid")
+	}
+	if status, _, _ := refresh(t, app, otherRefresh); status != http.StatusOK {
+		t.Errorf("expected the other session to refresh, got %d", status)
+	}
+}
+
+func TestLogoutEndsSessions(t *testing.T) {
+	app := newAuthApp(t, newTestContainer(t, config.DefaultConfig()))
+	access, refreshToken := login(t, app, "admin", "password")
+	otherAccess, otherRefresh := login(t, app, "admin", "password")
+
+	if status, body := callAPI(t, http.MethodPost, app+"/auth/logout", "Bearer "+access, nil); status != http.StatusNoContent {
+		t.Fatalf("logout: expected 204, got %d %v", status, body)
+	}
+	if authorized(t, app, access) {
+		t.Error("expected the access token to be revoked by logout")
+	}
+	if status, _, _ := refresh(t, app, refreshToken); status != http.StatusUnauthorized {
+		t.Errorf("expected the refresh token to be revoked by logout, got %d", status)
+	}
+	if !authorized(t, app, otherAccess) {
+		t.Fatal("expected logout to leave the other session alone")
+	}
+
+	// Logging out everywhere revokes every family, rotated ones included
+	_, rotatedAccess, rotated := refresh(t, app, otherRefresh)
+	thirdAccess, thirdRefresh := login(t, app, "admin", "password")
+	if status, body := callAPI(t, http.MethodPost, app+"/auth/logout-all", "Bearer "+thirdAccess, nil); status != http.StatusNoContent {
+		t.Fatalf("logout-all: expected 204, got %d %v", status, body)
+	}
+	for _, token := range []string{otherAccess, rotatedAccess, thirdAccess} {
+		if authorized(t, app, token) {
+			t.Error("expected every access token to be revoked by logout-all")
+		}
+	}
+	for _, token := range []string{rotated, thirdRefresh} {
+		if status, _, _ := refresh(t, app, token); status != http.StatusUnauthorized {
+			t.Errorf("expected every refresh token to be revoked by logout-all, got %d", status)
+		}
+	}
+
+	// Signing in again starts a new session
+	if access, _ := login(t, app, "admin", "password"); !authorized(t, app, access) {
+		t.Error("expected a new login to work after logout-all")
+	}
+}
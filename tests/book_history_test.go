@@ -0,0 +1,131 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"lab1/config"
+	"lab1/models"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// revisionChanges returns the changes of each revision on a history page as
+// "field: from -> to", keyed by revision number
+func revisionChanges(t *testing.T, body map[string]interface{}) map[int][]string {
+	t.Helper()
+	changes := map[int][]string{}
+	for _, revision := range body["data"].([]interface{}) {
+		revision := revision.(map[string]interface{})
+		number := int(revision["revision"].(float64))
+		changes[number] = []string{}
+		for _, change := range revision["changes"].([]interface{}) {
+			change := change.(map[string]interface{})
+			from, _ := json.Marshal(change["from"])
+			to, _ := json.Marshal(change["to"])
+			changes[number] = append(changes[number], fmt.Sprintf("%s: %s -> %s", change["field"], from, to))
+		}
+	}
+	return changes
+}
+
+func TestBookHistoryShowsChanges(t *testing.T) {
+	c := newTestContainer(t, config.DefaultConfig())
+	app := newBooksApp(t, c)
+	author := &models.Author{Name: "Frank Herbert"}
+	if err := c.AuthorRepository.Create(context.Background(), author); err != nil {
+		t.Fatalf("creating author: %v", err)
+	}
+
+	status, body := callAPI(t, http.MethodPost, app+"/books", "", map[string]interface{}{"title": "Dune", "isbn": "0306406152", "author_ids": []uint{author.ID}})
+	if status != http.StatusCreated {
+		t.Fatalf("creating book: status %d, %v", status, body)
+	}
+	book := fmt.Sprintf("%s/books/%v", app, body["id"])
+	update := map[string]interface{}{"title": "Dune Messiah", "description": "Sequel", "isbn": "0306406152", "author_ids": []uint{author.ID}}
+	if status, body := callAPI(t, http.MethodPut, book, "", update); status != http.StatusNoContent {
+		t.Fatalf("updating book: status %d, %v", status, body)
+	}
+
+	status, body = callAPI(t, http.MethodGet, book+"/history", "", nil)
+	if status != http.StatusOK {
+		t.Fatalf("history: status %d, %v", status, body)
+	}
+	authorIDs := fmt.Sprintf("[%d]", author.ID)
+	want := map[int][]string{
+		// The first revision shows every field as set
+		1: {"author_ids: null -> " + authorIDs, `description: null -> ""`, `isbn: null -> "9780306406157"`,
+			"publisher_ids: null -> []", "subject_ids: null -> []", `title: null -> "Dune"`},
+		2: {`description: "" -> "Sequel"`, `title: "Dune" -> "Dune Messiah"`},
+	}
+	if got := revisionChanges(t, body); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("expected changes %v, got %v", want, got)
+	}
+	if first := body["data"].([]interface{})[0].(map[string]interface{}); first["revision"] != 2.0 {
+		t.Errorf("expected the newest revision first, got %v", first["revision"])
+	}
+
+	// The revision before the oldest one on a page is looked up for its changes
+	status, body = callAPI(t, http.MethodGet, book+"/history?page=1&page_size=1", "", nil)
+	if got := revisionChanges(t, body); status != http.StatusOK || fmt.Sprint(got[2]) != fmt.Sprint(want[2]) {
+		t.Errorf("expected the changes of revision 2 on a page of its own, got %d %v", status, got)
+	}
+}
+
+func TestBookRestoreRevision(t *testing.T) {
+	c := newTestContainer(t, config.DefaultConfig())
+	app := newBooksApp(t, c)
+	ctx := context.Background()
+	author := &models.Author{Name: "Frank Herbert"}
+	if err := c.AuthorRepository.Create(ctx, author); err != nil {
+		t.Fatalf("creating author: %v", err)
+	}
+
+	status, body := callAPI(t, http.MethodPost, app+"/books", "", map[string]interface{}{"title": "Dune", "isbn": "0306406152", "author_ids": []uint{author.ID}})
+	if status != http.StatusCreated {
+		t.Fatalf("creating book: status %d, %v", status, body)
+	}
+	book := fmt.Sprintf("%s/books/%v", app, body["id"])
+	if status, body := callAPI(t, http.MethodPut, book, "", map[string]interface{}{"title": "Dune Messiah"}); status != http.StatusNoContent {
+		t.Fatalf("updating book: status %d, %v", status, body)
+	}
+
+	for _, tc := range []struct {
+		rev    string
+		status int
+	}{
+		{"9", http.StatusNotFound},
+		{"0", http.StatusBadRequest},
+		{"first", http.StatusBadRequest},
+	} {
+		if status, body := callAPI(t, http.MethodPost, book+"/history/"+tc.rev+"/restore", "", nil); status != tc.status {
+			t.Errorf("restoring revision %s: expected %d, got %d %v", tc.rev, tc.status, status, body)
+		}
+	}
+
+	// Restoring brings back the fields and links, as a new revision
+	status, body = callAPI(t, http.MethodPost, book+"/history/1/restore", "", nil)
+	if status != http.StatusOK || body["title"] != "Dune" || body["isbn"] != "9780306406157" {
+		t.Fatalf("restore: expected the first version, got %d %v", status, body)
+	}
+	if authors, _ := body["authors"].([]interface{}); len(authors) != 1 {
+		t.Errorf("expected the author to be linked again, got %v", body["authors"])
+	}
+	_, body = callAPI(t, http.MethodGet, book+"/history", "", nil)
+	changes := revisionChanges(t, body)
+	if len(changes) != 3 || !strings.Contains(strings.Join(changes[3], ", "), `title: "Dune Messiah" -> "Dune"`) {
+		t.Errorf("expected the restore as revision 3, got %v", changes)
+	}
+
+	// An ISBN taken by another book in the meantime is not restored over it
+	if status, body := callAPI(t, http.MethodPut, book, "", map[string]interface{}{"title": "Dune"}); status != http.StatusNoContent {
+		t.Fatalf("updating book: status %d, %v", status, body)
+	}
+	if status, body := callAPI(t, http.MethodPost, app+"/books", "", map[string]interface{}{"title": "Other", "isbn": "9780306406157"}); status != http.StatusCreated {
+		t.Fatalf("creating book: status %d, %v", status, body)
+	}
+	if status, body := callAPI(t, http.MethodPost, book+"/history/1/restore", "", nil); status != http.StatusConflict {
+		t.Errorf("restoring a taken ISBN: expected 409, got %d %v", status, body)
+	}
+}
@@ -0,0 +1,234 @@
+package tests
+
+import (
+	"context"
+	"lab1/config"
+	"lab1/container"
+	"lab1/handlers"
+	"lab1/models"
+	"lab1/repository"
+	"lab1/validation"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// searchModes are the values of full_text_search the search tests run with:
+// the full-text index where the build has one, and the substring fallback
+var searchModes = []string{"auto", "off"}
+
+// newBooksApp serves the book endpoints of c to the seeded admin
+func newBooksApp(t *testing.T, c *container.Container) string {
+	gin.SetMode(gin.TestMode)
+	h := handlers.NewBooksHandler(c.BookService, c.BookRepository, c.ItemRepository, c.AuthorRepository,
+		c.PublisherRepository, c.SubjectRepository, c.AuditRepository, c.Policy, c.Validator, c.Config)
+	r := gin.New()
+	r.Use(actAs(1, "admin", "admin"))
+	r.GET("/books/search", h.Search)
+	r.GET("/books/isbn/:isbn", h.GetByISBN)
+	r.POST("/books", h.Create)
+	r.PUT("/books/:id", h.Update)
+	r.GET("/books/:id/history", h.GetHistory)
+	r.POST("/books/:id/history/:rev/restore", h.RestoreRevision)
+	r.GET("/books/export", h.Export)
+	r.POST("/books/import", h.Import)
+	r.POST("/books/import/marc", h.ImportMARC)
+
+	app := httptest.NewServer(r)
+	t.Cleanup(app.Close)
+	return app.URL
+}
+
+// newSearchContainer creates the books the search tests look for
+func newSearchContainer(t *testing.T, mode string) *container.Container {
+	cfg := config.DefaultConfig()
+	cfg.FullTextSearch = mode
+	c := newTestContainer(t, cfg)
+	for _, book := range []models.Book{
+		{Title: "Dune Messiah", Description: "The second novel set on the desert planet Arrakis", UserID: 1},
+		{Title: "The Hobbit", Description: "There and back again", UserID: 1},
+		{Title: "Desert Solitaire", Description: "A season in the wilderness", UserID: 1},
+	} {
+		if err := c.BookRepository.Create(context.Background(), &book); err != nil {
+			t.Fatalf("creating %q: %v", book.Title, err)
+		}
+	}
+	return c
+}
+
+func searchTitles(t *testing.T, c *container.Container, q string) ([]string, *repository.BookSearchPage) {
+	t.Helper()
+	page, err := c.BookRepository.Search(context.Background(), repository.ListQuery{Q: q, Page: 1, PageSize: 10})
+	if err != nil {
+		t.Fatalf("searching %s: %v", q, err)
+	}
+	var titles []string
+	for _, hit := range page.Hits {
+		titles = append(titles, hit.Book.Title)
+	}
+	return titles, page
+}
+
+func TestBookSearchParsesWordsAndPhrases(t *testing.T) {
+	for _, mode := range searchModes {
+		t.Run(mode, func(t *testing.T) {
+			c := newSearchContainer(t, mode)
+			if mode == "off" && c.BookRepository.FullText() {
+				t.Fatal("full_text_search off still uses the index")
+			}
+
+			for _, tc := range []struct {
+				q    string
+				want []string
+			}{
+				{"dun", []string{"Dune Messiah"}},
+				{"HOBBIT", []string{"The Hobbit"}},
+				{"desert", []string{"Desert Solitaire", "Dune Messiah"}}, // title hits first
+				{"desert arrakis", []string{"Dune Messiah"}},
+				{`"desert planet"`, []string{"Dune Messiah"}},
+				{`"planet desert"`, nil},
+				{`  desert   "the wilderness"  `, []string{"Desert Solitaire"}},
+				{`"desert planet`, []string{"Dune Messiah"}}, // an open quote runs to the end
+				{"hobbit desert", nil},
+				{`""`, nil},
+			} {
+				titles, page := searchTitles(t, c, tc.q)
+				if strings.Join(titles, ", ") != strings.Join(tc.want, ", ") || page.Total != int64(len(tc.want)) {
+					t.Errorf("searching %s: expected %v, got %v of %d", tc.q, tc.want, titles, page.Total)
+				}
+			}
+		})
+	}
+}
+
+func TestFullTextSearchRequiredRefusesToStartWithoutIndex(t *testing.T) {
+	available := newTestContainer(t, config.DefaultConfig()).BookRepository.FullText()
+
+	cfg := config.DefaultConfig()
+	cfg.FullTextSearch = "required"
+	_, err := openTestContainer(t, cfg)
+	if available && err != nil {
+		t.Errorf("expected to start with the index available, got %v", err)
+	}
+	if !available && err == nil {
+		t.Error("expected to refuse to start without the index")
+	}
+
+	cfg = config.DefaultConfig()
+	cfg.FullTextSearch = "yes"
+	if _, err := openTestContainer(t, cfg); err == nil {
+		t.Error("expected an invalid full_text_search to be rejected")
+	}
+}
+
+func TestBookSearchHighlightsHits(t *testing.T) {
+	mark := func(s string) string { return repository.HighlightStart + s + repository.HighlightEnd }
+
+	for _, mode := range searchModes {
+		t.Run(mode, func(t *testing.T) {
+			c := newSearchContainer(t, mode)
+			long := &models.Book{
+				Title:       "Long",
+				Description: strings.Repeat("filler ", 30) + "needle " + strings.Repeat("padding ", 30),
+				UserID:      1,
+			}
+			if err := c.BookRepository.Create(context.Background(), long); err != nil {
+				t.Fatalf("creating book: %v", err)
+			}
+
+			_, page := searchTitles(t, c, "desert")
+			if len(page.Hits) != 2 {
+				t.Fatalf("expected 2 hits, got %d", len(page.Hits))
+			}
+			if got, want := page.Hits[0].TitleHighlight, mark("Desert")+" Solitaire"; got != want {
+				t.Errorf("expected title %q, got %q", want, got)
+			}
+			if got, want := page.Hits[1].Snippet, "The second novel set on the "+mark("desert")+" planet Arrakis"; got != want {
+				t.Errorf("expected snippet %q, got %q", want, got)
+			}
+
+			// Long descriptions are cut down to the words around the hit
+			_, page = searchTitles(t, c, "needle")
+			if len(page.Hits) != 1 {
+				t.Fatalf("expected 1 hit, got %d", len(page.Hits))
+			}
+			snippet := page.Hits[0].Snippet
+			if !strings.Contains(snippet, mark("needle")) || len(strings.Fields(snippet)) > 20 {
+				t.Errorf("expected a short snippet around the hit, got %q", snippet)
+			}
+		})
+	}
+}
+
+func TestBookSearchResponsesEscapeText(t *testing.T) {
+	for _, mode := range searchModes {
+		t.Run(mode, func(t *testing.T) {
+			c := newSearchContainer(t, mode)
+			app := newBooksApp(t, c)
+
+			status, body := callAPI(t, http.MethodPost, app+"/books", "", map[string]string{"title": "Tags <b>bold</b>"})
+			if status != http.StatusCreated {
+				t.Fatalf("creating book: status %d, %v", status, body)
+			}
+			status, body = callAPI(t, http.MethodGet, app+"/books/search?q=bold", "", nil)
+			if status != http.StatusOK {
+				t.Fatalf("searching: status %d, %v", status, body)
+			}
+			hits := body["data"].([]interface{})
+			if len(hits) != 1 {
+				t.Fatalf("expected 1 hit, got %v", hits)
+			}
+			if got, want := hits[0].(map[string]interface{})["title_highlight"], "Tags &lt;b&gt;<mark>bold</mark>&lt;/b&gt;"; got != want {
+				t.Errorf("expected %q, got %q", want, got)
+			}
+		})
+	}
+}
+
+// The highlight markers are control characters, book text must not fake them
+func TestBooksRejectControlCharacters(t *testing.T) {
+	c := newTestContainer(t, config.DefaultConfig())
+	app := newBooksApp(t, c)
+
+	for _, book := range []map[string]string{
+		{"title": "Fake " + repository.HighlightStart + "hit" + repository.HighlightEnd},
+		{"title": "Dune", "description": "Bell\a"},
+	} {
+		if status, body := callAPI(t, http.MethodPost, app+"/books", "", book); status != http.StatusBadRequest {
+			t.Errorf("creating %q: expected 400, got %d %v", book, status, body)
+		}
+	}
+	status, body := callAPI(t, http.MethodPost, app+"/books", "", map[string]string{"title": "Dune", "description": "Line one\n\tLine two\r\n"})
+	if status != http.StatusCreated {
+		t.Errorf("expected tabs and line breaks to be accepted, got %d %v", status, body)
+	}
+
+	// Imports drop them instead
+	status, body = callAPI(t, http.MethodPost, app+"/books/import", "", "title,description\n\"Emma\x02\",\"A \x03novel\"\n")
+	if status != http.StatusCreated {
+		t.Fatalf("importing: status %d, %v", status, body)
+	}
+	var book models.Book
+	if err := c.DB.Where("title LIKE ?", "Emma%").First(&book).Error; err != nil {
+		t.Fatalf("finding imported book: %v", err)
+	}
+	if book.Title != "Emma" || book.Description != "A novel" {
+		t.Errorf("expected control characters to be stripped, got %q, %q", book.Title, book.Description)
+	}
+}
+
+func TestStripControl(t *testing.T) {
+	for _, tc := range []struct{ in, want string }{
+		{"plain", "plain"},
+		{"a\x00b\x1fc", "abc"},
+		{"tab\tnew\nline\r", "tab\tnew\nline\r"},
+		{"\x02Ünïcode\x03", "Ünïcode"},
+	} {
+		if got := validation.StripControl(tc.in); got != tc.want {
+			t.Errorf("StripControl(%q) = %q, expected %q", tc.in, got, tc.want)
+		}
+	}
+}
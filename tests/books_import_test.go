@@ -0,0 +1,181 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"lab1/config"
+	"lab1/models"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// importErrors flattens the errors of an import result to "row field" strings
+func importErrors(body map[string]interface{}) []string {
+	var errs []string
+	list, _ := body["errors"].([]interface{})
+	for _, e := range list {
+		e := e.(map[string]interface{})
+		errs = append(errs, fmt.Sprintf("%v %v", e["row"], e["field"]))
+	}
+	return errs
+}
+
+func TestImportCSVCreatesBooksWithLinks(t *testing.T) {
+	c := newTestContainer(t, config.DefaultConfig())
+	app := newBooksApp(t, c)
+	herbert := &models.Author{Name: "Frank Herbert"}
+	if err := c.AuthorRepository.Create(context.Background(), herbert); err != nil {
+		t.Fatalf("creating author: %v", err)
+	}
+
+	csv := "\ufeffTitle,ISBN,Authors,Subjects,Shelf\n" +
+		"Dune,0-306-40615-2,Frank Herbert; Brian Herbert ;Frank Herbert,Science fiction,A1\n" +
+		`"Emma, a Novel",,Jane Austen,,B2` + "\n"
+
+	// A dry run validates without storing anything
+	status, body := callAPI(t, http.MethodPost, app+"/books/import?dry_run=true", "", csv)
+	if status != http.StatusOK || body["rows"] != 2.0 || body["imported"] != 0.0 {
+		t.Fatalf("dry run: expected 200 with 2 rows, got %d %v", status, body)
+	}
+	var books int64
+	c.DB.Model(&models.Book{}).Count(&books)
+	if books != 0 {
+		t.Fatalf("dry run stored %d books", books)
+	}
+
+	status, body = callAPI(t, http.MethodPost, app+"/books/import", "", csv)
+	if status != http.StatusCreated || body["imported"] != 2.0 {
+		t.Fatalf("import: expected 201 with 2 imported, got %d %v", status, body)
+	}
+
+	var dune models.Book
+	if err := c.DB.Preload("Authors").Preload("Subjects").Where("title = ?", "Dune").First(&dune).Error; err != nil {
+		t.Fatalf("finding imported book: %v", err)
+	}
+	if dune.ISBN == nil || *dune.ISBN != "9780306406157" {
+		t.Errorf("expected the normalized ISBN, got %v", dune.ISBN)
+	}
+	var authors []string
+	for _, author := range dune.Authors {
+		authors = append(authors, author.Name)
+		if author.Name == "Frank Herbert" && author.ID != herbert.ID {
+			t.Error("expected the existing author to be linked, not a new one")
+		}
+	}
+	if len(authors) != 2 || len(dune.Subjects) != 1 {
+		t.Errorf("expected 2 authors and 1 subject, got %v and %d", authors, len(dune.Subjects))
+	}
+	var emma models.Book
+	if err := c.DB.Where("title = ?", "Emma, a Novel").First(&emma).Error; err != nil {
+		t.Errorf("expected the quoted title to be imported: %v", err)
+	}
+}
+
+func TestImportCSVReportsEveryInvalidRow(t *testing.T) {
+	c := newTestContainer(t, config.DefaultConfig())
+	app := newBooksApp(t, c)
+	if status, body := callAPI(t, http.MethodPost, app+"/books", "", map[string]string{"title": "Existing", "isbn": "9780306406157"}); status != http.StatusCreated {
+		t.Fatalf("creating book: status %d, %v", status, body)
+	}
+
+	csv := "title,isbn,authors\n" +
+		"Valid,,\n" +
+		",,\n" + // line 3: no title
+		"Bad ISBN,978-0-306-40615-8,\n" + // line 4
+		"Taken,0-306-40615-2,\n" + // line 5: the ISBN of Existing
+		"First,0-8044-2957-X,\n" +
+		"Repeated,9780804429573,\n" + // line 7: the ISBN of line 6
+		"Long name,," + strings.Repeat("x", 256) + "\n" + // line 8
+		"\"Broken quote,,\n" // line 9
+	status, body := callAPI(t, http.MethodPost, app+"/books/import", "", csv)
+	if status != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d %v", status, body)
+	}
+	want := []string{"3 Title", "4 ISBN", "5 ISBN", "7 ISBN", "8 Authors", "9 general"}
+	if got := importErrors(body); strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Errorf("expected errors %v, got %v", want, got)
+	}
+	// Names are reported under their column, with the rule they broke
+	for _, e := range body["errors"].([]interface{}) {
+		e := e.(map[string]interface{})
+		if e["field"] == "Authors" && e["message"] != "Authors must be at most 255 characters" {
+			t.Errorf("expected the length of the author to be reported, got %q", e["message"])
+		}
+	}
+	if body["rows"] != 8.0 {
+		t.Errorf("expected 8 rows, got %v", body["rows"])
+	}
+
+	// Nothing is stored when a row is invalid
+	var books int64
+	c.DB.Model(&models.Book{}).Count(&books)
+	if books != 1 {
+		t.Errorf("expected only the existing book, got %d books", books)
+	}
+}
+
+func TestImportCSVRejectsUnusableFiles(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.ImportMaxRows = 2
+	app := newBooksApp(t, newTestContainer(t, cfg))
+
+	for _, tc := range []struct {
+		name, query, csv string
+	}{
+		{"empty file", "", ""},
+		{"no title column", "", "name,isbn\nDune,\n"},
+		{"two title columns", `?mapping={"Name":"title"}`, "Name,title\nDune,Dune\n"},
+		{"unknown mapped field", `?mapping={"Name":"owner"}`, "Name\nDune\n"},
+		{"invalid mapping", `?mapping=[1]`, "title\nDune\n"},
+		{"too many rows", "", "title\nA\nB\nC\n"},
+		{"invalid dry_run", "?dry_run=maybe", "title\nDune\n"},
+	} {
+		if status, body := callAPI(t, http.MethodPost, app+"/books/import"+tc.query, "", tc.csv); status != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d %v", tc.name, status, body)
+		}
+	}
+
+	// Files with other headers are imported with a mapping
+	status, body := callAPI(t, http.MethodPost, app+`/books/import?mapping={"Name":"title","Summary":"description"}`, "", "Name,Summary\nDune,Desert planet\n")
+	if status != http.StatusCreated || body["imported"] != 1.0 {
+		t.Errorf("mapped import: expected 201, got %d %v", status, body)
+	}
+}
+
+func TestExportCSVCanBeImportedAgain(t *testing.T) {
+	source := newTestContainer(t, config.DefaultConfig())
+	sourceApp := newBooksApp(t, source)
+	csv := "title,description,isbn,authors,publishers,subjects\n" +
+		"Dune,\"Desert planet, spice\",0306406152,Frank Herbert;Brian Herbert,Chilton,Science fiction\n" +
+		"Emma,,,Jane Austen,,\n"
+	if status, body := callAPI(t, http.MethodPost, sourceApp+"/books/import", "", csv); status != http.StatusCreated {
+		t.Fatalf("import: status %d, %v", status, body)
+	}
+
+	resp, err := http.Get(sourceApp + "/books/export")
+	if err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	exported, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("export: status %d, %v", resp.StatusCode, err)
+	}
+
+	// The extra columns id, owner and created_at are ignored
+	target := newTestContainer(t, config.DefaultConfig())
+	status, body := callAPI(t, http.MethodPost, newBooksApp(t, target)+"/books/import", "", string(exported))
+	if status != http.StatusCreated || body["imported"] != 2.0 {
+		t.Fatalf("re-import: expected 201 with 2 books, got %d %v", status, body)
+	}
+	var dune models.Book
+	if err := target.DB.Preload("Authors").Preload("Publishers").Preload("Subjects").Where("title = ?", "Dune").First(&dune).Error; err != nil {
+		t.Fatalf("finding re-imported book: %v", err)
+	}
+	if dune.Description != "Desert planet, spice" || dune.ISBN == nil || *dune.ISBN != "9780306406157" ||
+		len(dune.Authors) != 2 || len(dune.Publishers) != 1 || len(dune.Subjects) != 1 {
+		t.Errorf("re-imported book differs: %+v", dune)
+	}
+}
@@ -0,0 +1,55 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"lab1/config"
+	"lab1/models"
+	"lab1/rbac"
+	"lab1/service"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestBookUpdateChecksOwnerAndISBN(t *testing.T) {
+	c := newTestContainer(t, config.DefaultConfig())
+	ctx := context.Background()
+	_, book := newLendingFixture(t, c)
+	isbn := "9780306406157"
+	other := &models.Book{Title: "Emma", ISBN: &isbn, UserID: 1}
+	if err := c.BookRepository.Create(ctx, other); err != nil {
+		t.Fatalf("creating book: %v", err)
+	}
+
+	stranger := service.Actor{UserID: 2, Username: "member", Can: func(rbac.Permission) bool { return false }}
+	editor := service.Actor{UserID: 2, Username: "editor", Can: func(perm rbac.Permission) bool { return perm == rbac.BooksWriteAny }}
+	for _, tc := range []struct {
+		name    string
+		actor   service.Actor
+		id      uint
+		changes service.BookChanges
+		want    error
+	}{
+		{"other owner", stranger, book.ID, service.BookChanges{Title: "Dune Messiah"}, service.ErrNotOwner},
+		{"taken ISBN", testActor, book.ID, service.BookChanges{Title: "Dune", ISBN: &isbn}, service.ErrISBNTaken},
+		{"unknown book", testActor, 999, service.BookChanges{Title: "Dune"}, gorm.ErrRecordNotFound},
+	} {
+		_, _, err := c.BookService.Update(ctx, tc.actor, tc.id, tc.changes)
+		if !errors.Is(err, tc.want) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.want, err)
+		}
+	}
+
+	// Users with books:write_any change any book, and get it as it was and is
+	before, after, err := c.BookService.Update(ctx, editor, book.ID, service.BookChanges{Title: "Dune Messiah"})
+	if err != nil {
+		t.Fatalf("updating book: %v", err)
+	}
+	if before.Title != "Dune" || after.Title != "Dune Messiah" {
+		t.Errorf("expected Dune before and Dune Messiah after, got %q and %q", before.Title, after.Title)
+	}
+	if found, err := c.BookRepository.FindByID(ctx, book.ID); err != nil || found.Title != "Dune Messiah" {
+		t.Errorf("expected the change to be saved, got %v, %v", found, err)
+	}
+}
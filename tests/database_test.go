@@ -0,0 +1,190 @@
+package tests
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"lab1/config"
+	"lab1/container"
+	"lab1/models"
+	"lab1/repository"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Tests that need a database get a fresh SQLite file. To run them against
+// PostgreSQL instead, set TEST_DATABASE_DRIVER=postgres and TEST_DATABASE_DSN
+// to a database the user may create schemas in, e.g.
+// "host=localhost user=library password=library dbname=library_test".
+// Every test then works in a schema of its own, which is dropped afterwards.
+
+// newTestContainer builds the application for cfg on a fresh test database
+func newTestContainer(t *testing.T, cfg *config.Config) *container.Container {
+	c, err := openTestContainer(t, cfg)
+	if err != nil {
+		t.Fatalf("creating container: %v", err)
+	}
+	return c
+}
+
+// openTestContainer is newTestContainer for configurations that may be rejected
+func openTestContainer(t *testing.T, cfg *config.Config) (*container.Container, error) {
+	useTestDatabase(t, cfg)
+
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatalf("encoding config: %v", err)
+	}
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(configPath, data, 0o600); err != nil {
+		t.Fatalf("writing config: %v", err)
+	}
+
+	c, err := container.NewContainer(configPath)
+	if err != nil {
+		return nil, err
+	}
+	t.Cleanup(func() { c.Close() })
+	return c, nil
+}
+
+// useTestDatabase points cfg at a new, empty database of the backend under test
+func useTestDatabase(t *testing.T, cfg *config.Config) {
+	switch driver := os.Getenv("TEST_DATABASE_DRIVER"); driver {
+	case "", "sqlite":
+		cfg.DatabaseDriver = "sqlite"
+		cfg.DatabaseDSN = filepath.Join(t.TempDir(), "library.db")
+	case "postgres":
+		dsn := os.Getenv("TEST_DATABASE_DSN")
+		if dsn == "" {
+			t.Fatal("TEST_DATABASE_DSN is required with TEST_DATABASE_DRIVER=postgres")
+		}
+		db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+		if err != nil {
+			t.Fatalf("connecting to the test database: %v", err)
+		}
+		sqlDB, err := db.DB()
+		if err != nil {
+			t.Fatalf("connecting to the test database: %v", err)
+		}
+
+		id := make([]byte, 8)
+		if _, err := rand.Read(id); err != nil {
+			t.Fatalf("random: %v", err)
+		}
+		schema := "test_" + hex.EncodeToString(id)
+		if err := db.Exec("CREATE SCHEMA " + schema).Error; err != nil {
+			t.Fatalf("creating schema %s: %v", schema, err)
+		}
+		t.Cleanup(func() {
+			if err := db.Exec("DROP SCHEMA " + schema + " CASCADE").Error; err != nil {
+				t.Errorf("dropping schema %s: %v", schema, err)
+			}
+			sqlDB.Close()
+		})
+
+		cfg.DatabaseDriver = "postgres"
+		cfg.DatabaseDSN = withSearchPath(t, dsn, schema)
+	default:
+		t.Fatalf("unknown TEST_DATABASE_DRIVER %q, expected sqlite or postgres", driver)
+	}
+}
+
+// withSearchPath adds the schema to a connection string in URL or keyword form
+func withSearchPath(t *testing.T, dsn, schema string) string {
+	if !strings.HasPrefix(dsn, "postgres://") && !strings.HasPrefix(dsn, "postgresql://") {
+		return dsn + " search_path=" + schema
+	}
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatalf("invalid TEST_DATABASE_DSN: %v", err)
+	}
+	query := u.Query()
+	query.Set("search_path", schema)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+func TestBookSearchMatchesWordPrefixesAndPhrases(t *testing.T) {
+	c := newTestContainer(t, config.DefaultConfig())
+	ctx := context.Background()
+
+	books := []models.Book{
+		{Title: "Dune Messiah", Description: "The second novel set on the desert planet Arrakis", UserID: 1},
+		{Title: "The Hobbit", Description: "There and back again", UserID: 1},
+	}
+	for i := range books {
+		if err := c.BookRepository.Create(ctx, &books[i]); err != nil {
+			t.Fatalf("creating %q: %v", books[i].Title, err)
+		}
+	}
+
+	for _, tc := range []struct {
+		q    string
+		want []string
+	}{
+		{"dun", []string{"Dune Messiah"}},
+		{"HOBBIT", []string{"The Hobbit"}},
+		{`"desert planet"`, []string{"Dune Messiah"}},
+		{`"planet desert"`, nil},
+		{"hobbit desert", nil},
+	} {
+		page, err := c.BookRepository.Search(ctx, repository.ListQuery{Q: tc.q, Page: 1, PageSize: 10})
+		if err != nil {
+			t.Fatalf("searching %s: %v", tc.q, err)
+		}
+		var titles []string
+		for _, hit := range page.Hits {
+			titles = append(titles, hit.Book.Title)
+		}
+		if strings.Join(titles, ", ") != strings.Join(tc.want, ", ") || page.Total != int64(len(tc.want)) {
+			t.Errorf("searching %s: expected %v, got %v of %d", tc.q, tc.want, titles, page.Total)
+		}
+	}
+
+	// Deleted books drop out of the index
+	if err := c.BookRepository.Delete(ctx, books[0].ID); err != nil {
+		t.Fatalf("deleting: %v", err)
+	}
+	page, err := c.BookRepository.Search(ctx, repository.ListQuery{Q: "dune", Page: 1, PageSize: 10})
+	if err != nil {
+		t.Fatalf("searching: %v", err)
+	}
+	if page.Total != 0 {
+		t.Errorf("expected no hits for a deleted book, got %d", page.Total)
+	}
+}
+
+func TestAuditEventsRejectChangesInTheDatabase(t *testing.T) {
+	c := newTestContainer(t, config.DefaultConfig())
+	ctx := context.Background()
+
+	event := &models.AuditEvent{Action: "create", EntityType: "book", After: `{"title":"Dune"}`}
+	if err := c.AuditRepository.Record(ctx, event); err != nil {
+		t.Fatalf("recording: %v", err)
+	}
+
+	// Plain SQL bypasses the model hooks, the triggers have to stop it
+	if err := c.DB.Exec("UPDATE audit_events SET action = ?", "tampered").Error; err == nil {
+		t.Error("expected updating an audit event to fail")
+	}
+	if err := c.DB.Exec("DELETE FROM audit_events").Error; err == nil {
+		t.Error("expected deleting an audit event to fail")
+	}
+
+	var stored models.AuditEvent
+	if err := c.DB.First(&stored, event.ID).Error; err != nil {
+		t.Fatalf("reading the event back: %v", err)
+	}
+	if stored.Action != "create" {
+		t.Errorf("expected the event to be unchanged, got action %q", stored.Action)
+	}
+}
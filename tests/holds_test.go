@@ -0,0 +1,162 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"lab1/config"
+	"lab1/models"
+	"lab1/rbac"
+	"lab1/repository"
+	"lab1/service"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestHoldsFollowTheCopies(t *testing.T) {
+	c := newTestContainer(t, config.DefaultConfig())
+	ctx := context.Background()
+	first, book := newLendingFixture(t, c)
+	second := &models.Reader{Name: "Charles", Surname: "Babbage"}
+	if err := c.ReaderRepository.Create(ctx, second); err != nil {
+		t.Fatalf("creating reader: %v", err)
+	}
+
+	item := &models.Item{BookID: book.ID, Barcode: "D-1"}
+	if err := c.ItemService.Create(ctx, testActor, item); err != nil {
+		t.Fatalf("adding copy: %v", err)
+	}
+	if _, _, err := c.HoldService.Place(ctx, book.ID, first.ID); !errors.Is(err, service.ErrBookAvailable) {
+		t.Errorf("expected no hold on a book on the shelf, got %v", err)
+	}
+
+	// With the item in repair both readers queue up
+	item.Status = models.ItemStatusInRepair
+	if err := c.ItemService.Update(ctx, testActor, book.ID, item.ID, *item); err != nil {
+		t.Fatalf("updating copy: %v", err)
+	}
+	held, position, err := c.HoldService.Place(ctx, book.ID, first.ID)
+	if err != nil || position != 1 {
+		t.Fatalf("expected the first hold at position 1, got %d, %v", position, err)
+	}
+	if _, position, err := c.HoldService.Place(ctx, book.ID, second.ID); err != nil || position != 2 {
+		t.Fatalf("expected the second hold at position 2, got %d, %v", position, err)
+	}
+	if _, _, err := c.HoldService.Place(ctx, book.ID, first.ID); !errors.Is(err, service.ErrAlreadyHeld) {
+		t.Errorf("expected a second hold of the reader to be refused, got %v", err)
+	}
+
+	status := func(readerID uint) string {
+		holds, err := c.HoldRepository.FindByReader(ctx, readerID)
+		if err != nil || len(holds) != 1 {
+			t.Fatalf("expected one hold of reader %d, got %d, %v", readerID, len(holds), err)
+		}
+		return holds[0].Status
+	}
+
+	// The repaired item waits for the first reader, and goes to the second one when they cancel
+	item.Status = models.ItemStatusAvailable
+	if err := c.ItemService.Update(ctx, testActor, book.ID, item.ID, *item); err != nil {
+		t.Fatalf("updating copy: %v", err)
+	}
+	if got := status(first.ID); got != models.HoldStatusReady {
+		t.Errorf("expected the first hold to be ready, got %s", got)
+	}
+	if err := c.HoldService.Cancel(ctx, second.ID, held.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("expected the hold of another reader to be not found, got %v", err)
+	}
+	if err := c.HoldService.Cancel(ctx, first.ID, held.ID); err != nil {
+		t.Fatalf("cancelling: %v", err)
+	}
+	if got := status(second.ID); got != models.HoldStatusReady {
+		t.Errorf("expected the item to go to the second reader, got %s", got)
+	}
+	if err := c.HoldService.Cancel(ctx, first.ID, held.ID); !errors.Is(err, service.ErrHoldInactive) {
+		t.Errorf("expected a cancelled hold to stay cancelled, got %v", err)
+	}
+}
+
+func TestItemChangesAreCheckedInTheTransaction(t *testing.T) {
+	c := newTestContainer(t, config.DefaultConfig())
+	ctx := context.Background()
+	_, book := newLendingFixture(t, c)
+	if err := c.ItemService.Create(ctx, testActor, &models.Item{BookID: book.ID, Barcode: "D-1"}); err != nil {
+		t.Fatalf("adding copy: %v", err)
+	}
+
+	stranger := service.Actor{UserID: 2, Username: "member", Can: func(rbac.Permission) bool { return false }}
+	for _, tc := range []struct {
+		name  string
+		actor service.Actor
+		item  models.Item
+		want  error
+	}{
+		{"taken barcode", testActor, models.Item{BookID: book.ID, Barcode: "D-1"}, service.ErrBarcodeExists},
+		{"other owner", stranger, models.Item{BookID: book.ID, Barcode: "D-2"}, service.ErrNotOwner},
+		{"unknown book", testActor, models.Item{BookID: 999, Barcode: "D-3"}, gorm.ErrRecordNotFound},
+	} {
+		if err := c.ItemService.Create(ctx, tc.actor, &tc.item); !errors.Is(err, tc.want) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.want, err)
+		}
+	}
+
+	var items int64
+	c.DB.Model(&models.Item{}).Count(&items)
+	if items != 1 {
+		t.Errorf("expected the refused copies not to be added, got %d copies", items)
+	}
+}
+
+func TestDeletingACopyRequeuesItsHold(t *testing.T) {
+	c := newTestContainer(t, config.DefaultConfig())
+	ctx := context.Background()
+	reader, book := newLendingFixture(t, c)
+
+	repaired := &models.Item{BookID: book.ID, Barcode: "D-1", Status: models.ItemStatusInRepair}
+	if err := c.ItemService.Create(ctx, testActor, repaired); err != nil {
+		t.Fatalf("adding copy: %v", err)
+	}
+	if _, _, err := c.HoldService.Place(ctx, book.ID, reader.ID); err != nil {
+		t.Fatalf("placing hold: %v", err)
+	}
+	status := func() string {
+		hold, err := c.HoldRepository.FindActive(ctx, reader.ID, book.ID)
+		if err != nil {
+			return err.Error()
+		}
+		return hold.Status
+	}
+
+	shelved := &models.Item{BookID: book.ID, Barcode: "D-2"}
+	if err := c.ItemService.Create(ctx, testActor, shelved); err != nil {
+		t.Fatalf("adding copy: %v", err)
+	}
+	if got := status(); got != models.HoldStatusReady {
+		t.Fatalf("expected the new copy to make the hold ready, got %s", got)
+	}
+	if err := c.ItemService.Delete(ctx, testActor, book.ID, shelved.ID); err != nil {
+		t.Fatalf("deleting copy: %v", err)
+	}
+	if got := status(); got != models.HoldStatusWaiting {
+		t.Errorf("expected the hold to wait again without its copy, got %s", got)
+	}
+
+	// The repaired copy is lent to the reader and cannot be deleted any more
+	repaired.Status = models.ItemStatusAvailable
+	if err := c.ItemService.Update(ctx, testActor, book.ID, repaired.ID, *repaired); err != nil {
+		t.Fatalf("updating copy: %v", err)
+	}
+	if got := status(); got != models.HoldStatusReady {
+		t.Fatalf("expected the repaired copy to make the hold ready, got %s", got)
+	}
+	if _, err := c.LoanService.Checkout(ctx, testActor, service.Checkout{ReaderID: reader.ID, ItemID: repaired.ID}); err != nil {
+		t.Fatalf("checkout: %v", err)
+	}
+	if err := c.ItemService.Delete(ctx, testActor, book.ID, repaired.ID); !errors.Is(err, service.ErrItemOnLoan) {
+		t.Errorf("expected a lent copy not to be deleted, got %v", err)
+	}
+	// Neither when the status was read before the checkout
+	if err := c.ItemRepository.Delete(ctx, repaired.ID); !errors.Is(err, repository.ErrItemLent) {
+		t.Errorf("expected the repository to refuse deleting a lent copy, got %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// actAs stands in for the authentication middleware: every request acts as
+// the given user, with the permissions of the role
+func actAs(userID uint, username, role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("user_id", userID)
+		c.Set("username", username)
+		c.Set("role", role)
+		c.Next()
+	}
+}
+
+// callAPI sends the request and decodes the JSON response. A string body is
+// sent as it is, as CSV; anything else but nil is encoded as JSON.
+// authorization is the Authorization header, if not empty.
+func callAPI(t *testing.T, method, url, authorization string, body interface{}) (int, map[string]interface{}) {
+	t.Helper()
+	var reader io.Reader
+	contentType := "application/json"
+	switch body := body.(type) {
+	case nil:
+	case string:
+		reader, contentType = strings.NewReader(body), "text/csv"
+	default:
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("encoding request: %v", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		t.Fatalf("creating request: %v", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, url, err)
+	}
+	defer resp.Body.Close()
+
+	var result map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil && err != io.EOF {
+		t.Fatalf("%s %s: decoding response: %v", method, url, err)
+	}
+	return resp.StatusCode, result
+}
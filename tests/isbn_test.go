@@ -0,0 +1,124 @@
+package tests
+
+import (
+	"errors"
+	"lab1/config"
+	"lab1/validation"
+	"net/http"
+	"testing"
+)
+
+func TestNormalizeISBN(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want string // empty if invalid
+	}{
+		{"9780306406157", "9780306406157"},
+		{"978-0-306-40615-7", "9780306406157"},
+		{"978 0 306 40615 7", "9780306406157"},
+		{"0306406152", "9780306406157"},    // ISBN-10 gets the 978 prefix and a new check digit
+		{"0-8044-2957-X", "9780804429573"}, // check digit X
+		{"0-8044-2957-x", "9780804429573"}, // in lower case
+		{"9791032305690", "9791032305690"}, // 979 prefix
+		{"9780306406158", ""},              // wrong check digit
+		{"0306406153", ""},                 // wrong ISBN-10 check digit
+		{"030640615X", ""},                 // X where the check digit is a number
+		{"X306406152", ""},                 // X only as the check digit
+		{"978030640615X", ""},              // no X in ISBN-13
+		{"97803064061", ""},                // too short
+		{"97803064061570", ""},             // too long
+		{"978-0-306-40615-7a", ""},         // other characters
+		{"", ""},
+	} {
+		got, err := validation.NormalizeISBN(tc.in)
+		if tc.want == "" {
+			if !errors.Is(err, validation.ErrInvalidISBN) {
+				t.Errorf("NormalizeISBN(%q) = %q, %v, expected ErrInvalidISBN", tc.in, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("NormalizeISBN(%q) = %q, %v, expected %q", tc.in, got, err, tc.want)
+		}
+	}
+}
+
+func TestISBN13To10(t *testing.T) {
+	for _, tc := range []struct{ in, want string }{
+		{"9780306406157", "0306406152"},
+		{"9780804429573", "080442957X"},
+		{"9791032305690", ""}, // 979 ISBNs have no ISBN-10
+		{"978030640615", ""},
+	} {
+		if got := validation.ISBN13To10(tc.in); got != tc.want {
+			t.Errorf("ISBN13To10(%q) = %q, expected %q", tc.in, got, tc.want)
+		}
+	}
+
+	// Converting back and forth keeps the ISBN
+	for _, isbn10 := range []string{"0306406152", "080442957X", "0140449132"} {
+		isbn13, err := validation.NormalizeISBN(isbn10)
+		if err != nil {
+			t.Fatalf("NormalizeISBN(%q): %v", isbn10, err)
+		}
+		if got := validation.ISBN13To10(isbn13); got != isbn10 {
+			t.Errorf("round trip of %s gave %s", isbn10, got)
+		}
+	}
+}
+
+func TestISBNValidationTag(t *testing.T) {
+	v := validation.NewValidator()
+	type book struct {
+		ISBN string `validate:"omitempty,isbn"`
+	}
+	for _, tc := range []struct {
+		isbn  string
+		valid bool
+	}{
+		{"", true},
+		{"978-0-306-40615-7", true},
+		{"0-306-40615-2", true},
+		{"978-0-306-40615-8", false},
+		{"not an isbn", false},
+	} {
+		if err := v.ValidateStruct(book{ISBN: tc.isbn}); (err == nil) != tc.valid {
+			t.Errorf("validating %q: expected valid=%v, got %v", tc.isbn, tc.valid, err)
+		}
+	}
+}
+
+func TestBooksStoreNormalizedUniqueISBNs(t *testing.T) {
+	app := newBooksApp(t, newTestContainer(t, config.DefaultConfig()))
+
+	status, body := callAPI(t, http.MethodPost, app+"/books", "", map[string]string{"title": "Dune", "isbn": "0-306-40615-2"})
+	if status != http.StatusCreated {
+		t.Fatalf("creating book: status %d, %v", status, body)
+	}
+	if body["isbn"] != "9780306406157" || body["isbn10"] != "0306406152" {
+		t.Errorf("expected the ISBN-13 and ISBN-10 forms, got %v and %v", body["isbn"], body["isbn10"])
+	}
+	id := body["id"]
+
+	for _, tc := range []struct {
+		isbn   string
+		status int
+	}{
+		{"978-0-306-40615-7", http.StatusConflict}, // the same ISBN in another form
+		{"978-0-306-40615-8", http.StatusBadRequest},
+	} {
+		if status, body := callAPI(t, http.MethodPost, app+"/books", "", map[string]string{"title": "Other", "isbn": tc.isbn}); status != tc.status {
+			t.Errorf("creating a book with ISBN %s: expected %d, got %d %v", tc.isbn, tc.status, status, body)
+		}
+	}
+
+	for _, isbn := range []string{"9780306406157", "0306406152", "978-0-306-40615-7"} {
+		status, body := callAPI(t, http.MethodGet, app+"/books/isbn/"+isbn, "", nil)
+		if status != http.StatusOK || body["id"] != id {
+			t.Errorf("looking up %s: expected book %v, got %d %v", isbn, id, status, body)
+		}
+	}
+	if status, _ := callAPI(t, http.MethodGet, app+"/books/isbn/9791032305690", "", nil); status != http.StatusNotFound {
+		t.Errorf("looking up an unknown ISBN: expected 404, got %d", status)
+	}
+}
@@ -0,0 +1,116 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"lab1/config"
+	"lab1/container"
+	"lab1/models"
+	"lab1/repository"
+	"lab1/service"
+	"testing"
+	"time"
+)
+
+var testActor = service.Actor{UserID: 1, Username: "admin"}
+
+// newLendingFixture creates a reader and a book without copies
+func newLendingFixture(t *testing.T, c *container.Container) (*models.Reader, *models.Book) {
+	ctx := context.Background()
+	reader := &models.Reader{Name: "Ada", Surname: "Lovelace"}
+	if err := c.ReaderRepository.Create(ctx, reader); err != nil {
+		t.Fatalf("creating reader: %v", err)
+	}
+	book := &models.Book{Title: "Dune", UserID: 1}
+	if err := c.BookRepository.Create(ctx, book); err != nil {
+		t.Fatalf("creating book: %v", err)
+	}
+	return reader, book
+}
+
+func TestLoanFindByIDDoesNotShareTheCachedLoan(t *testing.T) {
+	c := newTestContainer(t, config.DefaultConfig())
+	ctx := context.Background()
+	reader, book := newLendingFixture(t, c)
+
+	loan, err := c.LoanService.Checkout(ctx, testActor, service.Checkout{ReaderID: reader.ID, BookID: book.ID})
+	if err != nil {
+		t.Fatalf("checkout: %v", err)
+	}
+
+	first, err := c.LoanRepository.FindByID(ctx, loan.ID)
+	if err != nil {
+		t.Fatalf("finding loan: %v", err)
+	}
+	returnedAt := time.Now()
+	first.ReturnedAt = &returnedAt
+
+	// The second lookup is answered from the cache
+	second, err := c.LoanRepository.FindByID(ctx, loan.ID)
+	if err != nil {
+		t.Fatalf("finding loan: %v", err)
+	}
+	if second == first || second.ReturnedAt != nil {
+		t.Error("changing a found loan changed the cached one")
+	}
+}
+
+// The repository must not rely on the availability the service read before:
+// a concurrent checkout may have lent the copy in between
+func TestLoanCheckoutRejectsLendingTwice(t *testing.T) {
+	c := newTestContainer(t, config.DefaultConfig())
+	ctx := context.Background()
+	reader, book := newLendingFixture(t, c)
+
+	withoutCopies, err := c.BookRepository.FindByID(ctx, book.ID)
+	if err != nil {
+		t.Fatalf("finding book: %v", err)
+	}
+	withCopy := &models.Book{Title: "Emma", UserID: 1}
+	if err := c.BookRepository.Create(ctx, withCopy); err != nil {
+		t.Fatalf("creating book: %v", err)
+	}
+	item := &models.Item{BookID: withCopy.ID, Barcode: "C-1", Status: models.ItemStatusAvailable}
+	if err := c.ItemRepository.Create(ctx, item); err != nil {
+		t.Fatalf("creating copy: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		bookID uint
+		itemID *uint
+	}{
+		{"copy", withCopy.ID, &item.ID},
+		{"book without copies", withoutCopies.ID, nil},
+	} {
+		newLoan := func() *models.Loan {
+			return &models.Loan{ReaderID: reader.ID, BookID: tc.bookID, ItemID: tc.itemID, CheckedOutAt: time.Now(),
+				DueAt: time.Now().AddDate(0, 0, 14), CheckedOutByID: 1}
+		}
+		first := newLoan()
+		if err := c.LoanRepository.Checkout(ctx, first); err != nil {
+			t.Fatalf("%s: first checkout: %v", tc.name, err)
+		}
+		if err := c.LoanRepository.Checkout(ctx, newLoan()); !errors.Is(err, repository.ErrAlreadyLent) {
+			t.Errorf("%s: expected ErrAlreadyLent for the second checkout, got %v", tc.name, err)
+		}
+
+		// Once returned it can be lent again, but not returned again
+		stale := *first
+		if err := c.LoanRepository.Return(ctx, first, time.Now()); err != nil {
+			t.Fatalf("%s: return: %v", tc.name, err)
+		}
+		if err := c.LoanRepository.Return(ctx, &stale, time.Now()); !errors.Is(err, repository.ErrLoanReturned) {
+			t.Errorf("%s: expected ErrLoanReturned for the second return, got %v", tc.name, err)
+		}
+		if err := c.LoanRepository.Checkout(ctx, newLoan()); err != nil {
+			t.Errorf("%s: checkout after the return: %v", tc.name, err)
+		}
+	}
+
+	var loans int64
+	c.DB.Model(&models.Loan{}).Count(&loans)
+	if loans != 4 {
+		t.Errorf("expected 4 loans, got %d", loans)
+	}
+}
@@ -0,0 +1,114 @@
+package tests
+
+import (
+	"context"
+	"lab1/config"
+	"lab1/models"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// attemptLogin tries a password and returns the status and the retry_after of a 429
+func attemptLogin(t *testing.T, app, password string) (int, float64) {
+	t.Helper()
+	status, body := callAPI(t, http.MethodPost, app+"/auth/login", "", map[string]string{"username": "admin", "password": password})
+	retryAfter, _ := body["retry_after"].(float64)
+	return status, retryAfter
+}
+
+func TestLoginBackoffAndLockout(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.LoginBackoffBaseSeconds = 10
+	cfg.LoginBackoffMaxSeconds = 40
+	cfg.LoginMaxFailures = 5
+	cfg.LoginLockoutMinutes = 15
+	c := newTestContainer(t, cfg)
+	app := newAuthApp(t, c)
+
+	// waitOut moves the failures back in time, past any back-off but within the window
+	waitOut := func() {
+		c.DB.Model(&models.LoginThrottle{}).Where("1 = 1").Update("last_failure_at", time.Now().Add(-time.Minute))
+	}
+
+	// Each failure doubles the wait, up to the maximum
+	for failures, want := range []float64{10, 20, 40, 40} {
+		if status, _ := attemptLogin(t, app, "wrong"); status != http.StatusUnauthorized {
+			t.Fatalf("failure %d: expected 401, got %d", failures+1, status)
+		}
+		// Even the right password is refused while waiting, and not counted
+		if status, retryAfter := attemptLogin(t, app, "password"); status != http.StatusTooManyRequests || retryAfter != want {
+			t.Errorf("after %d failures: expected 429 with retry_after %v, got %d with %v", failures+1, want, status, retryAfter)
+		}
+		waitOut()
+	}
+
+	// The fifth failure locks the username, waiting out the back-off does not help
+	if status, _ := attemptLogin(t, app, "wrong"); status != http.StatusUnauthorized {
+		t.Fatalf("fifth failure: expected 401, got %d", status)
+	}
+	waitOut()
+	if status, retryAfter := attemptLogin(t, app, "password"); status != http.StatusTooManyRequests || retryAfter != 15*60 {
+		t.Errorf("locked: expected 429 with retry_after 900, got %d with %v", status, retryAfter)
+	}
+
+	// Once the lockout is over the right password works and resets the counter
+	c.DB.Model(&models.LoginThrottle{}).Where("1 = 1").Update("locked_until", time.Now().Add(-time.Second))
+	if status, _ := attemptLogin(t, app, "password"); status != http.StatusOK {
+		t.Fatalf("after the lockout: expected 200, got %d", status)
+	}
+	if status, _ := attemptLogin(t, app, "wrong"); status != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", status)
+	}
+	if _, retryAfter := attemptLogin(t, app, "password"); retryAfter != 10 {
+		t.Errorf("expected the back-off to start over, got retry_after %v", retryAfter)
+	}
+}
+
+func TestLoginThrottlesArePurged(t *testing.T) {
+	c := newTestContainer(t, config.DefaultConfig())
+	ctx := context.Background()
+	now := time.Now()
+	window := now.Add(-15 * time.Minute)
+
+	for key, failedAt := range map[string]time.Time{
+		"user:stale":    now.Add(-time.Hour),
+		"user:locked":   now.Add(-time.Hour),
+		"user:unlocked": now.Add(-time.Hour),
+		"user:recent":   now.Add(-time.Minute),
+	} {
+		if _, err := c.LoginAttemptRepository.RecordFailure(ctx, key, failedAt, window); err != nil {
+			t.Fatalf("recording failure: %v", err)
+		}
+	}
+	if err := c.LoginAttemptRepository.Lock(ctx, "user:locked", now.Add(time.Minute)); err != nil {
+		t.Fatalf("locking: %v", err)
+	}
+	if err := c.LoginAttemptRepository.Lock(ctx, "user:unlocked", now.Add(-time.Minute)); err != nil {
+		t.Fatalf("locking: %v", err)
+	}
+
+	if err := c.LoginAttemptRepository.PurgeThrottles(ctx, now, window); err != nil {
+		t.Fatalf("purging: %v", err)
+	}
+	throttles, err := c.LoginAttemptRepository.GetThrottles(ctx, "user:stale", "user:locked", "user:unlocked", "user:recent")
+	if err != nil {
+		t.Fatalf("reading throttles: %v", err)
+	}
+	kept := map[string]bool{}
+	for _, throttle := range throttles {
+		kept[throttle.Key] = true
+	}
+	if len(kept) != 2 || !kept["user:locked"] || !kept["user:recent"] {
+		t.Errorf("expected only the locked and the recent counter to be kept, got %v", kept)
+	}
+
+	// Successful logins run the purge
+	if _, err := c.LoginAttemptRepository.RecordFailure(ctx, "user:stale", now.Add(-time.Hour), window); err != nil {
+		t.Fatalf("recording failure: %v", err)
+	}
+	login(t, newAuthApp(t, c), "admin", "password")
+	if throttles, _ := c.LoginAttemptRepository.GetThrottles(ctx, "user:stale"); len(throttles) != 0 {
+		t.Error("expected a successful login to purge expired counters")
+	}
+}
@@ -0,0 +1,84 @@
+package tests
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"lab1/config"
+	"lab1/marc"
+	"net/http"
+	"testing"
+)
+
+// marcRecord encodes a record with a control field and a title, so that the
+// directory entry of the title starts at byte 36 and the base address is 49
+func marcRecord(t *testing.T) []byte {
+	record := &marc.Record{Fields: []marc.Field{
+		{Tag: "001", Value: "42"},
+		marc.DataField("245", "1", "0", "a", "Dune"),
+	}}
+	data, err := record.MarshalBinary()
+	if err != nil {
+		t.Fatalf("encoding record: %v", err)
+	}
+	return data
+}
+
+func TestMARCReaderReadsRecords(t *testing.T) {
+	data := marcRecord(t)
+	reader := marc.NewReader(bytes.NewReader(append(append(append([]byte{}, data...), "\r\n"...), data...)))
+
+	for i := 0; i < 2; i++ {
+		record, err := reader.Read()
+		if err != nil {
+			t.Fatalf("record %d: %v", i+1, err)
+		}
+		titles := record.FieldsByTag("245")
+		if len(record.Fields) != 2 || len(titles) != 1 || titles[0].Subfield("a") != "Dune" || titles[0].Ind1 != "1" {
+			t.Errorf("record %d: unexpected fields %+v", i+1, record.Fields)
+		}
+	}
+	if _, err := reader.Read(); err != io.EOF {
+		t.Errorf("expected io.EOF after the last record, got %v", err)
+	}
+}
+
+func TestMARCReaderRejectsInvalidRecords(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		change func(data []byte) []byte
+	}{
+		{"truncated leader", func(data []byte) []byte { return data[:4] }},
+		{"record length too short", func(data []byte) []byte { return append([]byte("00025"), data[5:]...) }},
+		{"signed record length", func(data []byte) []byte { return append([]byte("+0"), data[2:]...) }},
+		{"record shorter than its length", func(data []byte) []byte { return data[:len(data)-3] }},
+		{"missing record terminator", func(data []byte) []byte { data[len(data)-1] = ' '; return data }},
+		{"base address not a number", func(data []byte) []byte { copy(data[12:17], "00x49"); return data }},
+		{"signed base address", func(data []byte) []byte { copy(data[12:17], "-0049"); return data }},
+		{"base address inside the leader", func(data []byte) []byte { copy(data[12:17], "00020"); return data }},
+		{"base address past the end", func(data []byte) []byte { copy(data[12:17], "99999"); return data }},
+		{"directory of partial entries", func(data []byte) []byte { copy(data[12:17], "00050"); return data }},
+		{"negative field offset", func(data []byte) []byte { copy(data[43:48], "-9999"); return data }},
+		{"signed field offset", func(data []byte) []byte { copy(data[43:48], "+0003"); return data }},
+		{"field offset past the end", func(data []byte) []byte { copy(data[43:48], "99990"); return data }},
+		{"signed field length", func(data []byte) []byte { copy(data[39:43], "-001"); return data }},
+		{"empty field", func(data []byte) []byte { copy(data[39:43], "0000"); return data }},
+		{"data field without indicators", func(data []byte) []byte { copy(data[39:43], "0001"); return data }},
+	} {
+		data := tc.change(marcRecord(t))
+		if _, err := marc.NewReader(bytes.NewReader(data)).Read(); !errors.Is(err, marc.ErrInvalidRecord) {
+			t.Errorf("%s: expected ErrInvalidRecord, got %v", tc.name, err)
+		}
+	}
+}
+
+func TestImportMARCRejectsNegativeOffsets(t *testing.T) {
+	app := newBooksApp(t, newTestContainer(t, config.DefaultConfig()))
+	data := marcRecord(t)
+	copy(data[43:48], "-9999")
+
+	status, body := callAPI(t, http.MethodPost, app+"/books/import/marc?format=binary", "", string(data))
+	if status != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d %v", status, body)
+	}
+}
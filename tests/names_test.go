@@ -0,0 +1,92 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"lab1/config"
+	"lab1/container"
+	"lab1/handlers"
+	"lab1/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newNamesApp serves the author, publisher and subject routes of c
+func newNamesApp(t *testing.T, c *container.Container) string {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	for path, h := range map[string]interface {
+		GetAll(*gin.Context)
+		Create(*gin.Context)
+		GetByID(*gin.Context)
+		Update(*gin.Context)
+		Delete(*gin.Context)
+	}{
+		"/authors":    handlers.NewAuthorsHandler(c.AuthorRepository, c.Validator, c.Config),
+		"/publishers": handlers.NewPublishersHandler(c.PublisherRepository, c.Validator, c.Config),
+		"/subjects":   handlers.NewSubjectsHandler(c.SubjectRepository, c.Validator, c.Config),
+	} {
+		r.GET(path, h.GetAll)
+		r.POST(path, h.Create)
+		r.GET(path+"/:id", h.GetByID)
+		r.PUT(path+"/:id", h.Update)
+		r.DELETE(path+"/:id", h.Delete)
+	}
+
+	app := httptest.NewServer(r)
+	t.Cleanup(app.Close)
+	return app.URL
+}
+
+func TestNamesLinkedToTrashedBooksAreKept(t *testing.T) {
+	c := newTestContainer(t, config.DefaultConfig())
+	app := newNamesApp(t, c)
+	ctx := context.Background()
+
+	urls := map[string]string{}
+	for _, path := range []string{"/authors", "/publishers", "/subjects"} {
+		status, body := callAPI(t, http.MethodPost, app+path, "", map[string]string{"name": "Herbert"})
+		if status != http.StatusCreated {
+			t.Fatalf("creating %s: status %d, %v", path, status, body)
+		}
+		urls[path] = fmt.Sprintf("%s%s/%v", app, path, body["id"])
+		if status, body := callAPI(t, http.MethodPut, urls[path], "", map[string]string{"name": "Frank Herbert"}); status != http.StatusNoContent {
+			t.Errorf("renaming %s: status %d, %v", path, status, body)
+		}
+		if _, body := callAPI(t, http.MethodGet, urls[path], "", nil); body["name"] != "Frank Herbert" {
+			t.Errorf("expected the new name of %s, got %v", path, body)
+		}
+	}
+
+	author, _ := c.AuthorRepository.FindByIDs(ctx, []uint{1})
+	publisher, _ := c.PublisherRepository.FindByIDs(ctx, []uint{1})
+	subject, _ := c.SubjectRepository.FindByIDs(ctx, []uint{1})
+	book := &models.Book{Title: "Dune", UserID: 1, Authors: author, Publishers: publisher, Subjects: subject}
+	if err := c.BookRepository.Create(ctx, book); err != nil {
+		t.Fatalf("creating book: %v", err)
+	}
+	if err := c.BookRepository.Delete(ctx, book.ID); err != nil {
+		t.Fatalf("deleting book: %v", err)
+	}
+
+	// The book in the trash still links to them, restoring it must find them
+	for path, url := range urls {
+		if status, body := callAPI(t, http.MethodDelete, url, "", nil); status != http.StatusConflict {
+			t.Errorf("deleting %s of a trashed book: expected 409, got %d %v", path, status, body)
+		}
+	}
+	if err := c.BookRepository.Purge(ctx, book.ID); err != nil {
+		t.Fatalf("purging book: %v", err)
+	}
+	for path, url := range urls {
+		if status, body := callAPI(t, http.MethodDelete, url, "", nil); status != http.StatusNoContent {
+			t.Errorf("deleting %s: expected 204, got %d %v", path, status, body)
+		}
+		if status, _ := callAPI(t, http.MethodGet, url, "", nil); status != http.StatusNotFound {
+			t.Errorf("expected %s to be gone, got %d", path, status)
+		}
+	}
+}
@@ -0,0 +1,441 @@
+package tests
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"lab1/config"
+	"lab1/handlers"
+	"lab1/jwtkeys"
+	"math/big"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// The OIDC tests run the auth routes in-process against a stub identity
+// provider, so unlike the Selenium tests they need no running server.
+
+const (
+	stubClientID     = "library"
+	stubClientSecret = "library-secret"
+)
+
+// stubIdP is a minimal OpenID provider: it signs in whoever is set in claims
+// without asking, and checks the parts of the flow the library must get right
+type stubIdP struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu     sync.Mutex
+	claims jwt.MapClaims            // identity of the next sign-in
+	tamper func(jwt.MapClaims)      // changes the next ID token, for invalid tokens
+	codes  map[string]stubAuthorize // issued authorization codes
+}
+
+type stubAuthorize struct {
+	nonce       string
+	challenge   string
+	redirectURI string
+}
+
+func newStubIdP(t *testing.T) *stubIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	idp := &stubIdP{t: t, key: key, codes: map[string]stubAuthorize{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", idp.discovery)
+	mux.HandleFunc("/authorize", idp.authorize)
+	mux.HandleFunc("/token", idp.token)
+	mux.HandleFunc("/jwks", idp.jwks)
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+func (idp *stubIdP) signInAs(claims jwt.MapClaims) {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	idp.claims = claims
+	idp.tamper = nil
+}
+
+func (idp *stubIdP) discovery(w http.ResponseWriter, r *http.Request) {
+	issuer := idp.server.URL
+	json.NewEncoder(w).Encode(map[string]string{
+		"issuer":                 issuer,
+		"authorization_endpoint": issuer + "/authorize",
+		"token_endpoint":         issuer + "/token",
+		"jwks_uri":               issuer + "/jwks",
+	})
+}
+
+func (idp *stubIdP) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("response_type") != "code" || q.Get("client_id") != stubClientID {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		http.Error(w, "PKCE required", http.StatusBadRequest)
+		return
+	}
+	if q.Get("nonce") == "" || q.Get("state") == "" {
+		http.Error(w, "state and nonce required", http.StatusBadRequest)
+		return
+	}
+
+	code := randomCode(idp.t)
+	idp.mu.Lock()
+	idp.codes[code] = stubAuthorize{nonce: q.Get("nonce"), challenge: q.Get("code_challenge"), redirectURI: q.Get("redirect_uri")}
+	idp.mu.Unlock()
+
+	redirect, _ := url.Parse(q.Get("redirect_uri"))
+	redirect.RawQuery = url.Values{"code": {code}, "state": {q.Get("state")}}.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (idp *stubIdP) token(w http.ResponseWriter, r *http.Request) {
+	id, secret, ok := r.BasicAuth()
+	if !ok || id != stubClientID || secret != stubClientSecret {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	idp.mu.Lock()
+	request, found := idp.codes[r.PostFormValue("code")]
+	delete(idp.codes, r.PostFormValue("code"))
+	claims := jwt.MapClaims{}
+	for k, v := range idp.claims {
+		claims[k] = v
+	}
+	tamper := idp.tamper
+	idp.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if !found || r.PostFormValue("redirect_uri") != request.redirectURI ||
+		base64.RawURLEncoding.EncodeToString(sum[:]) != request.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	claims["iss"] = idp.server.URL
+	claims["aud"] = stubClientID
+	claims["nonce"] = request.nonce
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(5 * time.Minute).Unix()
+	if tamper != nil {
+		tamper(claims)
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "stub-key"
+	idToken, err := token.SignedString(idp.key)
+	if err != nil {
+		idp.t.Errorf("signing ID token: %v", err)
+	}
+	json.NewEncoder(w).Encode(map[string]string{"access_token": "unused", "token_type": "Bearer", "id_token": idToken})
+}
+
+func (idp *stubIdP) jwks(w http.ResponseWriter, r *http.Request) {
+	public := idp.key.PublicKey
+	json.NewEncoder(w).Encode(jwtkeys.JWKSet{Keys: []jwtkeys.JWK{{
+		KeyType:   "RSA",
+		KeyID:     "stub-key",
+		Use:       "sig",
+		Algorithm: "RS256",
+		N:         base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+		E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+	}}})
+}
+
+func randomCode(t *testing.T) string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatalf("random: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// newOIDCApp starts the library's auth routes configured for the stub provider
+func newOIDCApp(t *testing.T, idp *stubIdP, configure func(*config.Config)) string {
+	gin.SetMode(gin.TestMode)
+
+	var router http.Handler
+	app := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		router.ServeHTTP(w, r)
+	}))
+	t.Cleanup(app.Close)
+
+	cfg := config.DefaultConfig()
+	cfg.AppBaseURL = app.URL
+	cfg.OIDCIssuer = idp.server.URL
+	cfg.OIDCClientID = stubClientID
+	cfg.OIDCClientSecret = stubClientSecret
+	cfg.OIDCRoleClaim = "groups"
+	cfg.OIDCRoleMapping = []config.OIDCRoleMapping{
+		{Value: "library-admins", Role: "admin"},
+		{Value: "library-staff", Role: "librarian"},
+	}
+	if configure != nil {
+		configure(cfg)
+	}
+
+	c := newTestContainer(t, cfg)
+	authHandler := handlers.NewAuthHandler(c.UserRepository, c.TokenRepository, c.UserTokenRepository, c.LoginAttemptRepository, c.AuditRepository, c.Mailer, c.Keys, c.OIDC, c.Policy, c.Validator, c.Config)
+	r := gin.New()
+	r.GET("/auth/oidc", authHandler.GetOIDCConfig)
+	r.GET("/auth/oidc/login", authHandler.OIDCLogin)
+	r.GET("/auth/oidc/callback", authHandler.OIDCCallback)
+	r.POST("/auth/oidc/token", authHandler.OIDCToken)
+	router = r
+	return app.URL
+}
+
+// startSignIn follows the browser redirects from the login endpoint through
+// the provider and the callback, and returns the query the web app is opened with
+func startSignIn(t *testing.T, appURL string, client *http.Client) url.Values {
+	resp, err := client.Get(appURL + "/auth/oidc/login")
+	if err != nil {
+		t.Fatalf("sign-in: %v", err)
+	}
+	defer resp.Body.Close()
+	return appRedirect(t, resp)
+}
+
+func appRedirect(t *testing.T, resp *http.Response) url.Values {
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("expected a redirect to the web app, got status %d", resp.StatusCode)
+	}
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || location.Path != "/" {
+		t.Fatalf("expected a redirect to the web app, got %q", resp.Header.Get("Location"))
+	}
+	return location.Query()
+}
+
+// newBrowser is an HTTP client with cookies that stops at the web app
+func newBrowser(t *testing.T) *http.Client {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("cookie jar: %v", err)
+	}
+	return &http.Client{
+		Jar: jar,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if req.URL.Path == "/" {
+				return http.ErrUseLastResponse
+			}
+			return nil
+		},
+	}
+}
+
+type oidcTokenResponse struct {
+	status   int
+	UserID   uint   `json:"user_id"`
+	Username string `json:"username"`
+	Role     string `json:"role"`
+	Token    string `json:"token"`
+	Error    string `json:"error"`
+}
+
+func redeemCode(t *testing.T, appURL, code string) oidcTokenResponse {
+	body, _ := json.Marshal(map[string]string{"code": code})
+	resp, err := http.Post(appURL+"/auth/oidc/token", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("redeeming code: %v", err)
+	}
+	defer resp.Body.Close()
+
+	result := oidcTokenResponse{status: resp.StatusCode}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("decoding token response: %v", err)
+	}
+	return result
+}
+
+// signIn runs the whole flow and expects it to succeed
+func signIn(t *testing.T, appURL string) oidcTokenResponse {
+	t.Helper()
+	query := startSignIn(t, appURL, newBrowser(t))
+	if query.Get("oidc_error") != "" {
+		t.Fatalf("sign-in failed: %s", query.Get("oidc_error"))
+	}
+	result := redeemCode(t, appURL, query.Get("oidc_code"))
+	if result.status != http.StatusOK || result.Token == "" {
+		t.Fatalf("redeeming the login code: status %d, %s", result.status, result.Error)
+	}
+	return result
+}
+
+func TestOIDCProvisionsUserWithMappedRole(t *testing.T) {
+	idp := newStubIdP(t)
+	appURL := newOIDCApp(t, idp, nil)
+
+	idp.signInAs(jwt.MapClaims{"sub": "u-1", "email": "jane@example.org", "email_verified": true,
+		"preferred_username": "jane", "groups": []string{"staff", "library-staff"}})
+	first := signIn(t, appURL)
+	if first.Username != "jane" || first.Role != "librarian" {
+		t.Fatalf("expected new user jane with role librarian, got %s with %s", first.Username, first.Role)
+	}
+
+	// Same subject: same user, even if the email changed at the provider
+	idp.signInAs(jwt.MapClaims{"sub": "u-1", "email": "jane.doe@example.org", "preferred_username": "jane",
+		"groups": []string{"library-admins"}})
+	second := signIn(t, appURL)
+	if second.UserID != first.UserID {
+		t.Fatalf("expected user ID %d again, got %d", first.UserID, second.UserID)
+	}
+	if second.Role != "admin" {
+		t.Fatalf("expected the role claim to change the role to admin, got %s", second.Role)
+	}
+
+	// Unmapped groups keep the role
+	idp.signInAs(jwt.MapClaims{"sub": "u-1", "email": "jane@example.org", "groups": "staff"})
+	if third := signIn(t, appURL); third.Role != "admin" {
+		t.Fatalf("expected the role to stay admin, got %s", third.Role)
+	}
+
+	// Another subject with a taken username gets a numbered one and the default role
+	idp.signInAs(jwt.MapClaims{"sub": "u-2", "email": "jane@elsewhere.org", "preferred_username": "jane"})
+	other := signIn(t, appURL)
+	if other.UserID == first.UserID || other.Username != "jane2" || other.Role != "member" {
+		t.Fatalf("expected a new user jane2 with role member, got ID %d %s with %s", other.UserID, other.Username, other.Role)
+	}
+}
+
+func TestOIDCShortensLongUsernames(t *testing.T) {
+	idp := newStubIdP(t)
+	appURL := newOIDCApp(t, idp, nil)
+
+	// 39 ASCII letters and then two-byte characters, so 40 bytes end inside one
+	long := strings.Repeat("a", 39) + strings.Repeat("é", 5)
+	idp.signInAs(jwt.MapClaims{"sub": "u-1", "email": "long@example.org", "preferred_username": long})
+	if got, want := signIn(t, appURL).Username, strings.Repeat("a", 39)+"é"; got != want {
+		t.Errorf("expected username %q, got %q", want, got)
+	}
+
+	// Short names are padded by characters, not bytes
+	idp.signInAs(jwt.MapClaims{"sub": "u-2", "email": "short@example.org", "preferred_username": "é"})
+	if got := signIn(t, appURL).Username; got != "é__" {
+		t.Errorf("expected username %q, got %q", "é__", got)
+	}
+}
+
+func TestOIDCLinksExistingUserByVerifiedEmail(t *testing.T) {
+	idp := newStubIdP(t)
+	appURL := newOIDCApp(t, idp, func(cfg *config.Config) { cfg.OIDCLinkByEmail = true })
+
+	// The seeded admin has admin@example.com; an unverified address must not take it over
+	idp.signInAs(jwt.MapClaims{"sub": "a-1", "email": "admin@example.com", "email_verified": false})
+	query := startSignIn(t, appURL, newBrowser(t))
+	if query.Get("oidc_code") != "" || query.Get("oidc_error") == "" {
+		t.Fatalf("expected linking an unverified email to fail, got %v", query)
+	}
+
+	idp.signInAs(jwt.MapClaims{"sub": "a-1", "email": "admin@example.com", "email_verified": true})
+	linked := signIn(t, appURL)
+	if linked.Username != "admin" || linked.Role != "admin" {
+		t.Fatalf("expected to sign in as the existing admin, got %s with %s", linked.Username, linked.Role)
+	}
+}
+
+func TestOIDCWithoutLinkingRejectsExistingEmail(t *testing.T) {
+	idp := newStubIdP(t)
+	appURL := newOIDCApp(t, idp, nil)
+
+	idp.signInAs(jwt.MapClaims{"sub": "a-1", "email": "admin@example.com", "email_verified": true})
+	query := startSignIn(t, appURL, newBrowser(t))
+	if query.Get("oidc_error") == "" {
+		t.Fatalf("expected an error for an existing email without oidc_link_by_email, got %v", query)
+	}
+}
+
+func TestOIDCRejectsInvalidIDTokens(t *testing.T) {
+	idp := newStubIdP(t)
+	appURL := newOIDCApp(t, idp, nil)
+
+	cases := map[string]func(jwt.MapClaims){
+		"wrong audience": func(c jwt.MapClaims) { c["aud"] = "another-client" },
+		"wrong issuer":   func(c jwt.MapClaims) { c["iss"] = "https://evil.example" },
+		"wrong nonce":    func(c jwt.MapClaims) { c["nonce"] = "replayed" },
+		"expired":        func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+		"no subject":     func(c jwt.MapClaims) { delete(c, "sub") },
+	}
+	for name, tamper := range cases {
+		t.Run(name, func(t *testing.T) {
+			idp.signInAs(jwt.MapClaims{"sub": "u-1", "email": "jane@example.org"})
+			idp.mu.Lock()
+			idp.tamper = tamper
+			idp.mu.Unlock()
+
+			query := startSignIn(t, appURL, newBrowser(t))
+			if query.Get("oidc_code") != "" || query.Get("oidc_error") == "" {
+				t.Fatalf("expected the sign-in to fail, got %v", query)
+			}
+		})
+	}
+}
+
+func TestOIDCCallbackRequiresStateOfTheBrowser(t *testing.T) {
+	idp := newStubIdP(t)
+	appURL := newOIDCApp(t, idp, nil)
+	idp.signInAs(jwt.MapClaims{"sub": "u-1", "email": "jane@example.org"})
+
+	// A callback URL from someone else's flow must not sign this browser in
+	attacker := newBrowser(t)
+	attacker.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if req.URL.Path == "/auth/oidc/callback" {
+			return http.ErrUseLastResponse
+		}
+		return nil
+	}
+	resp, err := attacker.Get(appURL + "/auth/oidc/login")
+	if err != nil {
+		t.Fatalf("starting sign-in: %v", err)
+	}
+	resp.Body.Close()
+	callback := resp.Header.Get("Location")
+
+	resp, err = newBrowser(t).Get(callback)
+	if err != nil {
+		t.Fatalf("callback: %v", err)
+	}
+	resp.Body.Close()
+	if query := appRedirect(t, resp); query.Get("oidc_error") == "" {
+		t.Fatalf("expected the callback without the state cookie to fail, got %v", query)
+	}
+}
+
+func TestOIDCLoginCodeIsSingleUse(t *testing.T) {
+	idp := newStubIdP(t)
+	appURL := newOIDCApp(t, idp, nil)
+	idp.signInAs(jwt.MapClaims{"sub": "u-1", "email": "jane@example.org"})
+
+	query := startSignIn(t, appURL, newBrowser(t))
+	if first := redeemCode(t, appURL, query.Get("oidc_code")); first.status != http.StatusOK {
+		t.Fatalf("expected the first redemption to succeed, got %d: %s", first.status, first.Error)
+	}
+	if second := redeemCode(t, appURL, query.Get("oidc_code")); second.status != http.StatusBadRequest {
+		t.Fatalf("expected the second redemption to fail, got %d", second.status)
+	}
+}
@@ -0,0 +1,47 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"lab1/config"
+	"lab1/service"
+	"testing"
+)
+
+func TestReaderFindByIDDoesNotShareTheCachedReader(t *testing.T) {
+	c := newTestContainer(t, config.DefaultConfig())
+	ctx := context.Background()
+	reader, _ := newLendingFixture(t, c)
+
+	first, err := c.ReaderRepository.FindByID(ctx, reader.ID)
+	if err != nil {
+		t.Fatalf("finding reader: %v", err)
+	}
+	first.Name = "Augusta"
+
+	// The second lookup is answered from the cache
+	second, err := c.ReaderRepository.FindByID(ctx, reader.ID)
+	if err != nil {
+		t.Fatalf("finding reader: %v", err)
+	}
+	if second == first || second.Name != "Ada" {
+		t.Error("changing a found reader changed the cached one")
+	}
+
+	// Neither does a change in a unit of work that rolls back
+	failure := errors.New("failure")
+	err = c.UnitOfWork.Do(ctx, func(repos *service.Repositories) error {
+		changed, err := repos.Readers.FindByID(ctx, reader.ID)
+		if err != nil {
+			return err
+		}
+		changed.Name = "Augusta"
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Fatalf("expected the error of the work, got %v", err)
+	}
+	if found, err := c.ReaderRepository.FindByID(ctx, reader.ID); err != nil || found.Name != "Ada" {
+		t.Errorf("expected the cached reader unchanged, got %v, %v", found, err)
+	}
+}
@@ -84,7 +84,7 @@ echo ""
 
 # Step 2: Build the application
 echo -e "${YELLOW}Step 2: Building application...${NC}"
-go build -o server main.go
+go build -tags sqlite_fts5 -o server main.go
 echo -e "${GREEN}Build completed${NC}"
 echo ""
 
@@ -115,7 +115,7 @@ echo -e "${YELLOW}Step 5: Running Selenium tests...${NC}"
 echo ""
 
 TEST_RESULT=0
-go test -v -timeout $TEST_TIMEOUT ./tests/ || TEST_RESULT=$?
+go test -tags sqlite_fts5 -v -timeout $TEST_TIMEOUT ./tests/ || TEST_RESULT=$?
 
 echo ""
 
@@ -0,0 +1,171 @@
+package tests
+
+import (
+	"lab1/config"
+	"lab1/totp"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 key of the RFC 6238 test vectors, "12345678901234567890"
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPMatchesRFC6238(t *testing.T) {
+	// The RFC lists 8 digit codes, authenticator apps show the last 6
+	for _, tc := range []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	} {
+		code, err := totp.Code(rfcSecret, totp.Counter(time.Unix(tc.unix, 0)))
+		if err != nil || code != tc.want {
+			t.Errorf("code at %d: expected %s, got %q, %v", tc.unix, tc.want, code, err)
+		}
+	}
+
+	if _, err := totp.Code("not base32!", 1); err == nil {
+		t.Error("expected an invalid secret to be rejected")
+	}
+}
+
+func TestTOTPValidateWindow(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := totp.Counter(now)
+	code := func(counter int64) string {
+		c, err := totp.Code(rfcSecret, counter)
+		if err != nil {
+			t.Fatalf("code: %v", err)
+		}
+		return c
+	}
+
+	for _, tc := range []struct {
+		name  string
+		code  string
+		after int64
+		ok    bool
+	}{
+		{"current step", code(step), 0, true},
+		{"one step late", code(step - 1), 0, true},
+		{"one step early", code(step + 1), 0, true},
+		{"two steps late", code(step - 2), 0, false},
+		{"two steps early", code(step + 2), 0, false},
+		{"with spaces", code(step)[:3] + " " + code(step)[3:], 0, true},
+		{"too short", code(step)[:5], 0, false},
+		{"already used", code(step), step, false},
+		{"before the used step", code(step - 1), step - 1, false},
+		{"after the used step", code(step + 1), step, true},
+	} {
+		counter, ok := totp.Validate(rfcSecret, tc.code, now, 1, tc.after)
+		if ok != tc.ok {
+			t.Errorf("%s: expected ok=%v, got %v", tc.name, tc.ok, ok)
+		}
+		if ok && (counter < step-1 || counter > step+1) {
+			t.Errorf("%s: returned step %d outside the window", tc.name, counter)
+		}
+	}
+}
+
+// enrolTwoFactor enables two-factor authentication for the session and
+// returns the secret and the recovery codes
+func enrolTwoFactor(t *testing.T, app, access string) (string, []string) {
+	t.Helper()
+	status, body := callAPI(t, http.MethodPost, app+"/auth/2fa/setup", "Bearer "+access, nil)
+	if status != http.StatusOK {
+		t.Fatalf("2fa setup: status %d, %v", status, body)
+	}
+	secret := body["secret"].(string)
+	code, err := totp.Code(secret, totp.Counter(time.Now()))
+	if err != nil {
+		t.Fatalf("code: %v", err)
+	}
+	status, body = callAPI(t, http.MethodPost, app+"/auth/2fa/verify", "Bearer "+access, map[string]string{"code": code})
+	if status != http.StatusOK {
+		t.Fatalf("2fa verify: status %d, %v", status, body)
+	}
+	var codes []string
+	for _, code := range body["recovery_codes"].([]interface{}) {
+		codes = append(codes, code.(string))
+	}
+	return secret, codes
+}
+
+// challenge starts a login of a user with two-factor authentication
+func challenge(t *testing.T, app string) string {
+	t.Helper()
+	status, body := callAPI(t, http.MethodPost, app+"/auth/login", "", map[string]string{"username": "admin", "password": "password"})
+	if status != http.StatusAccepted || body["two_factor_required"] != true {
+		t.Fatalf("login: expected a two-factor challenge, got %d %v", status, body)
+	}
+	return body["challenge_token"].(string)
+}
+
+func loginTwoFactor(t *testing.T, app, challenge, code string) int {
+	t.Helper()
+	status, _ := callAPI(t, http.MethodPost, app+"/auth/2fa/login", "", map[string]string{"challenge_token": challenge, "code": code})
+	return status
+}
+
+func TestTwoFactorLoginCodesWorkOnce(t *testing.T) {
+	app := newAuthApp(t, newTestContainer(t, config.DefaultConfig()))
+	access, _ := login(t, app, "admin", "password")
+	secret, recovery := enrolTwoFactor(t, app, access)
+	if len(recovery) != 10 {
+		t.Fatalf("expected 10 recovery codes, got %d", len(recovery))
+	}
+
+	// The step after the one confirmed at enrolment is still within the window
+	code, err := totp.Code(secret, totp.Counter(time.Now())+1)
+	if err != nil {
+		t.Fatalf("code: %v", err)
+	}
+
+	// A wrong code uses up the challenge
+	used := challenge(t, app)
+	if status := loginTwoFactor(t, app, used, "000000"); status != http.StatusUnauthorized {
+		t.Errorf("wrong code: expected 401, got %d", status)
+	}
+	if status := loginTwoFactor(t, app, used, code); status != http.StatusUnauthorized {
+		t.Errorf("used challenge: expected 401, got %d", status)
+	}
+
+	if status := loginTwoFactor(t, app, challenge(t, app), code); status != http.StatusOK {
+		t.Fatalf("expected the code to log in, got %d", status)
+	}
+	if status := loginTwoFactor(t, app, challenge(t, app), code); status != http.StatusUnauthorized {
+		t.Errorf("expected the same code to be refused a second time, got %d", status)
+	}
+
+	// Recovery codes work once, with or without the dash and in any case
+	if status := loginTwoFactor(t, app, challenge(t, app), recovery[0]); status != http.StatusOK {
+		t.Errorf("recovery code: expected 200, got %d", status)
+	}
+	if status := loginTwoFactor(t, app, challenge(t, app), recovery[0]); status != http.StatusUnauthorized {
+		t.Errorf("used recovery code: expected 401, got %d", status)
+	}
+	other := strings.ToUpper(strings.ReplaceAll(recovery[1], "-", ""))
+	if status := loginTwoFactor(t, app, challenge(t, app), other); status != http.StatusOK {
+		t.Errorf("recovery code without dash: expected 200, got %d", status)
+	}
+}
+
+func TestTwoFactorRequiredRolesCannotDisable(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.TwoFactorRequiredRoles = []string{"admin"}
+	app := newAuthApp(t, newTestContainer(t, cfg))
+	access, _ := login(t, app, "admin", "password")
+	_, recovery := enrolTwoFactor(t, app, access)
+
+	status, body := callAPI(t, http.MethodPost, app+"/auth/2fa/disable", "Bearer "+access, map[string]string{"password": "password", "code": recovery[0]})
+	if status != http.StatusForbidden {
+		t.Errorf("expected 403 for a role that requires two-factor authentication, got %d %v", status, body)
+	}
+}
@@ -0,0 +1,203 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"lab1/config"
+	"lab1/container"
+	"lab1/handlers"
+	"lab1/models"
+	"lab1/service"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newTrashApp serves the trash routes of c to the seeded admin
+func newTrashApp(t *testing.T, c *container.Container) string {
+	gin.SetMode(gin.TestMode)
+	h := handlers.NewTrashHandler(c.BookRepository, c.ReaderRepository, c.ItemRepository, c.AuditRepository, c.Config)
+	r := gin.New()
+	r.Use(actAs(1, "admin", "admin"))
+	r.GET("/trash/books", h.GetBooks)
+	r.DELETE("/trash/books", h.PurgeBooks)
+	r.POST("/trash/books/:id/restore", h.RestoreBook)
+	r.DELETE("/trash/books/:id", h.PurgeBook)
+	r.GET("/trash/readers", h.GetReaders)
+	r.DELETE("/trash/readers", h.PurgeReaders)
+	r.POST("/trash/readers/:id/restore", h.RestoreReader)
+	r.DELETE("/trash/readers/:id", h.PurgeReader)
+
+	app := httptest.NewServer(r)
+	t.Cleanup(app.Close)
+	return app.URL
+}
+
+// trashBook creates a book with a copy and deletes it
+func trashBook(t *testing.T, c *container.Container, title string) *models.Book {
+	ctx := context.Background()
+	book := &models.Book{Title: title, UserID: 1}
+	if err := c.BookRepository.Create(ctx, book); err != nil {
+		t.Fatalf("creating book: %v", err)
+	}
+	item := &models.Item{BookID: book.ID, Barcode: title + "-1", Status: models.ItemStatusAvailable}
+	if err := c.ItemRepository.Create(ctx, item); err != nil {
+		t.Fatalf("creating copy: %v", err)
+	}
+	if err := c.BookRepository.Delete(ctx, book.ID); err != nil {
+		t.Fatalf("deleting book: %v", err)
+	}
+	return book
+}
+
+// trashedTitles lists the titles in the book trash
+func trashedTitles(t *testing.T, app string) ([]string, []map[string]interface{}) {
+	t.Helper()
+	status, body := callAPI(t, http.MethodGet, app+"/trash/books", "", nil)
+	if status != http.StatusOK {
+		t.Fatalf("listing trash: status %d, %v", status, body)
+	}
+	var titles []string
+	var books []map[string]interface{}
+	for _, book := range body["data"].([]interface{}) {
+		book := book.(map[string]interface{})
+		titles = append(titles, book["title"].(string))
+		books = append(books, book)
+	}
+	return titles, books
+}
+
+func TestTrashRestoresBooksAndReaders(t *testing.T) {
+	c := newTestContainer(t, config.DefaultConfig())
+	app := newTrashApp(t, c)
+	ctx := context.Background()
+	book := trashBook(t, c, "Dune")
+
+	titles, books := trashedTitles(t, app)
+	if fmt.Sprint(titles) != "[Dune]" {
+		t.Fatalf("expected Dune in the trash, got %v", titles)
+	}
+	deletedAt, _ := time.Parse(time.RFC3339Nano, books[0]["deleted_at"].(string))
+	purgeAt, _ := time.Parse(time.RFC3339Nano, books[0]["purge_at"].(string))
+	if !purgeAt.Equal(deletedAt.AddDate(0, 0, 30)) {
+		t.Errorf("expected purge_at 30 days after deleted_at, got %v and %v", deletedAt, purgeAt)
+	}
+
+	id := fmt.Sprint(book.ID)
+	for _, tc := range []struct {
+		id     string
+		status int
+	}{
+		{id, http.StatusOK},
+		{id, http.StatusNotFound}, // not in the trash any more
+		{"999", http.StatusNotFound},
+		{"dune", http.StatusBadRequest},
+	} {
+		if status, body := callAPI(t, http.MethodPost, app+"/trash/books/"+tc.id+"/restore", "", nil); status != tc.status {
+			t.Errorf("restoring book %s: expected %d, got %d %v", tc.id, tc.status, status, body)
+		}
+	}
+	restored, err := c.BookRepository.FindByID(ctx, book.ID)
+	if err != nil || restored.Title != "Dune" {
+		t.Fatalf("expected the book back, got %v, %v", restored, err)
+	}
+	if items, err := c.ItemRepository.FindByBook(ctx, book.ID); err != nil || len(items) != 1 {
+		t.Errorf("expected the copy to come back with the book, got %d, %v", len(items), err)
+	}
+
+	reader := &models.Reader{Name: "Ada", Surname: "Lovelace"}
+	if err := c.ReaderRepository.Create(ctx, reader); err != nil {
+		t.Fatalf("creating reader: %v", err)
+	}
+	if err := c.ReaderRepository.Delete(ctx, reader.ID); err != nil {
+		t.Fatalf("deleting reader: %v", err)
+	}
+	if status, body := callAPI(t, http.MethodPost, fmt.Sprintf("%s/trash/readers/%d/restore", app, reader.ID), "", nil); status != http.StatusOK {
+		t.Fatalf("restoring reader: status %d, %v", status, body)
+	}
+	if _, err := c.ReaderRepository.FindByID(ctx, reader.ID); err != nil {
+		t.Errorf("expected the reader back: %v", err)
+	}
+}
+
+func TestTrashPurgesUnreferencedRecords(t *testing.T) {
+	c := newTestContainer(t, config.DefaultConfig())
+	app := newTrashApp(t, c)
+	ctx := context.Background()
+
+	// A book with a loan stays, even when the loan is returned
+	reader, lent := newLendingFixture(t, c)
+	if _, err := c.LoanService.Checkout(ctx, testActor, service.Checkout{ReaderID: reader.ID, BookID: lent.ID}); err != nil {
+		t.Fatalf("checkout: %v", err)
+	}
+	if err := c.BookRepository.Delete(ctx, lent.ID); err != nil {
+		t.Fatalf("deleting book: %v", err)
+	}
+	single := trashBook(t, c, "Emma")
+	trashBook(t, c, "Persuasion")
+	trashBook(t, c, "Sanditon")
+
+	if status, body := callAPI(t, http.MethodDelete, fmt.Sprintf("%s/trash/books/%d", app, lent.ID), "", nil); status != http.StatusConflict {
+		t.Errorf("purging a lent book: expected 409, got %d %v", status, body)
+	}
+	if status, body := callAPI(t, http.MethodDelete, fmt.Sprintf("%s/trash/books/%d", app, single.ID), "", nil); status != http.StatusNoContent {
+		t.Fatalf("purging a book: expected 204, got %d %v", status, body)
+	}
+	if status, _ := callAPI(t, http.MethodDelete, fmt.Sprintf("%s/trash/books/%d", app, single.ID), "", nil); status != http.StatusNotFound {
+		t.Errorf("purging a purged book: expected 404, got %d", status)
+	}
+	var items int64
+	c.DB.Unscoped().Model(&models.Item{}).Where("book_id = ?", single.ID).Count(&items)
+	if items != 0 {
+		t.Errorf("expected the copies to be purged with the book, got %d", items)
+	}
+
+	status, body := callAPI(t, http.MethodDelete, app+"/trash/books", "", nil)
+	if status != http.StatusOK || body["purged"] != 2.0 {
+		t.Errorf("emptying the trash: expected 2 purged, got %d %v", status, body)
+	}
+	if titles, _ := trashedTitles(t, app); fmt.Sprint(titles) != "[Dune]" {
+		t.Errorf("expected only the lent book to stay in the trash, got %v", titles)
+	}
+
+	// The reader of the loan cannot be purged either
+	if err := c.ReaderRepository.Delete(ctx, reader.ID); err != nil {
+		t.Fatalf("deleting reader: %v", err)
+	}
+	if status, _ := callAPI(t, http.MethodDelete, fmt.Sprintf("%s/trash/readers/%d", app, reader.ID), "", nil); status != http.StatusConflict {
+		t.Errorf("purging a reader with loans: expected 409, got %d", status)
+	}
+}
+
+func TestTrashPurgesExpiredRecords(t *testing.T) {
+	for _, retention := range []int{30, 0} {
+		t.Run(fmt.Sprint(retention), func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.TrashRetentionDays = retention
+			c := newTestContainer(t, cfg)
+			app := newTrashApp(t, c)
+
+			old := trashBook(t, c, "Old")
+			trashBook(t, c, "Recent")
+			c.DB.Unscoped().Model(&models.Book{}).Where("id = ?", old.ID).Update("deleted_at", time.Now().AddDate(0, 0, -31))
+
+			// Looking at the trash purges what is past the retention
+			titles, books := trashedTitles(t, app)
+			want := "[Recent]"
+			if retention == 0 {
+				want = "[Recent Old]"
+			}
+			if fmt.Sprint(titles) != want {
+				t.Errorf("expected %s in the trash, got %v", want, titles)
+			}
+			for _, book := range books {
+				if _, found := book["purge_at"]; found != (retention > 0) {
+					t.Errorf("expected purge_at only with a retention, got %v", book["purge_at"])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"lab1/cache"
+	"lab1/config"
+	"lab1/models"
+	"lab1/service"
+	"testing"
+)
+
+// cachedTitle returns the title of the book in the cache, if it is there
+func cachedTitle(c *cache.Cache, id uint) (string, bool) {
+	cached, found := c.Get(cache.BookIDKey(id))
+	if !found {
+		return "", false
+	}
+	return cached.(models.Book).Title, true
+}
+
+func TestUnitOfWorkRollbackKeepsTheCache(t *testing.T) {
+	c := newTestContainer(t, config.DefaultConfig())
+	ctx := context.Background()
+	_, book := newLendingFixture(t, c)
+	if _, err := c.BookRepository.FindByID(ctx, book.ID); err != nil {
+		t.Fatalf("finding book: %v", err)
+	}
+
+	failure := errors.New("failure")
+	err := c.UnitOfWork.Do(ctx, func(repos *service.Repositories) error {
+		changed, err := repos.Books.FindByID(ctx, book.ID)
+		if err != nil {
+			return err
+		}
+		changed.Title = "Dune Messiah"
+		if err := repos.Books.Update(ctx, changed); err != nil {
+			return err
+		}
+		// Inside the transaction the invalidated entry is not read
+		if inside, err := repos.Books.FindByID(ctx, book.ID); err != nil || inside.Title != "Dune Messiah" {
+			t.Errorf("expected the transaction to see its own change, got %v, %v", inside, err)
+		}
+		if title, _ := cachedTitle(c.Cache, book.ID); title != "Dune" {
+			t.Errorf("expected the invalidation to wait for the commit, got %q", title)
+		}
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Fatalf("expected the error of the work, got %v", err)
+	}
+
+	if title, found := cachedTitle(c.Cache, book.ID); !found || title != "Dune" {
+		t.Errorf("expected the rollback to keep the cached book, got %q, %v", title, found)
+	}
+	if found, err := c.BookRepository.FindByID(ctx, book.ID); err != nil || found.Title != "Dune" {
+		t.Errorf("expected the book unchanged, got %v, %v", found, err)
+	}
+}
+
+func TestUnitOfWorkCommitEvictsTheCache(t *testing.T) {
+	c := newTestContainer(t, config.DefaultConfig())
+	ctx := context.Background()
+	_, book := newLendingFixture(t, c)
+	if _, err := c.BookRepository.FindByID(ctx, book.ID); err != nil {
+		t.Fatalf("finding book: %v", err)
+	}
+
+	err := c.UnitOfWork.Do(ctx, func(repos *service.Repositories) error {
+		changed, err := repos.Books.FindByID(ctx, book.ID)
+		if err != nil {
+			return err
+		}
+		changed.Title = "Dune Messiah"
+		return repos.Books.Update(ctx, changed)
+	})
+	if err != nil {
+		t.Fatalf("unit of work: %v", err)
+	}
+
+	if title, found := cachedTitle(c.Cache, book.ID); found {
+		t.Errorf("expected the commit to evict the cached book, got %q", title)
+	}
+	if found, err := c.BookRepository.FindByID(ctx, book.ID); err != nil || found.Title != "Dune Messiah" {
+		t.Errorf("expected the changed book, got %v, %v", found, err)
+	}
+}
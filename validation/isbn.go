@@ -0,0 +1,95 @@
+package validation
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+var ErrInvalidISBN = errors.New("invalid ISBN")
+
+// NormalizeISBN validates an ISBN-10 or ISBN-13, ignoring hyphens and spaces,
+// and returns it as a bare ISBN-13. ISBN-10s are converted with the 978 prefix.
+func NormalizeISBN(s string) (string, error) {
+	digits := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(s))
+
+	switch len(digits) {
+	case 10:
+		if !validISBN10(digits) {
+			return "", ErrInvalidISBN
+		}
+		isbn13 := "978" + digits[:9]
+		return isbn13 + string(isbn13CheckDigit(isbn13)), nil
+	case 13:
+		if !allDigits(digits) || isbn13CheckDigit(digits[:12]) != digits[12] {
+			return "", ErrInvalidISBN
+		}
+		return digits, nil
+	default:
+		return "", ErrInvalidISBN
+	}
+}
+
+// ISBN13To10 returns the ISBN-10 form of a normalized ISBN-13. Only 978-prefixed
+// ISBNs have one; for the others it returns an empty string.
+func ISBN13To10(isbn13 string) string {
+	if len(isbn13) != 13 || !strings.HasPrefix(isbn13, "978") {
+		return ""
+	}
+	body := isbn13[3:12]
+	return body + string(isbn10CheckDigit(body))
+}
+
+func validISBN10(s string) bool {
+	if !allDigits(s[:9]) {
+		return false
+	}
+	check := s[9]
+	if check != 'X' && (check < '0' || check > '9') {
+		return false
+	}
+	return isbn10CheckDigit(s[:9]) == check
+}
+
+// isbn10CheckDigit computes the mod 11 check digit of the first nine digits
+func isbn10CheckDigit(body string) byte {
+	sum := 0
+	for i := 0; i < 9; i++ {
+		sum += int(body[i]-'0') * (10 - i)
+	}
+	check := (11 - sum%11) % 11
+	if check == 10 {
+		return 'X'
+	}
+	return byte('0' + check)
+}
+
+// isbn13CheckDigit computes the mod 10 check digit of the first twelve digits
+func isbn13CheckDigit(body string) byte {
+	sum := 0
+	for i := 0; i < 12; i++ {
+		weight := 1
+		if i%2 == 1 {
+			weight = 3
+		}
+		sum += int(body[i]-'0') * weight
+	}
+	return byte('0' + (10-sum%10)%10)
+}
+
+func allDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// validateISBN backs the "isbn" tag, replacing the validator's built-in one
+// so that hyphenated ISBNs as printed on books are accepted
+func validateISBN(fl validator.FieldLevel) bool {
+	_, err := NormalizeISBN(fl.Field().String())
+	return err == nil
+}
@@ -0,0 +1,32 @@
+package validation
+
+import (
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// isControl reports C0 control characters other than tab and line breaks
+func isControl(r rune) bool {
+	return r < 0x20 && r != '\t' && r != '\n' && r != '\r'
+}
+
+// StripControl removes the control characters that the "nocontrol" tag
+// rejects. Imports use it to clean up values from files instead of failing.
+func StripControl(s string) string {
+	if !strings.ContainsFunc(s, isControl) {
+		return s
+	}
+	return strings.Map(func(r rune) rune {
+		if isControl(r) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// validateNoControl backs the "nocontrol" tag. Search results mark hits with
+// control characters, so book text must not contain any of its own.
+func validateNoControl(fl validator.FieldLevel) bool {
+	return !strings.ContainsFunc(fl.Field().String(), isControl)
+}
@@ -11,8 +11,11 @@ type Validator struct {
 }
 
 func NewValidator() *Validator {
+	validate := validator.New()
+	validate.RegisterValidation("isbn", validateISBN)
+	validate.RegisterValidation("nocontrol", validateNoControl)
 	return &Validator{
-		validate: validator.New(),
+		validate: validate,
 	}
 }
 
@@ -54,6 +57,14 @@ func getErrorMessage(fe validator.FieldError) string {
 		return fmt.Sprintf("%s must be at least %s characters", fe.Field(), fe.Param())
 	case "max":
 		return fmt.Sprintf("%s must be at most %s characters", fe.Field(), fe.Param())
+	case "gt":
+		return fmt.Sprintf("%s must be greater than %s", fe.Field(), fe.Param())
+	case "isbn":
+		return fmt.Sprintf("%s must be a valid ISBN-10 or ISBN-13", fe.Field())
+	case "nocontrol":
+		return fmt.Sprintf("%s must not contain control characters", fe.Field())
+	case "oneof":
+		return fmt.Sprintf("%s must be one of: %s", fe.Field(), fe.Param())
 	default:
 		return fmt.Sprintf("%s is invalid", fe.Field())
 	}